@@ -0,0 +1,174 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/disk"
+)
+
+// fakeStore is a disk.PageStore keeping pages in a map and counting the calls the
+// buffer pool makes, so that tests can check its I/O without touching files. It is
+// safe for concurrent use; readDelay, when set, makes every page read that slow
+// without keeping other calls waiting, like a disk serving parallel requests.
+type fakeStore struct {
+	mu        sync.Mutex
+	readDelay time.Duration
+	pageSize  int
+	pages     map[config.PageId][]byte
+	next      int
+	// reads counts ReadPage and ReadPageInto calls, writes the pages written by
+	// WritePage, batches the WritePages calls
+	reads, writes, batches int
+}
+
+func newFakeStore(pageSize int) *fakeStore {
+	return &fakeStore{pageSize: pageSize, pages: make(map[config.PageId][]byte)}
+}
+
+func (s *fakeStore) AllocatePage() (config.PageId, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pid := config.PageId{FileIdx: 0, PageIdx: s.next}
+	s.next++
+	s.pages[pid] = make([]byte, s.pageSize)
+	return pid, nil
+}
+
+func (s *fakeStore) AllocateContiguous(n int) ([]config.PageId, error) {
+	out := make([]config.PageId, n)
+	for i := range out {
+		out[i], _ = s.AllocatePage()
+	}
+	return out, nil
+}
+
+func (s *fakeStore) FreePage(pid config.PageId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkPage(pid); err != nil {
+		return err
+	}
+	delete(s.pages, pid)
+	return nil
+}
+
+func (s *fakeStore) CheckPage(pid config.PageId) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkPage(pid)
+}
+
+func (s *fakeStore) checkPage(pid config.PageId) error {
+	if _, ok := s.pages[pid]; !ok {
+		return fmt.Errorf("%w: %v", disk.ErrInvalidPage, pid)
+	}
+	return nil
+}
+
+func (s *fakeStore) ReadPage(pid config.PageId) ([]byte, error) {
+	buf := make([]byte, s.pageSize)
+	return buf, s.ReadPageInto(pid, buf)
+}
+
+func (s *fakeStore) ReadPageInto(pid config.PageId, buf []byte) error {
+	time.Sleep(s.readDelay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkPage(pid); err != nil {
+		return err
+	}
+	s.reads++
+	copy(buf, s.pages[pid])
+	return nil
+}
+
+func (s *fakeStore) WritePage(pid config.PageId, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writePage(pid, data)
+}
+
+func (s *fakeStore) writePage(pid config.PageId, data []byte) error {
+	if err := s.checkPage(pid); err != nil {
+		return err
+	}
+	s.writes++
+	s.pages[pid] = append([]byte{}, data...)
+	return nil
+}
+
+func (s *fakeStore) WritePages(pages map[config.PageId][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	for pid, data := range pages {
+		if err := s.writePage(pid, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) PageSize() int               { return s.pageSize }
+func (s *fakeStore) PageReserve() int            { return 0 }
+func (s *fakeStore) ByteOrder() config.ByteOrder { return binary.LittleEndian }
+func (s *fakeStore) BinDir() string              { return "" }
+func (s *fakeStore) Finish() error               { return nil }
+
+// TestBufferManagerOnFakeStore checks the I/O the pool does: one read per miss, a
+// dirty victim written back once on eviction, and the remaining dirty frames in a
+// single batch on FlushBuffers.
+func TestBufferManagerOnFakeStore(t *testing.T) {
+	cfg := config.NewDBConfigWithParams("unused", 64, 1)
+	cfg.BMBufferCount = 2
+	st := newFakeStore(cfg.PageSize)
+	bm := NewBufferManager(cfg, st)
+	pids, _ := st.AllocateContiguous(3)
+
+	for i, pid := range pids[:2] {
+		f, err := bm.GetPage(pid)
+		if err != nil {
+			t.Fatalf("get %v: %v", pid, err)
+		}
+		f.Data[0] = byte(i + 1)
+		if err := bm.FreePage(pid, true); err != nil {
+			t.Fatalf("free %v: %v", pid, err)
+		}
+	}
+	// a hit reads nothing
+	if _, err := bm.GetPage(pids[1]); err != nil {
+		t.Fatalf("get again: %v", err)
+	}
+	if err := bm.FreePage(pids[1], false); err != nil {
+		t.Fatalf("free again: %v", err)
+	}
+	if st.reads != 2 || st.writes != 0 {
+		t.Fatalf("after two misses and a hit: %d reads, %d writes", st.reads, st.writes)
+	}
+	// pids[0] is the LRU victim
+	if _, err := bm.GetPage(pids[2]); err != nil {
+		t.Fatalf("get %v: %v", pids[2], err)
+	}
+	if st.reads != 3 || st.writes != 1 || st.pages[pids[0]][0] != 1 {
+		t.Fatalf("eviction: %d reads, %d writes, victim byte %d", st.reads, st.writes, st.pages[pids[0]][0])
+	}
+	if err := bm.FreePage(pids[2], false); err != nil {
+		t.Fatalf("free %v: %v", pids[2], err)
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("FlushBuffers: %v", err)
+	}
+	if st.batches != 1 || st.writes != 2 || st.pages[pids[1]][0] != 2 {
+		t.Fatalf("flush: %d batches, %d writes, byte %d", st.batches, st.writes, st.pages[pids[1]][0])
+	}
+	// a page the store does not have is reported as such
+	if _, err := bm.GetPage(config.PageId{FileIdx: 0, PageIdx: 99}); !errors.Is(err, disk.ErrInvalidPage) {
+		t.Fatalf("GetPage of an unknown page: %v", err)
+	}
+}
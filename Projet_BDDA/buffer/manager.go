@@ -3,13 +3,24 @@ package buffer
 import (
 	"container/list"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 
 	"malzahar-project/Projet_BDDA/config"
 	"malzahar-project/Projet_BDDA/disk"
 )
 
+var (
+	// ErrNoFreeFrame is returned by GetPage when every frame is pinned.
+	ErrNoFreeFrame = errors.New("no free frame")
+	// ErrPageNotBuffered is returned by FreePage for a page the pool does not hold.
+	ErrPageNotBuffered = errors.New("page not found in buffers")
+)
+
 type ReplacementPolicy string
 
 const (
@@ -18,15 +29,23 @@ const (
 )
 
 type BufferFrame struct {
-	PageId   config.PageId
+	PageId config.PageId
+	// Data holds the page; it is always exactly PageSize bytes and is reused for
+	// every page the frame holds, so it must not be kept after FreePage
 	Data     []byte
 	PinCount int
 	Dirty    bool
+	// latch is write-locked while the page is read into Data, from reserve to fill,
+	// and GetPage calls for the same page wait on it; the pool's mutex is not held
+	// meanwhile. Once the page is loaded, the users of the frame take it through
+	// RLock and Lock. loadErr is the error of that read, set under the latch.
+	latch   sync.RWMutex
+	loadErr error
 }
 
 type BufferManager struct {
 	cfg    *config.DBConfig
-	dm     *disk.DiskManager
+	dm     disk.PageStore
 	frames []*BufferFrame
 	mu     sync.Mutex
 	policy ReplacementPolicy
@@ -34,38 +53,62 @@ type BufferManager struct {
 	repl *list.List
 	// map from page key to list element
 	lookup map[string]*list.Element
+	// debug mode: pinSites records the caller of each outstanding GetPage per page
+	debug    bool
+	pinSites map[config.PageId][]string
+	stats    BufferStats
+	// persistent holds the pages PinPersistent keeps resident, until ReleasePersistent
+	persistent map[config.PageId]bool
+	// prefetching counts the Prefetch goroutines still running
+	prefetching sync.WaitGroup
 }
 
+// BufferStats counts GetPage calls since the manager was created or ResetStats.
+type BufferStats struct {
+	Requests int64 // GetPage calls
+	Hits     int64 // served from a frame already holding the page
+	Misses   int64 // page read from disk
+	// Prefetched counts pages loaded by Prefetch; a later GetPage on one is a hit
+	Prefetched int64
+}
+
+// unusedPage marks a frame holding no page (distinct from the valid PageId{0,0}).
+var unusedPage = config.PageId{FileIdx: -1, PageIdx: -1}
+
 func pageKey(pid config.PageId) string {
 	return strconv.Itoa(pid.FileIdx) + ":" + strconv.Itoa(pid.PageIdx)
 }
 
-func NewBufferManager(cfg *config.DBConfig, dm *disk.DiskManager) *BufferManager {
+func NewBufferManager(cfg *config.DBConfig, dm disk.PageStore) *BufferManager {
 	bm := &BufferManager{
-		cfg:    cfg,
-		dm:     dm,
-		frames: make([]*BufferFrame, cfg.BMBufferCount),
-		policy: PolicyLRU,
-		repl:   list.New(),
-		lookup: make(map[string]*list.Element),
+		cfg:        cfg,
+		dm:         dm,
+		frames:     make([]*BufferFrame, cfg.BMBufferCount),
+		policy:     PolicyLRU,
+		repl:       list.New(),
+		lookup:     make(map[string]*list.Element),
+		persistent: make(map[config.PageId]bool),
 	}
 	if cfg.BMPolicy != "" {
 		bm.policy = ReplacementPolicy(cfg.BMPolicy)
 	}
 	// use an explicit invalid PageId sentinel for unused frames (avoid zero-value collision with FileIdx=0,PageIdx=0)
-	empty := config.PageId{FileIdx: -1, PageIdx: -1}
 	for i := range bm.frames {
-		bm.frames[i] = &BufferFrame{PageId: empty, Data: make([]byte, cfg.PageSize)}
+		bm.frames[i] = &BufferFrame{PageId: unusedPage, Data: make([]byte, cfg.PageSize)}
 	}
 	return bm
 }
 
 // GetPage returns a buffer frame containing the page; applies replacement if needed.
+// bm.mu is only held to look the page up and pin it: a page missing from the pool
+// is read under the latch of its frame, so that GetPage calls for other pages go on
+// meanwhile, and a GetPage for the same page waits on that latch for the read.
 func (bm *BufferManager) GetPage(pid config.PageId) (*BufferFrame, error) {
 	bm.mu.Lock()
-	defer bm.mu.Unlock()
 	key := pageKey(pid)
+	bm.stats.Requests++
 	if el, ok := bm.lookup[key]; ok {
+		bm.stats.Hits++
 		// move in repl list according to policy
 		if bm.policy == PolicyLRU {
 			bm.repl.MoveToBack(el)
@@ -74,62 +117,307 @@ func (bm *BufferManager) GetPage(pid config.PageId) (*BufferFrame, error) {
 		}
 		fr := el.Value.(*BufferFrame)
 		fr.PinCount++
+		bm.recordPin(pid)
+		bm.mu.Unlock()
+		// the GetPage that missed the page may still be reading it
+		if err := fr.waitLoaded(); err != nil {
+			bm.unpin(fr, pid)
+			return nil, err
+		}
 		return fr, nil
 	}
+	f, err := bm.reserve(pid)
+	if err != nil {
+		bm.mu.Unlock()
+		return nil, err
+	}
+	bm.stats.Misses++
+	f.PinCount = 1
+	bm.recordPin(pid)
+	bm.mu.Unlock()
+	if err := bm.fill(f, pid); err != nil {
+		bm.unpin(f, pid)
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetPages pins several pages at once, in order, taking bm.mu once for all of them
+// instead of once per page; the missing pages are then read outside it, like
+// GetPage does. A page listed twice is pinned twice. If any page cannot be had, the
+// pages already pinned are unpinned and the error returned: requesting more pages
+// than the pool has unpinned frames fails with ErrNoFreeFrame.
+func (bm *BufferManager) GetPages(pids []config.PageId) ([]*BufferFrame, error) {
+	frames := make([]*BufferFrame, len(pids))
+	// loading[i] is set for the frames reserved here, still write-latched
+	loading := make([]bool, len(pids))
+	bm.mu.Lock()
+	for i, pid := range pids {
+		bm.stats.Requests++
+		if el, ok := bm.lookup[pageKey(pid)]; ok {
+			bm.stats.Hits++
+			if bm.policy == PolicyLRU {
+				bm.repl.MoveToBack(el)
+			} else {
+				bm.repl.MoveToFront(el)
+			}
+			frames[i] = el.Value.(*BufferFrame)
+			frames[i].PinCount++
+			bm.recordPin(pid)
+			continue
+		}
+		f, err := bm.reserve(pid)
+		if err != nil {
+			bm.abandon(pids[:i], frames[:i], loading[:i])
+			bm.mu.Unlock()
+			return nil, err
+		}
+		bm.stats.Misses++
+		f.PinCount = 1
+		bm.recordPin(pid)
+		frames[i], loading[i] = f, true
+	}
+	bm.mu.Unlock()
+	// read the reserved frames before waiting on any: a page listed twice waits on
+	// the latch of a frame reserved here
+	var firstErr error
+	for i, f := range frames {
+		if loading[i] {
+			if err := bm.fill(f, pids[i]); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	for i, f := range frames {
+		if !loading[i] {
+			if err := f.waitLoaded(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		for i, f := range frames {
+			bm.unpin(f, pids[i])
+		}
+		return nil, firstErr
+	}
+	return frames, nil
+}
+
+// abandon undoes the part of a GetPages done before a page could not be reserved:
+// the frames it reserved, not read yet, are dropped from the pool and unlatched, and
+// every frame it pinned is unpinned. Caller must hold bm.mu.
+func (bm *BufferManager) abandon(pids []config.PageId, frames []*BufferFrame, loading []bool) {
+	for i, f := range frames {
+		if loading[i] {
+			if el, ok := bm.lookup[pageKey(pids[i])]; ok && el.Value == f {
+				delete(bm.lookup, pageKey(pids[i]))
+				bm.repl.Remove(el)
+			}
+			f.PageId = unusedPage
+			f.loadErr = ErrNoFreeFrame
+			f.latch.Unlock()
+		}
+		if f.PinCount > 0 {
+			f.PinCount--
+			bm.recordUnpin(pids[i])
+		}
+	}
+}
+
+// reserve maps pid, which must not be in the pool, to a free frame or, failing that,
+// to the least (LRU) or most (MRU) recently used frame that is not pinned, and
+// returns the frame unpinned, clean and write-latched for fill to read the page into.
+// A dirty victim is written back first, under bm.mu, so that no GetPage of it can
+// read the old page from disk meanwhile. Caller must hold bm.mu.
+func (bm *BufferManager) reserve(pid config.PageId) (*BufferFrame, error) {
+	key := pageKey(pid)
+	// check the requested page first so a bad PageId leaves the pool untouched
+	if err := bm.dm.CheckPage(pid); err != nil {
+		return nil, err
+	}
 	// find free frame
 	for _, f := range bm.frames {
-		if f.PinCount == 0 && (f.PageId.FileIdx == -1 && f.PageId.PageIdx == -1) {
-			// use this
-			data, err := bm.dm.ReadPage(pid)
-			if err != nil {
-				return nil, err
-			}
-			copy(f.Data, data)
-			f.PageId = pid
-			f.PinCount = 1
-			f.Dirty = false
-			el := bm.repl.PushBack(f)
-			bm.lookup[key] = el
-			return f, nil
+		if f.PinCount == 0 && f.PageId == unusedPage {
+			bm.lookup[key] = bm.repl.PushBack(f)
+			return bm.claim(f, pid), nil
 		}
 	}
 	// need to evict according to policy
-	var victimEl *list.Element
-	if bm.policy == PolicyLRU {
-		victimEl = bm.repl.Front()
-	} else {
-		victimEl = bm.repl.Back()
+	if bm.repl.Len() == 0 {
+		return nil, fmt.Errorf("%w: no available frame to evict", ErrNoFreeFrame)
+	}
+	start, step := bm.repl.Front(), (*list.Element).Next
+	if bm.policy != PolicyLRU {
+		start, step = bm.repl.Back(), (*list.Element).Prev
+	}
+	// a persistent page is only taken when every other frame is pinned
+	var victimEl, persistentEl *list.Element
+	for el := start; el != nil; el = step(el) {
+		f := el.Value.(*BufferFrame)
+		if f.PinCount != 0 {
+			continue
+		}
+		if !bm.persistent[f.PageId] {
+			victimEl = el
+			break
+		}
+		if persistentEl == nil {
+			persistentEl = el
+		}
 	}
 	if victimEl == nil {
-		return nil, errors.New("no available frame to evict")
+		victimEl = persistentEl
 	}
-	victim := victimEl.Value.(*BufferFrame)
-	if victim.PinCount != 0 {
-		return nil, errors.New("all frames pinned")
+	if victimEl == nil {
+		return nil, fmt.Errorf("%w: all frames pinned", ErrNoFreeFrame)
 	}
-	// write back if dirty
+	victim := victimEl.Value.(*BufferFrame)
 	if victim.Dirty {
 		if err := bm.dm.WritePage(victim.PageId, victim.Data); err != nil {
 			return nil, err
 		}
+		victim.Dirty = false
 	}
 	delete(bm.lookup, pageKey(victim.PageId))
-	// load requested page into victim
-	data, err := bm.dm.ReadPage(pid)
-	if err != nil {
-		return nil, err
-	}
-	copy(victim.Data, data)
-	victim.PageId = pid
-	victim.PinCount = 1
-	victim.Dirty = false
 	if bm.policy == PolicyLRU {
 		bm.repl.MoveToBack(victimEl)
 	} else {
 		bm.repl.MoveToFront(victimEl)
 	}
 	bm.lookup[key] = victimEl
-	return victim, nil
+	return bm.claim(victim, pid), nil
+}
+
+// claim gives the unpinned frame f to pid and write-latches it. Nobody holds the
+// latch of an unpinned frame, so this does not block. Caller must hold bm.mu.
+func (bm *BufferManager) claim(f *BufferFrame, pid config.PageId) *BufferFrame {
+	f.latch.Lock()
+	f.PageId = pid
+	f.Dirty = false
+	f.loadErr = nil
+	return f
+}
+
+// fill reads pid into the frame reserve returned and releases its latch. On a read
+// error the frame, whose content is then undefined, is dropped from the pool, and
+// the GetPage calls waiting on the latch fail too; the caller still holds its pin.
+func (bm *BufferManager) fill(f *BufferFrame, pid config.PageId) error {
+	err := bm.dm.ReadPageInto(pid, bm.frameData(f))
+	f.loadErr = err
+	f.latch.Unlock()
+	if err == nil {
+		return nil
+	}
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	// FlushBuffers may have emptied the pool meanwhile
+	if el, ok := bm.lookup[pageKey(pid)]; ok && el.Value == f {
+		delete(bm.lookup, pageKey(pid))
+		bm.repl.Remove(el)
+		f.PageId = unusedPage
+	}
+	return err
+}
+
+// RLock takes the latch of f for reading the page in Data, Lock for changing it;
+// RUnlock and Unlock release it. The caller must hold a pin on f, and must release
+// the latch before calling back into the pool: FlushBuffers takes every latch while
+// holding the pool's mutex, so that it never writes a page halfway through a change.
+func (f *BufferFrame) RLock()   { f.latch.RLock() }
+func (f *BufferFrame) RUnlock() { f.latch.RUnlock() }
+func (f *BufferFrame) Lock()    { f.latch.Lock() }
+func (f *BufferFrame) Unlock()  { f.latch.Unlock() }
+
+// waitLoaded waits until the page f holds has been read and returns the read error.
+func (f *BufferFrame) waitLoaded() error {
+	f.latch.RLock()
+	defer f.latch.RUnlock()
+	return f.loadErr
+}
+
+// unpin drops a pin GetPage took on f for pid, which f may no longer hold.
+func (bm *BufferManager) unpin(f *BufferFrame, pid config.PageId) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if f.PinCount > 0 {
+		f.PinCount--
+		bm.recordUnpin(pid)
+	}
+}
+
+// Prefetch loads, in the background, up to PrefetchDepth pages of a chain into the
+// pool so that a sequential scan finds them there: pid first, then next(page) of each
+// loaded page until next returns an invalid PageId. next is called while the prefetch
+// pins the page and must not call the BufferManager. Pages already in the pool are not reloaded,
+// prefetched pages are left unpinned and only unpinned frames are reused, so a
+// prefetch never takes a frame a caller holds; it stops quietly at the first page it
+// cannot load, the following GetPage reporting the error if there is one. It does
+// nothing when PrefetchDepth is 0.
+func (bm *BufferManager) Prefetch(pid config.PageId, next func(page []byte) config.PageId) {
+	if bm.cfg.PrefetchDepth <= 0 || pid == unusedPage {
+		return
+	}
+	bm.prefetching.Add(1)
+	go func() {
+		defer bm.prefetching.Done()
+		for i := 0; i < bm.cfg.PrefetchDepth && pid != unusedPage; i++ {
+			pid = bm.prefetch(pid, next)
+		}
+	}()
+}
+
+// prefetch loads pid if it is not in the pool and returns the next page to prefetch,
+// or an invalid PageId to stop. The frame is pinned while it is read and next looks
+// at it, so that it cannot be evicted meanwhile.
+func (bm *BufferManager) prefetch(pid config.PageId, next func(page []byte) config.PageId) config.PageId {
+	bm.mu.Lock()
+	var f *BufferFrame
+	var err error
+	if el, ok := bm.lookup[pageKey(pid)]; ok {
+		f = el.Value.(*BufferFrame)
+		f.PinCount++
+		bm.mu.Unlock()
+		err = f.waitLoaded()
+	} else {
+		if f, err = bm.reserve(pid); err != nil {
+			bm.mu.Unlock()
+			return unusedPage
+		}
+		f.PinCount = 1
+		bm.stats.Prefetched++
+		bm.mu.Unlock()
+		err = bm.fill(f, pid)
+	}
+	nextPid := unusedPage
+	if err == nil {
+		nextPid = next(f.Data)
+	}
+	bm.mu.Lock()
+	if f.PinCount > 0 {
+		f.PinCount--
+	}
+	bm.mu.Unlock()
+	return nextPid
+}
+
+// WaitPrefetch waits for the prefetches in progress, so that nothing reads the disk
+// once it returns until the next Prefetch.
+func (bm *BufferManager) WaitPrefetch() {
+	bm.prefetching.Wait()
+}
+
+// frameData returns f.Data restored to exactly PageSize bytes, in case a caller
+// resliced it, so that a page is always read into a whole frame.
+func (bm *BufferManager) frameData(f *BufferFrame) []byte {
+	if len(f.Data) != bm.cfg.PageSize {
+		if cap(f.Data) >= bm.cfg.PageSize {
+			f.Data = f.Data[:bm.cfg.PageSize]
+		} else {
+			f.Data = make([]byte, bm.cfg.PageSize)
+		}
+	}
+	return f.Data
 }
 
 func (bm *BufferManager) FreePage(pid config.PageId, valdirty bool) error {
@@ -138,11 +426,12 @@ func (bm *BufferManager) FreePage(pid config.PageId, valdirty bool) error {
 	key := pageKey(pid)
 	el, ok := bm.lookup[key]
 	if !ok {
-		return errors.New("page not found in buffers")
+		return ErrPageNotBuffered
 	}
 	f := el.Value.(*BufferFrame)
 	if f.PinCount > 0 {
 		f.PinCount--
+		bm.recordUnpin(pid)
 	}
 	if valdirty {
 		f.Dirty = true
@@ -150,24 +439,141 @@ func (bm *BufferManager) FreePage(pid config.PageId, valdirty bool) error {
 	return nil
 }
 
+// PinPersistent loads pid into the pool and keeps it there across FreePage calls,
+// until ReleasePersistent: eviction takes any other unpinned frame first, and only
+// falls back on a persistent page when all the others are pinned, so that pages
+// kept this way never make GetPage fail. FlushBuffers still empties the pool; the
+// page is kept again once it is read back. It does not pin the page.
+func (bm *BufferManager) PinPersistent(pid config.PageId) error {
+	if _, err := bm.GetPage(pid); err != nil {
+		return err
+	}
+	bm.mu.Lock()
+	bm.persistent[pid] = true
+	bm.mu.Unlock()
+	return bm.FreePage(pid, false)
+}
+
+// ReleasePersistent makes pid an ordinary page again, evicted like any other. It is
+// a no-op for a page PinPersistent was not called on.
+func (bm *BufferManager) ReleasePersistent(pid config.PageId) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	delete(bm.persistent, pid)
+}
+
+// HoldsFile reports whether the pool holds a page of data file fileIdx, pinned or
+// not; see disk.DiskManager.RemoveEmptyFiles.
+func (bm *BufferManager) HoldsFile(fileIdx int) bool {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	for _, el := range bm.lookup {
+		if el.Value.(*BufferFrame).PageId.FileIdx == fileIdx {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDebug enables or disables pin tracking. In debug mode every GetPage records its
+// call site until the matching FreePage, so AssertAllUnpinned can report where a
+// leaked pin came from.
+func (bm *BufferManager) SetDebug(on bool) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.debug = on
+	bm.pinSites = make(map[config.PageId][]string)
+}
+
+// AssertAllUnpinned returns an error describing every frame that is still pinned
+// (with the pinning call sites when debug mode is on), or nil if none is.
+func (bm *BufferManager) AssertAllUnpinned() error {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	var leaks []string
+	for _, f := range bm.frames {
+		if f.PinCount == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("page %s pinned %d time(s)", pageKey(f.PageId), f.PinCount)
+		if sites := bm.pinSites[f.PageId]; len(sites) > 0 {
+			msg += " by " + strings.Join(sites, ", ")
+		}
+		leaks = append(leaks, msg)
+	}
+	if len(leaks) > 0 {
+		return fmt.Errorf("leaked pins: %s", strings.Join(leaks, "; "))
+	}
+	return nil
+}
+
+// recordPin remembers the caller of GetPage in debug mode. Caller must hold bm.mu.
+func (bm *BufferManager) recordPin(pid config.PageId) {
+	if !bm.debug {
+		return
+	}
+	site := "unknown"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		site = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	bm.pinSites[pid] = append(bm.pinSites[pid], site)
+}
+
+// recordUnpin drops the most recent pin site of pid. Caller must hold bm.mu.
+func (bm *BufferManager) recordUnpin(pid config.PageId) {
+	if !bm.debug {
+		return
+	}
+	if sites := bm.pinSites[pid]; len(sites) > 0 {
+		bm.pinSites[pid] = sites[:len(sites)-1]
+	}
+}
+
+// Stats returns the GetPage counters.
+func (bm *BufferManager) Stats() BufferStats {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.stats
+}
+
+// ResetStats zeroes the GetPage counters.
+func (bm *BufferManager) ResetStats() {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.stats = BufferStats{}
+}
+
 func (bm *BufferManager) SetCurrentReplacementPolicy(policy string) {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 	bm.policy = ReplacementPolicy(policy)
 }
 
+// FlushBuffers writes every dirty frame back in one batched disk write, then empties
+// the pool. It waits for the pages being read to be in their frames first.
 func (bm *BufferManager) FlushBuffers() error {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
+	// a read in progress holds its frame's latch, not bm.mu, until it completes
 	for _, f := range bm.frames {
-		if f.Dirty && f.PageId != (config.PageId{}) {
-			if err := bm.dm.WritePage(f.PageId, f.Data); err != nil {
-				return err
-			}
-			f.Dirty = false
+		f.latch.Lock()
+		defer f.latch.Unlock()
+	}
+	dirty := make(map[config.PageId][]byte)
+	for _, f := range bm.frames {
+		if f.Dirty && f.PageId != unusedPage {
+			dirty[f.PageId] = f.Data
 		}
+	}
+	if len(dirty) > 0 {
+		if err := bm.dm.WritePages(dirty); err != nil {
+			return err
+		}
+	}
+	for _, f := range bm.frames {
+		f.Dirty = false
 		// reset frame
-		f.PageId = config.PageId{FileIdx: -1, PageIdx: -1}
+		f.PageId = unusedPage
 		f.PinCount = 0
 		for i := range f.Data {
 			f.Data[i] = 0
@@ -175,5 +581,8 @@ func (bm *BufferManager) FlushBuffers() error {
 	}
 	bm.repl.Init()
 	bm.lookup = make(map[string]*list.Element)
+	if bm.debug {
+		bm.pinSites = make(map[config.PageId][]string)
+	}
 	return nil
 }
@@ -1,7 +1,13 @@
 package buffer
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"malzahar-project/Projet_BDDA/config"
 	"malzahar-project/Projet_BDDA/disk"
@@ -78,3 +84,581 @@ func TestBufferManagerMRU(t *testing.T) {
 		t.Fatalf("get p3: %v", err)
 	}
 }
+
+func TestFlushBuffersWritesPageZero(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 512, 2)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("alloc: %v", err)
+	}
+	if pid != (config.PageId{}) {
+		t.Fatalf("expected first page to be {0,0}, got %v", pid)
+	}
+	bf, err := bm.GetPage(pid)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	copy(bf.Data, "page zero")
+	if err := bm.FreePage(pid, true); err != nil {
+		t.Fatalf("free: %v", err)
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	got, err := dm.ReadPage(pid)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(got[:9]) != "page zero" {
+		t.Fatalf("dirty page {0,0} was not flushed: %q", got[:9])
+	}
+}
+
+func BenchmarkFlushBuffers(b *testing.B) {
+	cfg := config.NewDBConfigWithParams(b.TempDir(), 4096, 4)
+	cfg.BMBufferCount = 64
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		b.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	var pids []config.PageId
+	for i := 0; i < cfg.BMBufferCount; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			b.Fatalf("alloc: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// dirty every frame, then flush them all
+		for _, pid := range pids {
+			bf, err := bm.GetPage(pid)
+			if err != nil {
+				b.Fatalf("get: %v", err)
+			}
+			bf.Data[0] = byte(i)
+			if err := bm.FreePage(pid, true); err != nil {
+				b.Fatalf("free: %v", err)
+			}
+		}
+		if err := bm.FlushBuffers(); err != nil {
+			b.Fatalf("flush: %v", err)
+		}
+	}
+}
+
+func TestAssertAllUnpinnedReportsLeak(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 2)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	bm.SetDebug(true)
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("alloc: %v", err)
+	}
+	if _, err := bm.GetPage(pid); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	err = bm.AssertAllUnpinned()
+	if err == nil || !strings.Contains(err.Error(), "manager_test.go") {
+		t.Fatalf("expected leak report with call site, got %v", err)
+	}
+	if err := bm.FreePage(pid, false); err != nil {
+		t.Fatalf("free: %v", err)
+	}
+	if err := bm.AssertAllUnpinned(); err != nil {
+		t.Fatalf("unexpected leak after FreePage: %v", err)
+	}
+}
+
+func TestGetPageBadIdKeepsVictim(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 1)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("alloc: %v", err)
+	}
+	fr, err := bm.GetPage(pid)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	fr.Data[0] = 42
+	if err := bm.FreePage(pid, true); err != nil {
+		t.Fatalf("free: %v", err)
+	}
+	if _, err := bm.GetPage(config.PageId{FileIdx: 0, PageIdx: 99}); err == nil {
+		t.Fatalf("expected error for unallocated page")
+	}
+	fr2, err := bm.GetPage(pid)
+	if err != nil {
+		t.Fatalf("get again: %v", err)
+	}
+	if fr2 != fr || fr2.Data[0] != 42 || !fr2.Dirty {
+		t.Fatalf("cached page lost after failed eviction")
+	}
+	_ = bm.FreePage(pid, false)
+}
+
+func TestEvictionSkipsPinnedFrames(t *testing.T) {
+	for _, policy := range []string{"LRU", "MRU"} {
+		cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 1)
+		cfg.BMBufferCount = 3
+		cfg.BMPolicy = policy
+		dm := disk.NewDiskManager(cfg)
+		if err := dm.Init(); err != nil {
+			t.Fatalf("dm init: %v", err)
+		}
+		bm := NewBufferManager(cfg, dm)
+		var pids []config.PageId
+		for i := 0; i < 5; i++ {
+			pid, err := dm.AllocatePage()
+			if err != nil {
+				t.Fatalf("alloc: %v", err)
+			}
+			pids = append(pids, pid)
+		}
+		// pids[0] stays pinned, as a scan keeps its data page, while the other
+		// frames churn; with MRU pids[2] is the most recent one and is pinned too
+		for i := 0; i < 3; i++ {
+			if _, err := bm.GetPage(pids[i]); err != nil {
+				t.Fatalf("%s: get %d: %v", policy, i, err)
+			}
+			if i == 1 {
+				_ = bm.FreePage(pids[i], false)
+			}
+		}
+		if _, err := bm.GetPage(pids[3]); err != nil {
+			t.Fatalf("%s: a free frame behind a pinned one should be evicted: %v", policy, err)
+		}
+		if _, err := bm.GetPage(pids[4]); !errors.Is(err, ErrNoFreeFrame) {
+			t.Fatalf("%s: every frame is pinned, GetPage should fail with ErrNoFreeFrame, got %v", policy, err)
+		}
+		if err := bm.FreePage(pids[4], false); !errors.Is(err, ErrPageNotBuffered) {
+			t.Fatalf("%s: FreePage of a page not in the pool = %v, want ErrPageNotBuffered", policy, err)
+		}
+		if err := bm.FreePage(pids[0], false); err != nil {
+			t.Fatalf("%s: pinned page was evicted: %v", policy, err)
+		}
+	}
+}
+
+func TestPageFaultsReadCorrectData(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	cfg.BMBufferCount = 3
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	var pids []config.PageId
+	for i := 0; i < 10; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("alloc: %v", err)
+		}
+		page := make([]byte, 512)
+		for j := range page {
+			page[j] = byte(i + j)
+		}
+		if err := dm.WritePage(pid, page); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	// cycle through more pages than frames, so every read after the first round is
+	// an eviction; one caller shortens a frame's slice, which must not stick
+	for round := 0; round < 3; round++ {
+		for i, pid := range pids {
+			bf, err := bm.GetPage(pid)
+			if err != nil {
+				t.Fatalf("get %v: %v", pid, err)
+			}
+			if len(bf.Data) != 512 {
+				t.Fatalf("frame data is %d bytes, want 512", len(bf.Data))
+			}
+			for j, b := range bf.Data {
+				if b != byte(i+j) {
+					t.Fatalf("round %d page %d byte %d = %d, want %d", round, i, j, b, byte(i+j))
+				}
+			}
+			if i == 4 {
+				bf.Data = bf.Data[:8]
+			}
+			if err := bm.FreePage(pid, false); err != nil {
+				t.Fatalf("free: %v", err)
+			}
+		}
+	}
+	if st := bm.Stats(); st.Misses != 30 {
+		t.Fatalf("misses = %d, want 30", st.Misses)
+	}
+}
+
+// TestGetPages pins pages in bulk: the frames come back in order with the pages
+// data, and a request for more pages than the pool can hold fails with
+// ErrNoFreeFrame, unpinning what it had pinned and leaving the pool usable.
+func TestGetPages(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	cfg.BMBufferCount = 3
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	var pids []config.PageId
+	for i := 0; i < 5; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("alloc: %v", err)
+		}
+		page := make([]byte, 512)
+		page[0] = byte(i + 1)
+		if err := dm.WritePage(pid, page); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	freeAll := func(want []config.PageId) {
+		t.Helper()
+		for _, pid := range want {
+			if err := bm.FreePage(pid, false); err != nil {
+				t.Fatalf("free %v: %v", pid, err)
+			}
+		}
+	}
+
+	// a page listed twice is pinned twice, in the same frame
+	want := []config.PageId{pids[0], pids[1], pids[0]}
+	frames, err := bm.GetPages(want)
+	if err != nil {
+		t.Fatalf("GetPages: %v", err)
+	}
+	for i, f := range frames {
+		if f.PageId != want[i] || f.Data[0] != byte(want[i].PageIdx+1) {
+			t.Fatalf("frame %d holds %v byte %d, want %v", i, f.PageId, f.Data[0], want[i])
+		}
+	}
+	if frames[0] != frames[2] || frames[0].PinCount != 2 {
+		t.Fatalf("duplicate page: same frame %v, pin count %d", frames[0] == frames[2], frames[0].PinCount)
+	}
+	freeAll(want)
+
+	// more pages than frames
+	if _, err := bm.GetPages(pids[:4]); !errors.Is(err, ErrNoFreeFrame) {
+		t.Fatalf("GetPages of 4 pages in 3 frames = %v, want ErrNoFreeFrame", err)
+	}
+	if err := bm.AssertAllUnpinned(); err != nil {
+		t.Fatalf("after a failed GetPages: %v", err)
+	}
+	// fewer pages than frames, but one frame is held elsewhere; the hit on it is
+	// rolled back too
+	if _, err := bm.GetPage(pids[4]); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if _, err := bm.GetPages([]config.PageId{pids[4], pids[1], pids[2], pids[3]}); !errors.Is(err, ErrNoFreeFrame) {
+		t.Fatalf("GetPages with a frame held = %v, want ErrNoFreeFrame", err)
+	}
+	freeAll(pids[4:])
+	if err := bm.AssertAllUnpinned(); err != nil {
+		t.Fatalf("after a failed GetPages: %v", err)
+	}
+
+	frames, err = bm.GetPages(pids[2:])
+	if err != nil {
+		t.Fatalf("GetPages after failures: %v", err)
+	}
+	for i, f := range frames {
+		if f.Data[0] != byte(i+3) {
+			t.Fatalf("page %d byte %d, want %d", i+2, f.Data[0], i+3)
+		}
+	}
+	freeAll(pids[2:])
+	if err := bm.AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPinPersistentSurvivesEviction cycles data pages through a small LRU pool: a
+// page kept with PinPersistent stays resident while they evict each other, and is
+// evicted like the others once released.
+func TestPinPersistentSurvivesEviction(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	cfg.BMBufferCount = 3
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	pids := make([]config.PageId, 8)
+	for i := range pids {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("alloc: %v", err)
+		}
+		pids[i] = pid
+	}
+	header, data := pids[0], pids[1:]
+	if err := bm.PinPersistent(header); err != nil {
+		t.Fatalf("PinPersistent: %v", err)
+	}
+	scan := func() {
+		for _, pid := range data {
+			if _, err := bm.GetPage(pid); err != nil {
+				t.Fatalf("get %v: %v", pid, err)
+			}
+			if err := bm.FreePage(pid, false); err != nil {
+				t.Fatalf("free %v: %v", pid, err)
+			}
+		}
+	}
+	headerHit := func() bool {
+		bm.ResetStats()
+		if _, err := bm.GetPage(header); err != nil {
+			t.Fatalf("get header: %v", err)
+		}
+		if err := bm.FreePage(header, false); err != nil {
+			t.Fatalf("free header: %v", err)
+		}
+		return bm.Stats().Hits == 1
+	}
+	scan()
+	if !headerHit() {
+		t.Fatalf("persistent page evicted by the scan")
+	}
+	// with every other frame pinned, the persistent page is given up rather than
+	// failing the request
+	for _, pid := range data[:2] {
+		if _, err := bm.GetPage(pid); err != nil {
+			t.Fatalf("pin %v: %v", pid, err)
+		}
+	}
+	if _, err := bm.GetPage(data[2]); err != nil {
+		t.Fatalf("get with only the persistent page evictable: %v", err)
+	}
+	for _, pid := range data[:3] {
+		if err := bm.FreePage(pid, false); err != nil {
+			t.Fatalf("free %v: %v", pid, err)
+		}
+	}
+	if headerHit() {
+		t.Fatalf("persistent page should have made room")
+	}
+	bm.ReleasePersistent(header)
+	scan()
+	if headerHit() {
+		t.Fatalf("released page still kept resident")
+	}
+	if err := bm.AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentGetPage has goroutines read their own pages, and one page they all
+// share, through a pool too small to hold them, so that reads, hits waiting on a
+// frame being read and evictions interleave. Run it with -race.
+func TestConcurrentGetPage(t *testing.T) {
+	const workers, perWorker, rounds = 8, 4, 50
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	cfg.BMBufferCount = workers + 4
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	pids := make([]config.PageId, 1+workers*perWorker)
+	for i := range pids {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("alloc: %v", err)
+		}
+		page := make([]byte, 512)
+		for j := range page {
+			page[j] = byte(i + j)
+		}
+		if err := dm.WritePage(pid, page); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		pids[i] = pid
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				// page 0 is shared, the others belong to this worker
+				for _, i := range []int{0, 1 + w*perWorker + r%perWorker} {
+					bf, err := bm.GetPage(pids[i])
+					if err != nil {
+						errs <- err
+						return
+					}
+					for j, b := range bf.Data {
+						if b != byte(i+j) {
+							errs <- fmt.Errorf("page %d byte %d = %d, want %d", i, j, b, byte(i+j))
+							return
+						}
+					}
+					if err := bm.FreePage(pids[i], false); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if err := bm.AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+	if st := bm.Stats(); st.Requests != workers*rounds*2 || st.Hits+st.Misses != st.Requests {
+		t.Fatalf("stats %+v after %d requests", st, workers*rounds*2)
+	}
+}
+
+// BenchmarkGetPageParallel measures GetPage from parallel goroutines over 256 pages,
+// a quarter of which fit in the pool. A page fault only holds the pool's mutex to
+// pick the frame, so hits and faults on other pages go on during the read; with a
+// store whose reads take time ("slow-store"), the faults overlap too.
+func BenchmarkGetPageParallel(b *testing.B) {
+	for _, tc := range []struct {
+		name  string
+		store func(cfg *config.DBConfig) disk.PageStore
+	}{
+		{"disk", func(cfg *config.DBConfig) disk.PageStore {
+			dm := disk.NewDiskManager(cfg)
+			if err := dm.Init(); err != nil {
+				b.Fatalf("dm init: %v", err)
+			}
+			return dm
+		}},
+		{"slow-store", func(cfg *config.DBConfig) disk.PageStore {
+			st := newFakeStore(cfg.PageSize)
+			st.readDelay = 20 * time.Microsecond
+			return st
+		}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			cfg := config.NewDBConfigWithParams(b.TempDir(), 4096, 4)
+			cfg.BMBufferCount = 64
+			st := tc.store(cfg)
+			bm := NewBufferManager(cfg, st)
+			var pids []config.PageId
+			for i := 0; i < 256; i++ {
+				pid, err := st.AllocatePage()
+				if err != nil {
+					b.Fatalf("alloc: %v", err)
+				}
+				pids = append(pids, pid)
+			}
+			var seed int64
+			var seedMu sync.Mutex
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				seedMu.Lock()
+				seed++
+				rng := rand.New(rand.NewSource(seed))
+				seedMu.Unlock()
+				for pb.Next() {
+					pid := pids[rng.Intn(len(pids))]
+					if _, err := bm.GetPage(pid); err != nil {
+						b.Errorf("get: %v", err)
+						return
+					}
+					if err := bm.FreePage(pid, false); err != nil {
+						b.Errorf("free: %v", err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetPageFault measures page faults: four frames cycle through 64 pages,
+// so every GetPage evicts a frame and reads the page into it.
+func BenchmarkGetPageFault(b *testing.B) {
+	cfg := config.NewDBConfigWithParams(b.TempDir(), 4096, 4)
+	cfg.BMBufferCount = 4
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		b.Fatalf("dm init: %v", err)
+	}
+	bm := NewBufferManager(cfg, dm)
+	var pids []config.PageId
+	for i := 0; i < 64; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			b.Fatalf("alloc: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pid := pids[i%len(pids)]
+		if _, err := bm.GetPage(pid); err != nil {
+			b.Fatalf("get: %v", err)
+		}
+		if err := bm.FreePage(pid, false); err != nil {
+			b.Fatalf("free: %v", err)
+		}
+	}
+}
+
+// TestFlushWaitsForWriteLatch checks that FlushBuffers does not write a page while
+// its user holds the frame's write latch halfway through a change.
+func TestFlushWaitsForWriteLatch(t *testing.T) {
+	st := newFakeStore(64)
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 64, 1)
+	bm := NewBufferManager(cfg, st)
+	pid, _ := st.AllocatePage()
+	f, err := bm.GetPage(pid)
+	if err != nil {
+		t.Fatalf("GetPage: %v", err)
+	}
+	f.Lock()
+	f.Data[0] = 1
+	done := make(chan error, 1)
+	go func() { done <- bm.FlushBuffers() }()
+	select {
+	case err := <-done:
+		f.Unlock()
+		t.Fatalf("FlushBuffers did not wait for the latch (err %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	f.Data[1] = 2
+	f.Dirty = true
+	f.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("FlushBuffers: %v", err)
+	}
+	page, err := st.ReadPage(pid)
+	if err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+	if page[0] != 1 || page[1] != 2 {
+		t.Fatalf("flushed page starts % x, want the whole change", page[:2])
+	}
+}
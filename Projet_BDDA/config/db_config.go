@@ -2,8 +2,12 @@ package config
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -16,6 +20,103 @@ type DBConfig struct {
 	DMMaxFileCount int    `json:"dm_maxfilecount"`
 	BMBufferCount  int    `json:"bm_buffercount"`
 	BMPolicy       string `json:"bm_policy"`
+	// BMKeepHeaders keeps the header page of every table in the buffer pool (see
+	// buffer.BufferManager.PinPersistent): eviction takes data pages first, so that a
+	// long scan does not push out the headers every insert and delete reads.
+	BMKeepHeaders bool `json:"bm_keep_headers"`
+	// BinDir overrides where Data*.bin, bitmaps and .hdr files live; when empty they
+	// are stored under DBPath/BinData. database.save always stays in DBPath.
+	BinDir string `json:"bin_dir"`
+	// StrictStrings rejects CHAR/VARCHAR values longer than the column size instead
+	// of silently truncating them.
+	StrictStrings bool `json:"strict_strings"`
+	// RequirePow2PageSize makes Validate reject page sizes that are not a power of two.
+	RequirePow2PageSize bool `json:"require_pow2_pagesize"`
+	// SyncMode controls when data files are fsynced: SyncAlways (default),
+	// SyncBatch or SyncNever.
+	SyncMode string `json:"sync_mode"`
+	// CSVComment, when not empty, makes APPEND skip CSV lines starting with this
+	// prefix (e.g. "#"). Empty disables comment skipping.
+	CSVComment string `json:"csv_comment"`
+	// FillFactor, between 0 and 1, is the share of a data page's slots that inserts
+	// fill before the page counts as full, leaving the rest for later inserts. 0 (the
+	// default) and 1 both fill pages completely.
+	FillFactor float64 `json:"fill_factor"`
+	// SortMemoryRows is how many rows ORDER BY sorts in memory before spilling sorted
+	// runs to temporary files under DBPath. 0 uses DefaultSortMemoryRows.
+	SortMemoryRows int `json:"sort_memory_rows"`
+	// DistinctMemoryRows is how many distinct rows SELECT DISTINCT tracks in memory
+	// before partitioning the rows into temporary files under DBPath. 0 uses
+	// DefaultDistinctMemoryRows.
+	DistinctMemoryRows int `json:"distinct_memory_rows"`
+	// WAL makes every page write go through a write-ahead log, DBPath/wal.log, that
+	// is fsynced before the data files are touched and replayed on startup.
+	WAL bool `json:"wal"`
+	// CheckpointEvery checkpoints (flush, fsync, WAL truncation) after this many
+	// commands; CheckpointInterval after this many seconds, checked after each
+	// command. 0 disables either trigger.
+	CheckpointEvery    int `json:"checkpoint_every"`
+	CheckpointInterval int `json:"checkpoint_interval"`
+	// PageReserveBytes is reserved at the start of every relation page, before the
+	// page's own header, for page-level metadata. 0 (the default) is the historical
+	// layout. It is fixed when the database is created: the DiskManager records it
+	// and refuses to open the data files with another value.
+	PageReserveBytes int `json:"page_reserve_bytes"`
+	// PrefetchDepth is how many pages ahead of a sequential scan the buffer pool
+	// loads in the background. 0 disables read-ahead.
+	PrefetchDepth int `json:"prefetch_depth"`
+	// QueryTimeoutMs aborts a command whose table scans run longer than this many
+	// milliseconds. 0 disables the timeout; SET query_timeout_ms changes it.
+	QueryTimeoutMs int `json:"query_timeout_ms"`
+	// ByteOrder is how integers and floats are encoded in records, page headers and
+	// header location files: ByteOrderLittle (the default) or ByteOrderBig. Changing
+	// it makes existing pages unreadable.
+	ByteOrder string `json:"byte_order"`
+}
+
+// In-memory row limits used when SortMemoryRows or DistinctMemoryRows is 0.
+const (
+	DefaultSortMemoryRows     = 100000
+	DefaultDistinctMemoryRows = 100000
+)
+
+// MemoryDBPath as DBPath keeps the pages in memory instead of data files: the
+// database starts empty and is lost on exit. See InMemory.
+const MemoryDBPath = ":memory:"
+
+// InMemory reports whether DBPath is MemoryDBPath.
+func (c *DBConfig) InMemory() bool {
+	return c.DBPath == MemoryDBPath
+}
+
+// Durability modes for DBConfig.SyncMode.
+const (
+	// SyncAlways fsyncs after every page write.
+	SyncAlways = "always"
+	// SyncBatch fsyncs only on batched flushes and on Finish.
+	SyncBatch = "batch"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever = "never"
+)
+
+// Byte orders for DBConfig.ByteOrder.
+const (
+	ByteOrderLittle = "little"
+	ByteOrderBig    = "big"
+)
+
+// ByteOrder encodes and decodes the integers stored on disk.
+type ByteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// Order returns the byte order chosen by c.ByteOrder, little-endian when empty.
+func (c *DBConfig) Order() ByteOrder {
+	if c.ByteOrder == ByteOrderBig {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
 }
 
 // PageId identifies a page inside a Data file: FileIdx is the index x in Datax.bin
@@ -28,18 +129,58 @@ type PageId struct {
 // NewDBConfig constructs an instance from an in-memory path with default params.
 // To provide explicit page size and max file count use NewDBConfigWithParams.
 func NewDBConfig(dbpath string) *DBConfig {
-	return &DBConfig{DBPath: dbpath, PageSize: 4096, DMMaxFileCount: 8, BMBufferCount: 16, BMPolicy: "LRU"}
+	return &DBConfig{DBPath: dbpath, PageSize: 4096, DMMaxFileCount: 8, BMBufferCount: 16, BMPolicy: "LRU", SyncMode: SyncAlways}
 }
 
 // NewDBConfigWithParams constructs a DBConfig with explicit parameters.
 func NewDBConfigWithParams(dbpath string, pageSize int, dmMaxFileCount int) *DBConfig {
-	return &DBConfig{DBPath: dbpath, PageSize: pageSize, DMMaxFileCount: dmMaxFileCount, BMBufferCount: 16, BMPolicy: "LRU"}
+	return &DBConfig{DBPath: dbpath, PageSize: pageSize, DMMaxFileCount: dmMaxFileCount, BMBufferCount: 16, BMPolicy: "LRU", SyncMode: SyncAlways}
+}
+
+// NewDBConfigWithBinDir is NewDBConfigWithParams with data files stored in binDir
+// instead of DBPath/BinData.
+func NewDBConfigWithBinDir(dbpath string, binDir string, pageSize int, dmMaxFileCount int) *DBConfig {
+	c := NewDBConfigWithParams(dbpath, pageSize, dmMaxFileCount)
+	c.BinDir = binDir
+	return c
 }
 
-// LoadDBConfig loads configuration from a text file. The loader accepts either JSON
-// (e.g. {"dbpath":"./DB"}) or a simple key=value format (e.g. dbpath = '../DB').
+// LoadDBConfig loads configuration from a text file, given as a path or a file:// URL.
+// The loader accepts JSON (e.g. {"dbpath":"./DB"}), YAML with nested sections
+// (.yaml/.yml files or sniffed content) or a simple key=value format
+// (e.g. dbpath = '../DB').
+// GOBUFFER_* environment variables (see EnvDBPath and friends) override file values.
 func LoadDBConfig(filePath string) (*DBConfig, error) {
-	data, err := os.ReadFile(filePath)
+	if strings.HasPrefix(filePath, "file://") {
+		u, err := url.Parse(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config URL %q: %v", filePath, err)
+		}
+		filePath = u.Path
+		if u.Host != "" && u.Host != "localhost" {
+			// file://dir/config.txt: treat the host part as a relative directory
+			filePath = u.Host + u.Path
+		}
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseDBConfig(f, isYAMLPath(filePath))
+}
+
+// ParseDBConfig is LoadDBConfig reading the configuration from r (stdin, an embedded
+// string...). The format is detected from the content: JSON first, then YAML, then
+// key=value.
+func ParseDBConfig(r io.Reader) (*DBConfig, error) {
+	return parseDBConfig(r, false)
+}
+
+// parseDBConfig reads and parses a config; yaml forces the YAML parser (for files
+// named .yaml/.yml) instead of sniffing.
+func parseDBConfig(r io.Reader, yaml bool) (*DBConfig, error) {
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
@@ -48,9 +189,22 @@ func LoadDBConfig(filePath string) (*DBConfig, error) {
 	}
 
 	var c DBConfig
+	if yaml {
+		if err := parseYAMLConfig(data, &c); err != nil {
+			return nil, err
+		}
+		return finishConfig(&c)
+	}
 	// try JSON first
-	if err := json.Unmarshal(data, &c); err == nil && c.DBPath != "" {
-		return &c, nil
+	if err := json.Unmarshal(data, &c); err == nil {
+		return finishConfig(&c)
+	}
+	if looksLikeYAML(data) {
+		c = DBConfig{}
+		if err := parseYAMLConfig(data, &c); err != nil {
+			return nil, err
+		}
+		return finishConfig(&c)
 	}
 
 	// fallback to simple key=value parser
@@ -62,61 +216,200 @@ func LoadDBConfig(filePath string) (*DBConfig, error) {
 		}
 		// support dbpath = '...'
 		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			val := strings.TrimSpace(parts[1])
-			val = strings.Trim(val, `"'`)
-			if key == "dbpath" {
-				c.DBPath = val
-				// don't return yet; we may also parse other params
-			}
-			if key == "pagesize" {
-				// try parse int
-				if v, err := strconv.Atoi(val); err == nil {
-					c.PageSize = v
-				}
-			}
-			if key == "dm_maxfilecount" || key == "dm.maxfilecount" {
-				if v, err := strconv.Atoi(val); err == nil {
-					c.DMMaxFileCount = v
-				}
-			}
-			if key == "bm_buffercount" {
-				if v, err := strconv.Atoi(val); err == nil {
-					c.BMBufferCount = v
-				}
-			}
-			if key == "bm_policy" {
-				c.BMPolicy = val
-			}
+			setConfigKey(&c, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
 		}
 		// support dbpath: ...
 		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			val := strings.TrimSpace(parts[1])
-			val = strings.Trim(val, `"'`)
-			if key == "dbpath" {
-				c.DBPath = val
-			}
-			if key == "pagesize" {
-				if v, err := strconv.Atoi(val); err == nil {
-					c.PageSize = v
-				}
-			}
-			if key == "dm_maxfilecount" || key == "dm.maxfilecount" {
-				if v, err := strconv.Atoi(val); err == nil {
-					c.DMMaxFileCount = v
-				}
-			}
-			if key == "bm_buffercount" {
-				if v, err := strconv.Atoi(val); err == nil {
-					c.BMBufferCount = v
-				}
+			setConfigKey(&c, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+	return finishConfig(&c)
+}
+
+// setConfigKey assigns one key/value pair from a text config. Unknown keys and
+// malformed numbers are ignored.
+func setConfigKey(c *DBConfig, key string, val string) {
+	val = strings.Trim(val, `"'`)
+	switch key {
+	case "dbpath":
+		c.DBPath = val
+	case "pagesize":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.PageSize = v
+		}
+	case "dm_maxfilecount", "dm.maxfilecount":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.DMMaxFileCount = v
+		}
+	case "bm_buffercount":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.BMBufferCount = v
+		}
+	case "bm_policy":
+		c.BMPolicy = val
+	case "bm_keep_headers":
+		if v, err := strconv.ParseBool(val); err == nil {
+			c.BMKeepHeaders = v
+		}
+	case "bin_dir":
+		c.BinDir = val
+	case "sync_mode":
+		c.SyncMode = val
+	case "strict_strings":
+		if v, err := strconv.ParseBool(val); err == nil {
+			c.StrictStrings = v
+		}
+	case "csv_comment":
+		c.CSVComment = val
+	case "fill_factor":
+		if v, err := strconv.ParseFloat(val, 64); err == nil {
+			c.FillFactor = v
+		}
+	case "sort_memory_rows":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.SortMemoryRows = v
+		}
+	case "distinct_memory_rows":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.DistinctMemoryRows = v
+		}
+	case "checkpoint_every":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.CheckpointEvery = v
+		}
+	case "checkpoint_interval":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.CheckpointInterval = v
+		}
+	case "page_reserve_bytes":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.PageReserveBytes = v
+		}
+	case "prefetch_depth":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.PrefetchDepth = v
+		}
+	case "query_timeout_ms":
+		if v, err := strconv.Atoi(val); err == nil {
+			c.QueryTimeoutMs = v
+		}
+	case "byte_order":
+		c.ByteOrder = strings.ToLower(val)
+	case "wal":
+		if v, err := strconv.ParseBool(val); err == nil {
+			c.WAL = v
+		}
+	case "require_pow2_pagesize":
+		if v, err := strconv.ParseBool(val); err == nil {
+			c.RequirePow2PageSize = v
+		}
+	}
+}
+
+// Environment variables overriding config file values (env > file > defaults).
+const (
+	EnvDBPath              = "GOBUFFER_DBPATH"
+	EnvPageSize            = "GOBUFFER_PAGESIZE"
+	EnvDMMaxFileCount      = "GOBUFFER_DM_MAXFILECOUNT"
+	EnvBMBufferCount       = "GOBUFFER_BM_BUFFERCOUNT"
+	EnvBMPolicy            = "GOBUFFER_BM_POLICY"
+	EnvBMKeepHeaders       = "GOBUFFER_BM_KEEP_HEADERS"
+	EnvBinDir              = "GOBUFFER_BIN_DIR"
+	EnvStrictStrings       = "GOBUFFER_STRICT_STRINGS"
+	EnvRequirePow2PageSize = "GOBUFFER_REQUIRE_POW2_PAGESIZE"
+	EnvSyncMode            = "GOBUFFER_SYNC_MODE"
+	EnvCSVComment          = "GOBUFFER_CSV_COMMENT"
+	EnvFillFactor          = "GOBUFFER_FILL_FACTOR"
+	EnvSortMemoryRows      = "GOBUFFER_SORT_MEMORY_ROWS"
+	EnvDistinctMemoryRows  = "GOBUFFER_DISTINCT_MEMORY_ROWS"
+	EnvWAL                 = "GOBUFFER_WAL"
+	EnvCheckpointEvery     = "GOBUFFER_CHECKPOINT_EVERY"
+	EnvCheckpointInterval  = "GOBUFFER_CHECKPOINT_INTERVAL"
+	EnvPageReserveBytes    = "GOBUFFER_PAGE_RESERVE_BYTES"
+	EnvPrefetchDepth       = "GOBUFFER_PREFETCH_DEPTH"
+	EnvQueryTimeoutMs      = "GOBUFFER_QUERY_TIMEOUT_MS"
+	EnvByteOrder           = "GOBUFFER_BYTE_ORDER"
+)
+
+// applyEnvOverrides replaces config values with the GOBUFFER_* environment variables
+// that are set. Malformed numeric or boolean values are reported as errors.
+func applyEnvOverrides(c *DBConfig) error {
+	if v, ok := os.LookupEnv(EnvDBPath); ok {
+		c.DBPath = v
+	}
+	if v, ok := os.LookupEnv(EnvBMPolicy); ok {
+		c.BMPolicy = v
+	}
+	if v, ok := os.LookupEnv(EnvBinDir); ok {
+		c.BinDir = v
+	}
+	if v, ok := os.LookupEnv(EnvSyncMode); ok {
+		c.SyncMode = v
+	}
+	if v, ok := os.LookupEnv(EnvCSVComment); ok {
+		c.CSVComment = v
+	}
+	if v, ok := os.LookupEnv(EnvByteOrder); ok {
+		c.ByteOrder = strings.ToLower(strings.TrimSpace(v))
+	}
+	if v, ok := os.LookupEnv(EnvFillFactor); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", EnvFillFactor, v)
+		}
+		c.FillFactor = f
+	}
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{EnvPageSize, &c.PageSize},
+		{EnvDMMaxFileCount, &c.DMMaxFileCount},
+		{EnvBMBufferCount, &c.BMBufferCount},
+		{EnvSortMemoryRows, &c.SortMemoryRows},
+		{EnvDistinctMemoryRows, &c.DistinctMemoryRows},
+		{EnvCheckpointEvery, &c.CheckpointEvery},
+		{EnvCheckpointInterval, &c.CheckpointInterval},
+		{EnvPageReserveBytes, &c.PageReserveBytes},
+		{EnvPrefetchDepth, &c.PrefetchDepth},
+		{EnvQueryTimeoutMs, &c.QueryTimeoutMs},
+	}
+	for _, e := range ints {
+		if v, ok := os.LookupEnv(e.name); ok {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("%s: invalid integer %q", e.name, v)
 			}
-			if key == "bm_policy" {
-				c.BMPolicy = val
+			*e.dst = n
+		}
+	}
+	bools := []struct {
+		name string
+		dst  *bool
+	}{
+		{EnvStrictStrings, &c.StrictStrings},
+		{EnvBMKeepHeaders, &c.BMKeepHeaders},
+		{EnvRequirePow2PageSize, &c.RequirePow2PageSize},
+		{EnvWAL, &c.WAL},
+	}
+	for _, e := range bools {
+		if v, ok := os.LookupEnv(e.name); ok {
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("%s: invalid boolean %q", e.name, v)
 			}
+			*e.dst = b
 		}
 	}
+	return nil
+}
+
+// finishConfig applies environment overrides and defaults to a parsed config, then
+// validates it.
+func finishConfig(c *DBConfig) (*DBConfig, error) {
+	if err := applyEnvOverrides(c); err != nil {
+		return nil, err
+	}
 	if c.DBPath == "" {
 		return nil, errors.New("dbpath not found in config")
 	}
@@ -134,5 +427,79 @@ func LoadDBConfig(filePath string) (*DBConfig, error) {
 	if c.BMPolicy == "" {
 		c.BMPolicy = "LRU"
 	}
-	return &c, nil
+	if c.SyncMode == "" {
+		c.SyncMode = SyncAlways
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks that the configuration values are usable.
+func (c *DBConfig) Validate() error {
+	if c.DBPath == "" {
+		return errors.New("dbpath is empty")
+	}
+	if c.PageSize <= 0 {
+		return fmt.Errorf("invalid pagesize %d", c.PageSize)
+	}
+	if c.RequirePow2PageSize && !isPowerOfTwo(c.PageSize) {
+		return fmt.Errorf("pagesize %d is not a power of two", c.PageSize)
+	}
+	if c.DMMaxFileCount <= 0 {
+		return fmt.Errorf("invalid dm_maxfilecount %d", c.DMMaxFileCount)
+	}
+	if c.BMBufferCount <= 0 {
+		return fmt.Errorf("invalid bm_buffercount %d", c.BMBufferCount)
+	}
+	if c.BMPolicy != "LRU" && c.BMPolicy != "MRU" {
+		return fmt.Errorf("invalid bm_policy %q (expected LRU or MRU)", c.BMPolicy)
+	}
+	switch c.SyncMode {
+	case "", SyncAlways, SyncBatch, SyncNever:
+	default:
+		return fmt.Errorf("invalid sync_mode %q (expected always, batch or never)", c.SyncMode)
+	}
+	switch c.ByteOrder {
+	case "", ByteOrderLittle, ByteOrderBig:
+	default:
+		return fmt.Errorf("invalid byte_order %q (expected little or big)", c.ByteOrder)
+	}
+	if c.FillFactor < 0 || c.FillFactor > 1 {
+		return fmt.Errorf("invalid fill_factor %g (expected 0.0 to 1.0)", c.FillFactor)
+	}
+	if c.SortMemoryRows < 0 {
+		return fmt.Errorf("invalid sort_memory_rows %d", c.SortMemoryRows)
+	}
+	if c.DistinctMemoryRows < 0 {
+		return fmt.Errorf("invalid distinct_memory_rows %d", c.DistinctMemoryRows)
+	}
+	if c.PageReserveBytes < 0 || c.PageReserveBytes >= c.PageSize {
+		return fmt.Errorf("invalid page_reserve_bytes %d (expected 0 to %d)", c.PageReserveBytes, c.PageSize-1)
+	}
+	if c.CheckpointEvery < 0 {
+		return fmt.Errorf("invalid checkpoint_every %d", c.CheckpointEvery)
+	}
+	if c.CheckpointInterval < 0 {
+		return fmt.Errorf("invalid checkpoint_interval %d", c.CheckpointInterval)
+	}
+	if c.PrefetchDepth < 0 {
+		return fmt.Errorf("invalid prefetch_depth %d", c.PrefetchDepth)
+	}
+	if c.QueryTimeoutMs < 0 {
+		return fmt.Errorf("invalid query_timeout_ms %d", c.QueryTimeoutMs)
+	}
+	if c.InMemory() && c.WAL {
+		return fmt.Errorf("wal cannot be used with dbpath %s", MemoryDBPath)
+	}
+	if c.InMemory() && c.BinDir != "" {
+		return fmt.Errorf("bin_dir cannot be used with dbpath %s", MemoryDBPath)
+	}
+	return nil
+}
+
+// isPowerOfTwo reports whether n is a positive power of two.
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
 }
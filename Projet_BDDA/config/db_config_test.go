@@ -1,8 +1,11 @@
 package config_test
 
 import (
+	"encoding/binary"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"malzahar-project/Projet_BDDA/config"
@@ -98,3 +101,306 @@ func TestLoadDBConfigNoDbPath(t *testing.T) {
 		t.Fatalf("expected error when dbpath is missing")
 	}
 }
+
+func TestValidatePow2PageSize(t *testing.T) {
+	c := config.NewDBConfigWithParams("./DB", 5000, 4)
+	if err := c.Validate(); err != nil {
+		t.Fatalf("non power-of-two pagesize must be accepted by default: %v", err)
+	}
+	c.RequirePow2PageSize = true
+	if err := c.Validate(); err == nil {
+		t.Fatalf("expected pagesize 5000 to be rejected when require_pow2_pagesize is set")
+	}
+	c.PageSize = 4096
+	if err := c.Validate(); err != nil {
+		t.Fatalf("pagesize 4096 rejected: %v", err)
+	}
+
+	dir := t.TempDir()
+	p := filepath.Join(dir, "pow2.cfg")
+	if err := os.WriteFile(p, []byte("dbpath = ./DB\npagesize = 5000\nrequire_pow2_pagesize = true\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if _, err := config.LoadDBConfig(p); err == nil {
+		t.Fatalf("expected LoadDBConfig to reject pagesize 5000")
+	}
+}
+
+func TestValidateRejectsBadValues(t *testing.T) {
+	c := config.NewDBConfig("./DB")
+	c.BMBufferCount = 0
+	if err := c.Validate(); err == nil {
+		t.Fatalf("expected error for bm_buffercount 0")
+	}
+	c = config.NewDBConfig("./DB")
+	c.BMPolicy = "FIFO"
+	if err := c.Validate(); err == nil {
+		t.Fatalf("expected error for unknown bm_policy")
+	}
+	c = config.NewDBConfig(config.MemoryDBPath)
+	if err := c.Validate(); err != nil || !c.InMemory() {
+		t.Fatalf("in-memory config rejected: %v", err)
+	}
+	c.WAL = true
+	if err := c.Validate(); err == nil {
+		t.Fatalf("expected error for wal with an in-memory dbpath")
+	}
+}
+
+func TestLoadDBConfigEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.txt")
+	if err := os.WriteFile(path, []byte("dbpath = ./file\npagesize = 8192\nbm_policy = LRU\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	t.Setenv(config.EnvDBPath, "./env")
+	t.Setenv(config.EnvPageSize, "1024")
+	t.Setenv(config.EnvBMPolicy, "MRU")
+	c, err := config.LoadDBConfig(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if c.DBPath != "./env" || c.PageSize != 1024 || c.BMPolicy != "MRU" {
+		t.Fatalf("env overrides not applied: %+v", c)
+	}
+	// values not overridden keep file value or default
+	if c.DMMaxFileCount != 8 || c.BMBufferCount != 16 {
+		t.Fatalf("defaults lost: %+v", c)
+	}
+
+	t.Setenv(config.EnvPageSize, "big")
+	if _, err := config.LoadDBConfig(path); err == nil {
+		t.Fatalf("expected error for malformed %s", config.EnvPageSize)
+	}
+}
+
+func TestLoadDBConfigEnvSuppliesDbPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cfg.json")
+	if err := os.WriteFile(path, []byte(`{"pagesize": 2048}`), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	t.Setenv(config.EnvDBPath, "./fromenv")
+	c, err := config.LoadDBConfig(path)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if c.DBPath != "./fromenv" || c.PageSize != 2048 {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+}
+
+func TestLoadDBConfigYAMLMatchesOtherFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cfg.txt":  "dbpath = './DB'\npagesize = 8192\ndm_maxfilecount = 4\nbm_buffercount = 6\nbm_policy = MRU\n",
+		"cfg.json": `{"dbpath": "./DB", "pagesize": 8192, "dm_maxfilecount": 4, "bm_buffercount": 6, "bm_policy": "MRU"}`,
+		"cfg.yaml": "# engine config\ndbpath: ./DB\npagesize: 8192 # bytes\ndm:\n  maxfilecount: 4\nbm:\n  buffercount: 6\n  policy: \"MRU\"\n",
+		// no extension: detected by content sniffing
+		"cfg": "---\ndbpath: './DB'\npagesize: 8192\ndm:\n  maxfilecount: 4\nbm:\n  buffercount: 6\n  policy: MRU\n",
+	}
+	var want *config.DBConfig
+	for _, name := range []string{"cfg.txt", "cfg.json", "cfg.yaml", "cfg"} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(files[name]), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		c, err := config.LoadDBConfig(p)
+		if err != nil {
+			t.Fatalf("load %s: %v", name, err)
+		}
+		if want == nil {
+			want = c
+			continue
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Fatalf("%s loaded %+v, want %+v", name, c, want)
+		}
+	}
+	if want.DMMaxFileCount != 4 || want.BMBufferCount != 6 || want.BMPolicy != "MRU" {
+		t.Fatalf("unexpected config %+v", want)
+	}
+}
+
+func TestLoadDBConfigYAMLSyntaxError(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "bad.yml")
+	if err := os.WriteFile(p, []byte("dbpath: ./DB\nnot a mapping\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := config.LoadDBConfig(p); err == nil {
+		t.Fatalf("expected yaml syntax error")
+	}
+}
+
+func TestSyncModeConfig(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "sync.cfg")
+	if err := os.WriteFile(p, []byte("dbpath = ./DB\nsync_mode = batch\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	c, err := config.LoadDBConfig(p)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if c.SyncMode != config.SyncBatch {
+		t.Fatalf("expected sync_mode batch got %q", c.SyncMode)
+	}
+	if d := config.NewDBConfig("./DB"); d.SyncMode != config.SyncAlways {
+		t.Fatalf("expected default sync_mode always got %q", d.SyncMode)
+	}
+	c.SyncMode = "sometimes"
+	if err := c.Validate(); err == nil {
+		t.Fatalf("expected error for unknown sync_mode")
+	}
+}
+
+func TestStrictStringsConfig(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "strict.cfg")
+	if err := os.WriteFile(p, []byte("dbpath = ./DB\nstrict_strings = true\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	c, err := config.LoadDBConfig(p)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if !c.StrictStrings {
+		t.Fatalf("expected strict_strings true")
+	}
+	if d := config.NewDBConfig("./DB"); d.StrictStrings {
+		t.Fatalf("expected strict_strings off by default")
+	}
+}
+
+func TestCSVCommentConfig(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"kv.cfg":    "dbpath = ./DB\ncsv_comment = #\n",
+		"c.yaml":    "dbpath: ./DB\ncsv_comment: \"#\" # comment prefix\n",
+		"json.json": `{"dbpath": "./DB", "csv_comment": "//"}`,
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		c, err := config.LoadDBConfig(p)
+		if err != nil {
+			t.Fatalf("load %s: %v", name, err)
+		}
+		want := "#"
+		if name == "json.json" {
+			want = "//"
+		}
+		if c.CSVComment != want {
+			t.Fatalf("%s: csv_comment = %q, want %q", name, c.CSVComment, want)
+		}
+	}
+	if d := config.NewDBConfig("./DB"); d.CSVComment != "" {
+		t.Fatalf("comment skipping must be off by default, got %q", d.CSVComment)
+	}
+}
+
+func TestFillFactorConfig(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "kv.cfg")
+	if err := os.WriteFile(p, []byte("dbpath = ./DB\nfill_factor = 0.75\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	c, err := config.LoadDBConfig(p)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if c.FillFactor != 0.75 {
+		t.Fatalf("fill_factor = %g, want 0.75", c.FillFactor)
+	}
+	t.Setenv(config.EnvFillFactor, "0.5")
+	if c, err = config.LoadDBConfig(p); err != nil || c.FillFactor != 0.5 {
+		t.Fatalf("env override: %v, %v", c, err)
+	}
+	t.Setenv(config.EnvFillFactor, "1.5")
+	if _, err := config.LoadDBConfig(p); err == nil {
+		t.Fatalf("fill_factor above 1 should be rejected")
+	}
+}
+
+func TestByteOrderConfig(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "kv.cfg")
+	if err := os.WriteFile(p, []byte("dbpath = ./DB\nbyte_order = BIG\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	c, err := config.LoadDBConfig(p)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if c.ByteOrder != config.ByteOrderBig || c.Order() != binary.BigEndian {
+		t.Fatalf("byte_order = %q, order %v, want big", c.ByteOrder, c.Order())
+	}
+	if d := config.NewDBConfig("./DB"); d.Order() != binary.LittleEndian {
+		t.Fatalf("default order = %v, want little-endian", d.Order())
+	}
+	t.Setenv(config.EnvByteOrder, "little")
+	if c, err = config.LoadDBConfig(p); err != nil || c.Order() != binary.LittleEndian {
+		t.Fatalf("env override: %v, %v", c, err)
+	}
+	t.Setenv(config.EnvByteOrder, "middle")
+	if _, err := config.LoadDBConfig(p); err == nil {
+		t.Fatalf("byte_order middle should be rejected")
+	}
+}
+
+func TestParseDBConfigFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"kv":   "dbpath = ./DB\npagesize = 1024\n",
+		"json": `{"dbpath": "./DB", "pagesize": 1024}`,
+		"yaml": "dbpath: ./DB\ndm:\n  maxfilecount: 4\npagesize: 1024\n",
+	} {
+		c, err := config.ParseDBConfig(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if c.DBPath != "./DB" || c.PageSize != 1024 {
+			t.Fatalf("%s: got dbpath %q pagesize %d", name, c.DBPath, c.PageSize)
+		}
+	}
+	if _, err := config.ParseDBConfig(strings.NewReader("")); err == nil {
+		t.Fatalf("empty input should be rejected")
+	}
+}
+
+func TestLoadDBConfigFileURL(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "config.txt")
+	if err := os.WriteFile(p, []byte("dbpath = ./DB\n"), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	c, err := config.LoadDBConfig("file://" + filepath.ToSlash(p))
+	if err != nil {
+		t.Fatalf("load file URL: %v", err)
+	}
+	if c.DBPath != "./DB" {
+		t.Fatalf("dbpath = %q", c.DBPath)
+	}
+}
+
+func TestParseDBConfigDetection(t *testing.T) {
+	// a JSON document is taken as JSON even though it also contains ':' separators
+	c, err := config.ParseDBConfig(strings.NewReader(`{"dbpath": "./DB", "bm_policy": "MRU"}`))
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if c.BMPolicy != "MRU" || c.PageSize != 4096 {
+		t.Fatalf("json: got policy %q pagesize %d", c.BMPolicy, c.PageSize)
+	}
+	// anything that is not JSON falls back to key=value, with defaults and validation
+	c, err = config.ParseDBConfig(strings.NewReader("# comment\ndbpath = '../DB'\nbm_buffercount = 4\n"))
+	if err != nil {
+		t.Fatalf("kv: %v", err)
+	}
+	if c.DBPath != "../DB" || c.BMBufferCount != 4 || c.BMPolicy != "LRU" {
+		t.Fatalf("kv: got %+v", c)
+	}
+	if _, err := config.ParseDBConfig(strings.NewReader("pagesize = 1024\n")); err == nil {
+		t.Fatalf("config without dbpath should be rejected")
+	}
+}
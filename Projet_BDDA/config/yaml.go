@@ -0,0 +1,109 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// isYAMLPath reports whether the file extension designates a YAML config.
+func isYAMLPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// looksLikeYAML sniffs YAML content: a document marker, or an indented line nested
+// under a "section:" line. Flat "key: value" files are left to the key=value parser,
+// which already understands them.
+func looksLikeYAML(data []byte) bool {
+	prevSection := false
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		raw := scanner.Text()
+		line := strings.TrimSpace(stripYAMLComment(raw))
+		if line == "" {
+			continue
+		}
+		if line == "---" {
+			return true
+		}
+		indented := raw[0] == ' ' || raw[0] == '\t'
+		if indented && prevSection {
+			return true
+		}
+		prevSection = strings.HasSuffix(line, ":")
+	}
+	return false
+}
+
+// parseYAMLConfig reads the subset of YAML used for configs: nested mappings of
+// scalar values. Nested keys are joined with '_' so that
+//
+//	bm:
+//	  buffercount: 4
+//
+// is equivalent to bm_buffercount = 4.
+func parseYAMLConfig(data []byte, c *DBConfig) error {
+	type section struct {
+		indent int
+		key    string
+	}
+	var stack []section
+	lineNo := 0
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		lineNo++
+		raw := strings.TrimRight(stripYAMLComment(scanner.Text()), " \t\r")
+		line := strings.TrimSpace(raw)
+		if line == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(raw, "\t") {
+			return fmt.Errorf("yaml line %d: tabs are not allowed for indentation", lineNo)
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("yaml line %d: expected key: value", lineNo)
+		}
+		key := strings.TrimSpace(parts[0])
+		val := strings.TrimSpace(parts[1])
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if val == "" {
+			stack = append(stack, section{indent: indent, key: key})
+			continue
+		}
+		full := key
+		if len(stack) > 0 {
+			names := make([]string, 0, len(stack)+1)
+			for _, s := range stack {
+				names = append(names, s.key)
+			}
+			full = strings.Join(append(names, key), "_")
+		}
+		setConfigKey(c, full, val)
+	}
+	return scanner.Err()
+}
+
+// stripYAMLComment removes a trailing # comment that is not inside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// converter turns a value of a source column into the text form of the target
+// column, or reports why it cannot do so without loss.
+type converter func(v string, to relation.ColumnInfo) (string, error)
+
+// conversions holds the supported ALTER TABLE ... MODIFY COLUMN conversions, keyed
+// by source and target kind. Pairs missing from the table are rejected.
+var conversions = map[[2]relation.ColumnKind]converter{
+	{relation.KindInt, relation.KindInt}:     keepValue,
+	{relation.KindFloat, relation.KindFloat}: keepValue,
+	{relation.KindInt, relation.KindFloat}:   intToFloat,
+	{relation.KindFloat, relation.KindInt}:   floatToInt,
+
+	{relation.KindChar, relation.KindChar}:       fitString,
+	{relation.KindChar, relation.KindVarchar}:    fitString,
+	{relation.KindVarchar, relation.KindChar}:    fitString,
+	{relation.KindVarchar, relation.KindVarchar}: fitString,
+	{relation.KindInt, relation.KindChar}:        fitString,
+	{relation.KindInt, relation.KindVarchar}:     fitString,
+	{relation.KindFloat, relation.KindChar}:      fitString,
+	{relation.KindFloat, relation.KindVarchar}:   fitString,
+
+	{relation.KindChar, relation.KindInt}:      stringToInt,
+	{relation.KindVarchar, relation.KindInt}:   stringToInt,
+	{relation.KindChar, relation.KindFloat}:    stringToFloat,
+	{relation.KindVarchar, relation.KindFloat}: stringToFloat,
+}
+
+// convertValue converts v, a value of column from, to the type of column to.
+func convertValue(from, to relation.ColumnInfo, v string) (string, error) {
+	conv, ok := conversions[[2]relation.ColumnKind{from.Kind, to.Kind}]
+	if !ok {
+		return "", fmt.Errorf("cannot convert column %s from %s to %s", from.Name, from.TypeString(), to.TypeString())
+	}
+	out, err := conv(v, to)
+	if err != nil {
+		return "", fmt.Errorf("cannot convert column %s from %s to %s: %v", from.Name, from.TypeString(), to.TypeString(), err)
+	}
+	return out, nil
+}
+
+func keepValue(v string, _ relation.ColumnInfo) (string, error) {
+	return v, nil
+}
+
+// intToFloat rejects integers a FLOAT (float32) cannot represent exactly.
+func intToFloat(v string, _ relation.ColumnInfo) (string, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid int value %q", v)
+	}
+	if int(float32(n)) != n {
+		return "", fmt.Errorf("value %d is not exact as a FLOAT", n)
+	}
+	return v, nil
+}
+
+// floatToInt accepts only integral values within the INT (int32) range.
+func floatToInt(v string, _ relation.ColumnInfo) (string, error) {
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid float value %q", v)
+	}
+	if f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 {
+		return "", fmt.Errorf("value %s is not an INT", v)
+	}
+	return strconv.Itoa(int(f)), nil
+}
+
+// fitString keeps the text of the value and rejects values longer than the target.
+func fitString(v string, to relation.ColumnInfo) (string, error) {
+	if len(v) > to.Size {
+		return "", fmt.Errorf("value %q exceeds max length %d", v, to.Size)
+	}
+	return v, nil
+}
+
+func stringToInt(v string, _ relation.ColumnInfo) (string, error) {
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("value %q is not an INT", v)
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+func stringToFloat(v string, _ relation.ColumnInfo) (string, error) {
+	if _, err := strconv.ParseFloat(v, 32); err != nil {
+		return "", fmt.Errorf("value %q is not a FLOAT", v)
+	}
+	return v, nil
+}
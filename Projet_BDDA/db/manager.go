@@ -2,10 +2,12 @@ package db
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -14,16 +16,92 @@ import (
 	"malzahar-project/Projet_BDDA/buffer"
 	"malzahar-project/Projet_BDDA/config"
 	"malzahar-project/Projet_BDDA/disk"
+	"malzahar-project/Projet_BDDA/query"
 	"malzahar-project/Projet_BDDA/relation"
 )
 
+var (
+	// ErrTableNotFound is returned for a table the database does not have.
+	ErrTableNotFound = errors.New("table not found")
+	// ErrTableExists is returned when creating a table whose name is taken.
+	ErrTableExists = errors.New("table already exists")
+	// ErrColumnNotFound is returned for a column its table does not have.
+	ErrColumnNotFound = errors.New("column not found")
+	// ErrColumnExists is returned when renaming a column to a name already taken.
+	ErrColumnExists = errors.New("column already exists")
+	// ErrSoftDeleted is returned when rewriting a table that still holds
+	// soft-deleted records, which a rewrite could not carry over.
+	ErrSoftDeleted = errors.New("table holds soft-deleted records")
+)
+
 type tableSave struct {
-	Name   string                `json:"name"`
-	Cols   []relation.ColumnInfo `json:"cols"`
-	Header struct {
+	Name string                `json:"name"`
+	Cols []relation.ColumnInfo `json:"cols"`
+	// HasHeader tells whether Header is set; {0,0} is a valid header location
+	HasHeader bool `json:"has_header"`
+	Header    struct {
 		FileIdx int `json:"fileidx"`
 		PageIdx int `json:"pageidx"`
 	} `json:"header"`
+	SoftDelete bool `json:"soft_delete"`
+}
+
+// saveVersion is the database.save format written by SaveState:
+//
+//	1: a bare JSON array of tables; a header is only marked by a non-zero location
+//	   (has_header, when present, is honored)
+//	2: {"version": 2, "tables": [...]}, with has_header set for every table
+//
+// LoadState reads any version up to saveVersion and migrates older ones.
+const saveVersion = 2
+
+type saveFile struct {
+	Version int `json:"version"`
+	// ByteOrder is the config byte_order the pages were written with, omitted for
+	// the default little-endian order.
+	ByteOrder string      `json:"byte_order,omitempty"`
+	Tables    []tableSave `json:"tables"`
+}
+
+// decodeSaveFile parses database.save of any supported version and returns it in the
+// current format.
+func decodeSaveFile(data []byte) (*saveFile, error) {
+	var sf saveFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		sf.Version = 1
+		if err := json.Unmarshal(trimmed, &sf.Tables); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, err
+	}
+	switch {
+	case sf.Version > saveVersion:
+		return nil, fmt.Errorf("database.save version %d is newer than the supported version %d", sf.Version, saveVersion)
+	case sf.Version < 1:
+		return nil, fmt.Errorf("database.save has invalid version %d", sf.Version)
+	}
+	if sf.Version == 1 {
+		migrateSaveV1(sf.Tables)
+	}
+	return &sf, nil
+}
+
+// byteOrderName returns the byte_order value s, with the default "" as little.
+func byteOrderName(s string) string {
+	if s == "" {
+		return config.ByteOrderLittle
+	}
+	return s
+}
+
+// migrateSaveV1 upgrades version 1 entries to version 2.
+func migrateSaveV1(tables []tableSave) {
+	for i := range tables {
+		if tables[i].Header.FileIdx != 0 || tables[i].Header.PageIdx != 0 {
+			tables[i].HasHeader = true
+		}
+	}
 }
 
 // DBManager manages a collection of relations within a single database.
@@ -45,8 +123,16 @@ func (m *DBManager) AddTable(tab *relation.Relation) error {
 		return errors.New("nil relation")
 	}
 	if _, ok := m.tables[tab.Name]; ok {
-		return fmt.Errorf("table %s exists", tab.Name)
+		return fmt.Errorf("%w: %s", ErrTableExists, tab.Name)
 	}
+	if err := tab.Validate(); err != nil {
+		return err
+	}
+	if err := tab.FitToPage(m.cfg.PageSize, m.cfg.PageReserveBytes); err != nil {
+		return err
+	}
+	tab.Strict = m.cfg.StrictStrings
+	tab.FillFactor = m.cfg.FillFactor
 	rm, err := relation.NewRelationManager(tab, m.dm, m.bm)
 	if err != nil {
 		return err
@@ -55,15 +141,25 @@ func (m *DBManager) AddTable(tab *relation.Relation) error {
 	if err := rm.EnsureHeader(); err != nil {
 		return err
 	}
+	if m.cfg.BMKeepHeaders {
+		if err := m.bm.PinPersistent(rm.HeaderPageId); err != nil {
+			return err
+		}
+	}
 	m.tables[tab.Name] = tab
 	m.rms[tab.Name] = rm
 	return nil
 }
 
+// TableCount returns the number of tables in the database.
+func (m *DBManager) TableCount() int {
+	return len(m.tables)
+}
+
 func (m *DBManager) GetTable(name string) (*relation.Relation, error) {
 	t, ok := m.tables[name]
 	if !ok {
-		return nil, fmt.Errorf("table %s not found", name)
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, name)
 	}
 	return t, nil
 }
@@ -71,23 +167,51 @@ func (m *DBManager) GetTable(name string) (*relation.Relation, error) {
 func (m *DBManager) RemoveTable(name string) error {
 	rm, ok := m.rms[name]
 	if !ok {
-		return fmt.Errorf("table %s not found", name)
+		return fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	if err := m.freeHeap(rm); err != nil {
+		return err
 	}
-	// enumerate pages and free them
+	delete(m.tables, name)
+	delete(m.rms, name)
+	return nil
+}
+
+// freeHeap gives every page of the relation rm manages, overflow pages included, back
+// to the DiskManager and removes its metadata files.
+func (m *DBManager) freeHeap(rm *relation.RelationManager) error {
+	if err := m.freePages(rm); err != nil {
+		return err
+	}
+	// remove header metadata file
+	name := rm.Rel.Name
+	hdrPath := filepath.Join(m.dm.BinDir(), name+".hdr")
+	_ = os.Remove(hdrPath)
+	_ = os.Remove(filepath.Join(m.dm.BinDir(), name+".pages"))
+	return nil
+}
+
+// freePages is freeHeap leaving the metadata files alone.
+func (m *DBManager) freePages(rm *relation.RelationManager) error {
+	// enumerate pages, with the overflow pages of the records, and free them
 	pids, err := rm.AllPageIds()
 	if err != nil {
 		return err
 	}
+	overflow, err := rm.OverflowPageIds()
+	if err != nil {
+		return err
+	}
+	pids = append(overflow, pids...)
+	if rm.HasHeader() {
+		pids = append(pids, rm.HeaderPageId)
+	}
+	m.bm.ReleasePersistent(rm.HeaderPageId)
 	for _, pid := range pids {
 		if err := m.dm.FreePage(pid); err != nil {
 			return err
 		}
 	}
-	// remove header metadata file
-	hdrPath := filepath.Join(m.dm.BinDir(), name+".hdr")
-	_ = os.Remove(hdrPath)
-	delete(m.tables, name)
-	delete(m.rms, name)
 	return nil
 }
 
@@ -105,10 +229,21 @@ func (m *DBManager) RemoveAllTables() error {
 	return nil
 }
 
+// Compact flushes the buffer pool, then removes the data files left without any
+// used page, typically after dropping tables; Data0.bin always stays. It returns the
+// number of files removed.
+func (m *DBManager) Compact() (int, error) {
+	if err := m.bm.FlushBuffers(); err != nil {
+		return 0, err
+	}
+	removed, err := m.dm.RemoveEmptyFiles(m.bm.HoldsFile)
+	return len(removed), err
+}
+
 func (m *DBManager) DescribeTable(name string) (string, error) {
 	t, ok := m.tables[name]
 	if !ok {
-		return "", fmt.Errorf("table %s not found", name)
+		return "", fmt.Errorf("%w: %s", ErrTableNotFound, name)
 	}
 	// build schema string: Name (C1:TYPE,C2:TYPE(...))
 	s := t.Name + " ("
@@ -116,106 +251,160 @@ func (m *DBManager) DescribeTable(name string) (string, error) {
 		if i > 0 {
 			s += ","
 		}
-		switch c.Kind {
-		case relation.KindInt:
-			s += fmt.Sprintf("%s:INT", c.Name)
-		case relation.KindFloat:
-			s += fmt.Sprintf("%s:FLOAT", c.Name)
-		case relation.KindChar:
-			s += fmt.Sprintf("%s:CHAR(%d)", c.Name, c.Size)
-		case relation.KindVarchar:
-			s += fmt.Sprintf("%s:VARCHAR(%d)", c.Name, c.Size)
-		}
+		s += c.Name + ":" + c.TypeString()
 	}
 	s += ")"
 	return s, nil
 }
 
-func (m *DBManager) DescribeAllTables() []string {
-	var out []string
-	// produce deterministic order by sorting table names
+// TableStats returns the number of records and data pages of the given table.
+func (m *DBManager) TableStats(name string) (rows int, pages int, err error) {
+	rm, ok := m.rms[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	pids, err := rm.AllPageIds()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = rm.ScanRecords(func(relation.Record, relation.RecordId) error {
+		rows++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return rows, len(pids), nil
+}
+
+// TablePages returns the number of data pages of the given table without reading
+// its records.
+func (m *DBManager) TablePages(name string) (int, error) {
+	rm, ok := m.rms[name]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	pids, err := rm.AllPageIds()
+	if err != nil {
+		return 0, err
+	}
+	return len(pids), nil
+}
+
+// DescribeAllTables returns one DescribeTable line per table, sorted by table name.
+// With stats set, each line also carries the table's record and page counts, e.g.
+// "Emp (id:INT) rows=3 pages=1".
+func (m *DBManager) DescribeAllTables(stats bool) ([]string, error) {
 	names := make([]string, 0, len(m.tables))
 	for name := range m.tables {
 		names = append(names, name)
 	}
-	// sort
-	// import sort locally to avoid changing file-level imports layout
-	if len(names) > 1 {
-		// lightweight insertion sort via sort package
-		// use sort.Strings for clarity
-		// (we can import sort at top-level; do it inline by calling package)
-	}
-	// now sort using standard library
-	// (we add the import at top of file)
-	// ...existing code...
-	// sort and append descriptions
 	sort.Strings(names)
+	out := make([]string, 0, len(names))
 	for _, name := range names {
-		if s, err := m.DescribeTable(name); err == nil {
-			out = append(out, s)
+		s, err := m.DescribeTable(name)
+		if err != nil {
+			return nil, err
+		}
+		if stats {
+			rows, pages, err := m.TableStats(name)
+			if err != nil {
+				return nil, err
+			}
+			s += fmt.Sprintf(" rows=%d pages=%d", rows, pages)
 		}
+		out = append(out, s)
 	}
-	return out
+	return out, nil
 }
 
 // InsertRecord inserts a record into the named table and returns its RecordId.
 func (m *DBManager) InsertRecord(table string, rec *relation.Record) (relation.RecordId, error) {
 	rm, ok := m.rms[table]
 	if !ok {
-		return relation.RecordId{}, fmt.Errorf("table %s not found", table)
+		return relation.RecordId{}, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	// validate values up front so a bad literal never allocates pages
+	if err := rm.Rel.CheckRecord(rec); err != nil {
+		return relation.RecordId{}, err
 	}
 	return rm.InsertRecord(rec)
 }
 
 // AppendFromCSV reads a CSV file (relative path) and appends all records into table.
 // CSV format: values separated by commas, string values optionally quoted with double quotes.
+// Blank lines are skipped, and so are lines starting with cfg.CSVComment when it is set.
 // Returns number of inserted records.
 func (m *DBManager) AppendFromCSV(table string, csvPath string) (int, error) {
 	rm, ok := m.rms[table]
 	if !ok {
-		return 0, fmt.Errorf("table %s not found", table)
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, table)
 	}
 	f, err := os.Open(csvPath)
 	if err != nil {
 		return 0, err
 	}
 	defer f.Close()
-	// simple line-by-line parsing
-	// use scanner to read lines
-	inserted := 0
-	// Use bufio.NewScanner to read lines
+	// stream the file line by line into the relation's bulk-load path
+	lineNo := 0
 	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	next := func() (*relation.Record, error) {
+		for scanner.Scan() {
+			lineNo++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			// comment lines; a quoted first field starts with '"' so it never matches
+			if p := m.cfg.CSVComment; p != "" && strings.HasPrefix(line, p) {
+				continue
+			}
+			// split on commas
+			rec := &relation.Record{Values: splitCSVLine(line)}
+			if len(rec.Values) == len(rm.Rel.Columns) {
+				for i, c := range rm.Rel.Columns {
+					v, err := c.ParseText(rec.Values[i])
+					if err != nil {
+						return nil, fmt.Errorf("%s line %d: %v", csvPath, lineNo, err)
+					}
+					rec.Values[i] = v
+				}
+			}
+			if err := rm.Rel.CheckRecord(rec); err != nil {
+				return nil, fmt.Errorf("%s line %d: %v", csvPath, lineNo, err)
+			}
+			return rec, nil
 		}
-		// split on commas
-		parts := splitCSVLine(line)
-		rec := &relation.Record{Values: parts}
-		if _, err := rm.InsertRecord(rec); err != nil {
-			return inserted, err
+		if err := scanner.Err(); err != nil {
+			return nil, err
 		}
-		inserted++
-	}
-	if err := scanner.Err(); err != nil {
-		return inserted, err
+		return nil, io.EOF
 	}
-
-	return inserted, nil
+	return rm.BulkInsert(next)
 }
 
 // DeleteWhere deletes records matching match predicate and returns number deleted.
-func (m *DBManager) DeleteWhere(table string, match func(rec *relation.Record) bool) (int, error) {
+// With dryRun set, nothing is deleted and the number of matching records is returned.
+// An error from match aborts the scan before any record is deleted.
+func (m *DBManager) DeleteWhere(table string, match func(rec *relation.Record) (bool, error), dryRun bool) (int, error) {
+	return m.DeleteWhereContext(context.Background(), table, match, dryRun)
+}
+
+// DeleteWhereContext is DeleteWhere stopping with ctx.Err() once ctx is done;
+// nothing is deleted if the scan is cancelled.
+func (m *DBManager) DeleteWhereContext(ctx context.Context, table string, match func(rec *relation.Record) (bool, error), dryRun bool) (int, error) {
 	rm, ok := m.rms[table]
 	if !ok {
-		return 0, fmt.Errorf("table %s not found", table)
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, table)
 	}
-	deleted := 0
 	// collect RecordIds to delete to avoid modifying while scanning
 	var toDelete []relation.RecordId
-	err := rm.ScanRecords(func(rec relation.Record, rid relation.RecordId) error {
-		if match(&rec) {
+	err := rm.ScanRecordsContext(ctx, func(rec relation.Record, rid relation.RecordId) error {
+		ok, err := match(&rec)
+		if err != nil {
+			return err
+		}
+		if ok {
 			toDelete = append(toDelete, rid)
 		}
 		return nil
@@ -223,60 +412,559 @@ func (m *DBManager) DeleteWhere(table string, match func(rec *relation.Record) b
 	if err != nil {
 		return 0, err
 	}
-	for _, rid := range toDelete {
-		if err := rm.DeleteRecord(rid); err != nil {
-			return deleted, err
+	if dryRun {
+		return len(toDelete), nil
+	}
+	// one pin and at most one list update per page, however many rows it holds
+	if err := rm.DeleteRecords(toDelete); err != nil {
+		return 0, err
+	}
+	return len(toDelete), nil
+}
+
+// Exists reports whether table holds at least one record matching match. The scan
+// stops at the first match or at the first error from match.
+func (m *DBManager) Exists(table string, match func(rec *relation.Record) (bool, error)) (bool, error) {
+	return m.ExistsContext(context.Background(), table, match)
+}
+
+// ExistsContext is Exists stopping with ctx.Err() once ctx is done.
+func (m *DBManager) ExistsContext(ctx context.Context, table string, match func(rec *relation.Record) (bool, error)) (bool, error) {
+	rm, ok := m.rms[table]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	found := false
+	err := rm.ScanRecordsContext(ctx, func(rec relation.Record, rid relation.RecordId) error {
+		ok, err := match(&rec)
+		if err != nil {
+			return err
 		}
-		deleted++
+		if ok {
+			found = true
+			return relation.ErrStopScan
+		}
+		return nil
+	})
+	return found, err
+}
+
+// DeleteByRecordId deletes the single record identified by rid from table. The rid
+// must point into one of the table's own data pages.
+func (m *DBManager) DeleteByRecordId(table string, rid relation.RecordId) error {
+	rm, err := m.ownerOf(table, rid)
+	if err != nil {
+		return err
+	}
+	return rm.DeleteRecord(rid)
+}
+
+// ownerOf returns the manager of table after checking that rid points into one of
+// its data pages.
+func (m *DBManager) ownerOf(table string, rid relation.RecordId) (*relation.RelationManager, error) {
+	rm, ok := m.rms[table]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	pids, err := rm.AllPageIds()
+	if err != nil {
+		return nil, err
 	}
-	return deleted, nil
+	for _, pid := range pids {
+		if pid == rid.PageId {
+			return rm, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: rowid %s does not belong to table %s", relation.ErrRecordNotFound, rid, table)
 }
 
 // UpdateWhere updates records matching match by producing a new record via updater
 // (which receives a copy of the current record and returns the new record values).
-// It returns number of updated records.
-func (m *DBManager) UpdateWhere(table string, match func(rec *relation.Record) bool, updater func(rec *relation.Record) *relation.Record) (int, error) {
+// All new records are computed and validated before any row is modified, then written
+// in place (see RelationManager.UpdateRecords), so updated records keep their RecordId.
+// It returns number of updated records. With dryRun set, the new records are still
+// computed and validated but nothing is modified, and the number of records that would
+// be updated is returned. An error from match or updater aborts before any record is
+// modified.
+func (m *DBManager) UpdateWhere(table string, match func(rec *relation.Record) (bool, error), updater func(rec *relation.Record) (*relation.Record, error), dryRun bool) (int, error) {
+	return m.UpdateWhereContext(context.Background(), table, match, updater, dryRun)
+}
+
+// UpdateWhereContext is UpdateWhere stopping with ctx.Err() once ctx is done;
+// nothing is updated if the scan is cancelled.
+func (m *DBManager) UpdateWhereContext(ctx context.Context, table string, match func(rec *relation.Record) (bool, error), updater func(rec *relation.Record) (*relation.Record, error), dryRun bool) (int, error) {
 	rm, ok := m.rms[table]
 	if !ok {
-		return 0, fmt.Errorf("table %s not found", table)
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, table)
 	}
-	updated := 0
-	// collect pairs of rid and new record
-	type updItem struct {
-		rid relation.RecordId
-		rec *relation.Record
+	return updateMatching(ctx, rm, func(rec *relation.Record, _ relation.RecordId) (bool, error) {
+		return match(rec)
+	}, updater, dryRun)
+}
+
+// UpdateByRecordId updates the single record rid of table through updater, like
+// UpdateWhere, and returns 1, or 0 when rid holds no live record. Like
+// DeleteByRecordId, rid must point into one of the table's own data pages.
+func (m *DBManager) UpdateByRecordId(table string, rid relation.RecordId, updater func(rec *relation.Record) (*relation.Record, error), dryRun bool) (int, error) {
+	rm, err := m.ownerOf(table, rid)
+	if err != nil {
+		return 0, err
 	}
-	var todo []updItem
-	err := rm.ScanRecords(func(rec relation.Record, rid relation.RecordId) error {
-		if match(&rec) {
-			nr := updater(&rec)
-			todo = append(todo, updItem{rid: rid, rec: nr})
+	return updateMatching(context.Background(), rm, func(_ *relation.Record, r relation.RecordId) (bool, error) {
+		return r == rid, nil
+	}, updater, dryRun)
+}
+
+// updateMatching implements UpdateWhereContext and UpdateByRecordId for the records
+// of rm selected by match.
+func updateMatching(ctx context.Context, rm *relation.RelationManager, match func(rec *relation.Record, rid relation.RecordId) (bool, error), updater func(rec *relation.Record) (*relation.Record, error), dryRun bool) (int, error) {
+	// collect the new version of every matching record
+	var todo []relation.RecordUpdate
+	err := rm.ScanRecordsContext(ctx, func(rec relation.Record, rid relation.RecordId) error {
+		ok, err := match(&rec, rid)
+		if err != nil {
+			return err
+		}
+		if ok {
+			nr, err := updater(&rec)
+			if err != nil {
+				return err
+			}
+			if err := rm.Rel.CheckRecord(nr); err != nil {
+				return err
+			}
+			todo = append(todo, relation.RecordUpdate{Rid: rid, Rec: nr})
 		}
 		return nil
 	})
 	if err != nil {
 		return 0, err
 	}
-	for _, it := range todo {
-		// simple approach: delete old record and insert new one
-		if err := rm.DeleteRecord(it.rid); err != nil {
-			return updated, err
+	if dryRun {
+		return len(todo), nil
+	}
+	// records are rewritten in their slots: they keep their RecordId, the page lists
+	// are untouched, and in soft delete mode no old version is left to UNDELETE
+	if err := rm.UpdateRecords(todo); err != nil {
+		return 0, err
+	}
+	return len(todo), nil
+}
+
+// UndeleteRecord restores the soft-deleted record rid of table. Like
+// DeleteByRecordId, rid must point into one of the table's own data pages.
+func (m *DBManager) UndeleteRecord(table string, rid relation.RecordId) error {
+	rm, err := m.ownerOf(table, rid)
+	if err != nil {
+		return err
+	}
+	return rm.UndeleteRecord(rid)
+}
+
+// UndeleteAll restores every soft-deleted record of table and returns how many.
+func (m *DBManager) UndeleteAll(table string) (int, error) {
+	rm, ok := m.rms[table]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	return rm.Undelete()
+}
+
+// PurgeTable reclaims the slots of every soft-deleted record of table and returns how many.
+func (m *DBManager) PurgeTable(table string) (int, error) {
+	rm, ok := m.rms[table]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	return rm.Purge()
+}
+
+// DefragmentTable moves the pages of table next to each other in one data file (see
+// RelationManager.Defragment) and returns how many were moved. Record ids change.
+// It refuses to run while any buffer is pinned, as the buffer pool is flushed and the
+// pinned page could be one being moved.
+func (m *DBManager) DefragmentTable(table string) (int, error) {
+	rm, ok := m.rms[table]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	if err := m.bm.AssertAllUnpinned(); err != nil {
+		return 0, fmt.Errorf("defragment %s: %w", table, err)
+	}
+	return rm.Defragment()
+}
+
+// RenameColumn renames column oldName of table to newName. Records are stored
+// positionally, so only the schema changes; it is saved right away (SaveState).
+func (m *DBManager) RenameColumn(table, oldName, newName string) error {
+	t, ok := m.tables[table]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	if newName == "" {
+		return errors.New("empty column name")
+	}
+	idx := -1
+	for i, c := range t.Columns {
+		switch c.Name {
+		case oldName:
+			idx = i
+		case newName:
+			return fmt.Errorf("%w: %s in table %s", ErrColumnExists, newName, table)
 		}
-		if _, err := rm.InsertRecord(it.rec); err != nil {
-			return updated, err
+	}
+	if idx < 0 {
+		return fmt.Errorf("%w: %s in table %s", ErrColumnNotFound, oldName, table)
+	}
+	if oldName == newName {
+		return nil
+	}
+	t.Columns[idx].Name = newName
+	return m.SaveState()
+}
+
+// DropColumn removes column name from table. Unlike a rename this changes the
+// record size and slot layout, so the table is rewritten (see rewriteTable). The
+// last column of a table cannot be dropped.
+func (m *DBManager) DropColumn(table, name string) error {
+	t, ok := m.tables[table]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	idx := query.ColumnIndex(t, name)
+	if idx < 0 {
+		return fmt.Errorf("%w: %s in table %s", ErrColumnNotFound, name, table)
+	}
+	if len(t.Columns) == 1 {
+		return fmt.Errorf("cannot drop %s, the last column of table %s", name, table)
+	}
+	cols := make([]relation.ColumnInfo, 0, len(t.Columns)-1)
+	cols = append(cols, t.Columns[:idx]...)
+	cols = append(cols, t.Columns[idx+1:]...)
+	return m.rewriteTable(t, cols, func(vals []string) ([]string, error) {
+		out := make([]string, 0, len(vals)-1)
+		out = append(out, vals[:idx]...)
+		return append(out, vals[idx+1:]...), nil
+	})
+}
+
+// ModifyColumn changes the type of column name of table to to (its Name is
+// ignored), converting every record with convertValue. A value that cannot be
+// converted without loss, or an unsupported pair of kinds, aborts the change before
+// anything is rewritten.
+func (m *DBManager) ModifyColumn(table, name string, to relation.ColumnInfo) error {
+	t, ok := m.tables[table]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	idx := query.ColumnIndex(t, name)
+	if idx < 0 {
+		return fmt.Errorf("%w: %s in table %s", ErrColumnNotFound, name, table)
+	}
+	from := t.Columns[idx]
+	to.Name = from.Name
+	cols := append([]relation.ColumnInfo(nil), t.Columns...)
+	cols[idx] = to
+	return m.rewriteTable(t, cols, func(vals []string) ([]string, error) {
+		v, err := convertValue(from, to, vals[idx])
+		if err != nil {
+			return nil, err
 		}
-		updated++
+		out := append([]string(nil), vals...)
+		out[idx] = v
+		return out, nil
+	})
+}
+
+// rewriteSuffix names the heap a rewrite builds next to the table it replaces: the
+// metadata files of a relation are named after it.
+const rewriteSuffix = "~rewrite"
+
+// rewriteTable replaces t by a relation with the columns cols, for schema changes
+// that alter the record layout. The live records are read into memory and passed
+// through convert first, so a conversion error leaves the table untouched; then the
+// converted records are written into a fresh heap, built under a temporary name and
+// swapped in for the old one only once complete, so a failed write leaves t as it
+// was. A table holding soft-deleted records is refused (ErrSoftDeleted): they would
+// not survive the rewrite, so they must be purged or undeleted first.
+//
+// The swap is committed by saving the new schema, before the old heap is freed: a
+// crash before that point leaves the old table, one after it the new table, and
+// LoadState tidies the temporary files either way (see recoverRewrite).
+func (m *DBManager) rewriteTable(t *relation.Relation, cols []relation.ColumnInfo, convert func(vals []string) ([]string, error)) error {
+	old := m.rms[t.Name]
+	deleted, err := old.DeletedCount()
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		return fmt.Errorf("%w: %s has %d; PURGE or UNDELETE them first", ErrSoftDeleted, t.Name, deleted)
+	}
+	var rows [][]string
+	if err := m.ScanTableRecords(t.Name, func(rec relation.Record, _ relation.RecordId) error {
+		vals, err := convert(rec.Values)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, vals)
+		return nil
+	}); err != nil {
+		return err
+	}
+	rel := relation.NewRelation(t.Name, cols)
+	rel.SoftDelete = t.SoftDelete
+	if err := rel.Validate(); err != nil {
+		return err
+	}
+	if err := rel.FitToPage(m.cfg.PageSize, m.cfg.PageReserveBytes); err != nil {
+		return err
+	}
+	rel.Strict = m.cfg.StrictStrings
+	rel.FillFactor = m.cfg.FillFactor
+	// a leftover of an interrupted rewrite must not be mistaken for the new heap
+	tmp := t.Name + rewriteSuffix
+	tmpPath := filepath.Join(m.dm.BinDir(), tmp)
+	_ = os.Remove(tmpPath + ".hdr")
+	_ = os.Remove(tmpPath + ".pages")
+	rel.Name = tmp
+	rm, err := relation.NewRelationManager(rel, m.dm, m.bm)
+	if err != nil {
+		return err
+	}
+	next := 0
+	err = rm.EnsureHeader()
+	if err == nil {
+		_, err = rm.BulkInsert(func() (*relation.Record, error) {
+			if next == len(rows) {
+				return nil, io.EOF
+			}
+			next++
+			return relation.NewRecord(rows[next-1]...), nil
+		})
 	}
-	return updated, nil
+	if err != nil {
+		_ = m.freeHeap(rm)
+		return err
+	}
+	// commit: database.save now names the new heap's header
+	rel.Name = t.Name
+	m.tables[t.Name], m.rms[t.Name] = rel, rm
+	if err := m.SaveState(); err != nil {
+		rel.Name = tmp
+		m.tables[t.Name], m.rms[t.Name] = t, old
+		_ = m.SaveState()
+		_ = m.freeHeap(rm)
+		return err
+	}
+	if err := m.finishRewrite(t.Name); err != nil {
+		return err
+	}
+	if err := m.freePages(old); err != nil {
+		return err
+	}
+	if m.cfg.BMKeepHeaders {
+		if err := m.bm.PinPersistent(rm.HeaderPageId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishRewrite moves the page directory of the committed rewrite of table over the
+// old one, then removes the temporary header file, which marks the rewrite done.
+func (m *DBManager) finishRewrite(table string) error {
+	dir := filepath.Join(m.dm.BinDir(), table)
+	err := os.Rename(dir+rewriteSuffix+".pages", dir+".pages")
+	if os.IsNotExist(err) {
+		// the new heap has no data page yet
+		err = os.Remove(dir + ".pages")
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(dir + rewriteSuffix + ".hdr"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// recoverRewrite completes or abandons a rewrite of table e interrupted by a crash
+// (see rewriteTable). It was committed when database.save names the header recorded
+// in the temporary header file; otherwise the new heap is dropped and the old table
+// stays as saved. The pages of the heap left behind remain allocated, and CheckAll
+// reports them as orphans.
+func (m *DBManager) recoverRewrite(e tableSave) error {
+	tmp := filepath.Join(m.dm.BinDir(), e.Name+rewriteSuffix)
+	hdr, err := os.ReadFile(tmp + ".hdr")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	order := m.dm.ByteOrder()
+	if e.HasHeader && len(hdr) == 8 &&
+		int(int32(order.Uint32(hdr[0:4]))) == e.Header.FileIdx &&
+		int(int32(order.Uint32(hdr[4:8]))) == e.Header.PageIdx {
+		return m.finishRewrite(e.Name)
+	}
+	if err := os.Remove(tmp + ".pages"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(tmp + ".hdr")
+}
+
+// CheckTable verifies the page lists of the given table (see
+// RelationManager.CheckIntegrity). With repair set, the lists are rebuilt first and the
+// result of checking the rebuilt table is returned.
+func (m *DBManager) CheckTable(name string, repair bool) error {
+	rm, ok := m.rms[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	if repair {
+		if err := m.RepairTable(name); err != nil {
+			return err
+		}
+	}
+	return rm.CheckIntegrity()
+}
+
+// RepairTable rebuilds the free/full page lists of the given table from scratch. The
+// candidate pages are those the table already knows (page directory and lists) plus,
+// as a fallback, every allocated page of the database not owned by another table;
+// each page is filed by recomputing its fullness from the bytemap.
+func (m *DBManager) RepairTable(name string) error {
+	rm, ok := m.rms[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	others := make(map[config.PageId]bool)
+	for other, orm := range m.rms {
+		if other == name {
+			continue
+		}
+		others[orm.HeaderPageId] = true
+		pids, err := orm.KnownPageIds()
+		if err != nil {
+			return err
+		}
+		for _, pid := range pids {
+			others[pid] = true
+		}
+	}
+	allocated, err := m.dm.AllocatedPages()
+	if err != nil {
+		return err
+	}
+	var candidates []config.PageId
+	for _, pid := range allocated {
+		if !others[pid] {
+			candidates = append(candidates, pid)
+		}
+	}
+	return rm.RepairFrom(candidates)
+}
+
+// CheckReport is what CheckAll found in the database.
+type CheckReport struct {
+	// Tables maps every table name to its problems, none for a consistent table.
+	Tables map[string][]string
+	// Orphans are the pages marked used in the bitmaps that no table owns.
+	Orphans []config.PageId
+}
+
+// Problems returns the number of problems found, orphaned pages included.
+func (r *CheckReport) Problems() int {
+	n := len(r.Orphans)
+	for _, p := range r.Tables {
+		n += len(p)
+	}
+	return n
+}
+
+// CheckAll checks the whole database: the page lists of every table (see CheckTable),
+// that every page a table owns (header, data pages known to its lists or directory,
+// overflow pages) is marked used in the bitmaps, and that every page marked used is
+// owned by some table. Only I/O failures are returned as errors; inconsistencies go to
+// the report.
+func (m *DBManager) CheckAll() (*CheckReport, error) {
+	allocated, err := m.dm.AllocatedPages()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[config.PageId]bool, len(allocated))
+	for _, pid := range allocated {
+		used[pid] = true
+	}
+	report := &CheckReport{Tables: make(map[string][]string, len(m.rms))}
+	owned := make(map[config.PageId]bool)
+	for name, rm := range m.rms {
+		var problems []string
+		if err := rm.CheckIntegrity(); err != nil {
+			ierr, ok := err.(*relation.IntegrityError)
+			if !ok {
+				return nil, fmt.Errorf("table %s: %w", name, err)
+			}
+			problems = append(problems, ierr.Problems...)
+		}
+		if !rm.HasHeader() {
+			report.Tables[name] = problems
+			continue
+		}
+		pids, err := rm.KnownPageIds()
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", name, err)
+		}
+		pids = append(pids, rm.HeaderPageId)
+		overflow, err := rm.OverflowPageIds()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("overflow pages unreadable: %v", err))
+		}
+		pids = append(pids, overflow...)
+		mine := make(map[config.PageId]bool, len(pids))
+		for _, pid := range pids {
+			if mine[pid] {
+				continue
+			}
+			mine[pid] = true
+			owned[pid] = true
+			if !used[pid] {
+				problems = append(problems, fmt.Sprintf("page %d:%d belongs to the table but is free in the bitmap", pid.FileIdx, pid.PageIdx))
+			}
+		}
+		report.Tables[name] = problems
+	}
+	for _, pid := range allocated {
+		if !owned[pid] {
+			report.Orphans = append(report.Orphans, pid)
+		}
+	}
+	return report, nil
 }
 
 // ScanTableRecords calls cb for every record in the given table.
 func (m *DBManager) ScanTableRecords(table string, cb func(rec relation.Record, rid relation.RecordId) error) error {
+	return m.ScanTableRecordsContext(context.Background(), table, cb)
+}
+
+// ScanTableRecordsContext is ScanTableRecords stopping with ctx.Err() once ctx is done.
+func (m *DBManager) ScanTableRecordsContext(ctx context.Context, table string, cb func(rec relation.Record, rid relation.RecordId) error) error {
+	rm, ok := m.rms[table]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTableNotFound, table)
+	}
+	return rm.ScanRecordsContext(ctx, cb)
+}
+
+// ScanTableRecordsIn is ScanTableRecordsContext in the given direction (see
+// relation.ScanDirection).
+func (m *DBManager) ScanTableRecordsIn(ctx context.Context, table string, dir relation.ScanDirection, cb func(rec relation.Record, rid relation.RecordId) error) error {
 	rm, ok := m.rms[table]
 	if !ok {
-		return fmt.Errorf("table %s not found", table)
+		return fmt.Errorf("%w: %s", ErrTableNotFound, table)
 	}
-	return rm.ScanRecords(cb)
+	return rm.ScanRecordsIn(ctx, dir, cb)
 }
 
 // simple CSV line splitter: splits on commas, trims spaces, removes surrounding double quotes if present
@@ -304,20 +992,27 @@ func (m *DBManager) SaveState() error {
 		var e tableSave
 		e.Name = name
 		e.Cols = t.Columns
+		e.SoftDelete = t.SoftDelete
 		if rm, ok := m.rms[name]; ok {
-			if rm.HeaderPageId != (config.PageId{}) {
+			if rm.HasHeader() {
+				e.HasHeader = true
 				e.Header.FileIdx = rm.HeaderPageId.FileIdx
 				e.Header.PageIdx = rm.HeaderPageId.PageIdx
 				// also write per-relation header file (same format as relation.saveHeaderLocation)
 				buf := make([]byte, 8)
-				binary.LittleEndian.PutUint32(buf[0:4], uint32(e.Header.FileIdx))
-				binary.LittleEndian.PutUint32(buf[4:8], uint32(e.Header.PageIdx))
+				m.dm.ByteOrder().PutUint32(buf[0:4], uint32(e.Header.FileIdx))
+				m.dm.ByteOrder().PutUint32(buf[4:8], uint32(e.Header.PageIdx))
 				_ = os.WriteFile(filepath.Join(m.dm.BinDir(), name+".hdr"), buf, 0o644)
 			}
 		}
 		entries = append(entries, e)
 	}
-	data, err := json.MarshalIndent(entries, "", "  ")
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	sf := saveFile{Version: saveVersion, Tables: entries}
+	if name := byteOrderName(m.cfg.ByteOrder); name != config.ByteOrderLittle {
+		sf.ByteOrder = name
+	}
+	data, err := json.MarshalIndent(sf, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -333,19 +1028,27 @@ func (m *DBManager) LoadState() error {
 	if err != nil {
 		return err
 	}
-	var entries []tableSave
-	if err := json.Unmarshal(data, &entries); err != nil {
+	sf, err := decodeSaveFile(data)
+	if err != nil {
 		return err
 	}
-	for _, e := range entries {
+	// pages written in the other byte order would decode as garbage
+	if saved, want := byteOrderName(sf.ByteOrder), byteOrderName(m.cfg.ByteOrder); saved != want {
+		return fmt.Errorf("database.save was written with byte_order %s, the config has %s", saved, want)
+	}
+	for _, e := range sf.Tables {
+		if err := m.recoverRewrite(e); err != nil {
+			return err
+		}
 		// if header info present, write .hdr so NewRelationManager can load it when AddTable is called
-		if e.Header.FileIdx != 0 || e.Header.PageIdx != 0 {
+		if e.HasHeader {
 			buf := make([]byte, 8)
-			binary.LittleEndian.PutUint32(buf[0:4], uint32(e.Header.FileIdx))
-			binary.LittleEndian.PutUint32(buf[4:8], uint32(e.Header.PageIdx))
+			m.dm.ByteOrder().PutUint32(buf[0:4], uint32(e.Header.FileIdx))
+			m.dm.ByteOrder().PutUint32(buf[4:8], uint32(e.Header.PageIdx))
 			_ = os.WriteFile(filepath.Join(m.dm.BinDir(), e.Name+".hdr"), buf, 0o644)
 		}
 		rel := relation.NewRelation(e.Name, e.Cols)
+		rel.SoftDelete = e.SoftDelete
 		if err := m.AddTable(rel); err != nil {
 			return err
 		}
@@ -1,6 +1,13 @@
 package db
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"malzahar-project/Projet_BDDA/buffer"
@@ -83,3 +90,1197 @@ func TestRemoveTables(t *testing.T) {
 		t.Fatalf("RemoveAllTables: %v", err)
 	}
 }
+
+func TestInsertRejectsInvalidNumbers(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{{Name: "A", Kind: relation.KindInt}, {Name: "B", Kind: relation.KindFloat}}
+	if err := m.AddTable(relation.NewRelation("T", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	if _, err := m.InsertRecord("T", relation.NewRecord("abc", "1.0")); err == nil || !strings.Contains(err.Error(), "col A") {
+		t.Fatalf("expected invalid int error for col A, got %v", err)
+	}
+	if _, err := m.InsertRecord("T", relation.NewRecord("1", "zz")); err == nil || !strings.Contains(err.Error(), "col B") {
+		t.Fatalf("expected invalid float error for col B, got %v", err)
+	}
+	if _, err := m.InsertRecord("T", relation.NewRecord("3000000000", "1.0")); !errors.Is(err, relation.ErrInvalidValue) {
+		t.Fatalf("expected an INT out of range to be rejected, got %v", err)
+	}
+
+	csv := filepath.Join(dir, "t.csv")
+	if err := os.WriteFile(csv, []byte("1,1.5\n\n2,oops\n3,3.5\n"), 0o644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	n, err := m.AppendFromCSV("T", csv)
+	if err == nil || !strings.Contains(err.Error(), "line 3") || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("expected error on line 3, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 record inserted before the bad line, got %d", n)
+	}
+}
+
+func TestSaveLoadWithCustomBinDir(t *testing.T) {
+	dir := t.TempDir()
+	binDir := filepath.Join(t.TempDir(), "bin")
+	cfg := config.NewDBConfigWithBinDir(dir, binDir, 4096, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	if err := m.AddTable(relation.NewRelation("T", []relation.ColumnInfo{{Name: "A", Kind: relation.KindInt}})); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	if _, err := m.InsertRecord("T", relation.NewRecord("7")); err != nil {
+		t.Fatalf("InsertRecord: %v", err)
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("FlushBuffers: %v", err)
+	}
+	if err := m.SaveState(); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "database.save")); err != nil {
+		t.Fatalf("database.save should stay in dbpath: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(binDir, "T.hdr")); err != nil {
+		t.Fatalf("T.hdr should live in bin dir: %v", err)
+	}
+
+	dm2 := disk.NewDiskManager(cfg)
+	if err := dm2.Init(); err != nil {
+		t.Fatalf("dm2.Init: %v", err)
+	}
+	m2 := NewDBManager(cfg, dm2, buffer.NewBufferManager(cfg, dm2))
+	if err := m2.LoadState(); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	count := 0
+	if err := m2.ScanTableRecords("T", func(rec relation.Record, rid relation.RecordId) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 record after reload, got %d", count)
+	}
+}
+
+func TestRepairTableAfterHeaderCorruption(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 256, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}}
+	for _, name := range []string{"A", "B"} {
+		if err := m.AddTable(relation.NewRelation(name, cols)); err != nil {
+			t.Fatalf("AddTable: %v", err)
+		}
+	}
+	// interleave inserts so both tables own pages spread over the file
+	const n = 200
+	for i := 0; i < n; i++ {
+		for _, name := range []string{"A", "B"} {
+			if _, err := m.InsertRecord(name, relation.NewRecord(fmt.Sprint(i))); err != nil {
+				t.Fatalf("insert: %v", err)
+			}
+		}
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// corrupt A's header lists and lose its page directory, as after a bad crash
+	hdr := m.rms["A"].HeaderPageId
+	page, err := dm.ReadPage(hdr)
+	if err != nil {
+		t.Fatalf("read header: %v", err)
+	}
+	for i := 0; i < 16; i++ {
+		page[i] = 0x7f
+	}
+	if err := dm.WritePage(hdr, page); err != nil {
+		t.Fatalf("write header: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dm.BinDir(), "A.pages")); err != nil {
+		t.Fatalf("remove directory: %v", err)
+	}
+	count := func(name string) int {
+		c := 0
+		if err := m.ScanTableRecords(name, func(relation.Record, relation.RecordId) error { c++; return nil }); err != nil {
+			t.Fatalf("scan %s: %v", name, err)
+		}
+		return c
+	}
+	if err := m.CheckTable("A", false); err == nil {
+		t.Fatalf("expected integrity error on corrupted header")
+	}
+
+	if err := m.RepairTable("A"); err != nil {
+		t.Fatalf("RepairTable: %v", err)
+	}
+	if err := m.CheckTable("A", false); err != nil {
+		t.Fatalf("still inconsistent after repair: %v", err)
+	}
+	if got := count("A"); got != n {
+		t.Fatalf("A has %d records after repair, want %d", got, n)
+	}
+	// B's pages share A's layout but must not have been taken over
+	if got := count("B"); got != n {
+		t.Fatalf("B has %d records after repairing A, want %d", got, n)
+	}
+	if err := m.CheckTable("B", false); err != nil {
+		t.Fatalf("B damaged by repairing A: %v", err)
+	}
+}
+
+func TestCheckAllFlagsCorruptedTable(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 256, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}}
+	for _, name := range []string{"A", "B", "C"} {
+		if err := m.AddTable(relation.NewRelation(name, cols)); err != nil {
+			t.Fatalf("AddTable: %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			if _, err := m.InsertRecord(name, relation.NewRecord(fmt.Sprint(i))); err != nil {
+				t.Fatalf("insert: %v", err)
+			}
+		}
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	report, err := m.CheckAll()
+	if err != nil {
+		t.Fatalf("CheckAll: %v", err)
+	}
+	if n := report.Problems(); n != 0 || len(report.Tables) != 3 {
+		t.Fatalf("healthy database: %d problem(s) over %d table(s): %v", n, len(report.Tables), report.Tables)
+	}
+
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// corrupt B's lists: the header no longer points at its with-space pages
+	hdr := m.rms["B"].HeaderPageId
+	page, err := dm.ReadPage(hdr)
+	if err != nil {
+		t.Fatalf("read header: %v", err)
+	}
+	for i := 8; i < 16; i++ {
+		page[i] = 0xff
+	}
+	if err := dm.WritePage(hdr, page); err != nil {
+		t.Fatalf("write header: %v", err)
+	}
+	// and leak a page no table owns
+	leaked, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	report, err = m.CheckAll()
+	if err != nil {
+		t.Fatalf("CheckAll: %v", err)
+	}
+	for name, problems := range report.Tables {
+		if (len(problems) > 0) != (name == "B") {
+			t.Fatalf("table %s: problems %v", name, problems)
+		}
+	}
+	if !strings.Contains(strings.Join(report.Tables["B"], "\n"), "reachable from neither list") {
+		t.Fatalf("unexpected problems for B: %v", report.Tables["B"])
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0] != leaked {
+		t.Fatalf("orphans = %v, want [%v]", report.Orphans, leaked)
+	}
+}
+
+func TestSaveLoadHeaderAtPageZero(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	newManager := func() (*DBManager, *buffer.BufferManager) {
+		dm := disk.NewDiskManager(cfg)
+		if err := dm.Init(); err != nil {
+			t.Fatalf("dm.Init: %v", err)
+		}
+		bm := buffer.NewBufferManager(cfg, dm)
+		return NewDBManager(cfg, dm, bm), bm
+	}
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}}
+	count := func(m *DBManager) int {
+		c := 0
+		if err := m.ScanTableRecords("T", func(relation.Record, relation.RecordId) error { c++; return nil }); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		return c
+	}
+
+	m, bm := newManager()
+	if err := m.AddTable(relation.NewRelation("T", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := m.InsertRecord("T", relation.NewRecord(fmt.Sprint(i))); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// move the header to {0,0} by swapping it with the data page
+	hdr, data := config.PageId{FileIdx: 0, PageIdx: 1}, config.PageId{FileIdx: 0, PageIdx: 0}
+	if m.rms["T"].HeaderPageId != hdr {
+		t.Fatalf("unexpected header location %v", m.rms["T"].HeaderPageId)
+	}
+	hpage, err := m.dm.ReadPage(hdr)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	dpage, err := m.dm.ReadPage(data)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	binary.LittleEndian.PutUint32(hpage[8:12], 0)
+	binary.LittleEndian.PutUint32(hpage[12:16], 1)
+	if err := m.dm.WritePage(data, hpage); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := m.dm.WritePage(hdr, dpage); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	loc := make([]byte, 8)
+	if err := os.WriteFile(filepath.Join(m.dm.BinDir(), "T.hdr"), loc, 0o644); err != nil {
+		t.Fatalf("write hdr: %v", err)
+	}
+	binary.LittleEndian.PutUint32(loc[4:8], 1)
+	if err := os.WriteFile(filepath.Join(m.dm.BinDir(), "T.pages"), loc, 0o644); err != nil {
+		t.Fatalf("write pages: %v", err)
+	}
+
+	m2, _ := newManager()
+	if err := m2.AddTable(relation.NewRelation("T", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	if m2.rms["T"].HeaderPageId != data || count(m2) != 3 {
+		t.Fatalf("setup failed: header %v, %d rows", m2.rms["T"].HeaderPageId, count(m2))
+	}
+	if err := m2.SaveState(); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	// the save file alone must be enough to find the header again
+	if err := os.Remove(filepath.Join(m2.dm.BinDir(), "T.hdr")); err != nil {
+		t.Fatalf("remove hdr: %v", err)
+	}
+
+	m3, _ := newManager()
+	if err := m3.LoadState(); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if got := m3.rms["T"].HeaderPageId; got != data {
+		t.Fatalf("header reloaded at %v, want %v", got, data)
+	}
+	if got := count(m3); got != 3 {
+		t.Fatalf("got %d rows after reload, want 3", got)
+	}
+}
+
+// BenchmarkAppendFromCSV imports 10k rows through the bulk path and, for comparison,
+// through one InsertRecord call per row.
+func BenchmarkAppendFromCSV(b *testing.B) {
+	const rows = 10000
+	var sb strings.Builder
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&sb, "%d,\"name%d\",%d.5\n", i, i, i)
+	}
+	csvPath := filepath.Join(b.TempDir(), "rows.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0o644); err != nil {
+		b.Fatalf("write csv: %v", err)
+	}
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "name", Kind: relation.KindVarchar, Size: 12}, {Name: "score", Kind: relation.KindFloat}}
+	newTable := func(b *testing.B) (*DBManager, *buffer.BufferManager) {
+		cfg := config.NewDBConfig(b.TempDir())
+		cfg.SyncMode = config.SyncNever
+		dm := disk.NewDiskManager(cfg)
+		if err := dm.Init(); err != nil {
+			b.Fatalf("dm.Init: %v", err)
+		}
+		bm := buffer.NewBufferManager(cfg, dm)
+		m := NewDBManager(cfg, dm, bm)
+		if err := m.AddTable(relation.NewRelation("T", cols)); err != nil {
+			b.Fatalf("AddTable: %v", err)
+		}
+		return m, bm
+	}
+	b.Run("bulk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			m, bm := newTable(b)
+			b.StartTimer()
+			if n, err := m.AppendFromCSV("T", csvPath); err != nil || n != rows {
+				b.Fatalf("AppendFromCSV = %d, %v", n, err)
+			}
+			if err := bm.FlushBuffers(); err != nil {
+				b.Fatalf("flush: %v", err)
+			}
+		}
+	})
+	b.Run("row-by-row", func(b *testing.B) {
+		lines := strings.Split(strings.TrimSpace(sb.String()), "\n")
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			m, bm := newTable(b)
+			b.StartTimer()
+			for _, line := range lines {
+				if _, err := m.InsertRecord("T", &relation.Record{Values: splitCSVLine(line)}); err != nil {
+					b.Fatalf("InsertRecord: %v", err)
+				}
+			}
+			if err := bm.FlushBuffers(); err != nil {
+				b.Fatalf("flush: %v", err)
+			}
+		}
+	})
+}
+
+func TestLoadStateMigratesV1SaveFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	newManager := func() (*DBManager, *buffer.BufferManager) {
+		dm := disk.NewDiskManager(cfg)
+		if err := dm.Init(); err != nil {
+			t.Fatalf("dm.Init: %v", err)
+		}
+		bm := buffer.NewBufferManager(cfg, dm)
+		return NewDBManager(cfg, dm, bm), bm
+	}
+	m, bm := newManager()
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "name", Kind: relation.KindVarchar, Size: 6}}
+	if err := m.AddTable(relation.NewRelation("T", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, err := m.InsertRecord("T", relation.NewRecord(fmt.Sprint(i), "n")); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	hdr := m.rms["T"].HeaderPageId
+	// a save file as written before versioning: bare array, no has_header
+	v1 := fmt.Sprintf(`[
+  {
+    "name": "T",
+    "cols": [{"Name": "id", "Kind": 0, "Size": 0}, {"Name": "name", "Kind": 3, "Size": 6}],
+    "header": {"fileidx": %d, "pageidx": %d}
+  }
+]`, hdr.FileIdx, hdr.PageIdx)
+	savePath := filepath.Join(dir, "database.save")
+	if err := os.WriteFile(savePath, []byte(v1), 0o644); err != nil {
+		t.Fatalf("write v1: %v", err)
+	}
+	if err := os.Remove(filepath.Join(m.dm.BinDir(), "T.hdr")); err != nil {
+		t.Fatalf("remove hdr: %v", err)
+	}
+
+	m2, _ := newManager()
+	if err := m2.LoadState(); err != nil {
+		t.Fatalf("LoadState v1: %v", err)
+	}
+	if got := m2.rms["T"].HeaderPageId; got != hdr {
+		t.Fatalf("header %v after migration, want %v", got, hdr)
+	}
+	n := 0
+	if err := m2.ScanTableRecords("T", func(relation.Record, relation.RecordId) error { n++; return nil }); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("got %d rows after migration, want 4", n)
+	}
+	// saving again writes the current version
+	if err := m2.SaveState(); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	data, err := os.ReadFile(savePath)
+	if err != nil {
+		t.Fatalf("read save: %v", err)
+	}
+	if !strings.Contains(string(data), `"version": 2`) || !strings.Contains(string(data), `"has_header": true`) {
+		t.Fatalf("save file not upgraded: %s", data)
+	}
+
+	// files from a newer binary are rejected with a clear error
+	if err := os.WriteFile(savePath, []byte(`{"version": 99, "tables": []}`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	m3, _ := newManager()
+	if err := m3.LoadState(); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("expected newer-version error, got %v", err)
+	}
+}
+
+func TestDescribeAllTablesSortedWithStats(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	m := NewDBManager(cfg, dm, buffer.NewBufferManager(cfg, dm))
+	for _, name := range []string{"Zeta", "Alpha", "Mid"} {
+		cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "tag", Kind: relation.KindChar, Size: 3}}
+		if err := m.AddTable(relation.NewRelation(name, cols)); err != nil {
+			t.Fatalf("AddTable: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := m.InsertRecord("Mid", relation.NewRecord(fmt.Sprint(i), "abc")); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	plain, err := m.DescribeAllTables(false)
+	if err != nil {
+		t.Fatalf("DescribeAllTables: %v", err)
+	}
+	want := []string{"Alpha (id:INT,tag:CHAR(3))", "Mid (id:INT,tag:CHAR(3))", "Zeta (id:INT,tag:CHAR(3))"}
+	if strings.Join(plain, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("DescribeAllTables(false) = %q, want %q", plain, want)
+	}
+	withStats, err := m.DescribeAllTables(true)
+	if err != nil {
+		t.Fatalf("DescribeAllTables(true): %v", err)
+	}
+	wantStats := []string{want[0] + " rows=0 pages=1", want[1] + " rows=3 pages=1", want[2] + " rows=0 pages=1"}
+	if strings.Join(withStats, "\n") != strings.Join(wantStats, "\n") {
+		t.Fatalf("DescribeAllTables(true) = %q, want %q", withStats, wantStats)
+	}
+}
+
+func TestAppendFromCSVSkipsComments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	cfg.CSVComment = "#"
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	m := NewDBManager(cfg, dm, buffer.NewBufferManager(cfg, dm))
+	cols := []relation.ColumnInfo{{Name: "tag", Kind: relation.KindVarchar, Size: 8}, {Name: "n", Kind: relation.KindInt}}
+	if err := m.AddTable(relation.NewRelation("T", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	csv := "# exported rows\n\"a\",1\n\n   # indented comment\n\"#b\",2\nc,3\n#\"d\",4\n"
+	p := filepath.Join(dir, "rows.csv")
+	if err := os.WriteFile(p, []byte(csv), 0o644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	n, err := m.AppendFromCSV("T", p)
+	if err != nil || n != 3 {
+		t.Fatalf("AppendFromCSV = %d, %v; want 3 rows", n, err)
+	}
+	var tags []string
+	if err := m.ScanTableRecords("T", func(rec relation.Record, _ relation.RecordId) error {
+		tags = append(tags, rec.Values[0])
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	sort.Strings(tags)
+	if strings.Join(tags, ",") != "#b,a,c" {
+		t.Fatalf("imported tags %v, want [#b a c]", tags)
+	}
+
+	// without a comment prefix, '#' lines are data
+	cfg.CSVComment = ""
+	if _, err := m.AppendFromCSV("T", p); err == nil {
+		t.Fatalf("expected '# exported rows' to be rejected as a data row")
+	}
+}
+
+func TestExistsStopsAtFirstMatch(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "name", Kind: relation.KindVarchar, Size: 20}}
+	if err := m.AddTable(relation.NewRelation("Emp", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if _, err := m.InsertRecord("Emp", relation.NewRecord(fmt.Sprint(i), "emp")); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	_, pages, err := m.TableStats("Emp")
+	if err != nil {
+		t.Fatalf("TableStats: %v", err)
+	}
+	if pages < 10 {
+		t.Fatalf("table spans only %d pages, test needs a large table", pages)
+	}
+	idIs := func(want string) func(*relation.Record) (bool, error) {
+		return func(rec *relation.Record) (bool, error) { return rec.Values[0] == want, nil }
+	}
+
+	bm.ResetStats()
+	found, err := m.Exists("Emp", func(*relation.Record) (bool, error) { return true, nil })
+	if err != nil || !found {
+		t.Fatalf("Exists(any) = %v, %v", found, err)
+	}
+	if got := bm.Stats().Requests; got > 4 {
+		t.Fatalf("Exists(any) made %d page requests, want it to stop on the first page", got)
+	}
+
+	bm.ResetStats()
+	found, err = m.Exists("Emp", idIs("-1"))
+	if err != nil || found {
+		t.Fatalf("Exists(id=-1) = %v, %v", found, err)
+	}
+	full := bm.Stats().Requests
+	if full < int64(pages) {
+		t.Fatalf("a miss should read every page: %d requests for %d pages", full, pages)
+	}
+
+	bm.ResetStats()
+	found, err = m.Exists("Emp", idIs(fmt.Sprint(n-1)))
+	if err != nil || !found {
+		t.Fatalf("Exists(id=%d) = %v, %v", n-1, found, err)
+	}
+	if got := bm.Stats().Requests; got >= full {
+		t.Fatalf("Exists on a match made %d requests, no fewer than a full scan (%d)", got, full)
+	}
+
+	if _, err := m.Exists("Nope", idIs("1")); err == nil {
+		t.Fatalf("Exists on a missing table should fail")
+	}
+}
+
+func TestPredicateErrorsAbortBeforeModifying(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "name", Kind: relation.KindVarchar, Size: 20}}
+	if err := m.AddTable(relation.NewRelation("Emp", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := m.InsertRecord("Emp", relation.NewRecord(fmt.Sprint(i), "emp")); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	// matches every record until it fails on the last one
+	errBoom := errors.New("boom")
+	failLate := func(rec *relation.Record) (bool, error) {
+		if rec.Values[0] == "9" {
+			return false, errBoom
+		}
+		return true, nil
+	}
+	if _, err := m.DeleteWhere("Emp", failLate, false); !errors.Is(err, errBoom) {
+		t.Fatalf("DeleteWhere = %v, want the predicate error", err)
+	}
+	rename := func(rec *relation.Record) (*relation.Record, error) {
+		return relation.NewRecord(rec.Values[0], "renamed"), nil
+	}
+	if _, err := m.UpdateWhere("Emp", failLate, rename, false); !errors.Is(err, errBoom) {
+		t.Fatalf("UpdateWhere = %v, want the predicate error", err)
+	}
+	failFirst := func(*relation.Record) (bool, error) { return false, errBoom }
+	if _, err := m.Exists("Emp", failFirst); !errors.Is(err, errBoom) {
+		t.Fatalf("Exists = %v, want the predicate error", err)
+	}
+	left := 0
+	err := m.ScanTableRecords("Emp", func(rec relation.Record, _ relation.RecordId) error {
+		if rec.Values[1] != "emp" {
+			return fmt.Errorf("record %v was modified", rec.Values)
+		}
+		left++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left != 10 {
+		t.Fatalf("%d records left, want all 10", left)
+	}
+}
+
+func TestDropColumnRewritesRecords(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{
+		{Name: "id", Kind: relation.KindInt},
+		{Name: "note", Kind: relation.KindVarchar, Size: 200},
+		{Name: "name", Kind: relation.KindChar, Size: 8},
+	}
+	if err := m.AddTable(relation.NewRelation("Emp", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	const n = 300
+	for i := 0; i < n; i++ {
+		if _, err := m.InsertRecord("Emp", relation.NewRecord(fmt.Sprint(i), "some note", fmt.Sprintf("e%d", i))); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	_, before, err := m.TableStats("Emp")
+	if err != nil {
+		t.Fatalf("TableStats: %v", err)
+	}
+
+	if err := m.DropColumn("Emp", "note"); err != nil {
+		t.Fatalf("DropColumn: %v", err)
+	}
+	rel, _ := m.GetTable("Emp")
+	if len(rel.Columns) != 2 || rel.Columns[0].Name != "id" || rel.Columns[1].Name != "name" {
+		t.Fatalf("unexpected columns %+v", rel.Columns)
+	}
+	if rel.RecordSize != 4+8 {
+		t.Fatalf("RecordSize = %d, want 12", rel.RecordSize)
+	}
+	rows, after, err := m.TableStats("Emp")
+	if err != nil {
+		t.Fatalf("TableStats: %v", err)
+	}
+	if rows != n {
+		t.Fatalf("%d rows after drop, want %d", rows, n)
+	}
+	if after >= before {
+		t.Fatalf("table uses %d pages after the drop, %d before", after, before)
+	}
+	seen := make(map[string]string)
+	if err := m.ScanTableRecords("Emp", func(rec relation.Record, _ relation.RecordId) error {
+		seen[rec.Values[0]] = rec.Values[1]
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		if got := seen[fmt.Sprint(i)]; got != fmt.Sprintf("e%d", i) {
+			t.Fatalf("row %d has name %q", i, got)
+		}
+	}
+
+	if err := m.DropColumn("Emp", "note"); err == nil {
+		t.Fatalf("dropping a missing column should fail")
+	}
+	if err := m.DropColumn("Emp", "name"); err != nil {
+		t.Fatalf("DropColumn(name): %v", err)
+	}
+	if err := m.DropColumn("Emp", "id"); err == nil {
+		t.Fatalf("dropping the last column should fail")
+	}
+}
+
+// TestRewriteFailureKeepsTable makes the write of the new heap fail: the old table
+// must survive unchanged and the pages of the partial heap must be freed.
+func TestRewriteFailureKeepsTable(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "name", Kind: relation.KindVarchar, Size: 8}}
+	if err := m.AddTable(relation.NewRelation("Emp", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	const n = 200
+	for i := 0; i < n; i++ {
+		if _, err := m.InsertRecord("Emp", relation.NewRecord(fmt.Sprint(i), fmt.Sprintf("e%d", i))); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	before, err := dm.AllocatedPageCount()
+	if err != nil {
+		t.Fatalf("AllocatedPageCount: %v", err)
+	}
+	old, _ := m.GetTable("Emp")
+	// the last row cannot be stored, after the others filled several pages
+	err = m.rewriteTable(old, cols, func(vals []string) ([]string, error) {
+		if vals[0] == fmt.Sprint(n-1) {
+			return []string{"nope", vals[1]}, nil
+		}
+		return vals, nil
+	})
+	if !errors.Is(err, relation.ErrInvalidValue) {
+		t.Fatalf("rewriteTable: err = %v, want ErrInvalidValue", err)
+	}
+	if after, _ := dm.AllocatedPageCount(); after != before {
+		t.Fatalf("%d pages allocated after the failed rewrite, want %d", after, before)
+	}
+	if rel, _ := m.GetTable("Emp"); rel != old || m.TableCount() != 1 {
+		t.Fatalf("table replaced by a failed rewrite")
+	}
+	rows, _, err := m.TableStats("Emp")
+	if err != nil || rows != n {
+		t.Fatalf("TableStats = %d, %v; want %d rows", rows, err, n)
+	}
+
+	// a successful rewrite keeps every row under the table name
+	if err := m.DropColumn("Emp", "name"); err != nil {
+		t.Fatalf("DropColumn: %v", err)
+	}
+	if rows, _, err := m.TableStats("Emp"); err != nil || rows != n {
+		t.Fatalf("TableStats = %d, %v; want %d rows", rows, err, n)
+	}
+	if _, err := os.Stat(filepath.Join(dm.BinDir(), "Emp.hdr")); err != nil {
+		t.Fatalf("header file of the rewritten table: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dm.BinDir(), "Emp~rewrite.hdr")); !os.IsNotExist(err) {
+		t.Fatalf("temporary header file left behind: %v", err)
+	}
+}
+
+func TestRewriteRefusesSoftDeletedRecords(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	rel := relation.NewRelation("Emp", []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "name", Kind: relation.KindVarchar, Size: 8}})
+	rel.SoftDelete = true
+	if err := m.AddTable(rel); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	var rids []relation.RecordId
+	for i := 0; i < 10; i++ {
+		rid, err := m.InsertRecord("Emp", relation.NewRecord(fmt.Sprint(i), "e"))
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		rids = append(rids, rid)
+	}
+	if err := m.DeleteByRecordId("Emp", rids[3]); err != nil {
+		t.Fatalf("DeleteByRecordId: %v", err)
+	}
+	if err := m.DropColumn("Emp", "name"); !errors.Is(err, ErrSoftDeleted) {
+		t.Fatalf("DropColumn with a tombstone: err = %v, want ErrSoftDeleted", err)
+	}
+	if got, _ := m.GetTable("Emp"); got != rel || len(got.Columns) != 2 {
+		t.Fatalf("table changed by the refused rewrite")
+	}
+	// the tombstone is still there to UNDELETE
+	if err := m.UndeleteRecord("Emp", rids[3]); err != nil {
+		t.Fatalf("UndeleteRecord: %v", err)
+	}
+	if err := m.DeleteByRecordId("Emp", rids[3]); err != nil {
+		t.Fatalf("DeleteByRecordId: %v", err)
+	}
+	if n, err := m.PurgeTable("Emp"); err != nil || n != 1 {
+		t.Fatalf("PurgeTable = %d, %v", n, err)
+	}
+	if err := m.DropColumn("Emp", "name"); err != nil {
+		t.Fatalf("DropColumn after PURGE: %v", err)
+	}
+	if rows, _, err := m.TableStats("Emp"); err != nil || rows != 9 {
+		t.Fatalf("TableStats = %d, %v; want 9 rows", rows, err)
+	}
+}
+
+// TestLoadStateRecoversInterruptedRewrite recreates the files a crash during the swap
+// of rewriteTable leaves behind, before and after the new schema was saved.
+func TestLoadStateRecoversInterruptedRewrite(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	open := func() *DBManager {
+		t.Helper()
+		dm := disk.NewDiskManager(cfg)
+		if err := dm.Init(); err != nil {
+			t.Fatalf("dm.Init: %v", err)
+		}
+		m := NewDBManager(cfg, dm, buffer.NewBufferManager(cfg, dm))
+		if err := m.LoadState(); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("LoadState: %v", err)
+		}
+		return m
+	}
+	m := open()
+	cols := []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "name", Kind: relation.KindVarchar, Size: 8}}
+	if err := m.AddTable(relation.NewRelation("Emp", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	const n = 500
+	for i := 0; i < n; i++ {
+		if _, err := m.InsertRecord("Emp", relation.NewRecord(fmt.Sprint(i), "e")); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if err := m.DropColumn("Emp", "name"); err != nil {
+		t.Fatalf("DropColumn: %v", err)
+	}
+	if err := m.bm.FlushBuffers(); err != nil {
+		t.Fatalf("FlushBuffers: %v", err)
+	}
+	bin := m.dm.BinDir()
+	path := func(name string) string { return filepath.Join(bin, name) }
+	read := func(name string) []byte {
+		t.Helper()
+		data, err := os.ReadFile(path(name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return data
+	}
+	write := func(name string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(path(name), data, 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	check := func(m *DBManager) {
+		t.Helper()
+		if err := m.CheckTable("Emp", false); err != nil {
+			t.Fatalf("CheckTable: %v", err)
+		}
+		rel, err := m.GetTable("Emp")
+		if err != nil || len(rel.Columns) != 1 {
+			t.Fatalf("GetTable = %v, %v; want the rewritten schema", rel, err)
+		}
+		if rows, _, err := m.TableStats("Emp"); err != nil || rows != n {
+			t.Fatalf("TableStats = %d, %v; want %d rows", rows, err, n)
+		}
+		for _, name := range []string{"Emp~rewrite.hdr", "Emp~rewrite.pages"} {
+			if _, err := os.Stat(path(name)); !os.IsNotExist(err) {
+				t.Fatalf("%s left behind: %v", name, err)
+			}
+		}
+	}
+	pages := read("Emp.pages")
+	stale := binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, 0), 4000)
+
+	// crash after the schema was saved: the page directory of the new heap is still
+	// under the temporary name, the old table's directory under the table's
+	write("Emp~rewrite.hdr", read("Emp.hdr"))
+	write("Emp~rewrite.pages", pages)
+	write("Emp.pages", stale)
+	check(open())
+	if got := read("Emp.pages"); string(got) != string(pages) {
+		t.Fatalf("the page directory of the new heap was not moved into place")
+	}
+
+	// crash before the schema was saved: the temporary heap is abandoned
+	write("Emp~rewrite.hdr", stale)
+	write("Emp~rewrite.pages", stale)
+	check(open())
+	if got := read("Emp.pages"); string(got) != string(pages) {
+		t.Fatalf("the page directory of the table was replaced by an abandoned rewrite")
+	}
+}
+
+func TestModifyColumnConversions(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	cols := []relation.ColumnInfo{
+		{Name: "age", Kind: relation.KindInt},
+		{Name: "code", Kind: relation.KindChar, Size: 5},
+		{Name: "note", Kind: relation.KindVarchar, Size: 10},
+	}
+	if err := m.AddTable(relation.NewRelation("Emp", cols)); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	for _, r := range [][]string{{"30", "abcde", "12"}, {"41", "xy", "n/a"}} {
+		if _, err := m.InsertRecord("Emp", relation.NewRecord(r...)); err != nil {
+			t.Fatalf("insert %v: %v", r, err)
+		}
+	}
+	rows := func() []string {
+		var out []string
+		if err := m.ScanTableRecords("Emp", func(rec relation.Record, _ relation.RecordId) error {
+			out = append(out, strings.Join(rec.Values, "|"))
+			return nil
+		}); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		sort.Strings(out)
+		return out
+	}
+	want := []string{"30|abcde|12", "41|xy|n/a"}
+
+	if err := m.ModifyColumn("Emp", "code", relation.ColumnInfo{Kind: relation.KindChar, Size: 10}); err != nil {
+		t.Fatalf("widen CHAR(5) to CHAR(10): %v", err)
+	}
+	if err := m.ModifyColumn("Emp", "age", relation.ColumnInfo{Kind: relation.KindFloat}); err != nil {
+		t.Fatalf("INT to FLOAT: %v", err)
+	}
+	rel, _ := m.GetTable("Emp")
+	if got := rel.Columns[1].TypeString(); got != "CHAR(10)" || rel.Columns[1].Name != "code" {
+		t.Fatalf("code column is %s %s", rel.Columns[1].Name, got)
+	}
+	if rel.Columns[0].Kind != relation.KindFloat {
+		t.Fatalf("age column is %s", rel.Columns[0].TypeString())
+	}
+	if got := rows(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("rows after widening = %v, want %v", got, want)
+	}
+
+	// "n/a" is not a number: the change is rejected and the table left as it was
+	err := m.ModifyColumn("Emp", "note", relation.ColumnInfo{Kind: relation.KindInt})
+	if err == nil || !strings.Contains(err.Error(), `"n/a"`) {
+		t.Fatalf("VARCHAR to INT with non-numeric data: err = %v", err)
+	}
+	if err := m.ModifyColumn("Emp", "code", relation.ColumnInfo{Kind: relation.KindChar, Size: 3}); err == nil {
+		t.Fatalf("narrowing below the stored length should fail")
+	}
+	rel, _ = m.GetTable("Emp")
+	if rel.Columns[2].Kind != relation.KindVarchar || rel.Columns[1].Size != 10 {
+		t.Fatalf("rejected changes altered the schema: %+v", rel.Columns)
+	}
+	if got := rows(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("rows after rejected changes = %v, want %v", got, want)
+	}
+}
+
+func TestAddTableRejectsRecordLargerThanPage(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 4096, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	err := m.AddTable(relation.NewRelation("Big", []relation.ColumnInfo{{Name: "c", Kind: relation.KindChar, Size: 100000}}))
+	if err == nil || !strings.Contains(err.Error(), "record size 100000") || !strings.Contains(err.Error(), "4096-byte page") {
+		t.Fatalf("AddTable = %v, want an error naming the record and page sizes", err)
+	}
+	if m.TableCount() != 0 {
+		t.Fatalf("the table was registered anyway")
+	}
+	if n, err := dm.AllocatedPageCount(); err != nil || n != 0 {
+		t.Fatalf("%d pages allocated (%v), want none", n, err)
+	}
+
+	// a schema change that would not fit leaves the table alone
+	if err := m.AddTable(relation.NewRelation("Emp", []relation.ColumnInfo{{Name: "c", Kind: relation.KindChar, Size: 10}})); err != nil {
+		t.Fatalf("AddTable: %v", err)
+	}
+	if _, err := m.InsertRecord("Emp", relation.NewRecord("kept")); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := m.ModifyColumn("Emp", "c", relation.ColumnInfo{Kind: relation.KindChar, Size: 5000}); err == nil {
+		t.Fatalf("widening past the page size should fail")
+	}
+	if rows, _, err := m.TableStats("Emp"); err != nil || rows != 1 {
+		t.Fatalf("TableStats = %d, %v, want the row kept", rows, err)
+	}
+}
+
+func TestDefragmentTable(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 256, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	// A has an out-of-line VARCHAR, so its overflow pages move too
+	for _, r := range []*relation.Relation{
+		relation.NewRelation("A", []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "s", Kind: relation.KindVarchar, Size: 600}}),
+		relation.NewRelation("B", []relation.ColumnInfo{{Name: "id", Kind: relation.KindInt}, {Name: "s", Kind: relation.KindChar, Size: 40}}),
+	} {
+		if err := m.AddTable(r); err != nil {
+			t.Fatalf("AddTable: %v", err)
+		}
+	}
+	// interleaved inserts spread both tables over the file
+	want := map[string]string{}
+	for i := 0; i < 60; i++ {
+		v := strings.Repeat(fmt.Sprint(i%10), 1+i*7%500)
+		if _, err := m.InsertRecord("A", relation.NewRecord(fmt.Sprint(i), v)); err != nil {
+			t.Fatalf("insert A: %v", err)
+		}
+		want[fmt.Sprint(i)] = v
+		if _, err := m.InsertRecord("B", relation.NewRecord(fmt.Sprint(i), "b")); err != nil {
+			t.Fatalf("insert B: %v", err)
+		}
+	}
+	if _, err := m.DeleteWhere("A", func(rec *relation.Record) (bool, error) { return rec.Values[0] == "7", nil }, false); err != nil {
+		t.Fatalf("DeleteWhere: %v", err)
+	}
+	delete(want, "7")
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	allocated, _ := dm.AllocatedPageCount()
+
+	// a pinned page blocks it
+	rm := m.rms["A"]
+	if _, err := bm.GetPage(rm.HeaderPageId); err != nil {
+		t.Fatalf("GetPage: %v", err)
+	}
+	if _, err := m.DefragmentTable("A"); err == nil {
+		t.Fatalf("DefragmentTable should refuse to run with a pinned page")
+	}
+	if err := bm.FreePage(rm.HeaderPageId, false); err != nil {
+		t.Fatalf("FreePage: %v", err)
+	}
+
+	n, err := m.DefragmentTable("A")
+	if err != nil {
+		t.Fatalf("DefragmentTable: %v", err)
+	}
+	data, err := rm.AllPageIds()
+	if err != nil {
+		t.Fatalf("AllPageIds: %v", err)
+	}
+	overflow, err := rm.OverflowPageIds()
+	if err != nil {
+		t.Fatalf("OverflowPageIds: %v", err)
+	}
+	pages := append(data, overflow...)
+	if n != len(pages) || len(overflow) == 0 {
+		t.Fatalf("moved %d pages, the table has %d data and %d overflow pages", n, len(data), len(overflow))
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].PageIdx < pages[j].PageIdx })
+	for i, pid := range pages {
+		if pid.FileIdx != pages[0].FileIdx || pid.PageIdx != pages[0].PageIdx+i {
+			t.Fatalf("pages are not contiguous after defragmenting: %v", pages)
+		}
+	}
+	// the old pages are given back
+	if after, _ := dm.AllocatedPageCount(); after != allocated {
+		t.Fatalf("%d pages allocated after defragmenting, want %d", after, allocated)
+	}
+	if err := m.CheckTable("A", false); err != nil {
+		t.Fatalf("CheckTable: %v", err)
+	}
+	check := func(m *DBManager) {
+		t.Helper()
+		got := map[string]string{}
+		if err := m.ScanTableRecords("A", func(rec relation.Record, _ relation.RecordId) error {
+			got[rec.Values[0]] = rec.Values[1]
+			return nil
+		}); err != nil {
+			t.Fatalf("scan A: %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("A has %d records, want %d", len(got), len(want))
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Fatalf("record %s changed: %d bytes, want %d", k, len(got[k]), len(v))
+			}
+		}
+		if rows, _, err := m.TableStats("B"); err != nil || rows != 60 {
+			t.Fatalf("B has %d rows (%v), want 60", rows, err)
+		}
+	}
+	check(m)
+
+	// and survives a restart
+	if err := m.SaveState(); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	dm2 := disk.NewDiskManager(cfg)
+	if err := dm2.Init(); err != nil {
+		t.Fatalf("dm2.Init: %v", err)
+	}
+	m2 := NewDBManager(cfg, dm2, buffer.NewBufferManager(cfg, dm2))
+	if err := m2.LoadState(); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	check(m2)
+}
+
+// TestKeepHeadersSurvivesScan checks that with bm_keep_headers the header pages of
+// the tables stay in a pool that a scan of many data pages cycles through, and that
+// dropping a table gives its header back.
+func TestKeepHeadersSurvivesScan(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	cfg.BMBufferCount = 3
+	cfg.BMKeepHeaders = true
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm.Init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	m := NewDBManager(cfg, dm, bm)
+	for _, name := range []string{"A", "B"} {
+		if err := m.AddTable(relation.NewRelation(name, []relation.ColumnInfo{{Name: "x", Kind: relation.KindInt}})); err != nil {
+			t.Fatalf("AddTable %s: %v", name, err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := m.InsertRecord("A", relation.NewRecord(fmt.Sprint(i))); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	if err := m.ScanTableRecords("A", func(relation.Record, relation.RecordId) error { return nil }); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	resident := func(pid config.PageId) bool {
+		bm.ResetStats()
+		if _, err := bm.GetPage(pid); err != nil {
+			t.Fatalf("get %v: %v", pid, err)
+		}
+		if err := bm.FreePage(pid, false); err != nil {
+			t.Fatalf("free %v: %v", pid, err)
+		}
+		return bm.Stats().Hits == 1
+	}
+	for _, name := range []string{"A", "B"} {
+		if !resident(m.rms[name].HeaderPageId) {
+			t.Fatalf("header of %s evicted by the scan", name)
+		}
+	}
+	header := m.rms["B"].HeaderPageId
+	if err := m.RemoveTable("B"); err != nil {
+		t.Fatalf("RemoveTable: %v", err)
+	}
+	if err := m.ScanTableRecords("A", func(relation.Record, relation.RecordId) error { return nil }); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if resident(header) {
+		t.Fatalf("header of a dropped table still kept")
+	}
+}
@@ -6,81 +6,202 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 
 	"malzahar-project/Projet_BDDA/config"
 )
 
-// DiskManager handles page-level allocation and I/O on Datax.bin files under BinData.
+var (
+	// ErrNoSpace is returned when no page can be allocated because every data file
+	// dm_maxfilecount allows is full.
+	ErrNoSpace = errors.New("no space: reached dm_maxfilecount")
+	// ErrInvalidPage is returned for a PageId outside the allocated pages.
+	ErrInvalidPage = errors.New("invalid page")
+	// ErrPageSizeMismatch is returned by Init when the data files were created with
+	// another page size than the configured one.
+	ErrPageSizeMismatch = errors.New("page size mismatch")
+	// ErrPageReserveMismatch is returned by Init when the data files were created
+	// with another page_reserve_bytes than the configured one.
+	ErrPageReserveMismatch = errors.New("page reserve mismatch")
+)
+
+// DiskManager handles page-level allocation and I/O on Datax.bin files under BinData,
+// or on in-memory files (see NewDiskManagerInMemory).
 type DiskManager struct {
 	cfg    *config.DBConfig
 	binDir string
 	mu     sync.Mutex
-	// bitmaps[fileIdx] = []byte (0 free, 1 used)
+	// bitmaps[fileIdx] = []byte (0 free, 1 used); the in-memory copy is authoritative
 	bitmaps map[int][]byte
+	// onDisk holds the bitmaps as last persisted, and dirty the files whose bitmap
+	// changed since; see markUsed and persistDirtyBitmaps
+	onDisk map[int][]byte
+	dirty  map[int]bool
+	// unsynced holds data files written without fsync under SyncBatch
+	unsynced map[int]bool
+	// files caches the open DataN.bin handles by file index; see file and Close
+	files map[int]dataFile
+	// st holds the data files and bitmaps: on disk, or in memory
+	st storage
+	// wal, when attached, logs every page before it is written; see AttachWAL
+	wal *WAL
 }
 
-// NewDiskManager creates a manager but does not initialize on disk.
+// NewDiskManager creates a manager but does not initialize on disk. Data files go to
+// cfg.BinDir when set, otherwise to DBPath/BinData.
 func NewDiskManager(cfg *config.DBConfig) *DiskManager {
+	binDir := cfg.BinDir
+	if binDir == "" {
+		binDir = filepath.Join(cfg.DBPath, "BinData")
+	}
+	return newDiskManager(cfg, binDir, &fileStorage{dir: binDir})
+}
+
+// NewDiskManagerInMemory creates a manager keeping the data files and their bitmaps
+// in memory: nothing survives the process, and syncing costs nothing. BinDir is
+// still the DBPath/BinData (or cfg.BinDir) directory, which Init creates, for the
+// metadata files the layers above keep next to the pages.
+func NewDiskManagerInMemory(cfg *config.DBConfig) *DiskManager {
+	binDir := cfg.BinDir
+	if binDir == "" {
+		binDir = filepath.Join(cfg.DBPath, "BinData")
+	}
+	return newDiskManager(cfg, binDir, newMemStorage())
+}
+
+func newDiskManager(cfg *config.DBConfig, binDir string, st storage) *DiskManager {
 	return &DiskManager{
-		cfg:     cfg,
-		binDir:  filepath.Join(cfg.DBPath, "BinData"),
-		bitmaps: make(map[int][]byte),
+		cfg:      cfg,
+		binDir:   binDir,
+		bitmaps:  make(map[int][]byte),
+		onDisk:   make(map[int][]byte),
+		dirty:    make(map[int]bool),
+		unsynced: make(map[int]bool),
+		files:    make(map[int]dataFile),
+		st:       st,
 	}
 }
 
-// Init creates the BinData directory and ensures at least Data0.bin exists.
+// Init creates the BinData directory, ensures at least Data0.bin exists and loads the
+// bitmap of every existing data file, reconciled with the file's size (see
+// loadBitmap): a data file whose bitmap is missing or truncated keeps its pages.
 func (m *DiskManager) Init() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if err := os.MkdirAll(m.binDir, 0o755); err != nil {
 		return err
 	}
+	if err := m.st.checkPageSize(m.cfg.PageSize); err != nil {
+		return err
+	}
+	if err := m.st.checkPageReserve(m.cfg.PageReserveBytes); err != nil {
+		return err
+	}
 	// ensure Data0.bin exists
-	path := filepath.Join(m.binDir, fmt.Sprintf("Data%d.bin", 0))
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		f, err := os.Create(path)
-		if err != nil {
+	if _, err := m.st.dataSize(0); os.IsNotExist(err) {
+		if _, err := m.file(0); err != nil {
 			return err
 		}
-		f.Close()
 	}
 	// load bitmap if present, otherwise create empty
 	if err := m.loadBitmap(0); err != nil {
 		return err
 	}
+	for idx := 1; idx < m.cfg.DMMaxFileCount; idx++ {
+		if _, err := m.st.dataSize(idx); os.IsNotExist(err) {
+			continue
+		}
+		if err := m.loadBitmap(idx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (m *DiskManager) bitmapPath(idx int) string {
-	return filepath.Join(m.binDir, fmt.Sprintf("Data%d.bitmap", idx))
-}
-
-func (m *DiskManager) dataPath(idx int) string {
-	return filepath.Join(m.binDir, fmt.Sprintf("Data%d.bin", idx))
+// file returns the cached read-write handle of DataN.bin, opening (and creating)
+// it on first use. Caller must hold m.mu.
+func (m *DiskManager) file(idx int) (dataFile, error) {
+	if f, ok := m.files[idx]; ok {
+		return f, nil
+	}
+	f, err := m.st.open(idx)
+	if err != nil {
+		return nil, err
+	}
+	m.files[idx] = f
+	return f, nil
 }
 
+// loadBitmap reads the bitmap of file idx, creating an empty one if missing. Pages
+// the data file holds past the end of the bitmap (pages = file size / page size,
+// rounded up) were allocated by growing the file before a crash lost the bitmap
+// update, or the bitmap file was lost or truncated: there is no telling which of them
+// are still in use, so they are all marked used (at worst leaked, never handed out
+// twice) and the repaired bitmap is persisted.
 func (m *DiskManager) loadBitmap(idx int) error {
-	p := m.bitmapPath(idx)
-	if _, err := os.Stat(p); os.IsNotExist(err) {
-		m.bitmaps[idx] = []byte{}
-		// create empty bitmap file
-		if f, err := os.Create(p); err == nil {
-			f.Close()
-		}
+	data, err := m.st.readBitmap(idx)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	m.bitmaps[idx] = data
+	m.onDisk[idx] = append([]byte{}, data...)
+	pages := 0
+	if size, err := m.st.dataSize(idx); err == nil && m.cfg.PageSize > 0 {
+		pages = int((size + int64(m.cfg.PageSize) - 1) / int64(m.cfg.PageSize))
+	}
+	if len(data) >= pages && err == nil {
 		return nil
 	}
-	data, err := os.ReadFile(p)
-	if err != nil {
+	for len(m.bitmaps[idx]) < pages {
+		m.bitmaps[idx] = append(m.bitmaps[idx], 1)
+	}
+	return m.persistBitmap(idx)
+}
+
+// persistBitmap writes the bitmap of file idx so that a crash leaves either version
+// whole (a temporary file renamed over the old one). Caller must hold m.mu.
+func (m *DiskManager) persistBitmap(idx int) error {
+	if err := m.st.writeBitmap(idx, m.bitmaps[idx], m.cfg.SyncMode != config.SyncNever); err != nil {
 		return err
 	}
-	m.bitmaps[idx] = data
+	m.onDisk[idx] = append(m.onDisk[idx][:0], m.bitmaps[idx]...)
+	delete(m.dirty, idx)
 	return nil
 }
 
-func (m *DiskManager) persistBitmap(idx int) error {
-	p := m.bitmapPath(idx)
-	return os.WriteFile(p, m.bitmaps[idx], 0o644)
+// persistDirtyBitmaps persists the bitmaps changed since they were last persisted.
+// Caller must hold m.mu.
+func (m *DiskManager) persistDirtyBitmaps() error {
+	for idx := range m.dirty {
+		if err := m.persistBitmap(idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markUsed marks pages of file idx used in memory. Persisting that is deferred to
+// the next Sync, Checkpoint or Finish when a crash cannot lose it: a page past the
+// end of the persisted bitmap lies in the grown data file, which loadBitmap marks
+// used. A page the persisted bitmap has free could be handed out again after a
+// crash, so the bitmap is persisted at once. Caller must hold m.mu.
+func (m *DiskManager) markUsed(idx int, pages ...int) error {
+	now := false
+	for _, i := range pages {
+		m.bitmaps[idx][i] = 1
+		if i < len(m.onDisk[idx]) && m.onDisk[idx][i] == 0 {
+			now = true
+		}
+	}
+	m.dirty[idx] = true
+	if now {
+		return m.persistBitmap(idx)
+	}
+	return nil
 }
 
 // AllocatePage finds a free page or grows Data files and returns its PageId.
@@ -102,53 +223,244 @@ func (m *DiskManager) AllocatePage() (config.PageId, error) {
 		bmp := m.bitmaps[idx]
 		for i, b := range bmp {
 			if b == 0 {
-				m.bitmaps[idx][i] = 1
-				if err := m.persistBitmap(idx); err != nil {
+				if err := m.markUsed(idx, i); err != nil {
 					return config.PageId{}, err
 				}
 				return config.PageId{FileIdx: idx, PageIdx: i}, nil
 			}
 		}
 		// no free page, try to append one by extending file
-		// open file and append one page sized zero bytes
-		dataPath := m.dataPath(idx)
-		f, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		// append one page sized zero bytes at the end of the data file
+		f, err := m.file(idx)
 		if err != nil {
 			return config.PageId{}, err
 		}
-		zero := make([]byte, ps)
-		if _, err := f.Write(zero); err != nil {
-			f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			return config.PageId{}, err
+		}
+		if _, err := f.WriteAt(make([]byte, ps), stat.Size()); err != nil {
 			return config.PageId{}, err
 		}
-		f.Close()
 		// extend bitmap
-		m.bitmaps[idx] = append(m.bitmaps[idx], 1)
-		if err := m.persistBitmap(idx); err != nil {
+		m.bitmaps[idx] = append(m.bitmaps[idx], 0)
+		i := len(m.bitmaps[idx]) - 1
+		if err := m.markUsed(idx, i); err != nil {
 			return config.PageId{}, err
 		}
-		return config.PageId{FileIdx: idx, PageIdx: len(m.bitmaps[idx]) - 1}, nil
+		return config.PageId{FileIdx: idx, PageIdx: i}, nil
+	}
+	return config.PageId{}, ErrNoSpace
+}
+
+// AllocateContiguous allocates n pages with consecutive PageIdx in one data file and
+// returns them in order. It takes the first run of n free pages, like AllocatePage
+// takes the first free page; a run reaching the end of the file grows it.
+func (m *DiskManager) AllocateContiguous(n int) ([]config.PageId, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ps := m.cfg.PageSize
+	if ps <= 0 {
+		return nil, errors.New("invalid pagesize")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	for idx := 0; idx < m.cfg.DMMaxFileCount; idx++ {
+		if _, ok := m.bitmaps[idx]; !ok {
+			if err := m.loadBitmap(idx); err != nil {
+				return nil, err
+			}
+		}
+		bmp := m.bitmaps[idx]
+		// start of the first run of n free pages, counting the pages past the end
+		start, run := 0, 0
+		for i := 0; i < len(bmp) && run < n; i++ {
+			if bmp[i] != 0 {
+				start, run = i+1, 0
+			} else {
+				run++
+			}
+		}
+		if grow := start + n - len(bmp); grow > 0 {
+			f, err := m.file(idx)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := f.WriteAt(make([]byte, grow*ps), int64(len(bmp))*int64(ps)); err != nil {
+				return nil, err
+			}
+			bmp = append(bmp, make([]byte, grow)...)
+		}
+		m.bitmaps[idx] = bmp
+		out := make([]config.PageId, n)
+		pages := make([]int, n)
+		for i := range out {
+			out[i] = config.PageId{FileIdx: idx, PageIdx: start + i}
+			pages[i] = start + i
+		}
+		if err := m.markUsed(idx, pages...); err != nil {
+			return nil, err
+		}
+		return out, nil
 	}
-	return config.PageId{}, errors.New("no space: reached dm_maxfilecount")
+	return nil, ErrNoSpace
 }
 
 // FreePage marks a page free.
 func (m *DiskManager) FreePage(pid config.PageId) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if pid.FileIdx < 0 || pid.FileIdx >= m.cfg.DMMaxFileCount {
-		return errors.New("invalid file idx")
+	if err := m.checkPage(pid); err != nil {
+		return err
 	}
-	if _, ok := m.bitmaps[pid.FileIdx]; !ok {
-		if err := m.loadBitmap(pid.FileIdx); err != nil {
-			return err
+	// losing this in a crash only leaks the page, so it waits for the next persist
+	m.bitmaps[pid.FileIdx][pid.PageIdx] = 0
+	m.dirty[pid.FileIdx] = true
+	return nil
+}
+
+// RemoveEmptyFiles deletes the data files, other than Data0.bin, whose pages are
+// all free, with their bitmaps, and returns their indexes in order. inUse reports
+// the files the caller still holds pages of, typically in a buffer pool, which are
+// kept; nil keeps none. A removed file is created again when allocation reaches it.
+// The attached WAL, if any, is emptied first so that a replay cannot bring a removed
+// page back: dirty buffers must have been flushed, as for Checkpoint.
+func (m *DiskManager) RemoveEmptyFiles(inUse func(fileIdx int) bool) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var empty []int
+	for idx := 1; idx < m.cfg.DMMaxFileCount; idx++ {
+		if _, ok := m.bitmaps[idx]; !ok {
+			if _, err := m.st.dataSize(idx); os.IsNotExist(err) {
+				continue
+			}
+			if err := m.loadBitmap(idx); err != nil {
+				return nil, err
+			}
+		}
+		if !allFree(m.bitmaps[idx]) || (inUse != nil && inUse(idx)) {
+			continue
 		}
+		empty = append(empty, idx)
 	}
-	if pid.PageIdx < 0 || pid.PageIdx >= len(m.bitmaps[pid.FileIdx]) {
-		return errors.New("invalid page idx")
+	if len(empty) == 0 {
+		return nil, nil
 	}
-	m.bitmaps[pid.FileIdx][pid.PageIdx] = 0
-	return m.persistBitmap(pid.FileIdx)
+	if m.wal != nil {
+		if err := m.syncAll(); err != nil {
+			return nil, err
+		}
+		if err := m.wal.Truncate(); err != nil {
+			return nil, err
+		}
+	}
+	for i, idx := range empty {
+		if f, ok := m.files[idx]; ok {
+			if err := f.Close(); err != nil {
+				return empty[:i], err
+			}
+			delete(m.files, idx)
+		}
+		if err := m.st.remove(idx); err != nil {
+			return empty[:i], err
+		}
+		delete(m.bitmaps, idx)
+		delete(m.onDisk, idx)
+		delete(m.dirty, idx)
+		delete(m.unsynced, idx)
+	}
+	return empty, nil
+}
+
+// allFree reports whether a bitmap has no used page.
+func allFree(bmp []byte) bool {
+	for _, b := range bmp {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FileStats counts the pages of one data file, as recorded in its bitmap.
+type FileStats struct {
+	FileIdx int
+	Total   int
+	Used    int
+	Free    int
+}
+
+// DiskStats holds per-file page counts for the existing data files and their sums.
+type DiskStats struct {
+	Files []FileStats
+	Total int
+	Used  int
+	Free  int
+}
+
+// Stats computes page usage from the bitmaps. Free pages are pages of the data files
+// that are not allocated (they are reused before any file grows).
+func (m *DiskManager) Stats() (DiskStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var st DiskStats
+	for idx := 0; idx < m.cfg.DMMaxFileCount; idx++ {
+		if _, ok := m.bitmaps[idx]; !ok {
+			if _, err := m.st.readBitmap(idx); os.IsNotExist(err) {
+				continue
+			}
+			if err := m.loadBitmap(idx); err != nil {
+				return DiskStats{}, err
+			}
+		}
+		fs := FileStats{FileIdx: idx, Total: len(m.bitmaps[idx])}
+		for _, b := range m.bitmaps[idx] {
+			if b != 0 {
+				fs.Used++
+			}
+		}
+		fs.Free = fs.Total - fs.Used
+		st.Files = append(st.Files, fs)
+		st.Total += fs.Total
+		st.Used += fs.Used
+		st.Free += fs.Free
+	}
+	return st, nil
+}
+
+// AllocatedPageCount returns the number of pages currently marked used across the
+// existing data files.
+func (m *DiskManager) AllocatedPageCount() (int, error) {
+	st, err := m.Stats()
+	if err != nil {
+		return 0, err
+	}
+	return st.Used, nil
+}
+
+// AllocatedPages returns every page currently marked used in the bitmaps of the
+// existing data files, ordered by file then page index.
+func (m *DiskManager) AllocatedPages() ([]config.PageId, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []config.PageId
+	for idx := 0; idx < m.cfg.DMMaxFileCount; idx++ {
+		if _, ok := m.bitmaps[idx]; !ok {
+			if _, err := m.st.readBitmap(idx); os.IsNotExist(err) {
+				continue
+			}
+			if err := m.loadBitmap(idx); err != nil {
+				return nil, err
+			}
+		}
+		for i, b := range m.bitmaps[idx] {
+			if b != 0 {
+				out = append(out, config.PageId{FileIdx: idx, PageIdx: i})
+			}
+		}
+	}
+	return out, nil
 }
 
 // WritePage writes exactly one page worth of data to the page's offset.
@@ -158,85 +470,251 @@ func (m *DiskManager) WritePage(pid config.PageId, data []byte) error {
 	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if err := m.checkPage(pid); err != nil {
+		return err
+	}
+	if err := m.logPages([]PageImage{{Pid: pid, Data: padToPage(data, m.cfg.PageSize)}}); err != nil {
+		return err
+	}
+	f, err := m.file(pid.FileIdx)
+	if err != nil {
+		return err
+	}
+	if err := m.writeAt(f, pid, data); err != nil {
+		return err
+	}
+	switch m.cfg.SyncMode {
+	case config.SyncBatch:
+		m.unsynced[pid.FileIdx] = true
+	case config.SyncNever:
+	default:
+		// ensure data is written to disk
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePages writes several pages at once. Writes are grouped by data file: each
+// DataN.bin is written in page order and synced once, which is much cheaper than
+// one WritePage (write + fsync) per page. Unless the sync mode
+// is SyncNever, a WritePages call is a durability point.
+func (m *DiskManager) WritePages(pages map[config.PageId][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byFile := make(map[int][]config.PageId)
+	for pid, data := range pages {
+		if len(data) > m.cfg.PageSize {
+			return errors.New("data too large")
+		}
+		if err := m.checkPage(pid); err != nil {
+			return err
+		}
+		byFile[pid.FileIdx] = append(byFile[pid.FileIdx], pid)
+	}
+	files := make([]int, 0, len(byFile))
+	for idx := range byFile {
+		files = append(files, idx)
+	}
+	sort.Ints(files)
+	if m.wal != nil {
+		// the whole batch is logged, and replayed, as one unit
+		var images []PageImage
+		for _, idx := range files {
+			pids := byFile[idx]
+			sort.Slice(pids, func(i, j int) bool { return pids[i].PageIdx < pids[j].PageIdx })
+			for _, pid := range pids {
+				images = append(images, PageImage{Pid: pid, Data: padToPage(pages[pid], m.cfg.PageSize)})
+			}
+		}
+		if err := m.logPages(images); err != nil {
+			return err
+		}
+	}
+	for _, idx := range files {
+		pids := byFile[idx]
+		sort.Slice(pids, func(i, j int) bool { return pids[i].PageIdx < pids[j].PageIdx })
+		f, err := m.file(idx)
+		if err != nil {
+			return err
+		}
+		for _, pid := range pids {
+			if err := m.writeAt(f, pid, pages[pid]); err != nil {
+				return err
+			}
+		}
+		if m.cfg.SyncMode != config.SyncNever {
+			if err := f.Sync(); err != nil {
+				return err
+			}
+			delete(m.unsynced, idx)
+		}
+	}
+	if m.cfg.SyncMode == config.SyncBatch {
+		return m.syncUnsynced()
+	}
+	return nil
+}
+
+// syncUnsynced fsyncs the data files written without sync under SyncBatch.
+// Caller must hold m.mu.
+func (m *DiskManager) syncUnsynced() error {
+	for idx := range m.unsynced {
+		f, err := m.file(idx)
+		if err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		delete(m.unsynced, idx)
+	}
+	return nil
+}
+
+// checkPage validates pid against the configured files and the file's bitmap.
+// Caller must hold m.mu.
+func (m *DiskManager) checkPage(pid config.PageId) error {
 	if pid.FileIdx < 0 || pid.FileIdx >= m.cfg.DMMaxFileCount {
-		return errors.New("invalid file idx")
+		return fmt.Errorf("%w: invalid file idx %d", ErrInvalidPage, pid.FileIdx)
 	}
 	if _, ok := m.bitmaps[pid.FileIdx]; !ok {
+		// a missing file, maybe removed by RemoveEmptyFiles, has no pages
+		if _, err := m.st.dataSize(pid.FileIdx); os.IsNotExist(err) {
+			return fmt.Errorf("%w: no data file %d", ErrInvalidPage, pid.FileIdx)
+		}
 		if err := m.loadBitmap(pid.FileIdx); err != nil {
 			return err
 		}
 	}
 	if pid.PageIdx < 0 || pid.PageIdx >= len(m.bitmaps[pid.FileIdx]) {
-		return errors.New("invalid page idx")
+		return fmt.Errorf("%w: invalid page idx %d", ErrInvalidPage, pid.PageIdx)
 	}
-	path := m.dataPath(pid.FileIdx)
-	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+	return nil
+}
+
+// CheckPage reports whether pid names a page of an existing data file, without
+// reading it.
+func (m *DiskManager) CheckPage(pid config.PageId) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkPage(pid)
+}
+
+// writeAt writes one page into the already opened data file f, growing the file
+// with zeros if it is too short.
+func (m *DiskManager) writeAt(f dataFile, pid config.PageId, data []byte) error {
 	off := int64(pid.PageIdx) * int64(m.cfg.PageSize)
 	// ensure file large enough
 	if stat, err := f.Stat(); err == nil {
 		if stat.Size() < off+int64(m.cfg.PageSize) {
 			// extend file with zeros
-			if _, err := f.Seek(0, io.SeekEnd); err != nil {
-				return err
-			}
-			if _, err := f.Write(make([]byte, off+int64(m.cfg.PageSize)-stat.Size())); err != nil {
+			if _, err := f.WriteAt(make([]byte, off+int64(m.cfg.PageSize)-stat.Size()), stat.Size()); err != nil {
 				return err
 			}
 		}
 	}
 	// write at offset
-	if _, err := f.WriteAt(padToPage(data, m.cfg.PageSize), off); err != nil {
+	_, err := f.WriteAt(padToPage(data, m.cfg.PageSize), off)
+	return err
+}
+
+// ReadPage reads exactly one page into a new buffer. Bytes past the current end of
+// the data file (a page allocated but never written) read back as zeros.
+func (m *DiskManager) ReadPage(pid config.PageId) ([]byte, error) {
+	buf := make([]byte, m.cfg.PageSize)
+	if err := m.ReadPageInto(pid, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// ReadPageInto reads exactly one page into buf, which must be PageSize bytes long,
+// so that callers reading many pages can reuse one buffer. buf is left untouched if
+// pid is invalid; on a read error its content is undefined.
+func (m *DiskManager) ReadPageInto(pid config.PageId, buf []byte) error {
+	if len(buf) != m.cfg.PageSize {
+		return fmt.Errorf("read buffer is %d bytes, page size is %d", len(buf), m.cfg.PageSize)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkPage(pid); err != nil {
 		return err
 	}
-	// ensure data is written to disk
-	if err := f.Sync(); err != nil {
+	f, err := m.file(pid.FileIdx)
+	if err != nil {
+		return err
+	}
+	off := int64(pid.PageIdx) * int64(m.cfg.PageSize)
+	n, err := f.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
 		return err
 	}
+	// zero-fill any tail the file could not provide
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
 	return nil
 }
 
-// ReadPage reads exactly one page.
-func (m *DiskManager) ReadPage(pid config.PageId) ([]byte, error) {
+// Sync fsyncs every data file that may hold unsynced writes: the cached handles
+// and, under SyncBatch, the files written since the last sync, then persists the
+// bitmaps changed since the last persist. It is a durability point whatever the sync
+// mode, without paying an fsync per write.
+func (m *DiskManager) Sync() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if pid.FileIdx < 0 || pid.FileIdx >= m.cfg.DMMaxFileCount {
-		return nil, errors.New("invalid file idx")
+	return m.syncAll()
+}
+
+// syncAll fsyncs the unsynced files and every cached handle, then persists the
+// changed bitmaps. Caller must hold m.mu.
+func (m *DiskManager) syncAll() error {
+	if err := m.syncUnsynced(); err != nil {
+		return err
 	}
-	if _, ok := m.bitmaps[pid.FileIdx]; !ok {
-		if err := m.loadBitmap(pid.FileIdx); err != nil {
-			return nil, err
+	for _, f := range m.files {
+		if err := f.Sync(); err != nil {
+			return err
 		}
 	}
-	if pid.PageIdx < 0 || pid.PageIdx >= len(m.bitmaps[pid.FileIdx]) {
-		return nil, errors.New("invalid page idx")
-	}
-	path := m.dataPath(pid.FileIdx)
-	f, err := os.OpenFile(path, os.O_RDONLY, 0o644)
-	if err != nil {
-		return nil, err
+	return m.persistDirtyBitmaps()
+}
+
+// Finish persists the changed bitmaps, under SyncBatch fsyncs data files that were
+// written without sync, and closes the cached data file handles.
+func (m *DiskManager) Finish() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.syncUnsynced(); err != nil {
+		return err
 	}
-	defer f.Close()
-	off := int64(pid.PageIdx) * int64(m.cfg.PageSize)
-	buf := make([]byte, m.cfg.PageSize)
-	if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
-		return nil, err
+	if err := m.persistDirtyBitmaps(); err != nil {
+		return err
 	}
-	return buf, nil
+	return m.closeFiles()
 }
 
-func (m *DiskManager) Finish() error {
+// Close closes the data file handles kept open between reads and writes. The
+// manager stays usable: a later call reopens the files it needs.
+func (m *DiskManager) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	for idx := range m.bitmaps {
-		if err := m.persistBitmap(idx); err != nil {
-			return err
+	return m.closeFiles()
+}
+
+// closeFiles closes and forgets every cached handle, returning the first error.
+// Caller must hold m.mu.
+func (m *DiskManager) closeFiles() error {
+	var first error
+	for idx, f := range m.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
 		}
+		delete(m.files, idx)
 	}
-	return nil
+	return first
 }
 
 // PageSize returns the configured page size.
@@ -244,6 +722,17 @@ func (m *DiskManager) PageSize() int {
 	return m.cfg.PageSize
 }
 
+// PageReserve returns the number of bytes reserved at the start of every relation
+// page (config page_reserve_bytes).
+func (m *DiskManager) PageReserve() int {
+	return m.cfg.PageReserveBytes
+}
+
+// ByteOrder returns the byte order of integers stored in pages (config byte_order).
+func (m *DiskManager) ByteOrder() config.ByteOrder {
+	return m.cfg.Order()
+}
+
 // BinDir returns the directory path used to store Data*.bin and metadata files.
 func (m *DiskManager) BinDir() string {
 	return m.binDir
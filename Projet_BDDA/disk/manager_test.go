@@ -1,6 +1,8 @@
 package disk
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -43,3 +45,720 @@ func TestDiskManagerLifecycle(t *testing.T) {
 		t.Fatalf("bitmap missing: %v", err)
 	}
 }
+
+func TestDiskManagerInMemory(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 1024, 2)
+	dm := NewDiskManagerInMemory(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	pids, err := dm.AllocateContiguous(3)
+	if err != nil {
+		t.Fatalf("AllocateContiguous: %v", err)
+	}
+	if err := dm.WritePages(map[config.PageId][]byte{pids[0]: []byte("first"), pids[2]: []byte("third")}); err != nil {
+		t.Fatalf("WritePages: %v", err)
+	}
+	if err := dm.FreePage(pids[1]); err != nil {
+		t.Fatalf("FreePage: %v", err)
+	}
+	// closing the handles must not lose the pages
+	if err := dm.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	got, err := dm.ReadPage(pids[2])
+	if err != nil || string(got[:5]) != "third" {
+		t.Fatalf("ReadPage after Finish = %q, %v", got[:5], err)
+	}
+	if n, err := dm.AllocatedPageCount(); err != nil || n != 2 {
+		t.Fatalf("AllocatedPageCount = %d, %v; want 2", n, err)
+	}
+	if pid, err := dm.AllocatePage(); err != nil || pid != pids[1] {
+		t.Fatalf("AllocatePage = %v, %v; want the freed %v", pid, err, pids[1])
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "BinData"))
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("in-memory manager wrote files: %v %v", entries, err)
+	}
+}
+
+func TestDiskManagerCustomBinDir(t *testing.T) {
+	dir := t.TempDir()
+	binDir := filepath.Join(t.TempDir(), "data")
+	cfg := config.NewDBConfigWithBinDir(dir, binDir, 1024, 4)
+	dm := NewDiskManager(cfg)
+	if dm.BinDir() != binDir {
+		t.Fatalf("BinDir = %s, want %s", dm.BinDir(), binDir)
+	}
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if _, err := dm.AllocatePage(); err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	for _, name := range []string{"Data0.bin", "Data0.bitmap"} {
+		if _, err := os.Stat(filepath.Join(binDir, name)); err != nil {
+			t.Fatalf("%s missing from custom bin dir: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "BinData")); !os.IsNotExist(err) {
+		t.Fatalf("default BinData directory should not be created when bin_dir is set")
+	}
+}
+
+func TestReadAllocatedButUnwrittenPage(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 1024, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	p0, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	p1, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if err := dm.WritePage(p0, []byte("first")); err != nil {
+		t.Fatalf("WritePage: %v", err)
+	}
+	// simulate the extension of the second page never reaching disk: the file
+	// ends in the middle of p1
+	if err := os.Truncate(filepath.Join(dir, "BinData", "Data0.bin"), 1024+100); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	got, err := dm.ReadPage(p1)
+	if err != nil {
+		t.Fatalf("ReadPage of short page: %v", err)
+	}
+	if len(got) != 1024 {
+		t.Fatalf("expected full page, got %d bytes", len(got))
+	}
+	for i, b := range got {
+		if b != 0 {
+			t.Fatalf("byte %d = %d, want 0", i, b)
+		}
+	}
+	// p0 is unaffected
+	first, err := dm.ReadPage(p0)
+	if err != nil {
+		t.Fatalf("ReadPage p0: %v", err)
+	}
+	if string(first[:5]) != "first" {
+		t.Fatalf("p0 corrupted: %q", first[:5])
+	}
+}
+
+func TestWritePagesBatch(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 512, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	pages := make(map[config.PageId][]byte)
+	for i := 0; i < 10; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+		pages[pid] = []byte{byte('a' + i)}
+	}
+	if err := dm.WritePages(pages); err != nil {
+		t.Fatalf("WritePages: %v", err)
+	}
+	for pid, want := range pages {
+		got, err := dm.ReadPage(pid)
+		if err != nil {
+			t.Fatalf("ReadPage %v: %v", pid, err)
+		}
+		if got[0] != want[0] {
+			t.Fatalf("page %v: got %q want %q", pid, got[0], want[0])
+		}
+	}
+	// an invalid page in the batch is rejected
+	if err := dm.WritePages(map[config.PageId][]byte{{FileIdx: 0, PageIdx: 99}: {1}}); err == nil {
+		t.Fatalf("expected error for unallocated page")
+	}
+}
+
+func benchmarkPages(b *testing.B, n int) (*DiskManager, map[config.PageId][]byte) {
+	cfg := config.NewDBConfigWithParams(b.TempDir(), 4096, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		b.Fatalf("Init: %v", err)
+	}
+	pages := make(map[config.PageId][]byte)
+	for i := 0; i < n; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			b.Fatalf("AllocatePage: %v", err)
+		}
+		pages[pid] = make([]byte, 4096)
+	}
+	return dm, pages
+}
+
+func BenchmarkWritePageLoop(b *testing.B) {
+	dm, pages := benchmarkPages(b, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for pid, data := range pages {
+			if err := dm.WritePage(pid, data); err != nil {
+				b.Fatalf("WritePage: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkWritePages(b *testing.B) {
+	dm, pages := benchmarkPages(b, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := dm.WritePages(pages); err != nil {
+			b.Fatalf("WritePages: %v", err)
+		}
+	}
+}
+
+func TestSyncModeBatchDefersToFinish(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	cfg.SyncMode = config.SyncBatch
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if err := dm.WritePage(pid, []byte("batched")); err != nil {
+		t.Fatalf("WritePage: %v", err)
+	}
+	if !dm.unsynced[pid.FileIdx] {
+		t.Fatalf("batch mode write should leave the file pending sync")
+	}
+	if err := dm.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if len(dm.unsynced) != 0 {
+		t.Fatalf("Finish should sync pending files, still pending: %v", dm.unsynced)
+	}
+	got, err := dm.ReadPage(pid)
+	if err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+	if string(got[:7]) != "batched" {
+		t.Fatalf("unexpected data %q", got[:7])
+	}
+}
+
+func BenchmarkWritePageSyncModes(b *testing.B) {
+	for _, mode := range []string{config.SyncAlways, config.SyncBatch, config.SyncNever} {
+		b.Run(mode, func(b *testing.B) {
+			dm, pages := benchmarkPages(b, 16)
+			dm.cfg.SyncMode = mode
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for pid, data := range pages {
+					if err := dm.WritePage(pid, data); err != nil {
+						b.Fatalf("WritePage: %v", err)
+					}
+				}
+			}
+			b.StopTimer()
+			if err := dm.Finish(); err != nil {
+				b.Fatalf("Finish: %v", err)
+			}
+		})
+	}
+}
+
+func TestStatsCountsAllocatedAndFreePages(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	check := func(total, used, free int) {
+		t.Helper()
+		st, err := dm.Stats()
+		if err != nil {
+			t.Fatalf("Stats: %v", err)
+		}
+		if st.Total != total || st.Used != used || st.Free != free {
+			t.Fatalf("Stats = total %d used %d free %d, want %d %d %d", st.Total, st.Used, st.Free, total, used, free)
+		}
+		if len(st.Files) != 1 || st.Files[0] != (FileStats{FileIdx: 0, Total: total, Used: used, Free: free}) {
+			t.Fatalf("per-file stats = %+v", st.Files)
+		}
+		if n, err := dm.AllocatedPageCount(); err != nil || n != used {
+			t.Fatalf("AllocatedPageCount = %d, %v; want %d", n, err, used)
+		}
+	}
+	check(0, 0, 0)
+	var pids []config.PageId
+	for i := 0; i < 5; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	check(5, 5, 0)
+	for _, pid := range pids[1:3] {
+		if err := dm.FreePage(pid); err != nil {
+			t.Fatalf("FreePage: %v", err)
+		}
+	}
+	check(5, 3, 2)
+	// freed pages are reused before the file grows
+	if _, err := dm.AllocatePage(); err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	check(5, 4, 1)
+}
+
+func TestFinishClosesFileHandles(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if err := dm.WritePage(pid, []byte("cached")); err != nil {
+		t.Fatalf("WritePage: %v", err)
+	}
+	if _, err := dm.ReadPage(pid); err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+	f := dm.files[pid.FileIdx]
+	if f == nil || len(dm.files) != 1 {
+		t.Fatalf("expected one cached handle, got %v", dm.files)
+	}
+	if err := dm.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if len(dm.files) != 0 {
+		t.Fatalf("Finish left handles open: %v", dm.files)
+	}
+	if _, err := f.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("handle should be closed, Stat error = %v", err)
+	}
+	// the manager reopens files on demand after Finish
+	got, err := dm.ReadPage(pid)
+	if err != nil {
+		t.Fatalf("ReadPage after Finish: %v", err)
+	}
+	if string(got[:6]) != "cached" {
+		t.Fatalf("unexpected data %q", got[:6])
+	}
+	if err := dm.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(dm.files) != 0 {
+		t.Fatalf("Close left handles open: %v", dm.files)
+	}
+}
+
+func BenchmarkReadPage(b *testing.B) {
+	dm, pages := benchmarkPages(b, 64)
+	pids := make([]config.PageId, 0, len(pages))
+	for pid := range pages {
+		pids = append(pids, pid)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dm.ReadPage(pids[i%len(pids)]); err != nil {
+			b.Fatalf("ReadPage: %v", err)
+		}
+	}
+}
+
+// BenchmarkReadPageInto reads the same pages as BenchmarkReadPage into one reused
+// buffer: it should report no allocation per read.
+func BenchmarkReadPageInto(b *testing.B) {
+	dm, pages := benchmarkPages(b, 64)
+	pids := make([]config.PageId, 0, len(pages))
+	for pid := range pages {
+		pids = append(pids, pid)
+	}
+	buf := make([]byte, dm.PageSize())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := dm.ReadPageInto(pids[i%len(pids)], buf); err != nil {
+			b.Fatalf("ReadPageInto: %v", err)
+		}
+	}
+}
+
+func TestReadPageInto(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if err := dm.WritePage(pid, []byte("hello")); err != nil {
+		t.Fatalf("WritePage: %v", err)
+	}
+	buf := bytes.Repeat([]byte{0xff}, 512)
+	if err := dm.ReadPageInto(pid, buf); err != nil {
+		t.Fatalf("ReadPageInto: %v", err)
+	}
+	if string(buf[:5]) != "hello" || buf[5] != 0 || buf[511] != 0 {
+		t.Fatalf("page not read over the old buffer content: %q", buf[:8])
+	}
+	// a bad page id or buffer size fails without touching the buffer
+	buf[0] = 'x'
+	if err := dm.ReadPageInto(config.PageId{FileIdx: 0, PageIdx: 99}, buf); err == nil {
+		t.Fatalf("expected an error for an unallocated page")
+	}
+	if err := dm.ReadPageInto(pid, buf[:100]); err == nil {
+		t.Fatalf("expected an error for a short buffer")
+	}
+	if buf[0] != 'x' {
+		t.Fatalf("failed reads modified the buffer")
+	}
+}
+
+func TestSyncMakesBatchWritesDurable(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 512, 4)
+	cfg.SyncMode = config.SyncBatch
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if err := dm.WritePage(pid, []byte("durable")); err != nil {
+		t.Fatalf("WritePage: %v", err)
+	}
+	if err := dm.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if len(dm.unsynced) != 0 {
+		t.Fatalf("Sync should clear pending files, still pending: %v", dm.unsynced)
+	}
+	// the cached handle stays usable after Sync
+	if len(dm.files) != 1 {
+		t.Fatalf("Sync should keep the cached handle, got %v", dm.files)
+	}
+	// read the data file directly, bypassing the manager
+	raw, err := os.ReadFile(filepath.Join(dir, "BinData", "Data0.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	off := pid.PageIdx * 512
+	if string(raw[off:off+7]) != "durable" {
+		t.Fatalf("data file holds %q", raw[off:off+7])
+	}
+	if err := dm.WritePage(pid, []byte("again")); err != nil {
+		t.Fatalf("WritePage after Sync: %v", err)
+	}
+}
+
+func TestAllocateContiguous(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 256, 2)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	var pids []config.PageId
+	for i := 0; i < 6; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	// free 1 and 3-4: the run of 2 is 3-4, a run of 3 needs the file to grow
+	for _, i := range []int{1, 3, 4} {
+		if err := dm.FreePage(pids[i]); err != nil {
+			t.Fatalf("FreePage: %v", err)
+		}
+	}
+	run, err := dm.AllocateContiguous(2)
+	if err != nil {
+		t.Fatalf("AllocateContiguous: %v", err)
+	}
+	if run[0] != pids[3] || run[1] != pids[4] {
+		t.Fatalf("run of 2 = %v, want pages 3 and 4", run)
+	}
+	run, err = dm.AllocateContiguous(3)
+	if err != nil {
+		t.Fatalf("AllocateContiguous: %v", err)
+	}
+	for i, pid := range run {
+		if pid != (config.PageId{FileIdx: 0, PageIdx: 6 + i}) {
+			t.Fatalf("run of 3 = %v, want pages 6 to 8", run)
+		}
+		// the grown pages are real pages of the file
+		if _, err := dm.ReadPage(pid); err != nil {
+			t.Fatalf("ReadPage %v: %v", pid, err)
+		}
+	}
+	if n, _ := dm.AllocatedPageCount(); n != 8 {
+		t.Fatalf("%d pages allocated, want 8", n)
+	}
+}
+
+func TestErrorsMatchSentinels(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 256, 1)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	defer dm.Finish()
+	for _, pid := range []config.PageId{{FileIdx: 0, PageIdx: 99}, {FileIdx: 5, PageIdx: 0}, {FileIdx: -1, PageIdx: 0}} {
+		if _, err := dm.ReadPage(pid); !errors.Is(err, ErrInvalidPage) {
+			t.Fatalf("ReadPage(%v) = %v, want ErrInvalidPage", pid, err)
+		}
+		if err := dm.FreePage(pid); !errors.Is(err, ErrInvalidPage) {
+			t.Fatalf("FreePage(%v) = %v, want ErrInvalidPage", pid, err)
+		}
+	}
+	// data files grow as needed, so only a configuration without any runs out of space
+	cfg.DMMaxFileCount = 0
+	if _, err := dm.AllocatePage(); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("AllocatePage = %v, want ErrNoSpace", err)
+	}
+	if _, err := dm.AllocateContiguous(2); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("AllocateContiguous = %v, want ErrNoSpace", err)
+	}
+}
+
+// TestBitmapSurvivesCrashWithoutFinish allocates and frees pages, then drops the
+// manager without Finish: the bitmap rebuilt from the persisted file and the data file
+// size may leak pages, but must never hand out a page still in use.
+func TestBitmapSurvivesCrashWithoutFinish(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 512, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := dm.AllocatePage(); err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+	}
+	free := config.PageId{FileIdx: 0, PageIdx: 1}
+	if err := dm.FreePage(free); err != nil {
+		t.Fatalf("FreePage: %v", err)
+	}
+	if err := dm.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+
+	dm1 := NewDiskManager(cfg)
+	if err := dm1.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	// the persisted bitmap has page 1 free: reusing it is persisted at once
+	reused, err := dm1.AllocatePage()
+	if err != nil || reused != free {
+		t.Fatalf("AllocatePage = %v (%v), want %v", reused, err, free)
+	}
+	// growing the file and freeing a page are only persisted later
+	var grown []config.PageId
+	for i := 0; i < 2; i++ {
+		pid, err := dm1.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+		if err := dm1.WritePage(pid, []byte("in use")); err != nil {
+			t.Fatalf("WritePage: %v", err)
+		}
+		grown = append(grown, pid)
+	}
+	if err := dm1.FreePage(config.PageId{FileIdx: 0, PageIdx: 0}); err != nil {
+		t.Fatalf("FreePage: %v", err)
+	}
+	if data, err := os.ReadFile(dm1.st.(*fileStorage).bitmapPath(0)); err != nil || !bytes.Equal(data, []byte{1, 1, 1}) {
+		t.Fatalf("persisted bitmap = %v (%v), want [1 1 1] until the next persist", data, err)
+	}
+	// crash: no Finish
+	dm1.closeFiles()
+
+	dm2 := NewDiskManager(cfg)
+	if err := dm2.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	used, err := dm2.AllocatedPages()
+	if err != nil {
+		t.Fatalf("AllocatedPages: %v", err)
+	}
+	// page 0 leaks, the grown pages are found from the data file size
+	if len(used) != 5 {
+		t.Fatalf("allocated after recovery = %v, want pages 0 to 4", used)
+	}
+	pid, err := dm2.AllocatePage()
+	if err != nil {
+		t.Fatalf("AllocatePage: %v", err)
+	}
+	if pid.PageIdx != 5 {
+		t.Fatalf("AllocatePage after recovery = %v, want a new page past %v", pid, grown)
+	}
+	if err := dm2.Finish(); err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if data, err := os.ReadFile(dm2.st.(*fileStorage).bitmapPath(0)); err != nil || !bytes.Equal(data, []byte{1, 1, 1, 1, 1, 1}) {
+		t.Fatalf("bitmap after Finish = %v (%v)", data, err)
+	}
+	if _, err := os.Stat(dm2.st.(*fileStorage).bitmapPath(0) + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary bitmap left behind: %v", err)
+	}
+}
+
+// TestInitRebuildsLostBitmap removes or truncates the bitmap of a data file holding
+// pages: Init must count those pages as used, so allocation does not clobber them.
+func TestInitRebuildsLostBitmap(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		spoil func(path string) error
+	}{
+		{"missing", os.Remove},
+		{"truncated", func(path string) error { return os.WriteFile(path, []byte{1, 1}, 0o644) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := config.NewDBConfigWithParams(dir, 512, 4)
+			dm := NewDiskManager(cfg)
+			if err := dm.Init(); err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			var pids []config.PageId
+			for i := 0; i < 4; i++ {
+				pid, err := dm.AllocatePage()
+				if err != nil {
+					t.Fatalf("AllocatePage: %v", err)
+				}
+				if err := dm.WritePage(pid, []byte{'p', byte('0' + i)}); err != nil {
+					t.Fatalf("WritePage: %v", err)
+				}
+				pids = append(pids, pid)
+			}
+			if err := dm.Finish(); err != nil {
+				t.Fatalf("Finish: %v", err)
+			}
+			if err := tc.spoil(dm.st.(*fileStorage).bitmapPath(0)); err != nil {
+				t.Fatalf("spoil bitmap: %v", err)
+			}
+
+			dm2 := NewDiskManager(cfg)
+			if err := dm2.Init(); err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			defer dm2.Finish()
+			if n, err := dm2.AllocatedPageCount(); err != nil || n != len(pids) {
+				t.Fatalf("AllocatedPageCount = %d (%v), want %d", n, err, len(pids))
+			}
+			pid, err := dm2.AllocatePage()
+			if err != nil {
+				t.Fatalf("AllocatePage: %v", err)
+			}
+			for _, old := range pids {
+				if pid == old {
+					t.Fatalf("AllocatePage handed out %v, which holds data", pid)
+				}
+			}
+			if err := dm2.WritePage(pid, []byte("new")); err != nil {
+				t.Fatalf("WritePage: %v", err)
+			}
+			for i, old := range pids {
+				got, err := dm2.ReadPage(old)
+				if err != nil || got[0] != 'p' || got[1] != byte('0'+i) {
+					t.Fatalf("page %v clobbered: %q (%v)", old, got[:2], err)
+				}
+			}
+		})
+	}
+}
+
+// seedDataFile stores data file idx with one page per bitmap entry, as a database
+// that spread its pages over several files left it. Call it before Init.
+func seedDataFile(t *testing.T, dm *DiskManager, idx int, bitmap []byte) {
+	t.Helper()
+	if err := os.MkdirAll(dm.binDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := dm.st.open(idx)
+	if err != nil {
+		t.Fatalf("open Data%d: %v", idx, err)
+	}
+	if _, err := f.WriteAt(make([]byte, len(bitmap)*dm.cfg.PageSize), 0); err != nil {
+		t.Fatalf("write Data%d: %v", idx, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.st.writeBitmap(idx, bitmap, false); err != nil {
+		t.Fatalf("write Data%d bitmap: %v", idx, err)
+	}
+}
+
+func TestRemoveEmptyFiles(t *testing.T) {
+	for name, newDM := range map[string]func(*config.DBConfig) *DiskManager{
+		"file":   NewDiskManager,
+		"memory": NewDiskManagerInMemory,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 5)
+			dm := newDM(cfg)
+			seedDataFile(t, dm, 1, []byte{1, 1})
+			seedDataFile(t, dm, 2, []byte{0})
+			seedDataFile(t, dm, 3, []byte{0, 0})
+			seedDataFile(t, dm, 4, []byte{0, 1})
+			if err := dm.Init(); err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			defer dm.Finish()
+			for _, pid := range []config.PageId{{FileIdx: 1, PageIdx: 0}, {FileIdx: 1, PageIdx: 1}} {
+				if _, err := dm.ReadPage(pid); err != nil {
+					t.Fatalf("ReadPage %v: %v", pid, err)
+				}
+				if err := dm.FreePage(pid); err != nil {
+					t.Fatalf("FreePage %v: %v", pid, err)
+				}
+			}
+			// Data0 is empty too, Data3 is held by the caller and Data4 has a used page
+			removed, err := dm.RemoveEmptyFiles(func(idx int) bool { return idx == 3 })
+			if err != nil || len(removed) != 2 || removed[0] != 1 || removed[1] != 2 {
+				t.Fatalf("RemoveEmptyFiles = %v, %v; want [1 2]", removed, err)
+			}
+			for idx := 0; idx < cfg.DMMaxFileCount; idx++ {
+				_, dataErr := dm.st.dataSize(idx)
+				_, bitmapErr := dm.st.readBitmap(idx)
+				gone := idx == 1 || idx == 2
+				if os.IsNotExist(dataErr) != gone || os.IsNotExist(bitmapErr) != gone {
+					t.Fatalf("Data%d: data %v, bitmap %v; removed should be %v", idx, dataErr, bitmapErr, gone)
+				}
+			}
+			st, err := dm.Stats()
+			if err != nil || len(st.Files) != 3 || st.Used != 1 {
+				t.Fatalf("Stats = %+v, %v; want files 0, 3 and 4 with one used page", st, err)
+			}
+			if _, err := dm.ReadPage(config.PageId{FileIdx: 1, PageIdx: 0}); !errors.Is(err, ErrInvalidPage) {
+				t.Fatalf("ReadPage in a removed file = %v, want ErrInvalidPage", err)
+			}
+			if removed, err := dm.RemoveEmptyFiles(nil); err != nil || len(removed) != 1 || removed[0] != 3 {
+				t.Fatalf("RemoveEmptyFiles(nil) = %v, %v; want [3]", removed, err)
+			}
+		})
+	}
+}
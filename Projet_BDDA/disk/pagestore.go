@@ -0,0 +1,24 @@
+package disk
+
+import "malzahar-project/Projet_BDDA/config"
+
+// PageStore is the page-level storage the buffer pool and the relations work on:
+// page allocation, page I/O and the page layout settings. DiskManager implements it,
+// over data files or memory; tests can substitute a fake.
+type PageStore interface {
+	AllocatePage() (config.PageId, error)
+	AllocateContiguous(n int) ([]config.PageId, error)
+	FreePage(pid config.PageId) error
+	CheckPage(pid config.PageId) error
+	ReadPage(pid config.PageId) ([]byte, error)
+	ReadPageInto(pid config.PageId, buf []byte) error
+	WritePage(pid config.PageId, data []byte) error
+	WritePages(pages map[config.PageId][]byte) error
+	PageSize() int
+	PageReserve() int
+	ByteOrder() config.ByteOrder
+	BinDir() string
+	Finish() error
+}
+
+var _ PageStore = (*DiskManager)(nil)
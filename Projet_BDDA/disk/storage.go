@@ -0,0 +1,254 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storage is where a DiskManager keeps the bytes of its data files and bitmaps:
+// files under the bin directory (fileStorage) or memory (memStorage). Allocation,
+// bitmap reconciliation, sync modes and the WAL stay in DiskManager, which holds m.mu
+// around every call.
+type storage interface {
+	// checkPageSize fails with ErrPageSizeMismatch when the data was created with
+	// another page size, and records pageSize for new data.
+	checkPageSize(pageSize int) error
+	// checkPageReserve fails with ErrPageReserveMismatch when the data was created
+	// with another page_reserve_bytes, and records reserve for new data.
+	checkPageReserve(reserve int) error
+	// open returns data file idx, creating it empty if missing.
+	open(idx int) (dataFile, error)
+	// dataSize returns the size of data file idx, or an os.IsNotExist error.
+	dataSize(idx int) (int64, error)
+	// readBitmap returns the persisted bitmap of file idx, or an os.IsNotExist error.
+	readBitmap(idx int) ([]byte, error)
+	// writeBitmap replaces the persisted bitmap of file idx so that a crash leaves
+	// either version whole; sync asks for it to be durable on return.
+	writeBitmap(idx int, b []byte, sync bool) error
+	// remove deletes data file idx, then its bitmap; either may be missing already.
+	remove(idx int) error
+}
+
+// dataFile is an open data file. *os.File implements it.
+type dataFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Close() error
+}
+
+// fileStorage keeps DataN.bin and DataN.bitmap files in dir.
+type fileStorage struct {
+	dir string
+}
+
+// pageSizeFile and pageReserveFile, under the bin directory, hold the page size and
+// page_reserve_bytes the data files were created with, as decimal numbers.
+const (
+	pageSizeFile    = "pagesize"
+	pageReserveFile = "pagereserve"
+)
+
+// checkPageSize compares pageSize with pageSizeFile. The file is written when
+// missing, for new databases and those created before it existed.
+func (s *fileStorage) checkPageSize(pageSize int) error {
+	return s.checkRecorded(pageSizeFile, "pagesize", pageSize, ErrPageSizeMismatch)
+}
+
+// checkPageReserve compares reserve with pageReserveFile, written when missing like
+// pageSizeFile.
+func (s *fileStorage) checkPageReserve(reserve int) error {
+	return s.checkRecorded(pageReserveFile, "page_reserve_bytes", reserve, ErrPageReserveMismatch)
+}
+
+// checkRecorded compares v with the number held in file name, writing v there when
+// the file is missing; a different number fails with mismatch.
+func (s *fileStorage) checkRecorded(name, key string, v int, mismatch error) error {
+	p := filepath.Join(s.dir, name)
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return os.WriteFile(p, []byte(strconv.Itoa(v)+"\n"), 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	stored, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("%s: invalid %s %q", p, key, strings.TrimSpace(string(data)))
+	}
+	if stored != v {
+		return fmt.Errorf("%w: %s was created with %s %d, the config has %d", mismatch, s.dir, key, stored, v)
+	}
+	return nil
+}
+
+func (s *fileStorage) bitmapPath(idx int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("Data%d.bitmap", idx))
+}
+
+func (s *fileStorage) dataPath(idx int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("Data%d.bin", idx))
+}
+
+func (s *fileStorage) open(idx int) (dataFile, error) {
+	f, err := os.OpenFile(s.dataPath(idx), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *fileStorage) dataSize(idx int) (int64, error) {
+	st, err := os.Stat(s.dataPath(idx))
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (s *fileStorage) readBitmap(idx int) ([]byte, error) {
+	return os.ReadFile(s.bitmapPath(idx))
+}
+
+// writeBitmap writes b to a temporary file renamed over the old bitmap.
+func (s *fileStorage) writeBitmap(idx int, b []byte, sync bool) error {
+	p := s.bitmapPath(idx)
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// remove deletes DataN.bin before DataN.bitmap: a crash in between leaves a bitmap
+// without its data file, which Init ignores.
+func (s *fileStorage) remove(idx int) error {
+	for _, p := range []string{s.dataPath(idx), s.bitmapPath(idx)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// memStorage keeps the data files and bitmaps in memory, for databases that live
+// as long as the process (see NewDiskManagerInMemory). Nothing can crash halfway,
+// so sync is a no-op and the page size and reserve need no recording.
+type memStorage struct {
+	files   map[int]*memFile
+	bitmaps map[int][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[int]*memFile), bitmaps: make(map[int][]byte)}
+}
+
+func (s *memStorage) checkPageSize(pageSize int) error {
+	return nil
+}
+
+func (s *memStorage) checkPageReserve(reserve int) error {
+	return nil
+}
+
+func (s *memStorage) open(idx int) (dataFile, error) {
+	f, ok := s.files[idx]
+	if !ok {
+		f = &memFile{name: fmt.Sprintf("Data%d.bin", idx)}
+		s.files[idx] = f
+	}
+	return f, nil
+}
+
+func (s *memStorage) dataSize(idx int) (int64, error) {
+	f, ok := s.files[idx]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return int64(len(f.data)), nil
+}
+
+func (s *memStorage) readBitmap(idx int) ([]byte, error) {
+	b, ok := s.bitmaps[idx]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return append([]byte{}, b...), nil
+}
+
+func (s *memStorage) writeBitmap(idx int, b []byte, sync bool) error {
+	s.bitmaps[idx] = append([]byte{}, b...)
+	return nil
+}
+
+func (s *memStorage) remove(idx int) error {
+	delete(s.files, idx)
+	delete(s.bitmaps, idx)
+	return nil
+}
+
+// memFile is a data file held in a byte slice. Closing it keeps the data: the
+// DiskManager reopens files it closed, and must find them as it left them.
+type memFile struct {
+	name string
+	data []byte
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p at off, growing the file with zeros up to off if needed.
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	return memFileInfo{name: f.name, size: int64(len(f.data))}, nil
+}
+
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+// memFileInfo is the os.FileInfo of a memFile.
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0o644 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,248 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// WAL is a redo-only write-ahead log of page images. A DiskManager with a WAL
+// attached appends every page it is asked to write to the log, fsyncs the log, and
+// only then writes the data files, so that a crash in the middle of a write, or of
+// a WritePages batch, can be repaired on the next start by replaying the log.
+//
+// Record format, little-endian, appended to the log file:
+//
+//	kind    uint8    walPage or walCommit
+//	fileIdx int32
+//	pageIdx int32
+//	length  uint32   number of page bytes that follow (0 for a commit)
+//	data    [length]byte
+//	crc     uint32   CRC-32 (IEEE) of all the preceding bytes of the record
+//
+// One write (WritePage) or batch (WritePages) is logged as its page records
+// followed by a commit record. Recovery reads records in order and applies the
+// pages of every batch whose commit record is intact; it stops at the first torn
+// or corrupt record, since nothing after it was acknowledged. Records are full
+// after-images, so replaying a batch that had already reached the data files is
+// harmless. A checkpoint truncates the log once the data files are synced.
+type WAL struct {
+	f    *os.File
+	size int64
+}
+
+// PageImage is the content of one page as logged in the WAL.
+type PageImage struct {
+	Pid  config.PageId
+	Data []byte
+}
+
+const (
+	walPage   byte = 1
+	walCommit byte = 2
+	// walHeaderSize is kind + fileIdx + pageIdx + length.
+	walHeaderSize = 13
+)
+
+// OpenWAL opens the log at path, creating it if needed. New records are appended
+// after the existing ones, which Replay reads.
+func OpenWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &WAL{f: f, size: st.Size()}, nil
+}
+
+// Size returns the current length of the log in bytes.
+func (w *WAL) Size() int64 {
+	return w.size
+}
+
+// Append logs pages as one batch and fsyncs the log. When it returns nil the batch
+// will be replayed by recovery whatever happens to the data files.
+func (w *WAL) Append(pages []PageImage) error {
+	var buf []byte
+	for _, p := range pages {
+		buf = appendWALRecord(buf, walPage, p.Pid, p.Data)
+	}
+	buf = appendWALRecord(buf, walCommit, config.PageId{}, nil)
+	if _, err := w.f.WriteAt(buf, w.size); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.size += int64(len(buf))
+	return nil
+}
+
+// appendWALRecord appends one log record to buf. The framing is always little-endian,
+// whatever the config byte_order of the pages it carries.
+func appendWALRecord(buf []byte, kind byte, pid config.PageId, data []byte) []byte {
+	start := len(buf)
+	buf = append(buf, kind)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(pid.FileIdx)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(int32(pid.PageIdx)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+}
+
+// errWALTorn marks the end of the usable log: a short or corrupt record.
+var errWALTorn = errors.New("torn WAL record")
+
+// Replay calls apply with the pages of every committed batch, in log order, and
+// returns the number of batches replayed. A torn or corrupt tail is ignored.
+func (w *WAL) Replay(apply func(pid config.PageId, data []byte) error) (int, error) {
+	r := bufio.NewReader(io.NewSectionReader(w.f, 0, w.size))
+	batches := 0
+	var pending []PageImage
+	for {
+		kind, pid, data, err := readWALRecord(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, errWALTorn) {
+			return batches, nil
+		}
+		if err != nil {
+			return batches, err
+		}
+		switch kind {
+		case walPage:
+			pending = append(pending, PageImage{Pid: pid, Data: data})
+		case walCommit:
+			for _, p := range pending {
+				if err := apply(p.Pid, p.Data); err != nil {
+					return batches, fmt.Errorf("replay page %v: %w", p.Pid, err)
+				}
+			}
+			pending = pending[:0]
+			batches++
+		default:
+			return batches, nil
+		}
+	}
+}
+
+func readWALRecord(r *bufio.Reader) (byte, config.PageId, []byte, error) {
+	hdr := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, config.PageId{}, nil, errWALTorn
+		}
+		return 0, config.PageId{}, nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[9:13])
+	if n > 1<<30 {
+		return 0, config.PageId{}, nil, errWALTorn
+	}
+	rest := make([]byte, int(n)+4)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, config.PageId{}, nil, errWALTorn
+	}
+	sum := crc32.ChecksumIEEE(hdr)
+	sum = crc32.Update(sum, crc32.IEEETable, rest[:n])
+	if sum != binary.LittleEndian.Uint32(rest[n:]) {
+		return 0, config.PageId{}, nil, errWALTorn
+	}
+	pid := config.PageId{
+		FileIdx: int(int32(binary.LittleEndian.Uint32(hdr[1:5]))),
+		PageIdx: int(int32(binary.LittleEndian.Uint32(hdr[5:9]))),
+	}
+	return hdr[0], pid, rest[:n], nil
+}
+
+// Truncate empties the log. It must only be called once every logged page has
+// reached the data files and they have been synced.
+func (w *WAL) Truncate() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.f.Sync()
+}
+
+// Close closes the log file.
+func (w *WAL) Close() error {
+	return w.f.Close()
+}
+
+// AttachWAL makes every later WritePage and WritePages go through w. Recover should
+// be called first so that pages logged before a crash are not overwritten by the
+// replay later on.
+func (m *DiskManager) AttachWAL(w *WAL) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.wal = w
+}
+
+// Recover replays the committed batches of w into the data files, syncs them and
+// empties the log. A logged page past the end of its file's bitmap was allocated by
+// growing the file, an update the crash lost: it is marked used again. It returns
+// the number of batches replayed.
+func (m *DiskManager) Recover(w *WAL) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := w.Replay(func(pid config.PageId, data []byte) error {
+		if err := m.checkPage(pid); err != nil {
+			if !errors.Is(err, ErrInvalidPage) || pid.FileIdx < 0 || pid.FileIdx >= m.cfg.DMMaxFileCount || pid.PageIdx < 0 {
+				return err
+			}
+			for len(m.bitmaps[pid.FileIdx]) <= pid.PageIdx {
+				m.bitmaps[pid.FileIdx] = append(m.bitmaps[pid.FileIdx], 1)
+			}
+			m.dirty[pid.FileIdx] = true
+		}
+		f, err := m.file(pid.FileIdx)
+		if err != nil {
+			return err
+		}
+		return m.writeAt(f, pid, data)
+	})
+	if err != nil {
+		return n, err
+	}
+	for _, f := range m.files {
+		if err := f.Sync(); err != nil {
+			return n, err
+		}
+	}
+	if err := m.persistDirtyBitmaps(); err != nil {
+		return n, err
+	}
+	return n, w.Truncate()
+}
+
+// Checkpoint syncs every data file, like Sync, then empties the attached WAL, if
+// any: the logged pages are durable in the data files and need no replay. Dirty
+// buffers must have been flushed first.
+func (m *DiskManager) Checkpoint() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.syncAll(); err != nil {
+		return err
+	}
+	if m.wal == nil {
+		return nil
+	}
+	return m.wal.Truncate()
+}
+
+// logPages appends pages to the attached WAL, if any, as one batch. Caller must
+// hold m.mu.
+func (m *DiskManager) logPages(pages []PageImage) error {
+	if m.wal == nil {
+		return nil
+	}
+	return m.wal.Append(pages)
+}
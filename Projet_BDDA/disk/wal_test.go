@@ -0,0 +1,101 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+func TestWALReplaysCommittedBatchesOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 512, 4)
+	dm := NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	var pids []config.PageId
+	for i := 0; i < 3; i++ {
+		pid, err := dm.AllocatePage()
+		if err != nil {
+			t.Fatalf("AllocatePage: %v", err)
+		}
+		pids = append(pids, pid)
+	}
+	walPath := filepath.Join(dir, "wal.log")
+	w, err := OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	dm.AttachWAL(w)
+	page := func(b byte) []byte { return bytes.Repeat([]byte{b}, 512) }
+	if err := dm.WritePage(pids[0], page('a')); err != nil {
+		t.Fatalf("WritePage: %v", err)
+	}
+	if err := dm.WritePages(map[config.PageId][]byte{pids[1]: page('b'), pids[2]: []byte("short")}); err != nil {
+		t.Fatalf("WritePages: %v", err)
+	}
+	committed := w.Size()
+	// a third batch whose commit record is torn by the crash
+	if err := w.Append([]PageImage{{Pid: pids[0], Data: page('z')}}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if err := w.f.Truncate(w.Size() - 3); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	w.Close()
+	// the data file loses everything
+	dm.closeFiles()
+	if err := os.Truncate(dm.st.(*fileStorage).dataPath(0), 0); err != nil {
+		t.Fatalf("Truncate data: %v", err)
+	}
+
+	dm2 := NewDiskManager(cfg)
+	if err := dm2.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	w2, err := OpenWAL(walPath)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	defer w2.Close()
+	if w2.Size() <= committed {
+		t.Fatalf("reopened WAL is %d bytes, want more than %d", w2.Size(), committed)
+	}
+	n, err := dm2.Recover(w2)
+	if err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("replayed %d batches, want 2", n)
+	}
+	if w2.Size() != 0 {
+		t.Fatalf("WAL is %d bytes after recovery, want 0", w2.Size())
+	}
+	want := [][]byte{page('a'), page('b'), append([]byte("short"), make([]byte, 507)...)}
+	for i, pid := range pids {
+		got, err := dm2.ReadPage(pid)
+		if err != nil {
+			t.Fatalf("ReadPage: %v", err)
+		}
+		if !bytes.Equal(got, want[i]) {
+			t.Fatalf("page %v not recovered: %q...", pid, got[:8])
+		}
+	}
+	// once attached, a checkpoint empties the log again
+	dm2.AttachWAL(w2)
+	if err := dm2.WritePage(pids[0], page('c')); err != nil {
+		t.Fatalf("WritePage: %v", err)
+	}
+	if w2.Size() == 0 {
+		t.Fatalf("WritePage was not logged")
+	}
+	if err := dm2.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+	if w2.Size() != 0 {
+		t.Fatalf("WAL is %d bytes after Checkpoint, want 0", w2.Size())
+	}
+}
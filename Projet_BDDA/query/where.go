@@ -0,0 +1,603 @@
+// Package query compiles WHERE clauses into predicates over the records of a
+// relation. It is shared by the command layer (sgbd) and the storage layers that
+// take record predicates, such as DBManager.DeleteWhere and UpdateWhere.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// ErrSyntax is returned for a WHERE clause that cannot be parsed.
+var ErrSyntax = errors.New("syntax error")
+
+// Predicate is a compiled WHERE clause bound to the relation it was compiled for.
+// A nil Predicate, like an empty clause, matches every record.
+type Predicate struct {
+	rel  *relation.Relation
+	root *condExpr
+}
+
+// Compile parses a WHERE clause for rel, whose columns are referenced as alias.col.
+// With an empty alias the column names of rel are used as written, which lets a
+// join compile a clause over a relation whose columns are named alias.col.
+// Precedence from loosest to tightest: OR, AND, NOT, comparison; parentheses group
+// subexpressions, and a bare alias.col is true when non-zero or non-empty.
+func Compile(where string, rel *relation.Relation, alias string) (*Predicate, error) {
+	root, err := parseWhereClause(where, rel, alias)
+	if err != nil {
+		return nil, err
+	}
+	return &Predicate{rel: rel, root: root}, nil
+}
+
+// Match evaluates the predicate on rec. rec is bound to the predicate's relation so
+// numeric columns are read through its cached typed accessors.
+func (p *Predicate) Match(rec *relation.Record) (bool, error) {
+	if p == nil {
+		return true, nil
+	}
+	return evalConditions(rec.Bind(p.rel), p.rel, p.root)
+}
+
+// Equality reports whether the predicate is a single equality between two columns,
+// and which ones, as in "r.id = s.rid". Such a predicate can drive a hash join.
+func (p *Predicate) Equality() (left, right int, ok bool) {
+	if p == nil || p.root == nil || p.root.Kind != condCmp {
+		return 0, 0, false
+	}
+	c := p.root.Cond
+	if c.Op != "=" || !c.LeftIsCol || !c.RightIsCol {
+		return 0, 0, false
+	}
+	return c.LeftColIdx, c.RightColIdx, true
+}
+
+// ColumnIndex returns the index of the named column of rel or -1.
+func ColumnIndex(rel *relation.Relation, name string) int {
+	for i, c := range rel.Columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Condition represents a simple comparison between terms (col or constant)
+type Condition struct {
+	LeftIsCol   bool
+	LeftColIdx  int
+	LeftConst   string
+	RightIsCol  bool
+	RightColIdx int
+	RightConst  string
+	Op          string
+}
+
+// condKind identifies the node type of a WHERE expression tree.
+type condKind int
+
+const (
+	condCmp   condKind = iota // comparison leaf (Cond)
+	condTruth                 // bare column used as a boolean (ColIdx)
+	condAnd
+	condOr
+	condNot
+)
+
+// condExpr is a node of a WHERE boolean expression tree. Precedence from loosest to
+// tightest: OR, AND, NOT, comparison; parentheses group subexpressions.
+type condExpr struct {
+	Kind   condKind
+	Cond   Condition
+	ColIdx int
+	Left   *condExpr
+	Right  *condExpr
+}
+
+// parseWhereClause parses a WHERE clause into an expression tree. An empty clause
+// yields a nil tree, which matches every record.
+func parseWhereClause(where string, rel *relation.Relation, alias string) (*condExpr, error) {
+	where = strings.TrimSpace(where)
+	if where == "" {
+		return nil, nil
+	}
+	toks, err := tokenizeWhere(where)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{toks: toks, rel: rel, alias: alias}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("%w: unexpected %q in WHERE clause", ErrSyntax, p.toks[p.pos])
+	}
+	return e, nil
+}
+
+// tokenizeWhere splits a WHERE clause into "(", ")", AND, OR, NOT and atom tokens
+// (the raw text of a comparison or bare column). Quoted strings are never split.
+func tokenizeWhere(where string) ([]string, error) {
+	var toks []string
+	atomStart := -1
+	flush := func(end int) {
+		if atomStart >= 0 {
+			if a := strings.TrimSpace(where[atomStart:end]); a != "" {
+				toks = append(toks, a)
+			}
+			atomStart = -1
+		}
+	}
+	i := 0
+	for i < len(where) {
+		c := where[i]
+		switch {
+		case c == '"':
+			j := strings.IndexByte(where[i+1:], '"')
+			if j < 0 {
+				return nil, fmt.Errorf("%w: unterminated string in WHERE clause", ErrSyntax)
+			}
+			if atomStart < 0 {
+				atomStart = i
+			}
+			i += j + 2
+		case c == '(' || c == ')':
+			flush(i)
+			toks = append(toks, string(c))
+			i++
+		case c == ' ' || c == '\t':
+			i++
+		default:
+			j := i
+			for j < len(where) && strings.IndexByte(" \t()\"", where[j]) < 0 {
+				j++
+			}
+			word := strings.ToUpper(where[i:j])
+			if word == "AND" || word == "OR" || word == "NOT" {
+				flush(i)
+				toks = append(toks, word)
+			} else if atomStart < 0 {
+				atomStart = i
+			}
+			i = j
+		}
+	}
+	flush(len(where))
+	return toks, nil
+}
+
+type whereParser struct {
+	toks  []string
+	pos   int
+	rel   *relation.Relation
+	alias string
+}
+
+func (p *whereParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *whereParser) parseOr() (*condExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &condExpr{Kind: condOr, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (*condExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "AND" {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &condExpr{Kind: condAnd, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *whereParser) parseNot() (*condExpr, error) {
+	if p.peek() == "NOT" {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &condExpr{Kind: condNot, Left: inner}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *whereParser) parsePrimary() (*condExpr, error) {
+	t := p.peek()
+	switch t {
+	case "":
+		return nil, fmt.Errorf("%w: unexpected end of WHERE clause", ErrSyntax)
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing ) in WHERE clause", ErrSyntax)
+		}
+		p.pos++
+		return inner, nil
+	case ")", "AND", "OR":
+		return nil, fmt.Errorf("%w: unexpected %q in WHERE clause", ErrSyntax, t)
+	}
+	p.pos++
+	return parseAtom(t, p.rel, p.alias)
+}
+
+// parseAtom parses a single comparison, or a bare alias.col used as a boolean.
+func parseAtom(p string, rel *relation.Relation, alias string) (*condExpr, error) {
+	found, at := findOperator(p)
+	if found == "" {
+		if idx, isCol, err := columnRef(p, rel, alias); isCol && err == nil {
+			return &condExpr{Kind: condTruth, ColIdx: idx}, nil
+		}
+		return nil, fmt.Errorf("%w: unsupported condition: %s", ErrSyntax, p)
+	}
+	left := strings.TrimSpace(p[:at])
+	right := strings.TrimSpace(p[at+len(found):])
+	if found == "!=" {
+		found = "<>"
+	}
+	cond := Condition{Op: found}
+	// left can be alias.col or constant
+	if idx, isCol, err := columnRef(left, rel, alias); err != nil {
+		return nil, err
+	} else if isCol {
+		cond.LeftIsCol = true
+		cond.LeftColIdx = idx
+	} else {
+		// constant: strip quotes if present
+		lv := left
+		if len(lv) >= 2 && lv[0] == '"' && lv[len(lv)-1] == '"' {
+			lv = lv[1 : len(lv)-1]
+		}
+		cond.LeftConst = lv
+	}
+	// right can be alias.col or constant
+	if idx, isCol, err := columnRef(right, rel, alias); err != nil {
+		return nil, err
+	} else if isCol {
+		cond.RightIsCol = true
+		cond.RightColIdx = idx
+	} else {
+		// constant: strip quotes if present
+		rv := right
+		if len(rv) >= 2 && rv[0] == '"' && rv[len(rv)-1] == '"' {
+			rv = rv[1 : len(rv)-1]
+		}
+		cond.RightConst = rv
+	}
+	if _, err := comparisonKind(rel, cond); err != nil {
+		return nil, err
+	}
+	// a constant compared with a numeric column must itself be a number of that kind;
+	// checking it here makes a malformed constant an error for every command instead
+	// of a comparison that silently never (or always) matches
+	if cond.LeftIsCol != cond.RightIsCol {
+		col, val := cond.LeftColIdx, &cond.RightConst
+		if cond.RightIsCol {
+			col, val = cond.RightColIdx, &cond.LeftConst
+		}
+		// written as in INSERT: an INT constant may use scientific notation
+		v, err := rel.Columns[col].ParseText(*val)
+		if err != nil {
+			return nil, err
+		}
+		*val = v
+		if err := checkConstant(rel.Columns[col], v); err != nil {
+			return nil, err
+		}
+	}
+	return &condExpr{Kind: condCmp, Cond: cond}, nil
+}
+
+// findOperator returns the first comparison operator of p outside double-quoted
+// constants, so that a constant such as "a>b" is never split, and its index; op is
+// empty if there is none. Two-character operators (<=, >=, <>, !=) win over their
+// first character.
+func findOperator(p string) (op string, idx int) {
+	inQuote := false
+	for i := 0; i < len(p); i++ {
+		if p[i] == '"' {
+			inQuote = !inQuote
+			continue
+		}
+		if inQuote {
+			continue
+		}
+		for _, op := range []string{"<=", ">=", "<>", "!=", "=", "<", ">"} {
+			if strings.HasPrefix(p[i:], op) {
+				return op, i
+			}
+		}
+	}
+	return "", -1
+}
+
+// columnRef resolves term as a column of rel. With an alias, columns are written
+// alias.col. Without one, the column names of rel are already qualified, as in the
+// combined relation of a join, and term is the whole name; an unquoted name with a
+// dot that starts like an identifier is then taken for a column, so a typo is
+// reported instead of being compared as a constant. isCol is false for a constant.
+func columnRef(term string, rel *relation.Relation, alias string) (idx int, isCol bool, err error) {
+	name := term
+	if alias != "" {
+		if !strings.HasPrefix(term, alias+".") {
+			return -1, false, nil
+		}
+		name = term[len(alias)+1:]
+	} else if !isQualifiedName(term) {
+		return -1, false, nil
+	}
+	idx = ColumnIndex(rel, name)
+	if idx < 0 {
+		return -1, true, fmt.Errorf("unknown column: %s", name)
+	}
+	return idx, true, nil
+}
+
+// isQualifiedName reports whether term looks like alias.col rather than a constant.
+func isQualifiedName(term string) bool {
+	if term == "" || !strings.Contains(term, ".") {
+		return false
+	}
+	c := term[0]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// evaluate a WHERE expression tree on a record; a nil tree matches everything
+func evalConditions(rec *relation.Record, rel *relation.Relation, e *condExpr) (bool, error) {
+	if e == nil {
+		return true, nil
+	}
+	switch e.Kind {
+	case condAnd:
+		ok, err := evalConditions(rec, rel, e.Left)
+		if err != nil || !ok {
+			return false, err
+		}
+		return evalConditions(rec, rel, e.Right)
+	case condOr:
+		ok, err := evalConditions(rec, rel, e.Left)
+		if err != nil || ok {
+			return ok, err
+		}
+		return evalConditions(rec, rel, e.Right)
+	case condNot:
+		ok, err := evalConditions(rec, rel, e.Left)
+		if err != nil {
+			return false, err
+		}
+		return !ok, nil
+	case condTruth:
+		return truthy(rec, rel.Columns[e.ColIdx].Kind, e.ColIdx)
+	}
+	return evalCondition(rec, rel, e.Cond)
+}
+
+// truthy interprets column col as a boolean: numbers are true when non-zero,
+// strings when non-empty.
+func truthy(rec *relation.Record, kind relation.ColumnKind, col int) (bool, error) {
+	if isNumeric(kind) {
+		f, err := rec.Float(col)
+		if err != nil {
+			return false, err
+		}
+		return f != 0, nil
+	}
+	return rec.Values[col] != "", nil
+}
+
+// evaluate a single comparison on a record; numeric columns are read through the
+// record's typed accessors, so each is parsed once however many conditions use it
+func evalCondition(rec *relation.Record, rel *relation.Relation, c Condition) (bool, error) {
+	kind, err := comparisonKind(rel, c)
+	if err != nil {
+		return false, err
+	}
+	if !isNumeric(kind) {
+		return compareValues(kind, operandText(rec, c.LeftIsCol, c.LeftColIdx, c.LeftConst), c.Op,
+			operandText(rec, c.RightIsCol, c.RightColIdx, c.RightConst))
+	}
+	l, err := numericOperand(rec, kind, c.LeftIsCol, c.LeftColIdx, c.LeftConst)
+	if err != nil {
+		return false, err
+	}
+	r, err := numericOperand(rec, kind, c.RightIsCol, c.RightColIdx, c.RightConst)
+	if err != nil {
+		return false, err
+	}
+	return compareNumbers(kind, l, c.Op, r)
+}
+
+// operandText returns one side of a comparison as text.
+func operandText(rec *relation.Record, isCol bool, idx int, konst string) string {
+	if isCol {
+		return rec.Values[idx]
+	}
+	return konst
+}
+
+// number is a parsed comparison operand: i holds INT values, f FLOAT ones.
+type number struct {
+	i int64
+	f float64
+}
+
+// numericOperand returns one side of a comparison of the given numeric kind. A
+// column is read with Record.Int or Record.Float (which widens INT columns compared
+// as FLOAT); a constant is parsed.
+func numericOperand(rec *relation.Record, kind relation.ColumnKind, isCol bool, idx int, konst string) (number, error) {
+	if !isCol {
+		return parseNumber(kind, konst)
+	}
+	if kind == relation.KindInt {
+		i, err := rec.Int(idx)
+		return number{i: i}, err
+	}
+	f, err := rec.Float(idx)
+	return number{f: f}, err
+}
+
+// parseNumber parses s as a value of the numeric kind.
+func parseNumber(kind relation.ColumnKind, s string) (number, error) {
+	if kind == relation.KindInt {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return number{}, fmt.Errorf("invalid INT value %q", s)
+		}
+		return number{i: i}, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return number{}, fmt.Errorf("invalid FLOAT value %q", s)
+	}
+	return number{f: f}, nil
+}
+
+// comparisonKind returns the kind both sides of c are compared as. A column against
+// a constant uses the column's kind. Two columns of the same kind use it; INT and
+// FLOAT columns are promoted to FLOAT, and a numeric column cannot be compared with
+// a CHAR/VARCHAR one. Two constants compare as strings. BLOB columns cannot be
+// compared.
+func comparisonKind(rel *relation.Relation, c Condition) (relation.ColumnKind, error) {
+	for _, side := range []struct {
+		isCol bool
+		idx   int
+	}{{c.LeftIsCol, c.LeftColIdx}, {c.RightIsCol, c.RightColIdx}} {
+		if side.isCol && rel.Columns[side.idx].Kind == relation.KindBlob {
+			return 0, fmt.Errorf("cannot compare BLOB column %s", rel.Columns[side.idx].Name)
+		}
+	}
+	switch {
+	case c.LeftIsCol && c.RightIsCol:
+		l, r := rel.Columns[c.LeftColIdx], rel.Columns[c.RightColIdx]
+		if l.Kind == r.Kind {
+			return l.Kind, nil
+		}
+		if isNumeric(l.Kind) && isNumeric(r.Kind) {
+			return relation.KindFloat, nil
+		}
+		if isNumeric(l.Kind) || isNumeric(r.Kind) {
+			return 0, fmt.Errorf("cannot compare %s column %s with %s column %s", l.TypeString(), l.Name, r.TypeString(), r.Name)
+		}
+		// CHAR with VARCHAR
+		return relation.KindVarchar, nil
+	case c.LeftIsCol:
+		return rel.Columns[c.LeftColIdx].Kind, nil
+	case c.RightIsCol:
+		return rel.Columns[c.RightColIdx].Kind, nil
+	}
+	return relation.KindVarchar, nil
+}
+
+func isNumeric(kind relation.ColumnKind) bool {
+	return kind == relation.KindInt || kind == relation.KindFloat
+}
+
+// compareValues applies op (=, <>, <, >, <=, >=) to left and right read as values
+// of the given kind: INT sides as integers, FLOAT sides as floats, CHAR/VARCHAR
+// sides lexically. A side that is not a valid value of the kind is an error rather
+// than being compared as zero.
+// != never reaches here: the parser normalizes it to <>.
+func compareValues(kind relation.ColumnKind, left, op, right string) (bool, error) {
+	if !isNumeric(kind) {
+		return applyOp(strings.Compare(left, right), op)
+	}
+	l, err := parseNumber(kind, left)
+	if err != nil {
+		return false, err
+	}
+	r, err := parseNumber(kind, right)
+	if err != nil {
+		return false, err
+	}
+	return compareNumbers(kind, l, op, r)
+}
+
+// compareNumbers applies op to two parsed operands of the numeric kind.
+func compareNumbers(kind relation.ColumnKind, l number, op string, r number) (bool, error) {
+	if kind == relation.KindInt {
+		return applyOp(cmpOrdered(l.i, r.i), op)
+	}
+	if math.IsNaN(l.f) || math.IsNaN(r.f) {
+		// NaN is unordered: it differs from everything, itself included
+		return op == "<>", nil
+	}
+	return applyOp(cmpOrdered(l.f, r.f), op)
+}
+
+// applyOp turns the three-way comparison c into the result of op.
+func applyOp(c int, op string) (bool, error) {
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "<>":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+// checkConstant reports whether v is a valid value to compare with column col.
+func checkConstant(col relation.ColumnInfo, v string) error {
+	switch col.Kind {
+	case relation.KindInt:
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("col %s: %w %q for INT", col.Name, relation.ErrInvalidValue, v)
+		}
+	case relation.KindFloat:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("col %s: %w %q for FLOAT", col.Name, relation.ErrInvalidValue, v)
+		}
+	}
+	return nil
+}
+
+// cmpOrdered returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func cmpOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
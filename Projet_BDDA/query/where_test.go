@@ -0,0 +1,338 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+func whereTestRelation() *relation.Relation {
+	return relation.NewRelation("Emp", []relation.ColumnInfo{
+		{Name: "age", Kind: relation.KindInt},
+		{Name: "active", Kind: relation.KindInt},
+		{Name: "name", Kind: relation.KindVarchar, Size: 10},
+	})
+}
+
+func TestWhereNotAndOr(t *testing.T) {
+	rel := whereTestRelation()
+	young := relation.NewRecord("15", "1", "ann")
+	adult := relation.NewRecord("30", "0", "bob")
+	cases := []struct {
+		where string
+		young bool
+		adult bool
+	}{
+		{"NOT (e.age < 18)", false, true},
+		{"NOT e.age < 18", false, true},
+		{"NOT e.active", false, true},
+		{"e.active", true, false},
+		{"NOT e.active AND e.age > 18", false, true},
+		{"NOT (e.active OR e.age > 18)", false, false},
+		{"NOT e.active OR e.name = \"ann\"", true, true},
+		{"e.age < 18 OR e.age > 25 AND e.name = \"zed\"", true, false},
+		{"(e.age < 18 OR e.age > 25) AND NOT e.name = \"ann\"", false, true},
+		{"NOT NOT e.active", true, false},
+		{"e.name = \"a AND b\" OR e.age = 15", true, false},
+	}
+	for _, c := range cases {
+		pred, err := Compile(c.where, rel, "e")
+		if err != nil {
+			t.Fatalf("compile %q: %v", c.where, err)
+		}
+		for _, tc := range []struct {
+			rec  *relation.Record
+			want bool
+		}{{young, c.young}, {adult, c.adult}} {
+			got, err := pred.Match(tc.rec)
+			if err != nil {
+				t.Fatalf("eval %q: %v", c.where, err)
+			}
+			if got != tc.want {
+				t.Fatalf("%q on %v = %v, want %v", c.where, tc.rec.Values, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestNotEqualAlias(t *testing.T) {
+	rel := relation.NewRelation("E", []relation.ColumnInfo{
+		{Name: "x", Kind: relation.KindInt},
+		{Name: "s", Kind: relation.KindVarchar, Size: 10},
+	})
+	five := relation.NewRecord("5", "a!=b")
+	six := relation.NewRecord("6", "c")
+	cases := []struct {
+		where string
+		five  bool
+		six   bool
+	}{
+		{"e.x != 5", false, true},
+		{"e.x!=5", false, true},
+		{"5 != e.x", false, true},
+		{"NOT e.x != 5", true, false},
+		// != inside a constant is part of the value
+		{"e.s = \"a!=b\"", true, false},
+		{"e.s != \"a!=b\"", false, true},
+	}
+	for _, c := range cases {
+		pred, err := Compile(c.where, rel, "e")
+		if err != nil {
+			t.Fatalf("compile %q: %v", c.where, err)
+		}
+		for _, tc := range []struct {
+			rec  *relation.Record
+			want bool
+		}{{five, c.five}, {six, c.six}} {
+			if got, err := pred.Match(tc.rec); err != nil || got != tc.want {
+				t.Fatalf("%q on %v = %v, %v; want %v", c.where, tc.rec.Values, got, err, tc.want)
+			}
+		}
+	}
+}
+
+func TestOperatorsInsideConstants(t *testing.T) {
+	rel := whereTestRelation()
+	rec := relation.NewRecord("30", "1", "a>b")
+	cases := []struct {
+		where string
+		want  bool
+	}{
+		{"e.name = \"a>b\"", true},
+		{"\"a>b\" = e.name", true},
+		{"e.name <> \"a>b\"", false},
+		{"e.name = \"x<=y\"", false},
+		{"\"x<=y\" < e.name", false},
+		{"\"a=b\" <> e.name", true},
+		{"e.name >= \"a<>\"", true},
+		{"e.name=\"a>b\" AND e.age>=30", true},
+	}
+	for _, c := range cases {
+		pred, err := Compile(c.where, rel, "e")
+		if err != nil {
+			t.Fatalf("compile %q: %v", c.where, err)
+		}
+		if got, err := pred.Match(rec); err != nil || got != c.want {
+			t.Fatalf("%q = %v, %v; want %v", c.where, got, err, c.want)
+		}
+	}
+}
+
+func TestWhereSyntaxErrors(t *testing.T) {
+	rel := whereTestRelation()
+	for _, where := range []string{"NOT", "(e.age < 18", "e.age < 18)", "e.age < 18 AND", "e.unknown", "e.age = \"15", "e.nope = 1"} {
+		if _, err := Compile(where, rel, "e"); err == nil {
+			t.Fatalf("expected syntax error for %q", where)
+		}
+	}
+}
+
+func TestEmptyAndNilPredicatesMatchEverything(t *testing.T) {
+	rec := relation.NewRecord("15", "1", "ann")
+	pred, err := Compile("  ", whereTestRelation(), "e")
+	if err != nil {
+		t.Fatalf("compile empty clause: %v", err)
+	}
+	for _, p := range []*Predicate{pred, nil} {
+		if ok, err := p.Match(rec); err != nil || !ok {
+			t.Fatalf("Match = %v, %v, want true", ok, err)
+		}
+	}
+}
+
+func TestMatchReportsEvaluationErrors(t *testing.T) {
+	rel := whereTestRelation()
+	pred, err := Compile("e.age > 18 OR e.name = \"bob\"", rel, "e")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	bad := relation.NewRecord("x", "0", "bob")
+	if _, err := pred.Match(bad); err == nil {
+		t.Fatalf("Match should report the malformed INT value")
+	}
+	if ok, err := pred.Match(relation.NewRecord("40", "0", "ann")); err != nil || !ok {
+		t.Fatalf("Match(e.age = 40) = %v, %v, want true", ok, err)
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	rel := whereTestRelation()
+	if got := ColumnIndex(rel, "name"); got != 2 {
+		t.Fatalf("ColumnIndex(name) = %d", got)
+	}
+	if got := ColumnIndex(rel, "Name"); got != -1 {
+		t.Fatalf("column names are case-sensitive, got %d", got)
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	cases := []struct {
+		kind        relation.ColumnKind
+		left, right string
+		op          string
+		want        bool
+	}{
+		{relation.KindInt, "10", "9", ">", true},
+		{relation.KindInt, "-3", "-3", "<=", true},
+		{relation.KindInt, "7", "07", "=", true},
+		{relation.KindInt, "2", "10", ">=", false},
+		{relation.KindFloat, "2.5", "2.50", "=", true},
+		{relation.KindFloat, "1e2", "99.9", ">", true},
+		{relation.KindFloat, "0.1", "0.2", "<>", true},
+		{relation.KindFloat, "NaN", "NaN", "=", false},
+		{relation.KindFloat, "NaN", "1", "<>", true},
+		{relation.KindVarchar, "10", "9", "<", true},
+		{relation.KindChar, "ann", "ann", "=", true},
+		{relation.KindVarchar, "", "a", "<", true},
+	}
+	for _, c := range cases {
+		got, err := compareValues(c.kind, c.left, c.op, c.right)
+		if err != nil {
+			t.Fatalf("%q %s %q: %v", c.left, c.op, c.right, err)
+		}
+		if got != c.want {
+			t.Fatalf("%q %s %q (kind %d) = %v, want %v", c.left, c.op, c.right, c.kind, got, c.want)
+		}
+	}
+	for _, c := range []struct {
+		kind        relation.ColumnKind
+		left, right string
+	}{
+		{relation.KindInt, "1", "abc"},
+		{relation.KindInt, "abc", "1"},
+		{relation.KindInt, "1", "1.5"},
+		{relation.KindInt, "1", ""},
+		{relation.KindFloat, "1", "x"},
+		{relation.KindFloat, "", "1"},
+	} {
+		if _, err := compareValues(c.kind, c.left, "=", c.right); err == nil {
+			t.Fatalf("%q = %q (kind %d) should fail", c.left, c.right, c.kind)
+		}
+	}
+	if _, err := compareValues(relation.KindInt, "1", "!=", "1"); err == nil {
+		t.Fatalf("an unknown operator should fail")
+	}
+}
+
+func TestInvalidNumericConstantIsAnError(t *testing.T) {
+	rel := relation.NewRelation("Emp", []relation.ColumnInfo{
+		{Name: "age", Kind: relation.KindInt},
+		{Name: "salary", Kind: relation.KindFloat},
+		{Name: "name", Kind: relation.KindVarchar, Size: 10},
+	})
+	for _, where := range []string{`e.age = "abc"`, "e.age < 1.5", `e.salary = "x"`, `"abc" = e.age`, `e.name = "a" OR e.salary >= ""`} {
+		if _, err := Compile(where, rel, "e"); err == nil {
+			t.Fatalf("Compile(%s) should reject the constant", where)
+		}
+	}
+	for _, where := range []string{`e.age = "42"`, "e.salary < 1e3", `e.name = "abc"`, "e.age = e.salary"} {
+		if _, err := Compile(where, rel, "e"); err != nil {
+			t.Fatalf("Compile(%s): %v", where, err)
+		}
+	}
+}
+
+func TestCompareIntAndFloatColumns(t *testing.T) {
+	rel := relation.NewRelation("M", []relation.ColumnInfo{
+		{Name: "i", Kind: relation.KindInt},
+		{Name: "f", Kind: relation.KindFloat},
+		{Name: "s", Kind: relation.KindVarchar, Size: 8},
+		{Name: "c", Kind: relation.KindChar, Size: 8},
+	})
+	rec := relation.NewRecord("2", "2.5", "b", "b")
+	cases := []struct {
+		where string
+		want  bool
+	}{
+		{"m.i < m.f", true},
+		{"m.f > m.i", true},
+		{"m.i = m.f", false},
+		{"m.f <= m.i", false},
+		{"m.i <> m.f", true},
+		{"m.s = m.c", true},
+	}
+	for _, c := range cases {
+		pred, err := Compile(c.where, rel, "m")
+		if err != nil {
+			t.Fatalf("compile %q: %v", c.where, err)
+		}
+		got, err := pred.Match(rec)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.where, err)
+		}
+		if got != c.want {
+			t.Fatalf("%q = %v, want %v", c.where, got, c.want)
+		}
+	}
+	// equal values of different kinds compare equal once promoted
+	pred, _ := Compile("m.i = m.f", rel, "m")
+	if ok, err := pred.Match(relation.NewRecord("3", "3.0", "", "")); err != nil || !ok {
+		t.Fatalf("3 = 3.0 gave %v, %v", ok, err)
+	}
+	for _, where := range []string{"m.i = m.s", "m.c > m.f"} {
+		if _, err := Compile(where, rel, "m"); err == nil {
+			t.Fatalf("Compile(%s) should reject a numeric-vs-string comparison", where)
+		}
+	}
+}
+
+func TestQualifiedColumnsAndEquality(t *testing.T) {
+	rel := relation.NewRelation("Emp,Dept", []relation.ColumnInfo{
+		{Name: "e.dept", Kind: relation.KindInt},
+		{Name: "d.id", Kind: relation.KindFloat},
+		{Name: "d.rate", Kind: relation.KindFloat},
+	})
+	pred, err := Compile("e.dept = d.id", rel, "")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if l, r, ok := pred.Equality(); !ok || l != 0 || r != 1 {
+		t.Fatalf("Equality = %d, %d, %v; want 0, 1, true", l, r, ok)
+	}
+	if ok, err := pred.Match(relation.NewRecord("2", "2.0", "0.5")); err != nil || !ok {
+		t.Fatalf("Match = %v, %v; want true", ok, err)
+	}
+	for _, where := range []string{"e.dept = 1", "e.dept < d.id", "e.dept = d.id AND d.rate > 0.5", "d.rate = 1.5"} {
+		pred, err := Compile(where, rel, "")
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", where, err)
+		}
+		if _, _, ok := pred.Equality(); ok {
+			t.Fatalf("%q is not a column equality", where)
+		}
+	}
+	if _, err := Compile("x.dept = 1", rel, ""); err == nil {
+		t.Fatalf("an unknown qualified column should be an error")
+	}
+	if _, _, ok := (*Predicate)(nil).Equality(); ok {
+		t.Fatalf("a nil predicate is not an equality")
+	}
+}
+
+func TestMatchReadsColumnsThroughTypedAccessors(t *testing.T) {
+	rel := relation.NewRelation("Emp", []relation.ColumnInfo{
+		{Name: "age", Kind: relation.KindInt},
+		{Name: "salary", Kind: relation.KindFloat},
+	})
+	pred, err := Compile("e.age > 30 AND e.salary", rel, "e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := relation.NewRecord("31", "1.5")
+	if ok, err := pred.Match(rec); err != nil || !ok {
+		t.Fatalf("Match = %v, %v; want true", ok, err)
+	}
+	if _, err := rec.Int(0); err != nil {
+		t.Fatalf("Match should bind the record: %v", err)
+	}
+	// the cached parse follows the value it was read from
+	rec.Values[0] = "29"
+	if ok, err := pred.Match(rec); err != nil || ok {
+		t.Fatalf("Match after update = %v, %v; want false", ok, err)
+	}
+	rec.Values[0] = "x"
+	if _, err := pred.Match(rec); !errors.Is(err, relation.ErrInvalidValue) {
+		t.Fatalf("a stored non-number should fail with ErrInvalidValue, got %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package relation
+
+import (
+	"fmt"
+	"io"
+
+	"malzahar-project/Projet_BDDA/buffer"
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// BulkInsert inserts every record returned by next until it returns io.EOF, and
+// returns how many were inserted. It is the fast path for large imports: the page
+// being filled stays pinned across records, the with-space list is walked once
+// instead of once per record, and pages that become full are moved to the full
+// list only at the end. Any other error from next, or a record that cannot be
+// written, stops the load; the records inserted so far are kept and the lists are
+// left consistent. The relation is locked for writing for the whole call.
+func (rm *RelationManager) BulkInsert(next func() (*Record, error)) (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.slotsPerPage == 0 {
+		rm.slotsPerPage = computeSlotsPerPage(rm.pageSpace(), rm.Rel.RecordSize)
+	}
+	if rm.HeaderPageId == invalidPage {
+		if _, err := rm.addDataPage(); err != nil {
+			return 0, err
+		}
+	}
+	pid, err := rm.headerFirstWithSpace()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		bf       *buffer.BufferFrame
+		slots    int
+		slot     int  // next candidate slot in the pinned page
+		used     int  // used slots of the pinned page
+		limit    int  // used slots at which the pinned page counts as full
+		fresh    bool // pages added during this load are not followed via next pointers
+		filled   []config.PageId
+		inserted int
+	)
+	// finish unpins the current page and files every page filled by this load on
+	// the full list, keeping the first error seen.
+	finish := func(err error) (int, error) {
+		if bf != nil {
+			if slot >= slots || used >= limit {
+				filled = append(filled, pid)
+			}
+			if ferr := rm.bm.FreePage(pid, true); err == nil {
+				err = ferr
+			}
+		}
+		if merr := rm.moveToFullList(filled); err == nil {
+			err = merr
+		}
+		return inserted, err
+	}
+	for {
+		rec, err := next()
+		if err == io.EOF {
+			return finish(nil)
+		}
+		if err != nil {
+			return finish(err)
+		}
+		// make sure a page with a free slot is pinned
+		for bf == nil || slot >= slots || used >= limit {
+			if bf != nil {
+				following := invalidPage
+				if !fresh {
+					bf.RLock()
+					following = rm.header(bf).Next()
+					bf.RUnlock()
+				}
+				filled = append(filled, pid)
+				ferr := rm.bm.FreePage(pid, true)
+				bf = nil
+				if ferr != nil {
+					return finish(ferr)
+				}
+				pid = following
+			}
+			if pid == invalidPage {
+				if pid, err = rm.addDataPage(); err != nil {
+					return finish(err)
+				}
+				fresh = true
+			}
+			if bf, err = rm.bm.GetPage(pid); err != nil {
+				bf = nil
+				return finish(err)
+			}
+			bf.RLock()
+			slots = rm.header(bf).NumSlots()
+			used = usedSlots(rm.page(bf), slots)
+			limit = rm.fullAt(slots)
+			slot = 0
+			for slot < slots && rm.page(bf)[20+slot] != 0 {
+				slot++
+			}
+			bf.RUnlock()
+		}
+		refs, err := rm.writeOverflow(rec)
+		if err != nil {
+			return finish(err)
+		}
+		// the page stays pinned across records, but latched only while it is written
+		pos := 20 + slots + slot*rm.Rel.RecordSize
+		bf.Lock()
+		if err := rm.Rel.WriteRecordToBuffer(rec, rm.page(bf), pos); err != nil {
+			bf.Unlock()
+			_ = rm.freeOverflow(refs)
+			return finish(err)
+		}
+		rm.setOverflowRefs(rm.page(bf), pos, refs)
+		rm.page(bf)[20+slot] = 1
+		for slot < slots && rm.page(bf)[20+slot] != 0 {
+			slot++
+		}
+		bf.Unlock()
+		bf.Dirty = true
+		used++
+		inserted++
+	}
+}
+
+// moveToFullList unlinks pages from the with-space list in a single walk, then
+// prepends them to the full list. Caller must hold rm.mu.
+func (rm *RelationManager) moveToFullList(pages []config.PageId) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	move := make(map[config.PageId]bool, len(pages))
+	for _, p := range pages {
+		move[p] = true
+	}
+	head, err := rm.headerFirstWithSpace()
+	if err != nil {
+		return err
+	}
+	prev := invalidPage
+	visited := make(map[config.PageId]bool)
+	for cur := head; cur != invalidPage && !visited[cur]; {
+		visited[cur] = true
+		nxt, err := rm.pageNext(cur)
+		if err != nil {
+			return err
+		}
+		if move[cur] {
+			if prev == invalidPage {
+				err = rm.headerSetFirstWithSpace(nxt)
+			} else {
+				err = rm.pageSetNext(prev, nxt)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			prev = cur
+		}
+		cur = nxt
+	}
+	for _, p := range pages {
+		if err := rm.prependToFullList(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteRecords deletes the records of rids as DeleteRecord would, page by page: each
+// page is pinned once to clear all of its targeted slots, and the pages that drop
+// below their fill limit leave the full list together, in a single walk of it. With
+// Rel.SoftDelete the slots are only tombstoned. A RecordId that designates no record
+// (or one listed twice) fails with ErrRecordNotFound before its page is touched; the
+// pages handled before it stay deleted and the lists are left consistent. The
+// relation is locked for writing for the whole call.
+func (rm *RelationManager) DeleteRecords(rids []RecordId) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	var pages []config.PageId
+	bySlot := make(map[config.PageId][]int)
+	for _, rid := range rids {
+		if _, ok := bySlot[rid.PageId]; !ok {
+			pages = append(pages, rid.PageId)
+		}
+		bySlot[rid.PageId] = append(bySlot[rid.PageId], rid.SlotIdx)
+	}
+	var emptied []config.PageId
+	var err error
+	for _, pid := range pages {
+		var leavesFull bool
+		if leavesFull, err = rm.deletePageSlots(pid, bySlot[pid], rm.Rel.SoftDelete); err != nil {
+			break
+		}
+		if leavesFull {
+			emptied = append(emptied, pid)
+		}
+	}
+	if lerr := rm.moveToWithSpaceList(emptied); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+// deletePageSlots frees (or with soft, tombstones) the given slots of pid under a
+// single pin, then frees the overflow pages of the records, and reports whether the
+// page was full and no longer is. Caller must hold rm.mu.
+func (rm *RelationManager) deletePageSlots(pid config.PageId, slotIdxs []int, soft bool) (bool, error) {
+	bf, err := rm.bm.GetPage(pid)
+	if err != nil {
+		return false, err
+	}
+	p := rm.page(bf)
+	bf.Lock()
+	slots := rm.header(bf).NumSlots()
+	seen := make(map[int]bool, len(slotIdxs))
+	for _, i := range slotIdxs {
+		if i < 0 || i >= slots {
+			bf.Unlock()
+			_ = rm.bm.FreePage(pid, false)
+			return false, fmt.Errorf("%w: invalid slot index %d", ErrRecordNotFound, i)
+		}
+		state := p[20+i]
+		if seen[i] || state == slotFree || (soft && state == slotTombstone) {
+			bf.Unlock()
+			_ = rm.bm.FreePage(pid, false)
+			return false, fmt.Errorf("%w: slot %v already free", ErrRecordNotFound, RecordId{PageId: pid, SlotIdx: i})
+		}
+		seen[i] = true
+	}
+	if soft {
+		// keep the records and their slots until Purge; page lists are unaffected
+		for _, i := range slotIdxs {
+			p[20+i] = slotTombstone
+		}
+		bf.Unlock()
+		bf.Dirty = true
+		return false, rm.bm.FreePage(pid, true)
+	}
+	// as in deleteRecord: a page over its limit (fill factor lowered) stays full until
+	// enough slots are freed
+	used := usedSlots(p, slots)
+	leavesFull := used >= rm.fullAt(slots) && used-len(slotIdxs) < rm.fullAt(slots)
+	dataStart := 20 + slots
+	var refs []overflowRef
+	for _, i := range slotIdxs {
+		pos := dataStart + i*rm.Rel.RecordSize
+		refs = append(refs, rm.overflowRefs(p, pos)...)
+		p[20+i] = slotFree
+		for j := 0; j < rm.Rel.RecordSize; j++ {
+			p[pos+j] = 0
+		}
+	}
+	bf.Unlock()
+	bf.Dirty = true
+	if err := rm.bm.FreePage(pid, true); err != nil {
+		return false, err
+	}
+	return leavesFull, rm.freeOverflow(refs)
+}
+
+// moveToWithSpaceList unlinks pages from the full list in a single walk, then
+// prepends them to the with-space list. Caller must hold rm.mu.
+func (rm *RelationManager) moveToWithSpaceList(pages []config.PageId) error {
+	if len(pages) == 0 {
+		return nil
+	}
+	move := make(map[config.PageId]bool, len(pages))
+	for _, p := range pages {
+		move[p] = true
+	}
+	head, err := rm.headerFirstFull()
+	if err != nil {
+		return err
+	}
+	prev := invalidPage
+	visited := make(map[config.PageId]bool)
+	for cur := head; cur != invalidPage && !visited[cur]; {
+		visited[cur] = true
+		nxt, err := rm.pageNext(cur)
+		if err != nil {
+			return err
+		}
+		if move[cur] {
+			if prev == invalidPage {
+				err = rm.headerSetFirstFull(nxt)
+			} else {
+				err = rm.pageSetNext(prev, nxt)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			prev = cur
+		}
+		cur = nxt
+	}
+	if err := rm.failpoint("link-with-space"); err != nil {
+		return err
+	}
+	for _, p := range pages {
+		if err := rm.prependToWithSpace(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordUpdate is the new version of the record at Rid, for UpdateRecords.
+type RecordUpdate struct {
+	Rid RecordId
+	Rec *Record
+}
+
+// UpdateRecords overwrites each record in its own slot, so every record keeps its
+// RecordId and the page lists are left alone. Records have a fixed size, so the new
+// version always fits; out-of-line values get new chains and the old ones are freed.
+// Updates are applied page by page, each page pinned once. Every new record is
+// checked before anything is written; a RecordId that designates no live record (or
+// one listed twice) fails with ErrRecordNotFound before its page is touched, the
+// pages handled before it keeping their new records. The relation is locked for
+// writing for the whole call.
+func (rm *RelationManager) UpdateRecords(updates []RecordUpdate) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	var pages []config.PageId
+	byPage := make(map[config.PageId][]RecordUpdate)
+	for _, u := range updates {
+		if err := rm.Rel.CheckRecord(u.Rec); err != nil {
+			return err
+		}
+		if _, ok := byPage[u.Rid.PageId]; !ok {
+			pages = append(pages, u.Rid.PageId)
+		}
+		byPage[u.Rid.PageId] = append(byPage[u.Rid.PageId], u)
+	}
+	for _, pid := range pages {
+		if err := rm.updatePageSlots(pid, byPage[pid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePageSlots overwrites the records of updates, all on pid, under a single pin,
+// then frees the overflow chains of the old versions. Caller must hold rm.mu.
+func (rm *RelationManager) updatePageSlots(pid config.PageId, updates []RecordUpdate) error {
+	bf, err := rm.bm.GetPage(pid)
+	if err != nil {
+		return err
+	}
+	p := rm.page(bf)
+	bf.RLock()
+	slots := rm.header(bf).NumSlots()
+	seen := make(map[int]bool, len(updates))
+	for _, u := range updates {
+		i := u.Rid.SlotIdx
+		if i < 0 || i >= slots {
+			bf.RUnlock()
+			_ = rm.bm.FreePage(pid, false)
+			return fmt.Errorf("%w: invalid slot index %d", ErrRecordNotFound, i)
+		}
+		if seen[i] || p[20+i] != slotUsed {
+			bf.RUnlock()
+			_ = rm.bm.FreePage(pid, false)
+			return fmt.Errorf("%w: no record at %v", ErrRecordNotFound, u.Rid)
+		}
+		seen[i] = true
+	}
+	bf.RUnlock()
+	var old []overflowRef
+	for _, u := range updates {
+		// the new chains are complete before the record points at them
+		refs, err := rm.writeOverflow(u.Rec)
+		if err == nil {
+			pos := 20 + slots + u.Rid.SlotIdx*rm.Rel.RecordSize
+			bf.Lock()
+			prev := rm.overflowRefs(p, pos)
+			if err = rm.Rel.WriteRecordToBuffer(u.Rec, p, pos); err == nil {
+				rm.setOverflowRefs(p, pos, refs)
+				old = append(old, prev...)
+				bf.Dirty = true
+			}
+			bf.Unlock()
+			if err != nil {
+				_ = rm.freeOverflow(refs)
+			}
+		}
+		if err != nil {
+			_ = rm.bm.FreePage(pid, bf.Dirty)
+			return err
+		}
+	}
+	if err := rm.bm.FreePage(pid, true); err != nil {
+		return err
+	}
+	return rm.freeOverflow(old)
+}
@@ -0,0 +1,258 @@
+package relation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// recordSource yields n records then io.EOF.
+func recordSource(n int) func() (*Record, error) {
+	i := 0
+	return func() (*Record, error) {
+		if i == n {
+			return nil, io.EOF
+		}
+		i++
+		return NewRecord(fmt.Sprint(i), "bulk"), nil
+	}
+}
+
+func TestBulkInsertFillsExistingPagesFirst(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	// leave holes in existing pages so the bulk load has to reuse them
+	var rids []RecordId
+	for i := 0; i < rm.dm.PageSize()/rm.Rel.RecordSize*2; i++ {
+		rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x"))
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		rids = append(rids, rid)
+	}
+	for _, rid := range rids[:5] {
+		if err := rm.DeleteRecord(rid); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	before := countRecords(t, rm)
+	pagesBefore, err := rm.AllPageIds()
+	if err != nil {
+		t.Fatalf("AllPageIds: %v", err)
+	}
+	const n = 500
+	got, err := rm.BulkInsert(recordSource(n))
+	if err != nil || got != n {
+		t.Fatalf("BulkInsert = %d, %v; want %d", got, err, n)
+	}
+	if c := countRecords(t, rm); c != before+n {
+		t.Fatalf("got %d records, want %d", c, before+n)
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity after bulk insert: %v", err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("pins after bulk insert: %v", err)
+	}
+	// the freed slots were reused rather than left behind
+	pagesAfter, err := rm.AllPageIds()
+	if err != nil {
+		t.Fatalf("AllPageIds: %v", err)
+	}
+	minPages := (before + n + rm.slotsPerPage - 1) / rm.slotsPerPage
+	if len(pagesAfter) != minPages || len(pagesAfter) < len(pagesBefore) {
+		t.Fatalf("bulk insert used %d pages, want %d", len(pagesAfter), minPages)
+	}
+	// single inserts keep working on the resulting lists
+	if _, err := rm.InsertRecord(NewRecord("1", "y")); err != nil {
+		t.Fatalf("insert after bulk: %v", err)
+	}
+}
+
+func TestBulkInsertStopsOnError(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	stop := errors.New("bad row")
+	src := recordSource(100)
+	i := 0
+	next := func() (*Record, error) {
+		i++
+		if i == 60 {
+			return nil, stop
+		}
+		return src()
+	}
+	got, err := rm.BulkInsert(next)
+	if err != stop || got != 59 {
+		t.Fatalf("BulkInsert = %d, %v; want 59, %v", got, err, stop)
+	}
+	// a record that cannot be written also stops the load
+	bad := func() (*Record, error) { return NewRecord("1"), nil }
+	if _, err := rm.BulkInsert(bad); err == nil {
+		t.Fatalf("expected arity error")
+	}
+	if c := countRecords(t, rm); c != 59 {
+		t.Fatalf("got %d records, want 59", c)
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity after failed load: %v", err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("pins after failed load: %v", err)
+	}
+}
+
+func TestDeleteRecordsGroupsByPage(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	var rids []RecordId
+	for i := 0; i < rm.dm.PageSize()/rm.Rel.RecordSize*3; i++ {
+		rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x"))
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		rids = append(rids, rid)
+	}
+	before := countRecords(t, rm)
+	// every odd record, so full pages drop below their limit, last page first
+	var victims []RecordId
+	for i := len(rids) - 1; i >= 0; i-- {
+		if i%2 == 1 {
+			victims = append(victims, rids[i])
+		}
+	}
+	if err := rm.DeleteRecords(victims); err != nil {
+		t.Fatalf("DeleteRecords: %v", err)
+	}
+	if got := countRecords(t, rm); got != before-len(victims) {
+		t.Fatalf("%d records left, want %d", got, before-len(victims))
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("lists after DeleteRecords: %v", err)
+	}
+	full, err := rm.headerFirstFull()
+	if err != nil || full != invalidPage {
+		t.Fatalf("full list head = %v (%v), want every page back on the with-space list", full, err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a bad RecordId leaves its page untouched
+	left := countRecords(t, rm)
+	err = rm.DeleteRecords([]RecordId{rids[0], rids[0]})
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("duplicate RecordId: got %v, want ErrRecordNotFound", err)
+	}
+	if got := countRecords(t, rm); got != left {
+		t.Fatalf("%d records left after a failed delete, want %d", got, left)
+	}
+	if err := rm.DeleteRecords([]RecordId{rids[1]}); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("deleted RecordId: got %v, want ErrRecordNotFound", err)
+	}
+}
+
+// BenchmarkDeleteColocated deletes every record of a table of some fifty pages, one
+// DeleteRecord call at a time and with DeleteRecords.
+func BenchmarkDeleteColocated(b *testing.B) {
+	del := map[string]func(rm *RelationManager, rids []RecordId) error{
+		"one-by-one": func(rm *RelationManager, rids []RecordId) error {
+			for _, rid := range rids {
+				if err := rm.DeleteRecord(rid); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		"bulk": (*RelationManager).DeleteRecords,
+	}
+	for _, name := range []string{"one-by-one", "bulk"} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				rm, cleanup := newScanTable(b, 0, 2000)
+				var rids []RecordId
+				if err := rm.ScanRecords(func(_ Record, rid RecordId) error {
+					rids = append(rids, rid)
+					return nil
+				}); err != nil {
+					b.Fatalf("scan: %v", err)
+				}
+				b.StartTimer()
+				if err := del[name](rm, rids); err != nil {
+					b.Fatalf("delete: %v", err)
+				}
+				b.StopTimer()
+				cleanup()
+			}
+		})
+	}
+}
+
+func TestUpdateRecordsKeepsRecordIds(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	// a single page, all of it updated
+	var rids []RecordId
+	for i := 0; i < 10; i++ {
+		rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "old"))
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		if len(rids) > 0 && rid.PageId != rids[0].PageId {
+			t.Fatalf("records spread over several pages")
+		}
+		rids = append(rids, rid)
+	}
+	pagesBefore, err := rm.AllPageIds()
+	if err != nil {
+		t.Fatalf("AllPageIds: %v", err)
+	}
+	var updates []RecordUpdate
+	for i, rid := range rids {
+		updates = append(updates, RecordUpdate{Rid: rid, Rec: NewRecord(fmt.Sprint(100+i), "new")})
+	}
+	if err := rm.UpdateRecords(updates); err != nil {
+		t.Fatalf("UpdateRecords: %v", err)
+	}
+	got := make(map[RecordId]string)
+	if err := rm.ScanRecords(func(rec Record, rid RecordId) error {
+		got[rid] = rec.Values[0] + "/" + rec.Values[1]
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(got) != len(rids) {
+		t.Fatalf("%d records after update, want %d", len(got), len(rids))
+	}
+	for i, rid := range rids {
+		if want := fmt.Sprint(100+i) + "/new"; got[rid] != want {
+			t.Fatalf("record at %v = %q, want %q", rid, got[rid], want)
+		}
+	}
+	pagesAfter, err := rm.AllPageIds()
+	if err != nil || fmt.Sprint(pagesAfter) != fmt.Sprint(pagesBefore) {
+		t.Fatalf("pages changed from %v to %v (%v)", pagesBefore, pagesAfter, err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a deleted record cannot be updated, and its page is left untouched
+	if err := rm.DeleteRecord(rids[0]); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	err = rm.UpdateRecords([]RecordUpdate{{Rid: rids[1], Rec: NewRecord("7", "x")}, {Rid: rids[0], Rec: NewRecord("8", "x")}})
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("update of a deleted record: got %v, want ErrRecordNotFound", err)
+	}
+	if err := rm.ScanRecords(func(rec Record, rid RecordId) error {
+		if rid == rids[1] && rec.Values[0] != "101" {
+			t.Fatalf("record at %v updated despite the error: %v", rid, rec.Values)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+}
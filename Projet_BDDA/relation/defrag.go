@@ -0,0 +1,153 @@
+package relation
+
+import (
+	"fmt"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// Defragment moves the data pages of the relation, each followed by the overflow
+// pages of its records, to a run of consecutive pages of one data file, so that a
+// scan reads them in file order. The header page stays where it is. Record ids
+// change with their page; slots are kept.
+//
+// The copies are written and flushed before the header is pointed at them in a
+// single page write, and the old pages are only freed afterwards, so a crash leaves
+// either the old or the new pages in use (the other set leaked, never lost). The
+// buffer pool is flushed, so no page may be pinned: callers check that first.
+// A relation failing CheckIntegrity is left alone, as its directory cannot be
+// trusted to hold every page; Repair it first. It returns the number of pages moved.
+func (rm *RelationManager) Defragment() (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.HeaderPageId == invalidPage {
+		return 0, nil
+	}
+	if err := rm.checkIntegrity(); err != nil {
+		return 0, fmt.Errorf("defragment: %w", err)
+	}
+	pages, _, err := rm.listedPages()
+	if err != nil {
+		return 0, err
+	}
+	// old pages in their new order, and which of them are overflow pages
+	var order []config.PageId
+	overflow := make(map[config.PageId]bool)
+	for _, pid := range pages {
+		order = append(order, pid)
+		refs, err := rm.pageOverflowRefs(pid)
+		if err != nil {
+			return 0, err
+		}
+		for _, ref := range refs {
+			chain, err := rm.overflowPages(ref)
+			if err != nil {
+				return 0, err
+			}
+			for _, opid := range chain {
+				order = append(order, opid)
+				overflow[opid] = true
+			}
+		}
+	}
+	if len(order) == 0 {
+		return 0, nil
+	}
+	fresh, err := rm.dm.AllocateContiguous(len(order))
+	if err != nil {
+		return 0, err
+	}
+	moved := make(map[config.PageId]config.PageId, len(order))
+	for i, pid := range order {
+		moved[pid] = fresh[i]
+	}
+	relink := func(pid config.PageId) config.PageId {
+		if to, ok := moved[pid]; ok {
+			return to
+		}
+		return pid
+	}
+	for _, pid := range order {
+		if err := rm.copyPage(pid, moved[pid], func(p []byte) {
+			h := rm.headerOf(p)
+			h.SetNext(relink(h.Next()))
+			if overflow[pid] {
+				return
+			}
+			slots := h.NumSlots()
+			for i := 0; i < slots; i++ {
+				if p[20+i] == slotFree {
+					continue
+				}
+				pos := 20 + slots + i*rm.Rel.RecordSize
+				refs := rm.overflowRefs(p, pos)
+				for j := range refs {
+					refs[j].head = relink(refs[j].head)
+				}
+				rm.setOverflowRefs(p, pos, refs)
+			}
+		}); err != nil {
+			return 0, err
+		}
+	}
+	if err := rm.bm.FlushBuffers(); err != nil {
+		return 0, err
+	}
+	// both list heads live in the header page: one write switches to the copies
+	whead, err := rm.headerFirstWithSpace()
+	if err != nil {
+		return 0, err
+	}
+	fhead, err := rm.headerFirstFull()
+	if err != nil {
+		return 0, err
+	}
+	if err := rm.headerSetFirstWithSpace(relink(whead)); err != nil {
+		return 0, err
+	}
+	if err := rm.headerSetFirstFull(relink(fhead)); err != nil {
+		return 0, err
+	}
+	if err := rm.bm.FlushBuffers(); err != nil {
+		return 0, err
+	}
+	dir := make([]config.PageId, len(pages))
+	for i, pid := range pages {
+		dir[i] = moved[pid]
+	}
+	if err := rm.writePageDirectory(dir); err != nil {
+		return 0, err
+	}
+	for _, pid := range order {
+		if err := rm.dm.FreePage(pid); err != nil {
+			return 0, err
+		}
+	}
+	return len(order), nil
+}
+
+// copyPage copies page from, reserve included, to page to, then lets fix rewrite the
+// copy's layout.
+func (rm *RelationManager) copyPage(from, to config.PageId, fix func(p []byte)) error {
+	src, err := rm.bm.GetPage(from)
+	if err != nil {
+		return err
+	}
+	dst, err := rm.bm.GetPage(to)
+	if err != nil {
+		_ = rm.bm.FreePage(from, false)
+		return err
+	}
+	src.RLock()
+	dst.Lock()
+	copy(dst.Data, src.Data)
+	fix(rm.page(dst))
+	dst.Unlock()
+	src.RUnlock()
+	dst.Dirty = true
+	if err := rm.bm.FreePage(to, true); err != nil {
+		_ = rm.bm.FreePage(from, false)
+		return err
+	}
+	return rm.bm.FreePage(from, false)
+}
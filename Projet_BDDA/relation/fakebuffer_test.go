@@ -0,0 +1,120 @@
+package relation
+
+import (
+	"testing"
+
+	"malzahar-project/Projet_BDDA/buffer"
+	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/disk"
+)
+
+// countingPool is a BufferPool that keeps every page it is asked for in its own
+// frame, never evicting, and counts the pins and unpins of each page.
+type countingPool struct {
+	dm     disk.PageStore
+	frames map[config.PageId]*buffer.BufferFrame
+	pins   map[config.PageId]int
+	unpins map[config.PageId]int
+}
+
+func newCountingPool(dm disk.PageStore) *countingPool {
+	p := &countingPool{dm: dm, frames: make(map[config.PageId]*buffer.BufferFrame)}
+	p.reset()
+	return p
+}
+
+// reset forgets the counts, not the frames.
+func (p *countingPool) reset() {
+	p.pins = make(map[config.PageId]int)
+	p.unpins = make(map[config.PageId]int)
+}
+
+func (p *countingPool) GetPage(pid config.PageId) (*buffer.BufferFrame, error) {
+	f, ok := p.frames[pid]
+	if !ok {
+		data, err := p.dm.ReadPage(pid)
+		if err != nil {
+			return nil, err
+		}
+		f = &buffer.BufferFrame{PageId: pid, Data: data}
+		p.frames[pid] = f
+	}
+	f.PinCount++
+	p.pins[pid]++
+	return f, nil
+}
+
+func (p *countingPool) FreePage(pid config.PageId, dirty bool) error {
+	f, ok := p.frames[pid]
+	if !ok {
+		return buffer.ErrPageNotBuffered
+	}
+	if f.PinCount > 0 {
+		f.PinCount--
+	}
+	f.Dirty = f.Dirty || dirty
+	p.unpins[pid]++
+	return nil
+}
+
+func (p *countingPool) FlushBuffers() error {
+	for pid, f := range p.frames {
+		if f.Dirty {
+			if err := p.dm.WritePage(pid, f.Data); err != nil {
+				return err
+			}
+		}
+		delete(p.frames, pid)
+	}
+	return nil
+}
+
+// TestInsertRecordPinsEachPageOnce checks, through a counting pool, that an insert
+// into a page with free slots pins the header page and that data page once each, and
+// unpins both.
+func TestInsertRecordPinsEachPageOnce(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	defer dm.Finish()
+	bp := newCountingPool(dm)
+	rel := NewRelation("r_pins", []ColumnInfo{{Name: "a", Kind: KindInt}, {Name: "b", Kind: KindChar, Size: 8}})
+	rm, err := NewRelationManager(rel, dm, bp)
+	if err != nil {
+		t.Fatalf("new rm: %v", err)
+	}
+	rec := NewRecord("1", "x")
+	first, err := rm.InsertRecord(rec)
+	if err != nil {
+		t.Fatalf("first insert: %v", err)
+	}
+	// leave the last slot free so that no insert moves the page to the full list
+	for i := 1; i < rm.slotsPerPage-1; i++ {
+		bp.reset()
+		rid, err := rm.InsertRecord(rec)
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		if rid.PageId != first.PageId {
+			t.Fatalf("insert %d went to page %v, not %v", i, rid.PageId, first.PageId)
+		}
+		want := map[config.PageId]int{rm.HeaderPageId: 1, rid.PageId: 1}
+		if len(bp.pins) != len(want) {
+			t.Fatalf("insert %d pinned %v, want %v", i, bp.pins, want)
+		}
+		for pid, n := range want {
+			if bp.pins[pid] != n || bp.unpins[pid] != n {
+				t.Fatalf("insert %d: page %v pinned %d and unpinned %d times, want %d", i, pid, bp.pins[pid], bp.unpins[pid], n)
+			}
+		}
+	}
+	if err := bp.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	recs, err := rm.GetAllRecords()
+	if err != nil || len(recs) != rm.slotsPerPage-1 {
+		t.Fatalf("read back %d records, %v; want %d", len(recs), err, rm.slotsPerPage-1)
+	}
+}
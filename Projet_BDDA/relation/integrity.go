@@ -0,0 +1,321 @@
+package relation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// The page directory file <rel>.pages lists every data page allocated for the relation
+// as 8-byte entries (int32 fileIdx, int32 pageIdx), in the same encoding as the .hdr
+// file. The lists in the header page are the source of truth for scans; the directory
+// only lets CheckIntegrity and Repair find pages that fell off both lists.
+
+func (rm *RelationManager) pagesFilePath() string {
+	return filepath.Join(rm.dm.BinDir(), rm.Rel.Name+".pages")
+}
+
+func (rm *RelationManager) appendPageDirectory(pid config.PageId) error {
+	f, err := os.OpenFile(rm.pagesFilePath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8)
+	rm.order.PutUint32(buf[0:4], uint32(pid.FileIdx))
+	rm.order.PutUint32(buf[4:8], uint32(pid.PageIdx))
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadPageDirectory returns the recorded data pages; a missing file (tables created
+// before the directory existed) yields an empty list.
+func (rm *RelationManager) loadPageDirectory() ([]config.PageId, error) {
+	data, err := os.ReadFile(rm.pagesFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []config.PageId
+	for off := 0; off+8 <= len(data); off += 8 {
+		fi := int32(rm.order.Uint32(data[off : off+4]))
+		pi := int32(rm.order.Uint32(data[off+4 : off+8]))
+		out = append(out, config.PageId{FileIdx: int(fi), PageIdx: int(pi)})
+	}
+	return out, nil
+}
+
+func (rm *RelationManager) writePageDirectory(pids []config.PageId) error {
+	buf := make([]byte, 0, 8*len(pids))
+	for _, pid := range pids {
+		buf = rm.order.AppendUint32(buf, uint32(pid.FileIdx))
+		buf = rm.order.AppendUint32(buf, uint32(pid.PageIdx))
+	}
+	return os.WriteFile(rm.pagesFilePath(), buf, 0o644)
+}
+
+// IntegrityError lists the problems found by CheckIntegrity.
+type IntegrityError struct {
+	Relation string
+	Problems []string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("relation %s: %s", e.Relation, strings.Join(e.Problems, "; "))
+}
+
+// pageState is what the integrity walk reads from a data page header and bytemap.
+type pageState struct {
+	slots int
+	used  int
+	next  config.PageId
+}
+
+func (rm *RelationManager) readPageState(pid config.PageId) (pageState, error) {
+	bf, err := rm.bm.GetPage(pid)
+	if err != nil {
+		return pageState{}, err
+	}
+	bf.RLock()
+	st := pageState{slots: rm.header(bf).NumSlots()}
+	if st.slots == rm.slotsPerPage {
+		for i := 0; i < st.slots; i++ {
+			if rm.page(bf)[20+i] != 0 {
+				st.used++
+			}
+		}
+	}
+	st.next = rm.header(bf).Next()
+	bf.RUnlock()
+	if err := rm.bm.FreePage(pid, false); err != nil {
+		return pageState{}, err
+	}
+	return st, nil
+}
+
+// walkList follows one list from head, recording each page in seen under the list name
+// and appending any problem found. It stops at the first cycle or broken page.
+func (rm *RelationManager) walkList(list string, head config.PageId, seen map[config.PageId]string, problems *[]string) {
+	for pid := head; pid != invalidPage; {
+		if other, ok := seen[pid]; ok {
+			if other == list {
+				*problems = append(*problems, fmt.Sprintf("%s list: cycle at page %d:%d", list, pid.FileIdx, pid.PageIdx))
+			} else {
+				*problems = append(*problems, fmt.Sprintf("page %d:%d is on both lists", pid.FileIdx, pid.PageIdx))
+			}
+			return
+		}
+		st, err := rm.readPageState(pid)
+		if err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s list: page %d:%d unreadable: %v", list, pid.FileIdx, pid.PageIdx, err))
+			return
+		}
+		if st.slots != rm.slotsPerPage {
+			*problems = append(*problems, fmt.Sprintf("%s list: page %d:%d has %d slots, want %d", list, pid.FileIdx, pid.PageIdx, st.slots, rm.slotsPerPage))
+			return
+		}
+		seen[pid] = list
+		full := st.used >= rm.fullAt(st.slots)
+		if list == "with-space" && full {
+			*problems = append(*problems, fmt.Sprintf("page %d:%d is full but on the with-space list", pid.FileIdx, pid.PageIdx))
+		}
+		if list == "full" && !full {
+			*problems = append(*problems, fmt.Sprintf("page %d:%d has free slots but is on the full list", pid.FileIdx, pid.PageIdx))
+		}
+		pid = st.next
+	}
+}
+
+// CheckIntegrity walks the with-space and full lists and reports cycles, pages present
+// on both lists or filed on the wrong one, pages that cannot be read, and directory
+// pages reachable from neither list. It returns an *IntegrityError listing every
+// problem, or nil when the relation is consistent.
+func (rm *RelationManager) CheckIntegrity() error {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.checkIntegrity()
+}
+
+// checkIntegrity is CheckIntegrity for callers that hold rm.mu.
+func (rm *RelationManager) checkIntegrity() error {
+	if rm.HeaderPageId == invalidPage {
+		return nil
+	}
+	var problems []string
+	seen := make(map[config.PageId]string)
+	whead, err := rm.headerFirstWithSpace()
+	if err != nil {
+		return err
+	}
+	rm.walkList("with-space", whead, seen, &problems)
+	fhead, err := rm.headerFirstFull()
+	if err != nil {
+		return err
+	}
+	rm.walkList("full", fhead, seen, &problems)
+	dir, err := rm.loadPageDirectory()
+	if err != nil {
+		return err
+	}
+	for _, pid := range dir {
+		if _, ok := seen[pid]; !ok {
+			problems = append(problems, fmt.Sprintf("page %d:%d is reachable from neither list", pid.FileIdx, pid.PageIdx))
+		}
+	}
+	if len(problems) > 0 {
+		return &IntegrityError{Relation: rm.Rel.Name, Problems: problems}
+	}
+	return nil
+}
+
+// Repair rebuilds both lists from every page known to belong to the relation: the
+// page directory plus whatever the current lists still reach. Each page is filed by
+// its bytemap; record data is left untouched.
+func (rm *RelationManager) Repair() error {
+	return rm.RepairFrom(nil)
+}
+
+// RepairFrom is Repair with extra candidate pages, typically every allocated page of
+// the database when the directory itself cannot be trusted. Candidates that do not
+// look like data pages of this relation are ignored; callers must leave out pages
+// owned by other relations, which may share the same layout.
+func (rm *RelationManager) RepairFrom(candidates []config.PageId) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.HeaderPageId == invalidPage {
+		return nil
+	}
+	pages, err := rm.knownPages()
+	if err != nil {
+		return err
+	}
+	for _, pid := range candidates {
+		if rm.looksLikeDataPage(pid) {
+			pages = append(pages, pid)
+		}
+	}
+	return rm.rebuildLists(pages)
+}
+
+// KnownPageIds returns the data pages the relation owns as far as its page directory
+// and lists tell, without stopping at corruption (unlike AllPageIds it includes pages
+// reachable from neither list).
+func (rm *RelationManager) KnownPageIds() ([]config.PageId, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	if rm.HeaderPageId == invalidPage {
+		return nil, nil
+	}
+	return rm.knownPages()
+}
+
+// knownPages is the page directory plus the pages the lists reach before any
+// corruption. It may contain duplicates. Caller must hold rm.mu.
+func (rm *RelationManager) knownPages() ([]config.PageId, error) {
+	pages, err := rm.loadPageDirectory()
+	if err != nil {
+		return nil, err
+	}
+	var ignored []string
+	seen := make(map[config.PageId]string)
+	whead, err := rm.headerFirstWithSpace()
+	if err != nil {
+		return nil, err
+	}
+	rm.walkList("with-space", whead, seen, &ignored)
+	fhead, err := rm.headerFirstFull()
+	if err != nil {
+		return nil, err
+	}
+	rm.walkList("full", fhead, seen, &ignored)
+	for pid := range seen {
+		pages = append(pages, pid)
+	}
+	return pages, nil
+}
+
+// looksLikeDataPage reports whether pid is laid out like one of this relation's data
+// pages: matching slot count, unset prev pointer and a bytemap of slot states.
+func (rm *RelationManager) looksLikeDataPage(pid config.PageId) bool {
+	if pid == rm.HeaderPageId {
+		return false
+	}
+	bf, err := rm.bm.GetPage(pid)
+	if err != nil {
+		return false
+	}
+	defer rm.bm.FreePage(pid, false)
+	bf.RLock()
+	defer bf.RUnlock()
+	h := rm.header(bf)
+	if h.Prev() != invalidPage {
+		return false
+	}
+	slots := h.NumSlots()
+	if slots != rm.slotsPerPage || 20+slots > len(rm.page(bf)) {
+		return false
+	}
+	for i := 0; i < slots; i++ {
+		if rm.page(bf)[20+i] > slotTombstone {
+			return false
+		}
+	}
+	return true
+}
+
+// rebuildLists relinks pages (duplicates and non data pages are skipped) into fresh
+// with-space and full chains in page order, then publishes the new heads in the header
+// and rewrites the page directory. Caller must hold rm.mu.
+func (rm *RelationManager) rebuildLists(pages []config.PageId) error {
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].FileIdx != pages[j].FileIdx {
+			return pages[i].FileIdx < pages[j].FileIdx
+		}
+		return pages[i].PageIdx < pages[j].PageIdx
+	})
+	var withSpace, full, kept []config.PageId
+	for i, pid := range pages {
+		if pid == rm.HeaderPageId || (i > 0 && pid == pages[i-1]) {
+			continue
+		}
+		st, err := rm.readPageState(pid)
+		if err != nil || st.slots != rm.slotsPerPage {
+			continue
+		}
+		kept = append(kept, pid)
+		if st.used >= rm.fullAt(st.slots) {
+			full = append(full, pid)
+		} else {
+			withSpace = append(withSpace, pid)
+		}
+	}
+	// link each chain completely before pointing the header at it
+	heads := make([]config.PageId, 2)
+	for i, chain := range [][]config.PageId{withSpace, full} {
+		heads[i] = invalidPage
+		for j := len(chain) - 1; j >= 0; j-- {
+			if err := rm.pageSetNext(chain[j], heads[i]); err != nil {
+				return err
+			}
+			heads[i] = chain[j]
+		}
+	}
+	if err := rm.headerSetFirstWithSpace(heads[0]); err != nil {
+		return err
+	}
+	if err := rm.headerSetFirstFull(heads[1]); err != nil {
+		return err
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return rm.writePageDirectory(kept)
+}
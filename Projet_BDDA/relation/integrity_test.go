@@ -0,0 +1,230 @@
+package relation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fillPages inserts enough records to span several data pages, deletes a few so both
+// lists are populated, and returns the number of live records.
+func fillPages(t *testing.T, rm *RelationManager) int {
+	t.Helper()
+	n := rm.dm.PageSize()/rm.Rel.RecordSize*3 + 1
+	var rids []RecordId
+	for i := 0; i < n; i++ {
+		rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x"))
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		rids = append(rids, rid)
+	}
+	for _, rid := range rids[:3] {
+		if err := rm.DeleteRecord(rid); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	return n - 3
+}
+
+func countRecords(t *testing.T, rm *RelationManager) int {
+	t.Helper()
+	n := 0
+	if err := rm.ScanRecords(func(Record, RecordId) error { n++; return nil }); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	return n
+}
+
+func TestCheckIntegrityConsistent(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	fillPages(t, rm)
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("unexpected integrity error: %v", err)
+	}
+}
+
+func TestCheckIntegrityDetectsSelfLoop(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	want := fillPages(t, rm)
+	head, err := rm.headerFirstFull()
+	if err != nil || head == invalidPage {
+		t.Fatalf("expected a full page, got %v (%v)", head, err)
+	}
+	// corrupt: the head of the full list points to itself, hiding the rest of the list
+	if err := rm.pageSetNext(head, head); err != nil {
+		t.Fatalf("pageSetNext: %v", err)
+	}
+	if got := countRecords(t, rm); got >= want {
+		t.Fatalf("corruption should hide records, scan found %d of %d", got, want)
+	}
+	err = rm.CheckIntegrity()
+	var ierr *IntegrityError
+	if !errors.As(err, &ierr) {
+		t.Fatalf("expected IntegrityError, got %v", err)
+	}
+	msg := ierr.Error()
+	if !strings.Contains(msg, "cycle at page") || !strings.Contains(msg, "reachable from neither list") {
+		t.Fatalf("unexpected problems: %v", msg)
+	}
+
+	if err := rm.Repair(); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("still inconsistent after repair: %v", err)
+	}
+	if got := countRecords(t, rm); got != want {
+		t.Fatalf("after repair got %d records, want %d", got, want)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("pins after repair: %v", err)
+	}
+}
+
+func TestCheckIntegrityDetectsOrphanedPages(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	want := fillPages(t, rm)
+	// corrupt: drop the whole with-space list from the header
+	if err := rm.headerSetFirstWithSpace(invalidPage); err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	err := rm.CheckIntegrity()
+	if err == nil || !strings.Contains(err.Error(), "reachable from neither list") {
+		t.Fatalf("expected orphaned pages, got %v", err)
+	}
+	if err := rm.Repair(); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if got := countRecords(t, rm); got != want {
+		t.Fatalf("after repair got %d records, want %d", got, want)
+	}
+	// the repaired lists must keep working for inserts
+	if _, err := rm.InsertRecord(NewRecord("7", "y")); err != nil {
+		t.Fatalf("insert after repair: %v", err)
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("after insert: %v", err)
+	}
+}
+
+func TestDeleteFromWithSpacePageKeepsListsAcyclic(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	// two non-full pages on the with-space list: the older one is not the head
+	first, err := rm.InsertRecord(NewRecord("1", "x"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := rm.InsertRecord(NewRecord("2", "x")); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if _, err := rm.addDataPage(); err != nil {
+		t.Fatalf("addDataPage: %v", err)
+	}
+	if err := rm.DeleteRecord(first); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("delete on a with-space page corrupted the lists: %v", err)
+	}
+}
+
+var errCrash = errors.New("simulated crash")
+
+// crashAt makes the next list update stop right before step, as if the process died.
+func crashAt(rm *RelationManager, step string) {
+	rm.failAt = func(s string) error {
+		if s == step {
+			rm.failAt = nil
+			return errCrash
+		}
+		return nil
+	}
+}
+
+func TestCrashBetweenListStepsKeepsRecordsRecoverable(t *testing.T) {
+	for _, tc := range []struct {
+		step string
+		op   func(rm *RelationManager, rids []RecordId) error
+		// records added (+1) or removed (-1) by op even though it was interrupted
+		delta int
+	}{
+		{"publish-page", func(rm *RelationManager, _ []RecordId) error {
+			_, err := rm.addDataPage()
+			return err
+		}, 0},
+		{"link-full", func(rm *RelationManager, _ []RecordId) error {
+			// fill the with-space head until it moves to the full list
+			for {
+				if _, err := rm.InsertRecord(NewRecord("9", "z")); err != nil {
+					return err
+				}
+			}
+		}, 0},
+		{"link-with-space", func(rm *RelationManager, rids []RecordId) error {
+			// rids[3] sits on a page of the full list
+			return rm.DeleteRecord(rids[3])
+		}, -1},
+	} {
+		t.Run(tc.step, func(t *testing.T) {
+			rm, cleanup := setup(t)
+			defer cleanup()
+			var rids []RecordId
+			for i := 0; i < rm.dm.PageSize()/rm.Rel.RecordSize*2; i++ {
+				rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x"))
+				if err != nil {
+					t.Fatalf("insert: %v", err)
+				}
+				rids = append(rids, rid)
+			}
+			before := countRecords(t, rm)
+			crashAt(rm, tc.step)
+			if err := tc.op(rm, rids); err != errCrash {
+				t.Fatalf("expected simulated crash, got %v", err)
+			}
+			if err := pool(rm).AssertAllUnpinned(); err != nil {
+				t.Fatalf("pins after crash: %v", err)
+			}
+			if err := rm.Repair(); err != nil {
+				t.Fatalf("repair: %v", err)
+			}
+			if err := rm.CheckIntegrity(); err != nil {
+				t.Fatalf("inconsistent after repair: %v", err)
+			}
+			got := countRecords(t, rm)
+			if tc.step == "link-full" {
+				// the inserts before the crash completed; only check nothing was lost
+				if got < before {
+					t.Fatalf("lost records: %d < %d", got, before)
+				}
+				return
+			}
+			if got != before+tc.delta {
+				t.Fatalf("got %d records after repair, want %d", got, before+tc.delta)
+			}
+		})
+	}
+}
+
+func TestAddDataPageCrashDoesNotCutList(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	for i := 0; i < 3; i++ {
+		if _, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x")); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	crashAt(rm, "publish-page")
+	if _, err := rm.addDataPage(); err != errCrash {
+		t.Fatalf("expected simulated crash, got %v", err)
+	}
+	// without any repair, existing records must still be reachable
+	if got := countRecords(t, rm); got != 3 {
+		t.Fatalf("got %d records, want 3", got)
+	}
+}
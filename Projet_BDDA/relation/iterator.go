@@ -0,0 +1,162 @@
+package relation
+
+import (
+	"context"
+
+	"malzahar-project/Projet_BDDA/buffer"
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// RecordIterator walks the records of a relation one at a time, with-space list
+// first and then the full list, in the same order as ScanRecords.
+//
+// The iterator holds the relation's read lock from creation until it is exhausted,
+// fails or is closed, and keeps the page it is reading pinned. Always Close it (it is
+// safe to Close twice or after exhaustion); mutating the same RelationManager while an
+// iterator is open deadlocks, as with a ScanRecords callback.
+type RecordIterator struct {
+	rm       *RelationManager
+	fullHead config.PageId // head of the full list, walked once with-space is done
+	onFull   bool
+	pid      config.PageId // page being read; pinned while bf != nil
+	bf       *buffer.BufferFrame
+	slot     int
+	visited  map[config.PageId]bool
+	closed   bool
+	// ctx, when set, is checked before each page is read; see ScanRecordsContext
+	ctx context.Context
+}
+
+// Iterator returns an iterator positioned before the first record.
+func (rm *RelationManager) Iterator() *RecordIterator {
+	rm.mu.RLock()
+	it := &RecordIterator{rm: rm, pid: invalidPage, fullHead: invalidPage, visited: make(map[config.PageId]bool)}
+	if rm.HeaderPageId == invalidPage {
+		it.onFull = true
+	}
+	return it
+}
+
+// Next returns the next record and its RecordId. ok is false once every record has
+// been returned or after an error; the iterator is then closed.
+func (it *RecordIterator) Next() (rec Record, rid RecordId, ok bool, err error) {
+	if it.closed {
+		return Record{}, RecordId{}, false, nil
+	}
+	rec, rid, ok, err = it.advance()
+	if !ok || err != nil {
+		if cerr := it.Close(); err == nil {
+			err = cerr
+		}
+		return Record{}, RecordId{}, false, err
+	}
+	return rec, rid, true, nil
+}
+
+func (it *RecordIterator) advance() (Record, RecordId, bool, error) {
+	rm := it.rm
+	for {
+		if it.bf == nil {
+			if it.pid == invalidPage {
+				more, err := it.nextList()
+				if err != nil || !more {
+					return Record{}, RecordId{}, false, err
+				}
+				continue
+			}
+			if it.visited[it.pid] {
+				// cycle detected: stop this list
+				it.pid = invalidPage
+				continue
+			}
+			if it.ctx != nil {
+				if err := it.ctx.Err(); err != nil {
+					return Record{}, RecordId{}, false, err
+				}
+			}
+			it.visited[it.pid] = true
+			bf, err := rm.bm.GetPage(it.pid)
+			if err != nil {
+				return Record{}, RecordId{}, false, err
+			}
+			it.bf = bf
+			it.slot = 0
+			// read ahead the rest of the list while this page is being read
+			if pf, ok := rm.bm.(prefetcher); ok {
+				bf.RLock()
+				next := rm.header(bf).Next()
+				bf.RUnlock()
+				pf.Prefetch(next, func(p []byte) config.PageId {
+					return rm.headerOf(p[rm.reserve:]).Next()
+				})
+			}
+		}
+		// the page stays pinned between calls, but latched only while it is read
+		it.bf.RLock()
+		slots := rm.header(it.bf).NumSlots()
+		for it.slot < slots {
+			i := it.slot
+			it.slot++
+			if rm.page(it.bf)[20+i] != 1 {
+				continue
+			}
+			rec, refs, err := rm.readRecord(rm.page(it.bf), 20+slots+i*rm.Rel.RecordSize)
+			it.bf.RUnlock()
+			if err != nil {
+				return Record{}, RecordId{}, false, err
+			}
+			if err := rm.loadOverflow(&rec, refs); err != nil {
+				return Record{}, RecordId{}, false, err
+			}
+			return rec, RecordId{PageId: it.pid, SlotIdx: i}, true, nil
+		}
+		next := rm.header(it.bf).Next()
+		it.bf.RUnlock()
+		if err := it.release(); err != nil {
+			return Record{}, RecordId{}, false, err
+		}
+		it.pid = next
+	}
+}
+
+// nextList moves to the head of the next list to walk and reports whether there was one.
+func (it *RecordIterator) nextList() (bool, error) {
+	if it.onFull {
+		if it.fullHead == invalidPage {
+			return false, nil
+		}
+		it.pid, it.fullHead = it.fullHead, invalidPage
+		return true, nil
+	}
+	it.onFull = true
+	whead, err := it.rm.headerFirstWithSpace()
+	if err != nil {
+		return false, err
+	}
+	fhead, err := it.rm.headerFirstFull()
+	if err != nil {
+		return false, err
+	}
+	it.pid, it.fullHead = whead, fhead
+	return true, nil
+}
+
+// release unpins the current page, if any.
+func (it *RecordIterator) release() error {
+	if it.bf == nil {
+		return nil
+	}
+	it.bf = nil
+	return it.rm.bm.FreePage(it.pid, false)
+}
+
+// Close releases the pinned page and the read lock.
+func (it *RecordIterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	err := it.release()
+	it.rm.mu.RUnlock()
+	return err
+}
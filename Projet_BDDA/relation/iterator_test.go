@@ -0,0 +1,365 @@
+package relation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"malzahar-project/Projet_BDDA/buffer"
+	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/disk"
+)
+
+func TestIteratorMatchesScanOrder(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	n := fillPages(t, rm)
+
+	var want []RecordId
+	if err := rm.ScanRecords(func(_ Record, rid RecordId) error {
+		want = append(want, rid)
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(want) != n {
+		t.Fatalf("scan returned %d records, want %d", len(want), n)
+	}
+
+	it := rm.Iterator()
+	defer it.Close()
+	var got []RecordId
+	for {
+		rec, rid, ok, err := it.Next()
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if !ok {
+			break
+		}
+		if _, err := rec.Int(0); err != nil {
+			t.Fatalf("record not readable: %v", err)
+		}
+		got = append(got, rid)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("iterator returned %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("record %d: got %v want %v", i, got[i], want[i])
+		}
+	}
+	// exhausted iterators stay exhausted and have released everything
+	if _, _, ok, err := it.Next(); ok || err != nil {
+		t.Fatalf("Next after end = %v, %v", ok, err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanRecordsReverse(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	n := fillPages(t, rm)
+
+	var forward, reverse []RecordId
+	if err := rm.ScanRecords(func(_ Record, rid RecordId) error {
+		forward = append(forward, rid)
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if err := rm.ScanRecordsReverse(func(rec Record, rid RecordId) error {
+		if _, err := rec.Int(0); err != nil {
+			t.Fatalf("record not readable: %v", err)
+		}
+		reverse = append(reverse, rid)
+		return nil
+	}); err != nil {
+		t.Fatalf("reverse scan: %v", err)
+	}
+	if len(forward) != n || len(reverse) != n {
+		t.Fatalf("scans returned %d and %d records, want %d", len(forward), len(reverse), n)
+	}
+	for i := range forward {
+		if reverse[len(reverse)-1-i] != forward[i] {
+			t.Fatalf("record %d: forward %v, reverse has %v", i, forward[i], reverse[len(reverse)-1-i])
+		}
+	}
+
+	// ErrStopScan ends a reverse scan early too, with nothing left pinned
+	seen := 0
+	if err := rm.ScanRecordsIn(context.Background(), Reverse, func(Record, RecordId) error {
+		seen++
+		if seen == 2 {
+			return ErrStopScan
+		}
+		return nil
+	}); err != nil || seen != 2 {
+		t.Fatalf("stopped reverse scan: %v after %d records", err, seen)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIteratorCloseReleasesPins(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	fillPages(t, rm)
+
+	it := rm.Iterator()
+	for i := 0; i < 3; i++ {
+		if _, _, ok, err := it.Next(); !ok || err != nil {
+			t.Fatalf("next %d: %v, %v", i, ok, err)
+		}
+	}
+	if err := pool(rm).AssertAllUnpinned(); err == nil {
+		t.Fatalf("an open iterator should keep its current page pinned")
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+	// the read lock is released too: a writer can proceed
+	if _, err := rm.InsertRecord(NewRecord("1", "y")); err != nil {
+		t.Fatalf("insert after close: %v", err)
+	}
+}
+
+func TestIteratorEmptyRelation(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	it := rm.Iterator()
+	if _, _, ok, err := it.Next(); ok || err != nil {
+		t.Fatalf("Next on empty relation = %v, %v", ok, err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestScanRecordsStopScan(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	fillPages(t, rm)
+
+	calls := 0
+	if err := rm.ScanRecords(func(Record, RecordId) error {
+		calls++
+		return ErrStopScan
+	}); err != nil {
+		t.Fatalf("ErrStopScan should end the scan without error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("callback called %d times, want 1", calls)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetRecordsPageCoversAllRecordsOnce(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	n := fillPages(t, rm)
+
+	seen := make(map[string]int)
+	for _, limit := range []int{1, 7, n, n + 5} {
+		for k := range seen {
+			delete(seen, k)
+		}
+		windows := 0
+		for offset := 0; ; offset += limit {
+			recs, err := rm.GetRecordsPage(offset, limit)
+			if err != nil {
+				t.Fatalf("GetRecordsPage(%d, %d): %v", offset, limit, err)
+			}
+			if len(recs) > limit {
+				t.Fatalf("GetRecordsPage(%d, %d) returned %d records", offset, limit, len(recs))
+			}
+			if len(recs) == 0 {
+				break
+			}
+			windows++
+			for _, rec := range recs {
+				seen[rec.Values[0]]++
+			}
+		}
+		if len(seen) != n {
+			t.Fatalf("limit %d: %d distinct records, want %d", limit, len(seen), n)
+		}
+		for v, c := range seen {
+			if c != 1 {
+				t.Fatalf("limit %d: record %s read %d times", limit, v, c)
+			}
+		}
+		if want := (n + limit - 1) / limit; windows != want {
+			t.Fatalf("limit %d: %d windows, want %d", limit, windows, want)
+		}
+		if err := pool(rm).AssertAllUnpinned(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := rm.GetAllRecords()
+	if err != nil || len(all) != n {
+		t.Fatalf("GetAllRecords = %d records, %v; want %d", len(all), err, n)
+	}
+	if _, err := rm.GetRecordsPage(-1, 1); err == nil {
+		t.Fatalf("a negative offset should fail")
+	}
+}
+
+// newScanTable returns a relation of rows records spread over many pages, read
+// ahead depth pages by the buffer pool.
+func newScanTable(tb testing.TB, depth, rows int) (*RelationManager, func()) {
+	tb.Helper()
+	cfg := config.NewDBConfigWithParams(tb.TempDir(), 512, 4)
+	cfg.SyncMode = config.SyncNever
+	cfg.PrefetchDepth = depth
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		tb.Fatalf("dm init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	rm, err := NewRelationManager(NewRelation("scan", []ColumnInfo{{Name: "a", Kind: KindInt}, {Name: "b", Kind: KindChar, Size: 8}}), dm, bm)
+	if err != nil {
+		tb.Fatalf("new rm: %v", err)
+	}
+	for i := 0; i < rows; i++ {
+		if _, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x")); err != nil {
+			tb.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	return rm, func() {
+		bm.WaitPrefetch()
+		_ = bm.FlushBuffers()
+		_ = dm.Finish()
+	}
+}
+
+func TestPrefetchRaisesScanHitRate(t *testing.T) {
+	const rows = 400 // about 10 data pages
+	scan := func(depth int) buffer.BufferStats {
+		rm, cleanup := newScanTable(t, depth, rows)
+		defer cleanup()
+		// start cold
+		if err := rm.bm.FlushBuffers(); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		pool(rm).ResetStats()
+		it := rm.Iterator()
+		defer it.Close()
+		n := 0
+		for {
+			_, _, ok, err := it.Next()
+			if err != nil {
+				t.Fatalf("next: %v", err)
+			}
+			if !ok {
+				break
+			}
+			n++
+			// let the read-ahead finish so the result does not depend on scheduling
+			pool(rm).WaitPrefetch()
+		}
+		if n != rows {
+			t.Fatalf("depth %d: scanned %d records, want %d", depth, n, rows)
+		}
+		if err := pool(rm).AssertAllUnpinned(); err != nil {
+			t.Fatalf("depth %d: %v", depth, err)
+		}
+		return pool(rm).Stats()
+	}
+	off, on := scan(0), scan(2)
+	if off.Prefetched != 0 {
+		t.Fatalf("prefetch_depth 0 prefetched %d pages", off.Prefetched)
+	}
+	if on.Requests != off.Requests {
+		t.Fatalf("prefetching changed the GetPage calls: %d, want %d", on.Requests, off.Requests)
+	}
+	// every data page but the first is found in the pool
+	if on.Prefetched == 0 || on.Hits <= off.Hits || on.Misses >= off.Misses {
+		t.Fatalf("no gain from prefetching: without %+v, with %+v", off, on)
+	}
+	if on.Misses+on.Prefetched != off.Misses {
+		t.Fatalf("with prefetch %d misses + %d prefetched pages, want the %d pages read without", on.Misses, on.Prefetched, off.Misses)
+	}
+}
+
+// BenchmarkScanPrefetch scans a cold relation of a few hundred pages with and without
+// read-ahead.
+func BenchmarkScanPrefetch(b *testing.B) {
+	for _, depth := range []int{0, 4} {
+		b.Run(fmt.Sprintf("depth=%d", depth), func(b *testing.B) {
+			rm, cleanup := newScanTable(b, depth, 10000)
+			defer cleanup()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				pool(rm).WaitPrefetch()
+				if err := rm.bm.FlushBuffers(); err != nil {
+					b.Fatalf("flush: %v", err)
+				}
+				b.StartTimer()
+				if err := rm.ScanRecords(func(Record, RecordId) error { return nil }); err != nil {
+					b.Fatalf("scan: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestScanRecordsContextStopsOnCancel(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	total := fillPages(t, rm)
+	perPage := rm.dm.PageSize() / rm.Rel.RecordSize
+
+	// cancelled from the callback, the scan ends at the next page boundary
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	seen := 0
+	err := rm.ScanRecordsContext(ctx, func(Record, RecordId) error {
+		seen++
+		if seen == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("ScanRecordsContext = %v, want context.Canceled", err)
+	}
+	if seen >= total || seen > perPage {
+		t.Fatalf("scan went on for %d of %d records after the cancel", seen, total)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("after cancel: %v", err)
+	}
+	// the read lock is released too
+	if _, err := rm.InsertRecord(NewRecord("1", "y")); err != nil {
+		t.Fatalf("insert after cancel: %v", err)
+	}
+
+	// a context already done reads nothing
+	seen = 0
+	if err := rm.ScanRecordsContext(ctx, func(Record, RecordId) error { seen++; return nil }); !errors.Is(err, context.Canceled) || seen != 0 {
+		t.Fatalf("scan with a done context = %v after %d records", err, seen)
+	}
+	deadline, stop := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer stop()
+	if err := rm.ScanRecordsContext(deadline, func(Record, RecordId) error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("scan past its deadline = %v", err)
+	}
+	if n := countRecords(t, rm); n != total+1 {
+		t.Fatalf("full scan returned %d records, want %d", n, total+1)
+	}
+}
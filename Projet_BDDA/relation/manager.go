@@ -1,11 +1,15 @@
 package relation
 
 import (
-	"encoding/binary"
+	"context"
 	"errors"
+	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 
 	"malzahar-project/Projet_BDDA/buffer"
 	"malzahar-project/Projet_BDDA/config"
@@ -18,22 +22,77 @@ type RecordId struct {
 	SlotIdx int
 }
 
+// String renders the RecordId as FileIdx:PageIdx:SlotIdx (the ROWID format).
+func (rid RecordId) String() string {
+	return fmt.Sprintf("%d:%d:%d", rid.PageId.FileIdx, rid.PageId.PageIdx, rid.SlotIdx)
+}
+
+// ParseRecordId parses a FileIdx:PageIdx:SlotIdx string as produced by RecordId.String.
+func ParseRecordId(s string) (RecordId, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return RecordId{}, fmt.Errorf("invalid rowid %q: expected FileIdx:PageIdx:SlotIdx", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || n < 0 {
+			return RecordId{}, fmt.Errorf("invalid rowid %q", s)
+		}
+		nums[i] = n
+	}
+	return RecordId{PageId: config.PageId{FileIdx: nums[0], PageIdx: nums[1]}, SlotIdx: nums[2]}, nil
+}
+
+// BufferPool is the part of buffer.BufferManager a RelationManager works through:
+// pinning, unpinning and flushing pages. *buffer.BufferManager implements it; a pool
+// that also has Prefetch (see prefetcher) reads ahead of sequential scans.
+type BufferPool interface {
+	GetPage(pid config.PageId) (*buffer.BufferFrame, error)
+	FreePage(pid config.PageId, dirty bool) error
+	FlushBuffers() error
+}
+
+// prefetcher is implemented by buffer pools that can load the rest of a page chain in
+// the background, like buffer.BufferManager.Prefetch.
+type prefetcher interface {
+	Prefetch(pid config.PageId, next func(page []byte) config.PageId)
+}
+
+var _ BufferPool = (*buffer.BufferManager)(nil)
+
 // RelationManager manages a relation's heap file: header page, data pages, and provides
 // higher-level insertion/enumeration APIs.
+//
+// Concurrency: the exported methods are safe for use from multiple goroutines.
+// Read-only operations (ScanRecords, Iterator, GetAllRecords, GetRecordsPage,
+// AllPageIds) share a read lock and may run concurrently; mutations (InsertRecord,
+// DeleteRecord, EnsureHeader) take the write lock and are exclusive. A ScanRecords callback, or the holder of an
+// open RecordIterator, must not call back into a mutating method of the same
+// RelationManager (it would deadlock); collect RecordIds and mutate after the scan instead.
 type RelationManager struct {
 	Rel          *Relation
 	HeaderPageId config.PageId
-	SlotsPerPage int
-	dm           *disk.DiskManager
-	bm           *buffer.BufferManager
+	slotsPerPage int
+	// reserve is the number of bytes left untouched at the start of every page of
+	// the relation (config page_reserve_bytes); see page
+	reserve int
+	// order is the byte order of page headers and records (config byte_order)
+	order config.ByteOrder
+	dm    disk.PageStore
+	bm    BufferPool
+	mu    sync.RWMutex
+	// failAt, when set by tests, can abort a list update between two steps
+	failAt func(step string) error
 }
 
 // sentinel for invalid PageId
 var invalidPage = config.PageId{FileIdx: -1, PageIdx: -1}
 
 // NewRelationManager creates a RelationManager and allocates a header page persisted on disk.
-func NewRelationManager(rel *Relation, dm *disk.DiskManager, bm *buffer.BufferManager) (*RelationManager, error) {
-	rm := &RelationManager{Rel: rel, dm: dm, bm: bm, HeaderPageId: invalidPage}
+func NewRelationManager(rel *Relation, dm disk.PageStore, bm BufferPool) (*RelationManager, error) {
+	rm := &RelationManager{Rel: rel, dm: dm, bm: bm, HeaderPageId: invalidPage, reserve: dm.PageReserve(), order: dm.ByteOrder()}
+	rel.ByteOrder = rm.order
 	// try load header location from metadata file
 	if err := rm.loadHeaderLocation(); err != nil {
 		// if file does not exist, it's fine; other errors bubble up
@@ -43,11 +102,31 @@ func NewRelationManager(rel *Relation, dm *disk.DiskManager, bm *buffer.BufferMa
 	}
 	// if header exists, compute slots per page
 	if rm.HeaderPageId != invalidPage {
-		rm.SlotsPerPage = computeSlotsPerPage(rm.dm.PageSize(), rm.Rel.RecordSize)
+		rm.slotsPerPage = computeSlotsPerPage(rm.pageSpace(), rm.Rel.RecordSize)
 	}
 	return rm, nil
 }
 
+// page returns the bytes of a frame that the relation's page layout starts at:
+// everything after the page reserve. Page offsets in this package are relative to
+// it.
+// The bytes are read under the frame's read latch and changed under its write latch
+// (buffer.BufferFrame RLock and Lock), never held across a call into the pool.
+func (rm *RelationManager) page(bf *buffer.BufferFrame) []byte {
+	return bf.Data[rm.reserve:]
+}
+
+// pageSpace is the number of bytes of a page available to the relation's layout.
+func (rm *RelationManager) pageSpace() int {
+	return rm.dm.PageSize() - rm.reserve
+}
+
+// HasHeader reports whether the relation has a header page. Any PageId, including
+// {0,0}, is a valid header location.
+func (rm *RelationManager) HasHeader() bool {
+	return rm.HeaderPageId != invalidPage
+}
+
 // header metadata file: stores 8 bytes (int32 fileIdx, int32 pageIdx)
 func (rm *RelationManager) headerFilePath() string {
 	return filepath.Join(rm.dm.BinDir(), rm.Rel.Name+".hdr")
@@ -55,8 +134,8 @@ func (rm *RelationManager) headerFilePath() string {
 
 func (rm *RelationManager) saveHeaderLocation(pid config.PageId) error {
 	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint32(buf[0:4], uint32(pid.FileIdx))
-	binary.LittleEndian.PutUint32(buf[4:8], uint32(pid.PageIdx))
+	rm.order.PutUint32(buf[0:4], uint32(pid.FileIdx))
+	rm.order.PutUint32(buf[4:8], uint32(pid.PageIdx))
 	return os.WriteFile(rm.headerFilePath(), buf, 0o644)
 }
 
@@ -68,8 +147,8 @@ func (rm *RelationManager) loadHeaderLocation() error {
 	if len(data) < 8 {
 		return errors.New("invalid header metadata")
 	}
-	fi := int32(binary.LittleEndian.Uint32(data[0:4]))
-	pi := int32(binary.LittleEndian.Uint32(data[4:8]))
+	fi := int32(rm.order.Uint32(data[0:4]))
+	pi := int32(rm.order.Uint32(data[4:8]))
 	rm.HeaderPageId = config.PageId{FileIdx: int(fi), PageIdx: int(pi)}
 	return nil
 }
@@ -80,15 +159,13 @@ func (rm *RelationManager) pageNext(pid config.PageId) (config.PageId, error) {
 	if err != nil {
 		return config.PageId{}, err
 	}
-	fx := int32(binary.LittleEndian.Uint32(bf.Data[8:12]))
-	fy := int32(binary.LittleEndian.Uint32(bf.Data[12:16]))
+	bf.RLock()
+	next := rm.header(bf).Next()
+	bf.RUnlock()
 	if err := rm.bm.FreePage(pid, false); err != nil {
 		return config.PageId{}, err
 	}
-	if fx == -1 && fy == -1 {
-		return invalidPage, nil
-	}
-	return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}, nil
+	return next, nil
 }
 
 func (rm *RelationManager) pageSetNext(pid config.PageId, next config.PageId) error {
@@ -96,13 +173,9 @@ func (rm *RelationManager) pageSetNext(pid config.PageId, next config.PageId) er
 	if err != nil {
 		return err
 	}
-	if next == invalidPage {
-		writeInt32(bf.Data, 8, int32(-1))
-		writeInt32(bf.Data, 12, int32(-1))
-	} else {
-		binary.LittleEndian.PutUint32(bf.Data[8:12], uint32(next.FileIdx))
-		binary.LittleEndian.PutUint32(bf.Data[12:16], uint32(next.PageIdx))
-	}
+	bf.Lock()
+	rm.header(bf).SetNext(next)
+	bf.Unlock()
 	bf.Dirty = true
 	return rm.bm.FreePage(pid, true)
 }
@@ -112,7 +185,9 @@ func (rm *RelationManager) pageNumSlots(pid config.PageId) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	n := int(binary.LittleEndian.Uint32(bf.Data[16:20]))
+	bf.RLock()
+	n := rm.header(bf).NumSlots()
+	bf.RUnlock()
 	if err := rm.bm.FreePage(pid, false); err != nil {
 		return 0, err
 	}
@@ -128,15 +203,13 @@ func (rm *RelationManager) headerFirstWithSpace() (config.PageId, error) {
 	if err != nil {
 		return config.PageId{}, err
 	}
-	fx := int32(binary.LittleEndian.Uint32(hbf.Data[8:12]))
-	fy := int32(binary.LittleEndian.Uint32(hbf.Data[12:16]))
+	hbf.RLock()
+	first := rm.header(hbf).FirstWithSpace()
+	hbf.RUnlock()
 	if err := rm.bm.FreePage(rm.HeaderPageId, false); err != nil {
 		return config.PageId{}, err
 	}
-	if fx == -1 && fy == -1 {
-		return invalidPage, nil
-	}
-	return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}, nil
+	return first, nil
 }
 
 func (rm *RelationManager) headerSetFirstWithSpace(pid config.PageId) error {
@@ -147,44 +220,90 @@ func (rm *RelationManager) headerSetFirstWithSpace(pid config.PageId) error {
 	if err != nil {
 		return err
 	}
-	if pid == invalidPage {
-		writeInt32(hbf.Data, 8, int32(-1))
-		writeInt32(hbf.Data, 12, int32(-1))
-	} else {
-		binary.LittleEndian.PutUint32(hbf.Data[8:12], uint32(pid.FileIdx))
-		binary.LittleEndian.PutUint32(hbf.Data[12:16], uint32(pid.PageIdx))
+	hbf.Lock()
+	rm.header(hbf).SetFirstWithSpace(pid)
+	hbf.Unlock()
+	hbf.Dirty = true
+	return rm.bm.FreePage(rm.HeaderPageId, true)
+}
+
+func (rm *RelationManager) headerFirstFull() (config.PageId, error) {
+	if rm.HeaderPageId == invalidPage {
+		return invalidPage, nil
 	}
+	hbf, err := rm.bm.GetPage(rm.HeaderPageId)
+	if err != nil {
+		return config.PageId{}, err
+	}
+	hbf.RLock()
+	first := rm.header(hbf).FirstFull()
+	hbf.RUnlock()
+	if err := rm.bm.FreePage(rm.HeaderPageId, false); err != nil {
+		return config.PageId{}, err
+	}
+	return first, nil
+}
+
+func (rm *RelationManager) headerSetFirstFull(pid config.PageId) error {
+	if rm.HeaderPageId == invalidPage {
+		return errors.New("header not initialized")
+	}
+	hbf, err := rm.bm.GetPage(rm.HeaderPageId)
+	if err != nil {
+		return err
+	}
+	hbf.Lock()
+	rm.header(hbf).SetFirstFull(pid)
+	hbf.Unlock()
 	hbf.Dirty = true
 	return rm.bm.FreePage(rm.HeaderPageId, true)
 }
 
-// helper to check free slot in page and return first free slot idx or -1
-func (rm *RelationManager) firstFreeSlotInPage(pid config.PageId) (int, error) {
+// writeInFreeSlot writes rec into the first free slot of pid under a single pin and
+// reports the slot used (-1 if the page is full) and whether the page is now full.
+// The page is unpinned exactly once on every path.
+func (rm *RelationManager) writeInFreeSlot(pid config.PageId, rec *Record, refs []overflowRef) (int, bool, error) {
 	bf, err := rm.bm.GetPage(pid)
 	if err != nil {
-		return -1, err
+		return -1, false, err
 	}
-	slots := int(binary.LittleEndian.Uint32(bf.Data[16:20]))
-	base := 20
+	bf.Lock()
+	slots := rm.header(bf).NumSlots()
+	slot := -1
 	for i := 0; i < slots; i++ {
-		if bf.Data[base+i] == 0 {
-			if err := rm.bm.FreePage(pid, false); err != nil {
-				return -1, err
-			}
-			return i, nil
+		if rm.page(bf)[20+i] == 0 {
+			slot = i
+			break
 		}
 	}
-	if err := rm.bm.FreePage(pid, false); err != nil {
-		return -1, err
+	if slot < 0 {
+		bf.Unlock()
+		return -1, false, rm.bm.FreePage(pid, false)
+	}
+	pos := 20 + slots + slot*rm.Rel.RecordSize
+	if err := rm.Rel.WriteRecordToBuffer(rec, rm.page(bf), pos); err != nil {
+		bf.Unlock()
+		_ = rm.bm.FreePage(pid, false)
+		return -1, false, err
 	}
-	return -1, nil
+	rm.setOverflowRefs(rm.page(bf), pos, refs)
+	// mark bytemap and check if page now full
+	rm.page(bf)[20+slot] = 1
+	full := usedSlots(rm.page(bf), slots) >= rm.fullAt(slots)
+	bf.Unlock()
+	if err := rm.bm.FreePage(pid, true); err != nil {
+		return -1, false, err
+	}
+	return slot, full, nil
 }
 
 // InsertRecord inserts rec into a page and returns its RecordId
-func (rm *RelationManager) InsertRecord(rec *Record) (RecordId, error) {
+func (rm *RelationManager) InsertRecord(rec *Record) (rid RecordId, err error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 	// ensure slots per page computed
-	if rm.SlotsPerPage == 0 {
-		rm.SlotsPerPage = computeSlotsPerPage(rm.dm.PageSize(), rm.Rel.RecordSize)
+	if rm.slotsPerPage == 0 {
+		rm.slotsPerPage = computeSlotsPerPage(rm.pageSpace(), rm.Rel.RecordSize)
 	}
 	// ensure header exists
 	if rm.HeaderPageId == invalidPage {
@@ -205,6 +324,18 @@ func (rm *RelationManager) InsertRecord(rec *Record) (RecordId, error) {
 		}
 		cur = npid
 	}
+	// an out-of-line value is written before the record that refers to it
+	refs, err := rm.writeOverflow(rec)
+	if err != nil {
+		return RecordId{}, err
+	}
+	// until a slot refers to them the chains are ours to free
+	stored := false
+	defer func() {
+		if err != nil && !stored {
+			_ = rm.freeOverflow(refs)
+		}
+	}()
 	// traverse pages starting at cur until find free slot
 	visited := make(map[config.PageId]bool)
 	for pid := cur; pid != invalidPage; {
@@ -219,42 +350,20 @@ func (rm *RelationManager) InsertRecord(rec *Record) (RecordId, error) {
 			continue
 		}
 		visited[pid] = true
-		slot, err := rm.firstFreeSlotInPage(pid)
+		slot, full, err := rm.writeInFreeSlot(pid, rec, refs)
 		if err != nil {
 			return RecordId{}, err
 		}
 		if slot >= 0 {
-			// write record into page
-			bf, err := rm.bm.GetPage(pid)
-			if err != nil {
-				return RecordId{}, err
-			}
-			slots := int(binary.LittleEndian.Uint32(bf.Data[16:20]))
-			dataStart := 20 + slots
-			pos := dataStart + slot*rm.Rel.RecordSize
-			if err := rm.Rel.WriteRecordToBuffer(rec, bf.Data, pos); err != nil {
-				_ = rm.bm.FreePage(pid, false)
-				return RecordId{}, err
-			}
-			// mark bytemap
-			bf.Data[20+slot] = 1
-			// check if page now full
-			full := true
-			for i := 0; i < slots; i++ {
-				if bf.Data[20+i] == 0 {
-					full = false
-					break
-				}
-			}
-			bf.Dirty = true
-			if err := rm.bm.FreePage(pid, true); err != nil {
-				return RecordId{}, err
-			}
+			stored = true
 			if full {
 				// if page became full, unlink from with-space list
 				if err := rm.unlinkFromWithSpace(pid); err != nil {
 					return RecordId{}, err
 				}
+				if err := rm.failpoint("link-full"); err != nil {
+					return RecordId{}, err
+				}
 				// add to full list (prepend)
 				if err := rm.prependToFullList(pid); err != nil {
 					return RecordId{}, err
@@ -280,6 +389,26 @@ func (rm *RelationManager) InsertRecord(rec *Record) (RecordId, error) {
 	return RecordId{}, errors.New("could not insert record")
 }
 
+// List update ordering. Each change to the with-space/full lists is a sequence of
+// single-page writes ordered so that stopping after any of them never cuts other pages
+// off a list:
+//   - a page is completely linked (its next pointer set) before the header publishes it
+//     (addDataPage, prependToWithSpace, prependToFullList);
+//   - unlinking is a single write to the header or to the predecessor's next pointer;
+//   - a page moving between lists is unlinked first and prepended second, so in
+//     between it is on no list and only the page directory still records it, from
+//     which Repair relinks it.
+// The buffer pool writes dirty pages back at flush time, so on disk these steps are
+// only as ordered as the flushes between them; there is no write-ahead log.
+
+// failpoint lets tests simulate a crash between the steps of a list update.
+func (rm *RelationManager) failpoint(step string) error {
+	if rm.failAt == nil {
+		return nil
+	}
+	return rm.failAt(step)
+}
+
 // helper: unlink a page from the with-space list; header->firstWithSpace may change
 func (rm *RelationManager) unlinkFromWithSpace(target config.PageId) error {
 	head, err := rm.headerFirstWithSpace()
@@ -323,20 +452,10 @@ func (rm *RelationManager) prependToFullList(pid config.PageId) error {
 	if rm.HeaderPageId == invalidPage {
 		return errors.New("header not initialized")
 	}
-	hbf, err := rm.bm.GetPage(rm.HeaderPageId)
+	old, err := rm.headerFirstFull()
 	if err != nil {
 		return err
 	}
-	// current firstFull at 0..3 and 4..7
-	fx := int32(binary.LittleEndian.Uint32(hbf.Data[0:4]))
-	fy := int32(binary.LittleEndian.Uint32(hbf.Data[4:8]))
-	_ = rm.bm.FreePage(rm.HeaderPageId, false)
-	var old config.PageId
-	if fx == -1 && fy == -1 {
-		old = invalidPage
-	} else {
-		old = config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
-	}
 	// if already the head, nothing to do (avoid creating self-loop)
 	if old == pid {
 		return nil
@@ -346,133 +465,110 @@ func (rm *RelationManager) prependToFullList(pid config.PageId) error {
 		return err
 	}
 	// set header.firstFull = pid
-	hbf2, err := rm.bm.GetPage(rm.HeaderPageId)
-	if err != nil {
-		return err
-	}
-	binary.LittleEndian.PutUint32(hbf2.Data[0:4], uint32(pid.FileIdx))
-	binary.LittleEndian.PutUint32(hbf2.Data[4:8], uint32(pid.PageIdx))
-	hbf2.Dirty = true
-	return rm.bm.FreePage(rm.HeaderPageId, true)
+	return rm.headerSetFirstFull(pid)
 }
 
-// GetAllRecords returns all records present in the relation by scanning both lists
+// GetAllRecords returns all records present in the relation, in ScanRecords order.
+// It materializes the whole relation; prefer ScanRecords, Iterator or GetRecordsPage
+// for large tables.
 func (rm *RelationManager) GetAllRecords() ([]Record, error) {
-	var out []Record
-	// ensure header exists
-	if rm.HeaderPageId == invalidPage {
-		return out, nil
-	}
-	// scan with-space list
-	whead, err := rm.headerFirstWithSpace()
-	if err != nil {
-		return nil, err
+	return rm.GetRecordsPage(0, -1)
+}
+
+// GetRecordsPage returns up to limit records starting at the offset-th one, in
+// ScanRecords order, reading only as far as the end of the window. A negative limit
+// returns every record from offset on. Successive windows cover every record exactly
+// once as long as the relation is not modified in between.
+func (rm *RelationManager) GetRecordsPage(offset, limit int) ([]Record, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("negative offset %d", offset)
 	}
-	for pid := whead; pid != invalidPage; {
-		recs, nxt, err := rm.recordsInDataPage(pid)
+	it := rm.Iterator()
+	defer it.Close()
+	var out []Record
+	for i := 0; limit < 0 || len(out) < limit; i++ {
+		rec, _, ok, err := it.Next()
 		if err != nil {
 			return nil, err
 		}
-		out = append(out, recs...)
-		pid = nxt
-	}
-	// scan full list
-	// read header firstFull
-	if rm.HeaderPageId != invalidPage {
-		hbf, err := rm.bm.GetPage(rm.HeaderPageId)
-		if err != nil {
-			return nil, err
+		if !ok {
+			break
 		}
-		fx := int32(binary.LittleEndian.Uint32(hbf.Data[0:4]))
-		fy := int32(binary.LittleEndian.Uint32(hbf.Data[4:8]))
-		_ = rm.bm.FreePage(rm.HeaderPageId, false)
-		for pid := func() config.PageId {
-			if fx == -1 && fy == -1 {
-				return invalidPage
-			}
-			return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
-		}(); pid != invalidPage; {
-			recs, nxt, err := rm.recordsInDataPage(pid)
-			if err != nil {
-				return nil, err
-			}
-			out = append(out, recs...)
-			pid = nxt
+		if i >= offset {
+			out = append(out, rec)
 		}
 	}
 	return out, nil
 }
 
-// recordsInDataPage returns records in the given page and the next page id
-func (rm *RelationManager) recordsInDataPage(pid config.PageId) ([]Record, config.PageId, error) {
-	bf, err := rm.bm.GetPage(pid)
-	if err != nil {
-		return nil, invalidPage, err
-	}
-	slots := int(binary.LittleEndian.Uint32(bf.Data[16:20]))
-	dataStart := 20 + slots
-	var out []Record
-	for i := 0; i < slots; i++ {
-		if bf.Data[20+i] == 1 {
-			rec := &Record{}
-			if err := rm.Rel.ReadFromBuffer(rec, bf.Data, dataStart+i*rm.Rel.RecordSize); err != nil {
-				_ = rm.bm.FreePage(pid, false)
-				return nil, invalidPage, err
-			}
-			out = append(out, *rec)
-		}
-	}
-	nx := int32(binary.LittleEndian.Uint32(bf.Data[8:12]))
-	ny := int32(binary.LittleEndian.Uint32(bf.Data[12:16]))
-	if err := rm.bm.FreePage(pid, false); err != nil {
-		return nil, invalidPage, err
-	}
-	if nx == -1 && ny == -1 {
-		return out, invalidPage, nil
-	}
-	return out, config.PageId{FileIdx: int(nx), PageIdx: int(ny)}, nil
+// DeleteRecord frees a slot; updates header lists if needed. With Rel.SoftDelete the
+// slot is only tombstoned (see Undelete and Purge).
+func (rm *RelationManager) DeleteRecord(rid RecordId) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.deleteRecord(rid, rm.Rel.SoftDelete)
 }
 
-// DeleteRecord frees a slot; updates header lists if needed
-func (rm *RelationManager) DeleteRecord(rid RecordId) error {
+// PurgeRecord frees the slot of rid immediately, whether it holds a record or a
+// tombstone, regardless of Rel.SoftDelete.
+func (rm *RelationManager) PurgeRecord(rid RecordId) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.deleteRecord(rid, false)
+}
+
+func (rm *RelationManager) deleteRecord(rid RecordId, soft bool) error {
 	pid := rid.PageId
 	bf, err := rm.bm.GetPage(pid)
 	if err != nil {
 		return err
 	}
-	slots := int(binary.LittleEndian.Uint32(bf.Data[16:20]))
+	bf.Lock()
+	slots := rm.header(bf).NumSlots()
 	if rid.SlotIdx < 0 || rid.SlotIdx >= slots {
+		bf.Unlock()
 		_ = rm.bm.FreePage(pid, false)
-		return errors.New("invalid slot index")
+		return fmt.Errorf("%w: invalid slot index %d", ErrRecordNotFound, rid.SlotIdx)
 	}
-	if bf.Data[20+rid.SlotIdx] == 0 {
+	state := rm.page(bf)[20+rid.SlotIdx]
+	if state == slotFree || (soft && state == slotTombstone) {
+		bf.Unlock()
 		_ = rm.bm.FreePage(pid, false)
-		return errors.New("slot already free")
-	}
-	bf.Data[20+rid.SlotIdx] = 0
-	// optionally zero record bytes
+		return fmt.Errorf("%w: slot %v already free", ErrRecordNotFound, rid)
+	}
+	if soft {
+		// keep the record and its slot until Purge; page lists are unaffected
+		rm.page(bf)[20+rid.SlotIdx] = slotTombstone
+		bf.Unlock()
+		return rm.bm.FreePage(pid, true)
+	}
+	// a page at its fill limit sits on the full list and drops below it now; otherwise
+	// it is already on the with-space list and must not be prepended again (that would
+	// close a cycle), or it is over the limit (fill factor lowered) and stays full
+	leavesFull := usedSlots(rm.page(bf), slots) == rm.fullAt(slots)
+	rm.page(bf)[20+rid.SlotIdx] = 0
 	dataStart := 20 + slots
+	refs := rm.overflowRefs(rm.page(bf), dataStart+rid.SlotIdx*rm.Rel.RecordSize)
+	// optionally zero record bytes
 	for i := 0; i < rm.Rel.RecordSize; i++ {
-		bf.Data[dataStart+rid.SlotIdx*rm.Rel.RecordSize+i] = 0
+		rm.page(bf)[dataStart+rid.SlotIdx*rm.Rel.RecordSize+i] = 0
 	}
+	bf.Unlock()
 	bf.Dirty = true
 	if err := rm.bm.FreePage(pid, true); err != nil {
 		return err
 	}
-	// if page was in full list, move it to with-space list
-	// naive approach: ensure it's present in with-space list
-	// check if any free slots remain
-	slot, err := rm.firstFreeSlotInPage(pid)
-	if err != nil {
+	if err := rm.freeOverflow(refs); err != nil {
 		return err
 	}
-	if slot >= 0 {
-		// ensure page is in with-space list
-		// attempt to unlink from full list if present
+	if leavesFull {
+		// move the page from the full list to the with-space list
 		if err := rm.unlinkFromFull(pid); err != nil {
 			return err
 		}
-		// prepend to with-space
+		if err := rm.failpoint("link-with-space"); err != nil {
+			return err
+		}
 		if err := rm.prependToWithSpace(pid); err != nil {
 			return err
 		}
@@ -484,19 +580,10 @@ func (rm *RelationManager) unlinkFromFull(target config.PageId) error {
 	if rm.HeaderPageId == invalidPage {
 		return nil
 	}
-	hbf, err := rm.bm.GetPage(rm.HeaderPageId)
+	head, err := rm.headerFirstFull()
 	if err != nil {
 		return err
 	}
-	fx := int32(binary.LittleEndian.Uint32(hbf.Data[0:4]))
-	fy := int32(binary.LittleEndian.Uint32(hbf.Data[4:8]))
-	_ = rm.bm.FreePage(rm.HeaderPageId, false)
-	head := func() config.PageId {
-		if fx == -1 && fy == -1 {
-			return invalidPage
-		}
-		return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
-	}()
 	if head == invalidPage {
 		return nil
 	}
@@ -506,19 +593,7 @@ func (rm *RelationManager) unlinkFromFull(target config.PageId) error {
 			return err
 		}
 		// set header.firstFull = nx
-		hbf2, err := rm.bm.GetPage(rm.HeaderPageId)
-		if err != nil {
-			return err
-		}
-		if nx == invalidPage {
-			writeInt32(hbf2.Data, 0, int32(-1))
-			writeInt32(hbf2.Data, 4, int32(-1))
-		} else {
-			binary.LittleEndian.PutUint32(hbf2.Data[0:4], uint32(nx.FileIdx))
-			binary.LittleEndian.PutUint32(hbf2.Data[4:8], uint32(nx.PageIdx))
-		}
-		hbf2.Dirty = true
-		return rm.bm.FreePage(rm.HeaderPageId, true)
+		return rm.headerSetFirstFull(nx)
 	}
 	// traverse
 	prev := head
@@ -544,19 +619,10 @@ func (rm *RelationManager) prependToWithSpace(pid config.PageId) error {
 	if rm.HeaderPageId == invalidPage {
 		return errors.New("header not initialized")
 	}
-	hbf, err := rm.bm.GetPage(rm.HeaderPageId)
+	old, err := rm.headerFirstWithSpace()
 	if err != nil {
 		return err
 	}
-	fx := int32(binary.LittleEndian.Uint32(hbf.Data[8:12]))
-	fy := int32(binary.LittleEndian.Uint32(hbf.Data[12:16]))
-	_ = rm.bm.FreePage(rm.HeaderPageId, false)
-	var old config.PageId
-	if fx == -1 && fy == -1 {
-		old = invalidPage
-	} else {
-		old = config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
-	}
 	// if already the head, nothing to do (avoid creating self-loop)
 	if old == pid {
 		return nil
@@ -567,16 +633,17 @@ func (rm *RelationManager) prependToWithSpace(pid config.PageId) error {
 	return rm.headerSetFirstWithSpace(pid)
 }
 
-// helper: write ints little-endian into buffer
-func writeInt32(b []byte, off int, v int32) {
-	binary.LittleEndian.PutUint32(b[off:off+4], uint32(v))
+// writeInt32 and readInt32 encode an int32 at off in the relation's byte order.
+func (rm *RelationManager) writeInt32(b []byte, off int, v int32) {
+	rm.order.PutUint32(b[off:off+4], uint32(v))
 }
 
-func readInt32(b []byte, off int) int32 {
-	return int32(binary.LittleEndian.Uint32(b[off : off+4]))
+func (rm *RelationManager) readInt32(b []byte, off int) int32 {
+	return int32(rm.order.Uint32(b[off : off+4]))
 }
 
-// computeSlotsPerPage calculates how many slots fit in a page, given pageSize and recordSize.
+// computeSlotsPerPage calculates how many slots fit in a page, given the page bytes
+// available to the layout (the page size minus the reserve) and recordSize.
 // headerFixed = prev(8) + next(8) + numSlots(4) = 20 bytes
 func computeSlotsPerPage(pageSize int, recordSize int) int {
 	headerFixed := 20
@@ -584,20 +651,93 @@ func computeSlotsPerPage(pageSize int, recordSize int) int {
 	return int(math.Floor(float64(pageSize-headerFixed) / float64(1+recordSize)))
 }
 
+// fullAt returns how many used slots make a page of the given slot count full: all
+// of them, or Rel.FillFactor of them (at least one) when a fill factor below 1 is set.
+func (rm *RelationManager) fullAt(slots int) int {
+	ff := rm.Rel.FillFactor
+	if ff <= 0 || ff >= 1 {
+		return slots
+	}
+	n := int(ff * float64(slots))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// usedSlots counts the non-free slots (tombstones included) of a data page.
+func usedSlots(data []byte, slots int) int {
+	n := 0
+	for i := 0; i < slots; i++ {
+		if data[20+i] != slotFree {
+			n++
+		}
+	}
+	return n
+}
+
+// SlotsPerPage returns the number of record slots in each data page of the relation.
+func (rm *RelationManager) SlotsPerPage() int {
+	if rm.slotsPerPage != 0 {
+		return rm.slotsPerPage
+	}
+	return computeSlotsPerPage(rm.pageSpace(), rm.Rel.RecordSize)
+}
+
+// FillFactor returns the fraction of slots in use (tombstones included) across all data
+// pages of the relation, from 0 to 1; a relation without data pages reports 0.
+func (rm *RelationManager) FillFactor() (float64, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	pages, _, err := rm.listedPages()
+	if err != nil {
+		return 0, err
+	}
+	used, total := 0, 0
+	for _, pid := range pages {
+		bf, err := rm.bm.GetPage(pid)
+		if err != nil {
+			return 0, err
+		}
+		bf.RLock()
+		slots := rm.header(bf).NumSlots()
+		for i := 0; i < slots; i++ {
+			if rm.page(bf)[20+i] != slotFree {
+				used++
+			}
+		}
+		bf.RUnlock()
+		total += slots
+		if err := rm.bm.FreePage(pid, false); err != nil {
+			return 0, err
+		}
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(used) / float64(total), nil
+}
+
 // addDataPage allocates a new data page, initializes its header (prev/next = invalid) and
 // an empty bytemap. It inserts the new page into the 'with space' list via the header page.
 func (rm *RelationManager) addDataPage() (config.PageId, error) {
+	// check the record fits before allocating, so a failure does not leak a page
+	if err := rm.Rel.CheckFits(rm.dm.PageSize(), rm.reserve); err != nil {
+		return config.PageId{}, err
+	}
+	slots := computeSlotsPerPage(rm.pageSpace(), rm.Rel.RecordSize)
+
 	// allocate a new page via DiskManager
 	pid, err := rm.dm.AllocatePage()
 	if err != nil {
 		return config.PageId{}, err
 	}
 
-	// compute slotsPerPage now that we have PageSize from DiskManager
-	pageSize := rm.dm.PageSize()
-	slots := computeSlotsPerPage(pageSize, rm.Rel.RecordSize)
-	if slots <= 0 {
-		return config.PageId{}, errors.New("page too small for records")
+	// the new page is linked in front of the current with-space head before the
+	// header publishes it, so a crash in between never cuts the existing list
+	oldHead, err := rm.headerFirstWithSpace()
+	if err != nil {
+		return config.PageId{}, err
 	}
 
 	// load page into buffer
@@ -605,22 +745,29 @@ func (rm *RelationManager) addDataPage() (config.PageId, error) {
 	if err != nil {
 		return config.PageId{}, err
 	}
-	// initialize header: prev(FileIdx,PageIdx), next(FileIdx,PageIdx), numSlots
-	// prev = invalid, next = invalid
-	writeInt32(bf.Data, 0, int32(-1))
-	writeInt32(bf.Data, 4, int32(-1))
-	writeInt32(bf.Data, 8, int32(-1))
-	writeInt32(bf.Data, 12, int32(-1))
-	writeInt32(bf.Data, 16, int32(slots))
+	// initialize header: prev = invalid, next = old with-space head
+	bf.Lock()
+	h := rm.header(bf)
+	h.SetPrev(invalidPage)
+	h.SetNext(oldHead)
+	h.SetNumSlots(slots)
 	// zero bytemap
 	for i := 0; i < slots; i++ {
-		bf.Data[20+i] = 0
+		rm.page(bf)[20+i] = 0
 	}
+	bf.Unlock()
 	bf.Dirty = true
 	// free page (mark dirty)
 	if err := rm.bm.FreePage(pid, true); err != nil {
 		return config.PageId{}, err
 	}
+	// record ownership before linking so the page can always be found again
+	if err := rm.appendPageDirectory(pid); err != nil {
+		return config.PageId{}, err
+	}
+	if err := rm.failpoint("publish-page"); err != nil {
+		return config.PageId{}, err
+	}
 
 	// update header page: if none, create it
 	if rm.HeaderPageId == invalidPage {
@@ -633,12 +780,11 @@ func (rm *RelationManager) addDataPage() (config.PageId, error) {
 		if err != nil {
 			return config.PageId{}, err
 		}
-		// firstFull
-		writeInt32(hbf.Data, 0, int32(-1))
-		writeInt32(hbf.Data, 4, int32(-1))
-		// firstWithSpace -> pid
-		writeInt32(hbf.Data, 8, int32(pid.FileIdx))
-		writeInt32(hbf.Data, 12, int32(pid.PageIdx))
+		hbf.Lock()
+		hh := rm.header(hbf)
+		hh.SetFirstFull(invalidPage)
+		hh.SetFirstWithSpace(pid)
+		hbf.Unlock()
 		hbf.Dirty = true
 		if err := rm.bm.FreePage(hpid, true); err != nil {
 			return config.PageId{}, err
@@ -648,44 +794,19 @@ func (rm *RelationManager) addDataPage() (config.PageId, error) {
 		if err := rm.saveHeaderLocation(hpid); err != nil {
 			return config.PageId{}, err
 		}
-	} else {
-		// load header and set the new page as head of with-space list
-		hbf, err := rm.bm.GetPage(rm.HeaderPageId)
-		if err != nil {
-			return config.PageId{}, err
-		}
-		// existing firstWithSpace at offset 8..11,12..15
-		oldFx := readInt32(hbf.Data, 8)
-		oldFy := readInt32(hbf.Data, 12)
-		// set new page's next to old head
-		// set new page prev = -1 (already), next = old
-		// and update header firstWithSpace to pid
-		writeInt32(hbf.Data, 8, int32(pid.FileIdx))
-		writeInt32(hbf.Data, 12, int32(pid.PageIdx))
-		hbf.Dirty = true
-		if err := rm.bm.FreePage(rm.HeaderPageId, true); err != nil {
-			return config.PageId{}, err
-		}
-		// also set new page's next pointers to old head
-		npbf, err := rm.bm.GetPage(pid)
-		if err != nil {
-			return config.PageId{}, err
-		}
-		writeInt32(npbf.Data, 8, oldFx)
-		writeInt32(npbf.Data, 12, oldFy)
-		npbf.Dirty = true
-		if err := rm.bm.FreePage(pid, true); err != nil {
-			return config.PageId{}, err
-		}
+	} else if err := rm.headerSetFirstWithSpace(pid); err != nil {
+		return config.PageId{}, err
 	}
 
-	rm.SlotsPerPage = slots
+	rm.slotsPerPage = slots
 	return pid, nil
 }
 
 // EnsureHeader ensures the relation's header page exists by creating one if absent.
 // This is exported for callers that want the header initialized at table creation time.
 func (rm *RelationManager) EnsureHeader() error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 	if rm.HeaderPageId != invalidPage {
 		return nil
 	}
@@ -695,6 +816,8 @@ func (rm *RelationManager) EnsureHeader() error {
 
 // AllPageIds returns all data page ids (both with-space and full lists) belonging to the relation.
 func (rm *RelationManager) AllPageIds() ([]config.PageId, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
 	var out []config.PageId
 	if rm.HeaderPageId == invalidPage {
 		return out, nil
@@ -720,19 +843,11 @@ func (rm *RelationManager) AllPageIds() ([]config.PageId, error) {
 		pid = nx
 	}
 	// full list
-	hbf, err := rm.bm.GetPage(rm.HeaderPageId)
+	first, err := rm.headerFirstFull()
 	if err != nil {
 		return nil, err
 	}
-	fx := int32(binary.LittleEndian.Uint32(hbf.Data[0:4]))
-	fy := int32(binary.LittleEndian.Uint32(hbf.Data[4:8]))
-	_ = rm.bm.FreePage(rm.HeaderPageId, false)
-	for pid := func() config.PageId {
-		if fx == -1 && fy == -1 {
-			return invalidPage
-		}
-		return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
-	}(); pid != invalidPage; {
+	for pid := first; pid != invalidPage; {
 		if visited[pid] {
 			// cycle detected, break
 			break
@@ -748,88 +863,123 @@ func (rm *RelationManager) AllPageIds() ([]config.PageId, error) {
 	return out, nil
 }
 
+// ErrStopScan can be returned by a ScanRecords callback to stop the scan early;
+// ScanRecords then returns nil.
+var ErrStopScan = errors.New("stop scan")
+
+// ErrRecordNotFound is returned for a RecordId that designates no record: a slot out
+// of range of its page, one already free, or a page outside the relation.
+var ErrRecordNotFound = errors.New("record not found")
+
 // ScanRecords iterates all records in the relation and calls cb for each record with its RecordId.
-// If cb returns an error, scanning stops and the error is returned.
+// If cb returns an error, scanning stops and the error is returned, except ErrStopScan
+// which ends the scan successfully.
 func (rm *RelationManager) ScanRecords(cb func(rec Record, rid RecordId) error) error {
-	if rm.HeaderPageId == invalidPage {
-		return nil
+	return rm.ScanRecordsContext(context.Background(), cb)
+}
+
+// ScanDirection is the order in which a scan visits the records of a relation.
+type ScanDirection int
+
+const (
+	// Forward visits the with-space list then the full list, each page's slots in
+	// increasing order: the order of ScanRecords and RecordIterator.
+	Forward ScanDirection = iota
+	// Reverse visits the same records in exactly the opposite order.
+	Reverse
+)
+
+// ScanRecordsReverse is ScanRecords visiting the records in the opposite order, last
+// slot of the last page first.
+func (rm *RelationManager) ScanRecordsReverse(cb func(rec Record, rid RecordId) error) error {
+	return rm.ScanRecordsIn(context.Background(), Reverse, cb)
+}
+
+// ScanRecordsIn is ScanRecordsContext in the given direction.
+func (rm *RelationManager) ScanRecordsIn(ctx context.Context, dir ScanDirection, cb func(rec Record, rid RecordId) error) error {
+	if dir == Reverse {
+		return rm.scanReverse(ctx, cb)
 	}
-	// helper to scan a single page
-	scanPage := func(pid config.PageId) (config.PageId, error) {
-		bf, err := rm.bm.GetPage(pid)
-		if err != nil {
-			return invalidPage, err
+	return rm.ScanRecordsContext(ctx, cb)
+}
+
+// scanReverse lists the pages the forward scan would read, then reads them back to
+// front, each from its last slot, with the same locking and pinning as the iterator.
+func (rm *RelationManager) scanReverse(ctx context.Context, cb func(rec Record, rid RecordId) error) error {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	pages, _, err := rm.listedPages()
+	if err != nil {
+		return err
+	}
+	for i := len(pages) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		slots := int(binary.LittleEndian.Uint32(bf.Data[16:20]))
-		dataStart := 20 + slots
-		for i := 0; i < slots; i++ {
-			if bf.Data[20+i] == 1 {
-				rec := &Record{}
-				if err := rm.Rel.ReadFromBuffer(rec, bf.Data, dataStart+i*rm.Rel.RecordSize); err != nil {
-					_ = rm.bm.FreePage(pid, false)
-					return invalidPage, err
-				}
-				rid := RecordId{PageId: pid, SlotIdx: i}
-				if err := cb(*rec, rid); err != nil {
-					_ = rm.bm.FreePage(pid, false)
-					return invalidPage, err
-				}
+		if err := rm.scanPageReverse(pages[i], cb); err != nil {
+			if errors.Is(err, ErrStopScan) {
+				return nil
 			}
+			return err
 		}
-		nx := int32(binary.LittleEndian.Uint32(bf.Data[8:12]))
-		ny := int32(binary.LittleEndian.Uint32(bf.Data[12:16]))
-		if err := rm.bm.FreePage(pid, false); err != nil {
-			return invalidPage, err
-		}
-		if nx == -1 && ny == -1 {
-			return invalidPage, nil
-		}
-		return config.PageId{FileIdx: int(nx), PageIdx: int(ny)}, nil
 	}
+	return nil
+}
 
-	// scan with-space list
-	whead, err := rm.headerFirstWithSpace()
+// scanPageReverse calls cb for the live records of pid, last slot first.
+func (rm *RelationManager) scanPageReverse(pid config.PageId, cb func(rec Record, rid RecordId) error) error {
+	bf, err := rm.bm.GetPage(pid)
 	if err != nil {
 		return err
 	}
-	visited := make(map[config.PageId]bool)
-	for pid := whead; pid != invalidPage; {
-		if visited[pid] {
-			break // cycle detected
+	p := rm.page(bf)
+	bf.RLock()
+	slots := rm.header(bf).NumSlots()
+	bf.RUnlock()
+	for i := slots - 1; i >= 0; i-- {
+		// the latch is not held over loadOverflow and cb, which use the pool
+		bf.RLock()
+		used := p[20+i] == 1
+		var rec Record
+		var refs []overflowRef
+		var err error
+		if used {
+			rec, refs, err = rm.readRecord(p, 20+slots+i*rm.Rel.RecordSize)
+		}
+		bf.RUnlock()
+		if !used {
+			continue
+		}
+		if err == nil {
+			err = rm.loadOverflow(&rec, refs)
+		}
+		if err == nil {
+			err = cb(rec, RecordId{PageId: pid, SlotIdx: i})
 		}
-		visited[pid] = true
-		nxt, err := scanPage(pid)
 		if err != nil {
+			_ = rm.bm.FreePage(pid, false)
 			return err
 		}
-		pid = nxt
 	}
+	return rm.bm.FreePage(pid, false)
+}
 
-	// scan full list
-	if rm.HeaderPageId != invalidPage {
-		hbf, err := rm.bm.GetPage(rm.HeaderPageId)
-		if err != nil {
+// ScanRecordsContext is ScanRecords checking ctx before each page: once ctx is done
+// the scan stops, with no page left pinned, and returns ctx.Err().
+func (rm *RelationManager) ScanRecordsContext(ctx context.Context, cb func(rec Record, rid RecordId) error) error {
+	it := rm.Iterator()
+	it.ctx = ctx
+	defer it.Close()
+	for {
+		rec, rid, ok, err := it.Next()
+		if err != nil || !ok {
 			return err
 		}
-		fx := int32(binary.LittleEndian.Uint32(hbf.Data[0:4]))
-		fy := int32(binary.LittleEndian.Uint32(hbf.Data[4:8]))
-		_ = rm.bm.FreePage(rm.HeaderPageId, false)
-		for pid := func() config.PageId {
-			if fx == -1 && fy == -1 {
-				return invalidPage
-			}
-			return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
-		}(); pid != invalidPage; {
-			if visited[pid] {
-				break // cycle detected
+		if err := cb(rec, rid); err != nil {
+			if errors.Is(err, ErrStopScan) {
+				return it.Close()
 			}
-			visited[pid] = true
-			nxt, err := scanPage(pid)
-			if err != nil {
-				return err
-			}
-			pid = nxt
+			return err
 		}
 	}
-	return nil
 }
@@ -1,7 +1,13 @@
 package relation
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
 	"testing"
+	"time"
 
 	"malzahar-project/Projet_BDDA/buffer"
 	"malzahar-project/Projet_BDDA/config"
@@ -16,6 +22,7 @@ func setup(t *testing.T) (*RelationManager, func()) {
 		t.Fatalf("dm init: %v", err)
 	}
 	bm := buffer.NewBufferManager(cfg, dm)
+	bm.SetDebug(true)
 	cols := []ColumnInfo{{Name: "a", Kind: KindInt}, {Name: "b", Kind: KindChar, Size: 8}}
 	rel := NewRelation("r_test", cols)
 	rm, err := NewRelationManager(rel, dm, bm)
@@ -29,6 +36,12 @@ func setup(t *testing.T) (*RelationManager, func()) {
 	}
 }
 
+// pool returns the buffer manager of a RelationManager built by the tests, for the
+// calls BufferPool does not have.
+func pool(rm *RelationManager) *buffer.BufferManager {
+	return rm.bm.(*buffer.BufferManager)
+}
+
 func TestInsertAndReadMany(t *testing.T) {
 	rm, cleanup := setup(t)
 	defer cleanup()
@@ -113,3 +126,374 @@ func TestPrependDoesNotSelfLoop(t *testing.T) {
 		t.Fatalf("page next points to itself (self-loop) %v", pid)
 	}
 }
+
+func TestConcurrentScansWithWriter(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	const initial = 20
+	const extra = 30
+	for i := 0; i < initial; i++ {
+		if _, err := rm.InsertRecord(NewRecord("1", "seed")); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	// one writer
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < extra; i++ {
+			if _, err := rm.InsertRecord(NewRecord("2", "new")); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	// several scanners; each sees a consistent snapshot between writes
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				n := 0
+				if err := rm.ScanRecords(func(rec Record, rid RecordId) error {
+					n++
+					return nil
+				}); err != nil {
+					errs <- err
+					return
+				}
+				if n < initial || n > initial+extra {
+					errs <- fmt.Errorf("scan saw %d records", n)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent access: %v", err)
+	}
+	recs, err := rm.GetAllRecords()
+	if err != nil {
+		t.Fatalf("get all: %v", err)
+	}
+	if len(recs) != initial+extra {
+		t.Fatalf("expected %d records, got %d", initial+extra, len(recs))
+	}
+}
+
+func TestErrorPathsReleasePins(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	for i := 0; i < 3; i++ {
+		if _, err := rm.InsertRecord(NewRecord("1", "x")); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	// write failure inside InsertRecord (arity mismatch)
+	if _, err := rm.InsertRecord(NewRecord("1")); err == nil {
+		t.Fatalf("expected arity error")
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("after failed insert: %v", err)
+	}
+	// callback error stops the scan
+	var first RecordId
+	stop := errors.New("stop")
+	if err := rm.ScanRecords(func(rec Record, rid RecordId) error {
+		first = rid
+		return stop
+	}); err != stop {
+		t.Fatalf("expected callback error, got %v", err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("after aborted scan: %v", err)
+	}
+	// invalid and already free slots
+	if err := rm.DeleteRecord(RecordId{PageId: first.PageId, SlotIdx: 10000}); err == nil {
+		t.Fatalf("expected invalid slot error")
+	}
+	if err := rm.DeleteRecord(first); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := rm.DeleteRecord(first); err == nil {
+		t.Fatalf("expected slot already free error")
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("after failed deletes: %v", err)
+	}
+}
+
+func TestAddDataPageTooSmallDoesNotAllocate(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	rel := NewRelation("big", []ColumnInfo{{Name: "c", Kind: KindChar, Size: 1000}})
+	rm, err := NewRelationManager(rel, dm, bm)
+	if err != nil {
+		t.Fatalf("new rm: %v", err)
+	}
+	if _, err := rm.addDataPage(); err == nil {
+		t.Fatalf("expected page too small error")
+	}
+	pid, err := dm.AllocatePage()
+	if err != nil {
+		t.Fatalf("alloc: %v", err)
+	}
+	if pid != (config.PageId{}) {
+		t.Fatalf("failed addDataPage leaked a page: next allocation is %v", pid)
+	}
+}
+
+func TestInsertWriteFailureLeavesNoPins(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	// fill the first page so the loop walks several pages before failing
+	for i := 0; i < rm.slotsPerPage+2; i++ {
+		if _, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x")); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := rm.InsertRecord(NewRecord("1", "x", "extra")); err == nil {
+			t.Fatalf("expected arity error")
+		}
+		if err := pool(rm).AssertAllUnpinned(); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	// the failed inserts must not have consumed a slot
+	n := 0
+	if err := rm.ScanRecords(func(Record, RecordId) error { n++; return nil }); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if n != rm.slotsPerPage+2 {
+		t.Fatalf("got %d records, want %d", n, rm.slotsPerPage+2)
+	}
+	if _, err := rm.InsertRecord(NewRecord("99", "y")); err != nil {
+		t.Fatalf("insert after failure: %v", err)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatalf("after insert: %v", err)
+	}
+}
+
+func TestSlotsPerPageAndFillFactor(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	want := computeSlotsPerPage(rm.dm.PageSize(), rm.Rel.RecordSize)
+	if got := rm.SlotsPerPage(); got != want {
+		t.Fatalf("SlotsPerPage before any insert = %d, want %d", got, want)
+	}
+	if ff, err := rm.FillFactor(); err != nil || ff != 0 {
+		t.Fatalf("FillFactor of empty relation = %g, %v", ff, err)
+	}
+	// one full page and half of a second one
+	n := want + want/2
+	for i := 0; i < n; i++ {
+		if _, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x")); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	if got := rm.SlotsPerPage(); got != want {
+		t.Fatalf("SlotsPerPage = %d, want %d", got, want)
+	}
+	ff, err := rm.FillFactor()
+	if err != nil {
+		t.Fatalf("FillFactor: %v", err)
+	}
+	if exp := float64(n) / float64(2*want); ff != exp {
+		t.Fatalf("FillFactor = %g, want %g", ff, exp)
+	}
+}
+
+func TestFillFactorLimitsPageUse(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	rm.Rel.FillFactor = 0.5
+	slots := rm.SlotsPerPage()
+	limit := slots / 2
+	var rids []RecordId
+	for i := 0; i < limit*3; i++ {
+		rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x"))
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		rids = append(rids, rid)
+	}
+	perPage := make(map[config.PageId]int)
+	for _, rid := range rids {
+		perPage[rid.PageId]++
+	}
+	if len(perPage) != 3 {
+		t.Fatalf("%d records spread over %d pages, want 3", len(rids), len(perPage))
+	}
+	for pid, n := range perPage {
+		if n != limit {
+			t.Fatalf("page %v holds %d records, want %d", pid, n, limit)
+		}
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity: %v", err)
+	}
+	// a delete drops the page below the limit, so the next insert goes back there
+	if err := rm.DeleteRecord(rids[0]); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	rid, err := rm.InsertRecord(NewRecord("99", "y"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if rid.PageId != rids[0].PageId {
+		t.Fatalf("insert went to %v, want the page below its fill limit %v", rid.PageId, rids[0].PageId)
+	}
+
+	// bulk loads honor the fill factor too
+	n := 0
+	if _, err := rm.BulkInsert(func() (*Record, error) {
+		if n == limit*2 {
+			return nil, io.EOF
+		}
+		n++
+		return NewRecord("7", "bulk"), nil
+	}); err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+	pages, err := rm.AllPageIds()
+	if err != nil {
+		t.Fatalf("AllPageIds: %v", err)
+	}
+	if len(pages) != 5 {
+		t.Fatalf("got %d pages after bulk load, want 5", len(pages))
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity after bulk load: %v", err)
+	}
+}
+
+func TestPageReserve(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	cfg.PageReserveBytes = 32
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	bm := buffer.NewBufferManager(cfg, dm)
+	rel := NewRelation("r_reserve", []ColumnInfo{{Name: "a", Kind: KindInt}, {Name: "b", Kind: KindChar, Size: 8}})
+	rm, err := NewRelationManager(rel, dm, bm)
+	if err != nil {
+		t.Fatalf("new rm: %v", err)
+	}
+	slots := rm.SlotsPerPage()
+	if want := (512 - 32 - 20) / (1 + rel.RecordSize); slots != want {
+		t.Fatalf("SlotsPerPage = %d, want %d", slots, want)
+	}
+	if slots == computeSlotsPerPage(512, rel.RecordSize) {
+		t.Fatalf("the reserve should cost slots")
+	}
+	var rids []RecordId
+	for i := 0; i < slots+3; i++ {
+		rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), fmt.Sprint("v", i)))
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		rids = append(rids, rid)
+	}
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// on disk, the reserve is untouched and the layout starts right after it
+	for i, rid := range rids {
+		raw, err := dm.ReadPage(rid.PageId)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		for j := 0; j < 32; j++ {
+			if raw[j] != 0 {
+				t.Fatalf("page %v: reserve byte %d = %d", rid.PageId, j, raw[j])
+			}
+		}
+		if n := int(binary.LittleEndian.Uint32(raw[32+16 : 32+20])); n != slots {
+			t.Fatalf("page %v: slot count %d, want %d", rid.PageId, n, slots)
+		}
+		if raw[32+20+rid.SlotIdx] != slotUsed {
+			t.Fatalf("record %d: slot byte not set", i)
+		}
+		var rec Record
+		if err := rel.ReadFromBuffer(&rec, raw, 32+20+slots+rid.SlotIdx*rel.RecordSize); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if rec.Values[0] != fmt.Sprint(i) || rec.Values[1] != fmt.Sprint("v", i) {
+			t.Fatalf("record %d at its offset = %v", i, rec.Values)
+		}
+	}
+	if got := countRecords(t, rm); got != len(rids) {
+		t.Fatalf("scan found %d records, want %d", got, len(rids))
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity: %v", err)
+	}
+	if err := rel.CheckFits(512, 32); err != nil {
+		t.Fatalf("CheckFits: %v", err)
+	}
+	big := NewRelation("big", []ColumnInfo{{Name: "s", Kind: KindChar, Size: 512 - 21 - 16}})
+	if err := big.CheckFits(512, 0); err != nil {
+		t.Fatalf("CheckFits without reserve: %v", err)
+	}
+	if err := big.CheckFits(512, 32); err == nil {
+		t.Fatalf("a record filling the reserve should not fit")
+	}
+}
+
+// TestPageAccessTakesFrameLatch holds the latch of a data page from outside: a scan
+// must wait for a write latch to be released, and an insert for a read latch.
+func TestPageAccessTakesFrameLatch(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	rid, err := rm.InsertRecord(NewRecord("1", "a"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	bf, err := pool(rm).GetPage(rid.PageId)
+	if err != nil {
+		t.Fatalf("GetPage: %v", err)
+	}
+	blocked := func(name string, lock, unlock func(), op func() error) {
+		t.Helper()
+		lock()
+		done := make(chan error, 1)
+		go func() { done <- op() }()
+		select {
+		case err := <-done:
+			unlock()
+			t.Fatalf("%s did not wait for the latch (err %v)", name, err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		unlock()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s still blocked after the latch was released", name)
+		}
+	}
+	blocked("scan", bf.Lock, bf.Unlock, func() error {
+		_, err := rm.GetAllRecords()
+		return err
+	})
+	blocked("insert", bf.RLock, bf.RUnlock, func() error {
+		_, err := rm.InsertRecord(NewRecord("2", "b"))
+		return err
+	})
+	if err := pool(rm).FreePage(rid.PageId, false); err != nil {
+		t.Fatalf("FreePage: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package relation
+
+import (
+	"fmt"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// The value of a column stored out of line (a BLOB, or an Overflow VARCHAR) is kept
+// in a chain of overflow pages and the record holds a reference to it: the first page
+// of the chain (int32 fileIdx, int32 pageIdx) and the value length (uint32). An empty
+// value has no chain and refers to (-1,-1). Overflow pages use the data page header
+// positions:
+//
+//	marker [0:8]   (-2,-2), which no data page has as prev pointer
+//	next   [8:16]  next page of the chain, (-1,-1) on the last one
+//	length [16:20] number of value bytes on this page
+//	data   [20:]
+//
+// Chains belong to the record that refers to them: they are written before the record
+// and freed when its slot is freed (DeleteRecord, PurgeRecord, Purge). Until the record
+// is stored they belong to the writer, which frees them if the insert fails.
+
+const overflowMarker = -2
+
+// overflowRef locates the value of a column stored out of line.
+type overflowRef struct {
+	head   config.PageId
+	length int
+}
+
+// pageIdAt decodes a (fileIdx, pageIdx) pointer stored at off in a record, mapping
+// (-1,-1) to invalidPage.
+func (rm *RelationManager) pageIdAt(b []byte, off int) config.PageId {
+	fx := rm.readInt32(b, off)
+	fy := rm.readInt32(b, off+4)
+	if fx == -1 && fy == -1 {
+		return invalidPage
+	}
+	return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
+}
+
+// overflowRefs decodes the references of the record at pos in page b, one per
+// column stored out of line, in column order.
+func (rm *RelationManager) overflowRefs(b []byte, pos int) []overflowRef {
+	var refs []overflowRef
+	for _, col := range rm.Rel.overflowColumns() {
+		off := pos + rm.Rel.columnOffset(col)
+		refs = append(refs, overflowRef{head: rm.pageIdAt(b, off), length: int(rm.order.Uint32(b[off+8 : off+12]))})
+	}
+	return refs
+}
+
+// setOverflowRefs stores refs, as returned by writeOverflow, in the record at pos in
+// page b.
+func (rm *RelationManager) setOverflowRefs(b []byte, pos int, refs []overflowRef) {
+	for i, col := range rm.Rel.overflowColumns() {
+		off := pos + rm.Rel.columnOffset(col)
+		rm.writeInt32(b, off, int32(refs[i].head.FileIdx))
+		rm.writeInt32(b, off+4, int32(refs[i].head.PageIdx))
+		rm.order.PutUint32(b[off+8:off+12], uint32(refs[i].length))
+	}
+}
+
+// writeOverflow stores the out-of-line values of rec in new chains and returns their
+// references, in column order. rec is checked first so that an invalid record does
+// not leave chains behind, and the chains already written are freed when a later one
+// fails.
+func (rm *RelationManager) writeOverflow(rec *Record) ([]overflowRef, error) {
+	cols := rm.Rel.overflowColumns()
+	if len(cols) == 0 {
+		return nil, nil
+	}
+	if err := rm.Rel.CheckRecord(rec); err != nil {
+		return nil, err
+	}
+	refs := make([]overflowRef, 0, len(cols))
+	for _, col := range cols {
+		b, err := rm.Rel.stringBytes(rm.Rel.Columns[col], rec.Values[col])
+		if err != nil {
+			_ = rm.freeOverflow(refs)
+			return nil, err
+		}
+		ref, err := rm.writeChain(b)
+		if err != nil {
+			_ = rm.freeOverflow(refs)
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// writeChain stores b in a new chain of overflow pages and returns its reference. On
+// failure the pages allocated so far are given back.
+func (rm *RelationManager) writeChain(b []byte) (overflowRef, error) {
+	// written back to front so each page is complete, next pointer included, in one go
+	per := rm.pageSpace() - 20
+	ref := overflowRef{head: invalidPage, length: len(b)}
+	var pages []config.PageId
+	fail := func(err error) (overflowRef, error) {
+		for _, pid := range pages {
+			_ = rm.dm.FreePage(pid)
+		}
+		return overflowRef{}, err
+	}
+	for end := len(b); end > 0; {
+		start := (end - 1) / per * per
+		pid, err := rm.dm.AllocatePage()
+		if err != nil {
+			return fail(err)
+		}
+		pages = append(pages, pid)
+		bf, err := rm.bm.GetPage(pid)
+		if err != nil {
+			return fail(err)
+		}
+		p := rm.page(bf)
+		bf.Lock()
+		h := rm.headerOf(p)
+		h.setOverflow()
+		h.SetNext(ref.head)
+		h.SetNumSlots(end - start)
+		copy(p[20:], b[start:end])
+		bf.Unlock()
+		bf.Dirty = true
+		if err := rm.bm.FreePage(pid, true); err != nil {
+			return fail(err)
+		}
+		ref.head = pid
+		end = start
+	}
+	return ref, nil
+}
+
+// readOverflow returns the value ref refers to.
+func (rm *RelationManager) readOverflow(ref overflowRef) (string, error) {
+	b := make([]byte, 0, ref.length)
+	err := rm.walkOverflow(ref, func(pid config.PageId, p []byte) error {
+		n := rm.headerOf(p).NumSlots()
+		if n > len(p)-20 || len(b)+n > ref.length {
+			return fmt.Errorf("overflow page %v: invalid length %d", pid, n)
+		}
+		b = append(b, p[20:20+n]...)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(b) != ref.length {
+		return "", fmt.Errorf("overflow chain at %v: %d bytes, want %d", ref.head, len(b), ref.length)
+	}
+	return string(b), nil
+}
+
+// readRecord decodes the record at pos in page b and the references of its
+// out-of-line values. It only reads b, so it runs under the page latch;
+// loadOverflow then reads the values in once the latch is released.
+func (rm *RelationManager) readRecord(b []byte, pos int) (Record, []overflowRef, error) {
+	rec := Record{}
+	if err := rm.Rel.ReadFromBuffer(&rec, b, pos); err != nil {
+		return Record{}, nil, err
+	}
+	return rec, rm.overflowRefs(b, pos), nil
+}
+
+// loadOverflow fills in the out-of-line columns of rec from refs, as returned by
+// readRecord.
+func (rm *RelationManager) loadOverflow(rec *Record, refs []overflowRef) error {
+	for i, col := range rm.Rel.overflowColumns() {
+		v, err := rm.readOverflow(refs[i])
+		if err != nil {
+			return err
+		}
+		rec.Values[col] = v
+	}
+	return nil
+}
+
+// overflowPages returns the pages of the chain ref refers to.
+func (rm *RelationManager) overflowPages(ref overflowRef) ([]config.PageId, error) {
+	var out []config.PageId
+	err := rm.walkOverflow(ref, func(pid config.PageId, _ []byte) error {
+		out = append(out, pid)
+		return nil
+	})
+	return out, err
+}
+
+// freeOverflow gives the pages of the chains refs refer to back to the DiskManager.
+func (rm *RelationManager) freeOverflow(refs []overflowRef) error {
+	for _, ref := range refs {
+		pids, err := rm.overflowPages(ref)
+		if err != nil {
+			return err
+		}
+		for _, pid := range pids {
+			if err := rm.dm.FreePage(pid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkOverflow calls fn with every page of the chain ref refers to, in order, each
+// pinned and read-latched for the duration of the call; fn must not use the pool.
+func (rm *RelationManager) walkOverflow(ref overflowRef, fn func(pid config.PageId, p []byte) error) error {
+	visited := make(map[config.PageId]bool)
+	for pid := ref.head; pid != invalidPage; {
+		if visited[pid] {
+			return fmt.Errorf("overflow chain at %v: cycle at %v", ref.head, pid)
+		}
+		visited[pid] = true
+		bf, err := rm.bm.GetPage(pid)
+		if err != nil {
+			return err
+		}
+		p := rm.page(bf)
+		bf.RLock()
+		if !rm.headerOf(p).isOverflow() {
+			bf.RUnlock()
+			_ = rm.bm.FreePage(pid, false)
+			return fmt.Errorf("page %v is not an overflow page", pid)
+		}
+		err = fn(pid, p)
+		next := rm.headerOf(p).Next()
+		bf.RUnlock()
+		if ferr := rm.bm.FreePage(pid, false); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			return err
+		}
+		pid = next
+	}
+	return nil
+}
+
+// pageOverflowRefs returns the overflow references of the records of data page pid,
+// soft-deleted ones included.
+func (rm *RelationManager) pageOverflowRefs(pid config.PageId) ([]overflowRef, error) {
+	if len(rm.Rel.overflowColumns()) == 0 {
+		return nil, nil
+	}
+	bf, err := rm.bm.GetPage(pid)
+	if err != nil {
+		return nil, err
+	}
+	var refs []overflowRef
+	p := rm.page(bf)
+	bf.RLock()
+	slots := rm.headerOf(p).NumSlots()
+	for i := 0; i < slots; i++ {
+		if p[20+i] != slotFree {
+			refs = append(refs, rm.overflowRefs(p, 20+slots+i*rm.Rel.RecordSize)...)
+		}
+	}
+	bf.RUnlock()
+	return refs, rm.bm.FreePage(pid, false)
+}
+
+// OverflowPageIds returns the overflow pages of every record of the relation,
+// soft-deleted ones included, so that dropping the relation can free them.
+func (rm *RelationManager) OverflowPageIds() ([]config.PageId, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	if rm.HeaderPageId == invalidPage || len(rm.Rel.overflowColumns()) == 0 {
+		return nil, nil
+	}
+	pages, _, err := rm.listedPages()
+	if err != nil {
+		return nil, err
+	}
+	var out []config.PageId
+	for _, pid := range pages {
+		refs, err := rm.pageOverflowRefs(pid)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			pids, err := rm.overflowPages(ref)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, pids...)
+		}
+	}
+	return out, nil
+}
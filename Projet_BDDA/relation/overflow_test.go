@@ -0,0 +1,317 @@
+package relation
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/buffer"
+	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/disk"
+)
+
+func TestOverflowValuesLargerThanAPage(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 4)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	defer dm.Finish()
+	bm := buffer.NewBufferManager(cfg, dm)
+	rel := NewRelation("docs", []ColumnInfo{{Name: "id", Kind: KindInt}, {Name: "title", Kind: KindVarchar, Size: 16}, {Name: "body", Kind: KindVarchar, Size: 4000}})
+	if err := rel.CheckFits(512, 0); err == nil {
+		t.Fatalf("a 4000-byte VARCHAR should not fit in a 512-byte page inline")
+	}
+	if err := rel.FitToPage(512, 0); err != nil {
+		t.Fatalf("FitToPage: %v", err)
+	}
+	if cols := rel.overflowColumns(); len(cols) != 1 || cols[0] != 2 || rel.RecordSize != 4+16+overflowRefSize {
+		t.Fatalf("body should move out of line: overflow columns %v, record size %d", rel.overflowColumns(), rel.RecordSize)
+	}
+	rm, err := NewRelationManager(rel, dm, bm)
+	if err != nil {
+		t.Fatalf("new rm: %v", err)
+	}
+	if err := rm.EnsureHeader(); err != nil {
+		t.Fatalf("EnsureHeader: %v", err)
+	}
+	before, err := dm.AllocatedPageCount()
+	if err != nil {
+		t.Fatalf("AllocatedPageCount: %v", err)
+	}
+
+	// 3000 bytes span several overflow pages; the empty value has no chain
+	bodies := map[string]string{
+		"1": strings.Repeat("abcdefghij", 300),
+		"2": "",
+		"3": strings.Repeat("x", 492),
+	}
+	var rids []RecordId
+	for _, id := range []string{"1", "2", "3"} {
+		rid, err := rm.InsertRecord(NewRecord(id, "t"+id, bodies[id]))
+		if err != nil {
+			t.Fatalf("insert %s: %v", id, err)
+		}
+		rids = append(rids, rid)
+	}
+	// a bulk load goes through the same path
+	bodies["4"] = strings.Repeat("y", 1000)
+	done := false
+	if _, err := rm.BulkInsert(func() (*Record, error) {
+		if done {
+			return nil, io.EOF
+		}
+		done = true
+		return NewRecord("4", "t4", bodies["4"]), nil
+	}); err != nil {
+		t.Fatalf("BulkInsert: %v", err)
+	}
+	// an invalid record leaves no chain behind
+	if _, err := rm.InsertRecord(NewRecord("nope", "t", bodies["1"])); err == nil {
+		t.Fatalf("an invalid INT should be rejected")
+	}
+	// the buffer pool must not hide a wrong layout
+	if err := bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	check := func() {
+		t.Helper()
+		recs, err := rm.GetAllRecords()
+		if err != nil {
+			t.Fatalf("GetAllRecords: %v", err)
+		}
+		if len(recs) != len(bodies) {
+			t.Fatalf("got %d records, want %d", len(recs), len(bodies))
+		}
+		for _, rec := range recs {
+			id := rec.Values[0]
+			if rec.Values[1] != "t"+id || rec.Values[2] != bodies[id] {
+				t.Fatalf("record %s read back as %q, %d-byte body", id, rec.Values[1], len(rec.Values[2]))
+			}
+		}
+	}
+	check()
+	pages, err := rm.OverflowPageIds()
+	if err != nil {
+		t.Fatalf("OverflowPageIds: %v", err)
+	}
+	// 492 bytes fill exactly one page, 1000 and 3000 need 3 and 7
+	if len(pages) != 1+3+7 {
+		t.Fatalf("%d overflow pages, want 11", len(pages))
+	}
+	after, err := dm.AllocatedPageCount()
+	if err != nil {
+		t.Fatalf("AllocatedPageCount: %v", err)
+	}
+	if after-before != len(pages) {
+		t.Fatalf("%d pages allocated for %d overflow pages", after-before, len(pages))
+	}
+	// overflow pages are never mistaken for data pages
+	if err := rm.Repair(); err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity: %v", err)
+	}
+
+	// deleting a record frees its chain
+	if err := rm.DeleteRecord(rids[0]); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	delete(bodies, "1")
+	check()
+	if n, _ := dm.AllocatedPageCount(); n != after-7 {
+		t.Fatalf("%d pages allocated after delete, want %d", n, after-7)
+	}
+
+	// an update in place swaps the chain: 1000 bytes (3 pages) become 492 (1 page)
+	bodies["4"] = strings.Repeat("z", 492)
+	var rid4 RecordId
+	if err := rm.ScanRecords(func(rec Record, rid RecordId) error {
+		if rec.Values[0] == "4" {
+			rid4 = rid
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if err := rm.UpdateRecords([]RecordUpdate{{Rid: rid4, Rec: NewRecord("4", "t4", bodies["4"])}}); err != nil {
+		t.Fatalf("UpdateRecords: %v", err)
+	}
+	check()
+	if n, _ := dm.AllocatedPageCount(); n != after-7-2 {
+		t.Fatalf("%d pages allocated after update, want %d", n, after-7-2)
+	}
+}
+
+func TestBlobRoundTrip(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	rel := NewRelation("files", []ColumnInfo{{Name: "id", Kind: KindInt}, {Name: "data", Kind: KindBlob}, {Name: "thumb", Kind: KindBlob}})
+	if err := rel.FitToPage(512, 0); err != nil {
+		t.Fatalf("FitToPage: %v", err)
+	}
+	if rel.RecordSize != 4+2*overflowRefSize {
+		t.Fatalf("record size %d, want %d", rel.RecordSize, 4+2*overflowRefSize)
+	}
+	rm, err := NewRelationManager(rel, rm.dm, rm.bm)
+	if err != nil {
+		t.Fatalf("new rm: %v", err)
+	}
+	// every byte value, zeros included, over several pages
+	data := make([]byte, 5000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	rec, err := NewTypedRecord(rel, 1, data, []byte{0, 0, 1})
+	if err != nil {
+		t.Fatalf("NewTypedRecord: %v", err)
+	}
+	rid, err := rm.InsertRecord(rec)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := rm.bm.FlushBuffers(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	recs, err := rm.GetAllRecords()
+	if err != nil {
+		t.Fatalf("GetAllRecords: %v", err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("got %d records, want 1", len(recs))
+	}
+	got, err := recs[0].Bytes(1)
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("blob read back differs (%d bytes, want %d)", len(got), len(data))
+	}
+	if thumb, _ := recs[0].Bytes(2); !bytes.Equal(thumb, []byte{0, 0, 1}) {
+		t.Fatalf("second blob read back as %v", thumb)
+	}
+	if _, err := recs[0].Bytes(0); err == nil {
+		t.Fatalf("Bytes on an INT column should fail")
+	}
+	pages, err := rm.OverflowPageIds()
+	if err != nil {
+		t.Fatalf("OverflowPageIds: %v", err)
+	}
+	// 5000 bytes at 492 per page, and 3 bytes
+	if len(pages) != 11+1 {
+		t.Fatalf("%d overflow pages, want 12", len(pages))
+	}
+	before, _ := rm.dm.(*disk.DiskManager).AllocatedPageCount()
+	if err := rm.DeleteRecord(rid); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if after, _ := rm.dm.(*disk.DiskManager).AllocatedPageCount(); before-after != 12 {
+		t.Fatalf("delete freed %d pages, want 12", before-after)
+	}
+
+	// base64 is only the text form used by commands
+	col := rel.Columns[1]
+	if col.TypeString() != "BLOB" {
+		t.Fatalf("TypeString = %q", col.TypeString())
+	}
+	if k, _, err := ParseColumnType("blob"); err != nil || k != KindBlob {
+		t.Fatalf("ParseColumnType(blob) = %v, %v", k, err)
+	}
+	v, err := col.ParseText(col.FormatText(string(data)))
+	if err != nil || v != string(data) {
+		t.Fatalf("base64 round trip failed: %v", err)
+	}
+	if _, err := col.ParseText("not base64!"); err == nil {
+		t.Fatalf("invalid base64 should be rejected")
+	}
+}
+
+// allocLimit is a PageStore that refuses allocations once left reaches zero.
+type allocLimit struct {
+	disk.PageStore
+	left int
+}
+
+func (s *allocLimit) AllocatePage() (config.PageId, error) {
+	if s.left == 0 {
+		return config.PageId{}, disk.ErrNoSpace
+	}
+	s.left--
+	return s.PageStore.AllocatePage()
+}
+
+// failingGet is a BufferPool that cannot load one page.
+type failingGet struct {
+	BufferPool
+	pid config.PageId
+}
+
+func (p *failingGet) GetPage(pid config.PageId) (*buffer.BufferFrame, error) {
+	if pid == p.pid {
+		return nil, errors.New("injected read failure")
+	}
+	return p.BufferPool.GetPage(pid)
+}
+
+func TestFailedInsertFreesOverflowChains(t *testing.T) {
+	cfg := config.NewDBConfigWithParams(t.TempDir(), 512, 8)
+	dm := disk.NewDiskManager(cfg)
+	if err := dm.Init(); err != nil {
+		t.Fatalf("dm init: %v", err)
+	}
+	defer dm.Finish()
+	bm := buffer.NewBufferManager(cfg, dm)
+	rel := NewRelation("files", []ColumnInfo{{Name: "id", Kind: KindInt}, {Name: "a", Kind: KindBlob}, {Name: "b", Kind: KindBlob}})
+	if err := rel.FitToPage(512, 0); err != nil {
+		t.Fatalf("FitToPage: %v", err)
+	}
+	store := &allocLimit{PageStore: dm, left: -1}
+	pool := &failingGet{BufferPool: bm, pid: invalidPage}
+	rm, err := NewRelationManager(rel, store, pool)
+	if err != nil {
+		t.Fatalf("new rm: %v", err)
+	}
+	small, err := NewTypedRecord(rel, 1, []byte("a"), []byte("b"))
+	if err != nil {
+		t.Fatalf("NewTypedRecord: %v", err)
+	}
+	rid, err := rm.InsertRecord(small)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	before, err := dm.AllocatedPageCount()
+	if err != nil {
+		t.Fatalf("AllocatedPageCount: %v", err)
+	}
+	// each value takes 3 pages
+	big, err := NewTypedRecord(rel, 2, bytes.Repeat([]byte("a"), 1200), bytes.Repeat([]byte("b"), 1200))
+	if err != nil {
+		t.Fatalf("NewTypedRecord: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		left int
+		pid  config.PageId
+	}{
+		{"second chain fails halfway", 4, invalidPage},
+		{"data page unreadable", -1, rid.PageId},
+	} {
+		store.left, pool.pid = tc.left, tc.pid
+		if _, err := rm.InsertRecord(big); err == nil {
+			t.Fatalf("%s: insert should fail", tc.name)
+		}
+		if n, _ := dm.AllocatedPageCount(); n != before {
+			t.Fatalf("%s: %d pages allocated, want %d", tc.name, n, before)
+		}
+	}
+	store.left, pool.pid = -1, invalidPage
+	if _, err := rm.InsertRecord(big); err != nil {
+		t.Fatalf("insert after failures: %v", err)
+	}
+	if n, _ := dm.AllocatedPageCount(); n != before+6 {
+		t.Fatalf("%d pages allocated, want %d", n, before+6)
+	}
+}
@@ -0,0 +1,99 @@
+package relation
+
+import (
+	"encoding/binary"
+
+	"malzahar-project/Projet_BDDA/buffer"
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// pageHeaderSize is the size of the header at the start of every relation page.
+const pageHeaderSize = 20
+
+// pageHeader reads and writes the header of a relation page, in the relation's byte
+// order:
+//
+//	[0:8)   prev: a page id as (fileIdx, pageIdx) int32s, (-1,-1) for none
+//	[8:16)  next: a page id, same encoding
+//	[16:20) numSlots: the number of slots of a data page
+//
+// On the relation's header page prev holds the first full page and next the first
+// page with space (see FirstFull and FirstWithSpace). An overflow page holds
+// overflowMarker twice in prev, the next page of its chain in next and the length of
+// its chunk in numSlots.
+type pageHeader struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+// header returns the header of the page held by bf.
+func (rm *RelationManager) header(bf *buffer.BufferFrame) pageHeader {
+	return rm.headerOf(rm.page(bf))
+}
+
+// headerOf returns the header of p, a page as returned by rm.page.
+func (rm *RelationManager) headerOf(p []byte) pageHeader {
+	return pageHeader{b: p[:pageHeaderSize], order: rm.order}
+}
+
+func (h pageHeader) int32At(off int) int32 {
+	return int32(h.order.Uint32(h.b[off : off+4]))
+}
+
+func (h pageHeader) setInt32At(off int, v int32) {
+	h.order.PutUint32(h.b[off:off+4], uint32(v))
+}
+
+func (h pageHeader) pageIdAt(off int) config.PageId {
+	fx, fy := h.int32At(off), h.int32At(off+4)
+	if fx == -1 && fy == -1 {
+		return invalidPage
+	}
+	return config.PageId{FileIdx: int(fx), PageIdx: int(fy)}
+}
+
+func (h pageHeader) setPageIdAt(off int, pid config.PageId) {
+	h.setInt32At(off, int32(pid.FileIdx))
+	h.setInt32At(off+4, int32(pid.PageIdx))
+}
+
+// Prev returns the previous page, invalidPage if none.
+func (h pageHeader) Prev() config.PageId { return h.pageIdAt(0) }
+
+// SetPrev sets the previous page; invalidPage clears it.
+func (h pageHeader) SetPrev(pid config.PageId) { h.setPageIdAt(0, pid) }
+
+// Next returns the next page, invalidPage if none.
+func (h pageHeader) Next() config.PageId { return h.pageIdAt(8) }
+
+// SetNext sets the next page; invalidPage clears it.
+func (h pageHeader) SetNext(pid config.PageId) { h.setPageIdAt(8, pid) }
+
+// NumSlots returns the number of slots of the page.
+func (h pageHeader) NumSlots() int { return int(h.order.Uint32(h.b[16:20])) }
+
+// SetNumSlots sets the number of slots of the page.
+func (h pageHeader) SetNumSlots(n int) { h.order.PutUint32(h.b[16:20], uint32(n)) }
+
+// FirstFull returns the head of the full-page list stored in a header page.
+func (h pageHeader) FirstFull() config.PageId { return h.Prev() }
+
+// SetFirstFull sets the head of the full-page list stored in a header page.
+func (h pageHeader) SetFirstFull(pid config.PageId) { h.SetPrev(pid) }
+
+// FirstWithSpace returns the head of the with-space list stored in a header page.
+func (h pageHeader) FirstWithSpace() config.PageId { return h.Next() }
+
+// SetFirstWithSpace sets the head of the with-space list stored in a header page.
+func (h pageHeader) SetFirstWithSpace(pid config.PageId) { h.SetNext(pid) }
+
+// isOverflow reports whether prev holds the overflow page marker.
+func (h pageHeader) isOverflow() bool {
+	return h.int32At(0) == overflowMarker && h.int32At(4) == overflowMarker
+}
+
+// setOverflow writes the overflow page marker into prev.
+func (h pageHeader) setOverflow() {
+	h.setInt32At(0, overflowMarker)
+	h.setInt32At(4, overflowMarker)
+}
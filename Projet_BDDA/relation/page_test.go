@@ -0,0 +1,62 @@
+package relation
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+func TestPageHeaderAccessors(t *testing.T) {
+	for _, order := range []config.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		rm := &RelationManager{order: order}
+		p := make([]byte, 64)
+		h := rm.headerOf(p)
+		h.SetPrev(config.PageId{FileIdx: 1, PageIdx: 2})
+		h.SetNext(config.PageId{FileIdx: 3, PageIdx: 40000})
+		h.SetNumSlots(7)
+
+		// the layout is the historical one: prev, next, numSlots as 32-bit words
+		for off, want := range map[int]uint32{0: 1, 4: 2, 8: 3, 12: 40000, 16: 7} {
+			if got := order.Uint32(p[off : off+4]); got != want {
+				t.Fatalf("%v: word at %d = %d, want %d", order, off, got, want)
+			}
+		}
+		if got := h.Prev(); got != (config.PageId{FileIdx: 1, PageIdx: 2}) {
+			t.Fatalf("%v: Prev = %v", order, got)
+		}
+		if got := h.Next(); got != (config.PageId{FileIdx: 3, PageIdx: 40000}) {
+			t.Fatalf("%v: Next = %v", order, got)
+		}
+		if h.NumSlots() != 7 {
+			t.Fatalf("%v: NumSlots = %d", order, h.NumSlots())
+		}
+		if h.FirstFull() != h.Prev() || h.FirstWithSpace() != h.Next() {
+			t.Fatalf("%v: header page lists do not map to prev and next", order)
+		}
+
+		// invalidPage is stored as (-1,-1) and read back as invalidPage
+		h.SetFirstFull(invalidPage)
+		h.SetNext(invalidPage)
+		if h.Prev() != invalidPage || h.FirstWithSpace() != invalidPage {
+			t.Fatalf("%v: cleared pointers read as %v and %v", order, h.Prev(), h.Next())
+		}
+		if int32(order.Uint32(p[8:12])) != -1 || int32(order.Uint32(p[12:16])) != -1 {
+			t.Fatalf("%v: invalidPage not stored as (-1,-1): % x", order, p[8:16])
+		}
+
+		if h.isOverflow() {
+			t.Fatalf("%v: a data page reads as an overflow page", order)
+		}
+		h.setOverflow()
+		if !h.isOverflow() || int32(order.Uint32(p[0:4])) != overflowMarker {
+			t.Fatalf("%v: overflow marker not set: % x", order, p[0:8])
+		}
+		// nothing past the header is touched
+		for i := pageHeaderSize; i < len(p); i++ {
+			if p[i] != 0 {
+				t.Fatalf("%v: byte %d written", order, i)
+			}
+		}
+	}
+}
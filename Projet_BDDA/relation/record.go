@@ -1,10 +1,226 @@
 package relation
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Record represents a tuple as a slice of string values.
+//
+// A record bound to its Relation (records read from pages, NewTypedRecord, Bind) also
+// offers typed accessors; parsed numbers are cached per column for as long as the
+// underlying string is unchanged.
 type Record struct {
 	Values []string
+	rel    *Relation
+	cache  []cachedValue
+}
+
+// cachedValue is the parsed form of Values[i], valid while raw == Values[i].
+type cachedValue struct {
+	set bool
+	raw string
+	i   int64
+	f   float64
+	err error
 }
 
 func NewRecord(values ...string) *Record {
 	return &Record{Values: append([]string{}, values...)}
 }
+
+// NewTypedRecord builds a record of rel from Go values: integers for INT columns,
+// floats or integers for FLOAT columns, strings for CHAR/VARCHAR columns, and byte
+// slices or strings of raw bytes (not base64) for BLOB columns. The record is
+// validated with CheckRecord and bound to rel.
+func NewTypedRecord(rel *Relation, values ...any) (*Record, error) {
+	if len(values) != len(rel.Columns) {
+		return nil, fmt.Errorf("record arity mismatch: got %d values, want %d", len(values), len(rel.Columns))
+	}
+	rec := &Record{Values: make([]string, len(values)), rel: rel}
+	for i, v := range values {
+		col := rel.Columns[i]
+		var s string
+		var ok bool
+		switch col.Kind {
+		case KindInt:
+			if s, ok = formatInt(v); ok {
+				if _, err := strconv.ParseInt(s, 10, 32); err != nil {
+					return nil, fmt.Errorf("col %s: %w: %s is out of range for INT", col.Name, ErrInvalidValue, s)
+				}
+			}
+		case KindFloat:
+			if s, ok = formatInt(v); !ok {
+				switch f := v.(type) {
+				case float32:
+					s, ok = strconv.FormatFloat(float64(f), 'g', -1, 32), true
+				case float64:
+					s, ok = strconv.FormatFloat(f, 'g', -1, 64), true
+				}
+			}
+		case KindChar, KindVarchar:
+			s, ok = v.(string)
+		case KindBlob:
+			switch b := v.(type) {
+			case []byte:
+				s, ok = string(b), true
+			case string:
+				s, ok = b, true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("col %s: cannot store %T in %s", col.Name, v, col.TypeString())
+		}
+		rec.Values[i] = s
+	}
+	if err := rel.CheckRecord(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func formatInt(v any) (string, bool) {
+	switch n := v.(type) {
+	case int:
+		return strconv.FormatInt(int64(n), 10), true
+	case int32:
+		return strconv.FormatInt(int64(n), 10), true
+	case int64:
+		return strconv.FormatInt(n, 10), true
+	}
+	return "", false
+}
+
+// Bind attaches rel to the record so the typed accessors can be used, and returns rec.
+func (r *Record) Bind(rel *Relation) *Record {
+	if r.rel != rel {
+		r.rel = rel
+		r.cache = nil
+	}
+	return r
+}
+
+// column checks that the record is bound and col is in range, and returns its info.
+func (r *Record) column(col int) (ColumnInfo, error) {
+	if r.rel == nil {
+		return ColumnInfo{}, fmt.Errorf("record is not bound to a relation")
+	}
+	if col < 0 || col >= len(r.rel.Columns) || col >= len(r.Values) {
+		return ColumnInfo{}, fmt.Errorf("column index %d out of range", col)
+	}
+	return r.rel.Columns[col], nil
+}
+
+// parsed returns the cached parse of column col, parsing Values[col] as needed.
+func (r *Record) parsed(col int, kind ColumnKind) cachedValue {
+	if len(r.cache) != len(r.Values) {
+		r.cache = make([]cachedValue, len(r.Values))
+	}
+	c := &r.cache[col]
+	raw := r.Values[col]
+	if c.set && c.raw == raw {
+		return *c
+	}
+	*c = cachedValue{set: true, raw: raw}
+	if kind == KindInt {
+		c.i, c.err = strconv.ParseInt(raw, 10, 64)
+		c.f = float64(c.i)
+	} else {
+		c.f, c.err = strconv.ParseFloat(raw, 64)
+	}
+	if c.err != nil {
+		c.err = fmt.Errorf("col %s: %w %q for %s", r.rel.Columns[col].Name, ErrInvalidValue, raw, r.rel.Columns[col].TypeString())
+	}
+	return *c
+}
+
+// Int returns the value of the INT column col.
+func (r *Record) Int(col int) (int64, error) {
+	info, err := r.column(col)
+	if err != nil {
+		return 0, err
+	}
+	if info.Kind != KindInt {
+		return 0, fmt.Errorf("col %s: %s is not INT", info.Name, info.TypeString())
+	}
+	c := r.parsed(col, KindInt)
+	return c.i, c.err
+}
+
+// Float returns the value of the numeric column col (FLOAT, or INT widened).
+func (r *Record) Float(col int) (float64, error) {
+	info, err := r.column(col)
+	if err != nil {
+		return 0, err
+	}
+	if info.Kind != KindInt && info.Kind != KindFloat {
+		return 0, fmt.Errorf("col %s: %s is not numeric", info.Name, info.TypeString())
+	}
+	c := r.parsed(col, info.Kind)
+	return c.f, c.err
+}
+
+// String returns the text of column col, whatever its type.
+func (r *Record) String(col int) (string, error) {
+	if _, err := r.column(col); err != nil {
+		return "", err
+	}
+	return r.Values[col], nil
+}
+
+// Bytes returns the raw bytes of the BLOB column col.
+func (r *Record) Bytes(col int) ([]byte, error) {
+	info, err := r.column(col)
+	if err != nil {
+		return nil, err
+	}
+	if info.Kind != KindBlob {
+		return nil, fmt.Errorf("col %s: %s is not BLOB", info.Name, info.TypeString())
+	}
+	return []byte(r.Values[col]), nil
+}
+
+// RecordKey returns a key of rec that is equal for two records of r exactly when
+// their values are equal as r's column types see them: INT and FLOAT values compare
+// by number, so 1 and 01 on an INT column (or 1.50 and 1.5 on a FLOAT one) share a
+// key, other values by their text. A value that does not parse as its column's
+// number is kept as written.
+func (r *Relation) RecordKey(rec *Record) string {
+	var b strings.Builder
+	for i, v := range rec.Values {
+		if i < len(r.Columns) {
+			v = canonicalValue(r.Columns[i].Kind, v)
+		}
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// RecordEquals reports whether a and b have the same RecordKey in r.
+func (r *Relation) RecordEquals(a, b *Record) bool {
+	return r.RecordKey(a) == r.RecordKey(b)
+}
+
+// canonicalValue is the text RecordKey uses for a value v of a column of kind.
+func canonicalValue(kind ColumnKind, v string) string {
+	switch kind {
+	case KindInt:
+		if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			return strconv.FormatInt(n, 10)
+		}
+		if n, ok := intLiteral(v); ok {
+			return n
+		}
+	case KindFloat:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 32); err == nil {
+			if f == 0 {
+				f = 0 // -0 and 0 are the same value
+			}
+			return strconv.FormatFloat(f, 'g', -1, 32)
+		}
+	}
+	return v
+}
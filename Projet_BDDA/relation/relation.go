@@ -1,11 +1,13 @@
 package relation
 
 import (
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 )
 
 type ColumnKind int
@@ -15,22 +17,153 @@ const (
 	KindFloat
 	KindChar
 	KindVarchar
+	// KindBlob holds arbitrary bytes, always stored out of line in overflow pages.
+	KindBlob
 )
 
 type ColumnInfo struct {
 	Name string
 	Kind ColumnKind
-	Size int // for CHAR/VARCHAR: length; for INT/FLOAT ignored
+	Size int // for CHAR/VARCHAR: length; for INT/FLOAT/BLOB ignored
+	// Overflow marks the VARCHAR column stored out of line, in a chain of overflow
+	// pages, because the record would not fit in a page otherwise; the record only
+	// keeps a reference to the chain. It is derived from the page size by FitToPage
+	// and not saved.
+	Overflow bool `json:"-"`
+}
+
+// TypeString renders the column type as written in schemas: INT, FLOAT, CHAR(n),
+// VARCHAR(n) or BLOB. ParseColumnType is its inverse.
+func (c ColumnInfo) TypeString() string {
+	switch c.Kind {
+	case KindInt:
+		return "INT"
+	case KindFloat:
+		return "FLOAT"
+	case KindChar:
+		return fmt.Sprintf("CHAR(%d)", c.Size)
+	case KindVarchar:
+		return fmt.Sprintf("VARCHAR(%d)", c.Size)
+	case KindBlob:
+		return "BLOB"
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int(c.Kind))
+}
+
+var (
+	// ErrInvalidValue is returned for a value that is not valid for its column type.
+	ErrInvalidValue = errors.New("invalid value")
+	// ErrValueTooLong is returned for a string longer than its column allows when
+	// strings are not truncated, or a BLOB over 4 GiB.
+	ErrValueTooLong = errors.New("value too long")
+)
+
+// ParseText converts a value of c as written in commands and CSV files to the value
+// stored in records: BLOB values are written in base64 and stored as raw bytes, INT
+// values written in scientific notation (1e3) are stored as plain integers, other
+// values are stored as written. FormatText is its inverse.
+func (c ColumnInfo) ParseText(s string) (string, error) {
+	if c.Kind == KindInt {
+		if v, ok := intLiteral(s); ok {
+			return v, nil
+		}
+		return s, nil
+	}
+	if c.Kind != KindBlob {
+		return s, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("col %s: %w for BLOB, expected base64: %v", c.Name, ErrInvalidValue, err)
+	}
+	return string(b), nil
+}
+
+// FormatText renders a stored value of c for display: base64 for a BLOB, the value
+// itself otherwise.
+func (c ColumnInfo) FormatText(v string) string {
+	if c.Kind != KindBlob {
+		return v
+	}
+	return base64.StdEncoding.EncodeToString([]byte(v))
+}
+
+// intLiteral returns the integer written as s in scientific notation, such as 1e3 or
+// -2.5E2, if it is a whole number within the INT range.
+func intLiteral(s string) (string, bool) {
+	if !strings.ContainsAny(s, "eE") || strings.ContainsAny(s, "xX") {
+		return "", false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 {
+		return "", false
+	}
+	return strconv.Itoa(int(f)), true
+}
+
+// ParseColumnType parses a type as written in schemas (case-insensitive) and returns
+// its kind and size. REAL is accepted as an alias for FLOAT.
+func ParseColumnType(s string) (ColumnKind, int, error) {
+	s = strings.TrimSpace(s)
+	sUp := strings.ToUpper(s)
+	switch sUp {
+	case "INT":
+		return KindInt, 0, nil
+	case "FLOAT", "REAL":
+		return KindFloat, 0, nil
+	case "BLOB":
+		return KindBlob, 0, nil
+	}
+	for _, t := range []struct {
+		prefix string
+		kind   ColumnKind
+	}{{"CHAR(", KindChar}, {"VARCHAR(", KindVarchar}} {
+		if strings.HasPrefix(sUp, t.prefix) && strings.HasSuffix(sUp, ")") {
+			n, err := strconv.Atoi(sUp[len(t.prefix) : len(sUp)-1])
+			if err != nil {
+				return 0, 0, err
+			}
+			return t.kind, n, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("unknown column type: %s", s)
 }
 
 type Relation struct {
 	Name       string
 	Columns    []ColumnInfo
 	RecordSize int
+	// Strict rejects CHAR/VARCHAR values longer than the column size; when false
+	// (lenient mode, handy for imports) such values are truncated.
+	Strict bool
+	// SoftDelete makes DeleteRecord leave tombstones that Undelete can restore and
+	// Purge reclaims.
+	SoftDelete bool
+	// FillFactor, when between 0 and 1 (exclusive), is the share of a page's slots
+	// after which the page counts as full (see RelationManager.fullAt).
+	FillFactor float64
+	// ByteOrder encodes INT and FLOAT values (config byte_order); nil is
+	// little-endian.
+	ByteOrder binary.ByteOrder
+}
+
+// order returns r.ByteOrder, little-endian when unset.
+func (r *Relation) order() binary.ByteOrder {
+	if r.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return r.ByteOrder
 }
 
 func NewRelation(name string, cols []ColumnInfo) *Relation {
-	r := &Relation{Name: name, Columns: cols}
+	return &Relation{Name: name, Columns: cols, RecordSize: recordSize(cols)}
+}
+
+// overflowRefSize is the size an Overflow column takes in the record: the first page
+// of its chain (int32 fileIdx, int32 pageIdx) and the value length (uint32).
+const overflowRefSize = 12
+
+func recordSize(cols []ColumnInfo) int {
 	sz := 0
 	for _, c := range cols {
 		switch c.Kind {
@@ -38,15 +171,152 @@ func NewRelation(name string, cols []ColumnInfo) *Relation {
 			sz += 4
 		case KindFloat:
 			sz += 4
+		case KindChar, KindVarchar, KindBlob:
+			if c.outOfLine() {
+				sz += overflowRefSize
+			} else {
+				sz += c.Size
+			}
+		}
+	}
+	return sz
+}
+
+// outOfLine reports whether values of c are stored in overflow pages: BLOB columns
+// always, VARCHAR columns when FitToPage made them Overflow.
+func (c ColumnInfo) outOfLine() bool {
+	return c.Overflow || c.Kind == KindBlob
+}
+
+// Validate checks the schema itself: at least one column, non-empty unique column
+// names, known kinds, and a positive size for CHAR/VARCHAR columns.
+func (r *Relation) Validate() error {
+	if len(r.Columns) == 0 {
+		return fmt.Errorf("table %s: no columns", r.Name)
+	}
+	seen := make(map[string]bool, len(r.Columns))
+	for _, c := range r.Columns {
+		if c.Name == "" {
+			return fmt.Errorf("table %s: empty column name", r.Name)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("table %s: duplicate column %s", r.Name, c.Name)
+		}
+		seen[c.Name] = true
+		switch c.Kind {
+		case KindInt, KindFloat, KindBlob:
 		case KindChar, KindVarchar:
-			sz += c.Size
+			if c.Size <= 0 {
+				return fmt.Errorf("table %s: column %s: %s needs a positive size", r.Name, c.Name, c.TypeString())
+			}
+		default:
+			return fmt.Errorf("table %s: column %s: %s", r.Name, c.Name, c.TypeString())
 		}
 	}
-	r.RecordSize = sz
-	return r
+	return nil
+}
+
+// CheckFits reports an error when a single record of r does not fit in a data page
+// of pageSize bytes, next to the page reserve, the page header and its bytemap byte.
+func (r *Relation) CheckFits(pageSize, reserve int) error {
+	if computeSlotsPerPage(pageSize-reserve, r.RecordSize) <= 0 {
+		// the reserve, 20 header bytes and one bytemap byte leave the rest for the record
+		return fmt.Errorf("table %s: record size %d does not fit in a %d-byte page (at most %d bytes)", r.Name, r.RecordSize, pageSize, pageSize-reserve-21)
+	}
+	return nil
+}
+
+// FitToPage lays r out for data pages of pageSize bytes with reserve bytes reserved.
+// When a record does not fit in a page, its largest VARCHAR column is moved out of
+// line (see ColumnInfo.Overflow); CheckFits reports an error if it still does not fit.
+func (r *Relation) FitToPage(pageSize, reserve int) error {
+	cols := append([]ColumnInfo(nil), r.Columns...)
+	for i := range cols {
+		cols[i].Overflow = false
+	}
+	r.Columns, r.RecordSize = cols, recordSize(cols)
+	if r.CheckFits(pageSize, reserve) == nil {
+		return nil
+	}
+	big := -1
+	for i, c := range cols {
+		if c.Kind == KindVarchar && c.Size > overflowRefSize && (big < 0 || c.Size > cols[big].Size) {
+			big = i
+		}
+	}
+	if big >= 0 {
+		cols[big].Overflow = true
+		r.RecordSize = recordSize(cols)
+	}
+	return r.CheckFits(pageSize, reserve)
+}
+
+// overflowColumns returns the indexes of the columns of r stored out of line.
+func (r *Relation) overflowColumns() []int {
+	var out []int
+	for i, c := range r.Columns {
+		if c.outOfLine() {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// columnOffset returns the offset of column i within an encoded record.
+func (r *Relation) columnOffset(i int) int {
+	return recordSize(r.Columns[:i])
+}
+
+// stringBytes returns the bytes stored for val in the CHAR/VARCHAR/BLOB column col:
+// CHAR/VARCHAR values longer than col.Size are rejected in strict mode and truncated
+// otherwise.
+func (r *Relation) stringBytes(col ColumnInfo, val string) ([]byte, error) {
+	b := []byte(val)
+	if col.Kind == KindBlob {
+		if int64(len(b)) > math.MaxUint32 {
+			return nil, fmt.Errorf("col %s: %w: %d-byte BLOB", col.Name, ErrValueTooLong, len(b))
+		}
+		return b, nil
+	}
+	if len(b) > col.Size {
+		if r.Strict {
+			return nil, fmt.Errorf("col %s: %w: %q exceeds max length %d", col.Name, ErrValueTooLong, val, col.Size)
+		}
+		b = b[:col.Size]
+	}
+	return b, nil
+}
+
+// CheckRecord validates rec against the schema without encoding it, so callers can
+// reject bad values (with the offending column and value) before touching any page.
+// WriteRecordToBuffer still performs its own checks as a backstop.
+func (r *Relation) CheckRecord(rec *Record) error {
+	if len(rec.Values) != len(r.Columns) {
+		return fmt.Errorf("record arity mismatch: got %d values, want %d", len(rec.Values), len(r.Columns))
+	}
+	for i, col := range r.Columns {
+		val := rec.Values[i]
+		switch col.Kind {
+		case KindInt:
+			if _, err := strconv.ParseInt(val, 10, 32); err != nil {
+				return fmt.Errorf("col %s: %w %q for INT", col.Name, ErrInvalidValue, val)
+			}
+		case KindFloat:
+			if _, err := strconv.ParseFloat(val, 32); err != nil {
+				return fmt.Errorf("col %s: %w %q for FLOAT", col.Name, ErrInvalidValue, val)
+			}
+		case KindChar, KindVarchar:
+			if r.Strict && len(val) > col.Size {
+				return fmt.Errorf("col %s: %w: %q exceeds max length %d", col.Name, ErrValueTooLong, val, col.Size)
+			}
+		}
+	}
+	return nil
 }
 
 // writeRecordToBuffer writes the record into buff starting at pos. buff must be large enough.
+// The value of a column stored out of line is checked but not written: its reference is zeroed
+// and the RelationManager stores the value in overflow pages.
 func (r *Relation) WriteRecordToBuffer(rec *Record, buff []byte, pos int) error {
 	if len(rec.Values) != len(r.Columns) {
 		return errors.New("record arity mismatch")
@@ -54,31 +324,41 @@ func (r *Relation) WriteRecordToBuffer(rec *Record, buff []byte, pos int) error
 	if pos < 0 || pos+r.RecordSize > len(buff) {
 		return errors.New("buffer too small or pos out of range")
 	}
+	order := r.order()
 	off := pos
 	for i, col := range r.Columns {
 		val := rec.Values[i]
 		switch col.Kind {
 		case KindInt:
-			v, err := strconv.Atoi(val)
+			// INT is stored on 32 bits: larger values are rejected, not wrapped
+			v, err := strconv.ParseInt(val, 10, 32)
 			if err != nil {
-				return fmt.Errorf("col %s: invalid int: %v", col.Name, err)
+				return fmt.Errorf("col %s: %w %q for INT", col.Name, ErrInvalidValue, val)
 			}
-			binary.LittleEndian.PutUint32(buff[off:off+4], uint32(int32(v)))
+			order.PutUint32(buff[off:off+4], uint32(int32(v)))
 			off += 4
 		case KindFloat:
 			f, err := strconv.ParseFloat(val, 32)
 			if err != nil {
-				return fmt.Errorf("col %s: invalid float: %v", col.Name, err)
+				return fmt.Errorf("col %s: %w %q for FLOAT", col.Name, ErrInvalidValue, val)
 			}
 			bits := math.Float32bits(float32(f))
-			binary.LittleEndian.PutUint32(buff[off:off+4], bits)
+			order.PutUint32(buff[off:off+4], bits)
 			off += 4
-		case KindChar, KindVarchar:
-			// write up to col.Size bytes, pad with zeros
-			b := []byte(val)
-			if len(b) > col.Size {
-				b = b[:col.Size]
+		case KindChar, KindVarchar, KindBlob:
+			b, err := r.stringBytes(col, val)
+			if err != nil {
+				return err
 			}
+			if col.outOfLine() {
+				// the RelationManager stores the value and fills in the reference
+				for j := 0; j < overflowRefSize; j++ {
+					buff[off+j] = 0
+				}
+				off += overflowRefSize
+				continue
+			}
+			// write up to col.Size bytes, pad with zeros
 			copy(buff[off:off+col.Size], b)
 			// pad remainder
 			for j := len(b); j < col.Size; j++ {
@@ -91,24 +371,33 @@ func (r *Relation) WriteRecordToBuffer(rec *Record, buff []byte, pos int) error
 }
 
 // ReadFromBuffer reads a record from buff at pos and fills rec.Values (must be empty slice).
+// A column stored out of line reads as "" until the RelationManager loads it from its chain.
 func (r *Relation) ReadFromBuffer(rec *Record, buff []byte, pos int) error {
 	if pos < 0 || pos+r.RecordSize > len(buff) {
 		return errors.New("buffer too small or pos out of range")
 	}
 	rec.Values = make([]string, 0, len(r.Columns))
+	rec.Bind(r)
+	order := r.order()
 	off := pos
 	for _, col := range r.Columns {
 		switch col.Kind {
 		case KindInt:
-			v := int32(binary.LittleEndian.Uint32(buff[off : off+4]))
+			v := int32(order.Uint32(buff[off : off+4]))
 			rec.Values = append(rec.Values, strconv.FormatInt(int64(v), 10))
 			off += 4
 		case KindFloat:
-			bits := binary.LittleEndian.Uint32(buff[off : off+4])
+			bits := order.Uint32(buff[off : off+4])
 			f := math.Float32frombits(bits)
 			rec.Values = append(rec.Values, fmt.Sprintf("%g", f))
 			off += 4
-		case KindChar, KindVarchar:
+		case KindChar, KindVarchar, KindBlob:
+			if col.outOfLine() {
+				// left for the RelationManager to read from the overflow chain
+				rec.Values = append(rec.Values, "")
+				off += overflowRefSize
+				continue
+			}
 			b := buff[off : off+col.Size]
 			// trim trailing zeros
 			end := col.Size
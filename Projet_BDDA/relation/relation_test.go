@@ -1,6 +1,8 @@
 package relation
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +33,242 @@ func TestWriteReadRecord(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckRecordInvalidNumbers(t *testing.T) {
+	rel := NewRelation("t", []ColumnInfo{{Name: "id", Kind: KindInt}, {Name: "score", Kind: KindFloat}})
+	if err := rel.CheckRecord(NewRecord("1", "2.5")); err != nil {
+		t.Fatalf("valid record rejected: %v", err)
+	}
+	err := rel.CheckRecord(NewRecord("abc", "2.5"))
+	if err == nil || !strings.Contains(err.Error(), "id") || !strings.Contains(err.Error(), "abc") {
+		t.Fatalf("expected invalid int error naming column and value, got %v", err)
+	}
+	err = rel.CheckRecord(NewRecord("1", "x1"))
+	if err == nil || !strings.Contains(err.Error(), "score") || !strings.Contains(err.Error(), "x1") {
+		t.Fatalf("expected invalid float error naming column and value, got %v", err)
+	}
+	if err := rel.CheckRecord(NewRecord("1")); err == nil {
+		t.Fatalf("expected arity error")
+	}
+	// INT is 32-bit: the bounds are stored as is, one past them is rejected
+	buf := make([]byte, rel.RecordSize)
+	for _, v := range []string{"2147483647", "-2147483648"} {
+		if err := rel.CheckRecord(NewRecord(v, "0")); err != nil {
+			t.Fatalf("%s rejected: %v", v, err)
+		}
+		if err := rel.WriteRecordToBuffer(NewRecord(v, "0"), buf, 0); err != nil {
+			t.Fatalf("write %s: %v", v, err)
+		}
+		var got Record
+		if err := rel.ReadFromBuffer(&got, buf, 0); err != nil || got.Values[0] != v {
+			t.Fatalf("%s read back as %v, %v", v, got.Values, err)
+		}
+	}
+	for _, v := range []string{"2147483648", "-2147483649", "3000000000"} {
+		if err := rel.CheckRecord(NewRecord(v, "0")); !errors.Is(err, ErrInvalidValue) {
+			t.Fatalf("CheckRecord(%s) = %v, want ErrInvalidValue", v, err)
+		}
+		if err := rel.WriteRecordToBuffer(NewRecord(v, "0"), buf, 0); !errors.Is(err, ErrInvalidValue) {
+			t.Fatalf("WriteRecordToBuffer(%s) = %v, want ErrInvalidValue", v, err)
+		}
+	}
+}
+
+func TestStringLengthStrictAndLenient(t *testing.T) {
+	cols := []ColumnInfo{{Name: "code", Kind: KindChar, Size: 3}, {Name: "note", Kind: KindVarchar, Size: 4}}
+	rel := NewRelation("t", cols)
+	buf := make([]byte, rel.RecordSize)
+
+	// lenient: values are truncated to the column size
+	if err := rel.WriteRecordToBuffer(NewRecord("ABCD", "hello"), buf, 0); err != nil {
+		t.Fatalf("lenient write: %v", err)
+	}
+	got := &Record{}
+	if err := rel.ReadFromBuffer(got, buf, 0); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got.Values[0] != "ABC" || got.Values[1] != "hell" {
+		t.Fatalf("expected truncated values, got %v", got.Values)
+	}
+
+	// strict: over-length values are rejected with the column name and size
+	rel.Strict = true
+	err := rel.CheckRecord(NewRecord("AB", "hello"))
+	if err == nil || !strings.Contains(err.Error(), "note") || !strings.Contains(err.Error(), "4") {
+		t.Fatalf("expected length error for note, got %v", err)
+	}
+	if err := rel.WriteRecordToBuffer(NewRecord("ABCD", "x"), buf, 0); err == nil {
+		t.Fatalf("expected strict write to reject over-length CHAR")
+	}
+	if err := rel.CheckRecord(NewRecord("ABC", "hell")); err != nil {
+		t.Fatalf("values at max length must be accepted: %v", err)
+	}
+}
+
+func TestColumnTypeStringRoundTrip(t *testing.T) {
+	for _, c := range []ColumnInfo{
+		{Kind: KindInt},
+		{Kind: KindFloat},
+		{Kind: KindChar, Size: 5},
+		{Kind: KindVarchar, Size: 120},
+	} {
+		s := c.TypeString()
+		kind, size, err := ParseColumnType(s)
+		if err != nil {
+			t.Fatalf("ParseColumnType(%q): %v", s, err)
+		}
+		if kind != c.Kind || size != c.Size {
+			t.Fatalf("%q parsed as kind %v size %d, want %v %d", s, kind, size, c.Kind, c.Size)
+		}
+	}
+	for in, want := range map[string]string{"int": "INT", " real ": "FLOAT", "varchar(3)": "VARCHAR(3)"} {
+		kind, size, err := ParseColumnType(in)
+		if err != nil {
+			t.Fatalf("ParseColumnType(%q): %v", in, err)
+		}
+		if got := (ColumnInfo{Kind: kind, Size: size}).TypeString(); got != want {
+			t.Fatalf("ParseColumnType(%q) renders as %q, want %q", in, got, want)
+		}
+	}
+	for _, bad := range []string{"TEXT", "CHAR(x)", "VARCHAR(", ""} {
+		if _, _, err := ParseColumnType(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestTypedRecordAccessors(t *testing.T) {
+	rel := NewRelation("t", []ColumnInfo{
+		{Name: "id", Kind: KindInt},
+		{Name: "score", Kind: KindFloat},
+		{Name: "code", Kind: KindVarchar, Size: 5},
+	})
+	rec, err := NewTypedRecord(rel, 42, 2.5, "abc")
+	if err != nil {
+		t.Fatalf("NewTypedRecord: %v", err)
+	}
+	// INT is 32-bit, FLOAT takes any integer
+	if _, err := NewTypedRecord(rel, int64(3000000000), 2.5, "abc"); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("NewTypedRecord with an INT out of range = %v, want ErrInvalidValue", err)
+	}
+	if _, err := NewTypedRecord(rel, int32(-2147483648), int64(3000000000), "abc"); err != nil {
+		t.Fatalf("NewTypedRecord at the INT bound: %v", err)
+	}
+	if got := strings.Join(rec.Values, ","); got != "42,2.5,abc" {
+		t.Fatalf("values = %q", got)
+	}
+	if v, err := rec.Int(0); err != nil || v != 42 {
+		t.Fatalf("Int(0) = %d, %v", v, err)
+	}
+	if v, err := rec.Float(0); err != nil || v != 42 {
+		t.Fatalf("Float(0) = %g, %v", v, err)
+	}
+	if v, err := rec.Float(1); err != nil || v != 2.5 {
+		t.Fatalf("Float(1) = %g, %v", v, err)
+	}
+	if v, err := rec.String(2); err != nil || v != "abc" {
+		t.Fatalf("String(2) = %q, %v", v, err)
+	}
+	if _, err := rec.Int(1); err == nil {
+		t.Fatalf("Int on a FLOAT column should fail")
+	}
+	if _, err := rec.Float(2); err == nil {
+		t.Fatalf("Float on a VARCHAR column should fail")
+	}
+	if _, err := rec.String(3); err == nil {
+		t.Fatalf("out of range column should fail")
+	}
+
+	// the cache must follow changes to Values
+	rec.Values[0] = "7"
+	if v, err := rec.Int(0); err != nil || v != 7 {
+		t.Fatalf("Int(0) after update = %d, %v", v, err)
+	}
+
+	if _, err := NewTypedRecord(rel, "x", 1.0, "abc"); err == nil {
+		t.Fatalf("string for INT column should be rejected")
+	}
+	rel.Strict = true
+	if _, err := NewTypedRecord(rel, 1, 1.0, "toolong"); err == nil {
+		t.Fatalf("oversized string should be rejected")
+	}
+}
+
+func TestTypedAccessorsMalformedValues(t *testing.T) {
+	rel := NewRelation("t", []ColumnInfo{{Name: "id", Kind: KindInt}, {Name: "score", Kind: KindFloat}})
+	rec := NewRecord("1.5", "abc")
+	if _, err := rec.Int(0); err == nil {
+		t.Fatalf("unbound record should fail")
+	}
+	rec.Bind(rel)
+	if _, err := rec.Int(0); err == nil || !strings.Contains(err.Error(), "id") {
+		t.Fatalf("malformed INT: got %v", err)
+	}
+	if _, err := rec.Float(1); err == nil {
+		t.Fatalf("malformed FLOAT should fail")
+	}
+	rec.Values[1] = "3"
+	if v, err := rec.Float(1); err != nil || v != 3 {
+		t.Fatalf("Float after fix = %g, %v", v, err)
+	}
+
+	// records read from a page come back bound
+	buf := make([]byte, rel.RecordSize)
+	if err := rel.WriteRecordToBuffer(NewRecord("9", "0.5"), buf, 0); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	r2 := &Record{}
+	if err := rel.ReadFromBuffer(r2, buf, 0); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if v, err := r2.Int(0); err != nil || v != 9 {
+		t.Fatalf("Int on read record = %d, %v", v, err)
+	}
+}
+
+func TestRelationValidate(t *testing.T) {
+	ok := NewRelation("T", []ColumnInfo{{Name: "id", Kind: KindInt}, {Name: "c", Kind: KindChar, Size: 1}})
+	if err := ok.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cases := []struct {
+		cols []ColumnInfo
+		want string
+	}{
+		{nil, "no columns"},
+		{[]ColumnInfo{{Name: "c", Kind: KindChar, Size: 0}}, "CHAR(0) needs a positive size"},
+		{[]ColumnInfo{{Name: "v", Kind: KindVarchar, Size: -3}}, "VARCHAR(-3) needs a positive size"},
+		{[]ColumnInfo{{Name: "id", Kind: KindInt}, {Name: "id", Kind: KindFloat}}, "duplicate column id"},
+		{[]ColumnInfo{{Name: "", Kind: KindInt}}, "empty column name"},
+	}
+	for _, c := range cases {
+		err := NewRelation("T", c.cols).Validate()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("Validate(%+v) = %v, want an error containing %q", c.cols, err, c.want)
+		}
+	}
+}
+
+func TestRecordKeyByColumnType(t *testing.T) {
+	rel := NewRelation("t", []ColumnInfo{
+		{Name: "n", Kind: KindInt},
+		{Name: "f", Kind: KindFloat},
+		{Name: "s", Kind: KindVarchar, Size: 8},
+	})
+	for _, c := range []struct {
+		a, b  []string
+		equal bool
+	}{
+		{[]string{"1", "1.5", "x"}, []string{"01", "1.50", "x"}, true},
+		{[]string{"-0", "-0", "x"}, []string{"0", "0", "x"}, true},
+		{[]string{"1e3", "2", "x"}, []string{"1000", "2.0", "x"}, true},
+		{[]string{"1", "1.5", "01"}, []string{"1", "1.5", "1"}, false},
+		{[]string{"1", "1.5", "x"}, []string{"2", "1.5", "x"}, false},
+		{[]string{"1", "1.5", "x:"}, []string{"1", "1.5:", "x"}, false},
+	} {
+		a, b := NewRecord(c.a...), NewRecord(c.b...)
+		if got := rel.RecordEquals(a, b); got != c.equal {
+			t.Fatalf("RecordEquals(%v, %v) = %v, want %v", c.a, c.b, got, c.equal)
+		}
+	}
+}
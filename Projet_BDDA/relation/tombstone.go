@@ -0,0 +1,186 @@
+package relation
+
+import (
+	"fmt"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// Bytemap values of a data page slot. With Relation.SoftDelete set, DeleteRecord marks
+// a slot as a tombstone: scans skip it, but it still occupies the slot (inserts do not
+// reuse it) until Purge frees it or Undelete brings the record back.
+const (
+	slotFree      = 0
+	slotUsed      = 1
+	slotTombstone = 2
+)
+
+// UndeleteRecord restores the soft-deleted record at rid.
+func (rm *RelationManager) UndeleteRecord(rid RecordId) error {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	bf, err := rm.bm.GetPage(rid.PageId)
+	if err != nil {
+		return err
+	}
+	bf.Lock()
+	slots := rm.header(bf).NumSlots()
+	if rid.SlotIdx < 0 || rid.SlotIdx >= slots {
+		bf.Unlock()
+		_ = rm.bm.FreePage(rid.PageId, false)
+		return fmt.Errorf("%w: invalid slot index %d", ErrRecordNotFound, rid.SlotIdx)
+	}
+	if rm.page(bf)[20+rid.SlotIdx] != slotTombstone {
+		bf.Unlock()
+		_ = rm.bm.FreePage(rid.PageId, false)
+		return fmt.Errorf("%w: slot %v is not deleted", ErrRecordNotFound, rid)
+	}
+	rm.page(bf)[20+rid.SlotIdx] = slotUsed
+	bf.Unlock()
+	return rm.bm.FreePage(rid.PageId, true)
+}
+
+// Undelete restores every soft-deleted record and returns how many were restored.
+// Page lists are untouched: a tombstone already counts as an occupied slot.
+func (rm *RelationManager) Undelete() (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	pages, _, err := rm.listedPages()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, pid := range pages {
+		c, _, err := rm.rewriteTombstones(pid, slotUsed)
+		if err != nil {
+			return n, err
+		}
+		n += c
+	}
+	return n, nil
+}
+
+// Purge frees every tombstoned slot, moving full pages that regain space to the
+// with-space list, and returns the number of slots reclaimed.
+func (rm *RelationManager) Purge() (int, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	pages, full, err := rm.listedPages()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, pid := range pages {
+		c, used, err := rm.rewriteTombstones(pid, slotFree)
+		if err != nil {
+			return n, err
+		}
+		n += c
+		if c > 0 && full[pid] && used < rm.fullAt(rm.SlotsPerPage()) {
+			if err := rm.unlinkFromFull(pid); err != nil {
+				return n, err
+			}
+			if err := rm.prependToWithSpace(pid); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// DeletedCount returns the number of soft-deleted records waiting for Purge or
+// Undelete.
+func (rm *RelationManager) DeletedCount() (int, error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	pages, _, err := rm.listedPages()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, pid := range pages {
+		bf, err := rm.bm.GetPage(pid)
+		if err != nil {
+			return n, err
+		}
+		bf.RLock()
+		slots := rm.header(bf).NumSlots()
+		for i := 0; i < slots; i++ {
+			if rm.page(bf)[20+i] == slotTombstone {
+				n++
+			}
+		}
+		bf.RUnlock()
+		if err := rm.bm.FreePage(pid, false); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// rewriteTombstones sets every tombstone of pid to to (zeroing the record bytes when
+// freeing) and returns how many slots changed and how many are used afterwards.
+func (rm *RelationManager) rewriteTombstones(pid config.PageId, to byte) (int, int, error) {
+	bf, err := rm.bm.GetPage(pid)
+	if err != nil {
+		return 0, 0, err
+	}
+	bf.Lock()
+	slots := rm.header(bf).NumSlots()
+	n := 0
+	var refs []overflowRef
+	for i := 0; i < slots; i++ {
+		if rm.page(bf)[20+i] != slotTombstone {
+			continue
+		}
+		rm.page(bf)[20+i] = to
+		if to == slotFree {
+			pos := 20 + slots + i*rm.Rel.RecordSize
+			refs = append(refs, rm.overflowRefs(rm.page(bf), pos)...)
+			for j := pos; j < pos+rm.Rel.RecordSize; j++ {
+				rm.page(bf)[j] = 0
+			}
+		}
+		n++
+	}
+	used := usedSlots(rm.page(bf), slots)
+	bf.Unlock()
+	if err := rm.bm.FreePage(pid, n > 0); err != nil {
+		return n, used, err
+	}
+	return n, used, rm.freeOverflow(refs)
+}
+
+// listedPages returns the pages of both lists, with-space first, and the set of those
+// on the full list. A cycle ends the walk of its list. Caller must hold rm.mu.
+func (rm *RelationManager) listedPages() ([]config.PageId, map[config.PageId]bool, error) {
+	var pages []config.PageId
+	full := make(map[config.PageId]bool)
+	if rm.HeaderPageId == invalidPage {
+		return nil, full, nil
+	}
+	whead, err := rm.headerFirstWithSpace()
+	if err != nil {
+		return nil, nil, err
+	}
+	fhead, err := rm.headerFirstFull()
+	if err != nil {
+		return nil, nil, err
+	}
+	visited := make(map[config.PageId]bool)
+	for i, head := range []config.PageId{whead, fhead} {
+		for pid := head; pid != invalidPage && !visited[pid]; {
+			visited[pid] = true
+			pages = append(pages, pid)
+			if i == 1 {
+				full[pid] = true
+			}
+			nx, err := rm.pageNext(pid)
+			if err != nil {
+				return nil, nil, err
+			}
+			pid = nx
+		}
+	}
+	return pages, full, nil
+}
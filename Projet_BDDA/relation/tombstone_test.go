@@ -0,0 +1,124 @@
+package relation
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSoftDeleteUndeleteAndPurge(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	rm.Rel.SoftDelete = true
+	perPage := rm.dm.PageSize() / rm.Rel.RecordSize
+	n := perPage * 2
+	var rids []RecordId
+	for i := 0; i < n; i++ {
+		rid, err := rm.InsertRecord(NewRecord(fmt.Sprint(i), "x"))
+		if err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		rids = append(rids, rid)
+	}
+	total := countRecords(t, rm)
+	pages, err := rm.AllPageIds()
+	if err != nil {
+		t.Fatalf("AllPageIds: %v", err)
+	}
+
+	// delete: hidden from scans, slot still taken
+	for _, rid := range rids[:3] {
+		if err := rm.DeleteRecord(rid); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	if err := rm.DeleteRecord(rids[0]); err == nil {
+		t.Fatalf("deleting a tombstone twice should fail")
+	}
+	if got := countRecords(t, rm); got != total-3 {
+		t.Fatalf("after soft delete: %d records, want %d", got, total-3)
+	}
+	rid, err := rm.InsertRecord(NewRecord("99", "new"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	for _, old := range rids[:3] {
+		if rid == old {
+			t.Fatalf("insert reused tombstoned slot %v", rid)
+		}
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity after soft delete: %v", err)
+	}
+
+	// undelete one, then all
+	if err := rm.UndeleteRecord(rids[0]); err != nil {
+		t.Fatalf("UndeleteRecord: %v", err)
+	}
+	if err := rm.UndeleteRecord(rids[0]); err == nil {
+		t.Fatalf("undeleting a live record should fail")
+	}
+	if got, err := rm.Undelete(); err != nil || got != 2 {
+		t.Fatalf("Undelete = %d, %v; want 2", got, err)
+	}
+	if got := countRecords(t, rm); got != total+1 {
+		t.Fatalf("after undelete: %d records, want %d", got, total+1)
+	}
+
+	// purge reclaims the slots and refiles full pages
+	for _, rid := range rids[:perPage] {
+		if err := rm.DeleteRecord(rid); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+	}
+	if got, err := rm.Purge(); err != nil || got != perPage {
+		t.Fatalf("Purge = %d, %v; want %d", got, err, perPage)
+	}
+	if got, err := rm.Undelete(); err != nil || got != 0 {
+		t.Fatalf("Undelete after purge = %d, %v; want 0", got, err)
+	}
+	if err := rm.CheckIntegrity(); err != nil {
+		t.Fatalf("integrity after purge: %v", err)
+	}
+	if got := countRecords(t, rm); got != total+1-perPage {
+		t.Fatalf("after purge: %d records, want %d", got, total+1-perPage)
+	}
+	before := len(pages)
+	for i := 0; i < perPage; i++ {
+		if _, err := rm.InsertRecord(NewRecord("7", "refill")); err != nil {
+			t.Fatalf("refill: %v", err)
+		}
+	}
+	pages, err = rm.AllPageIds()
+	if err != nil {
+		t.Fatalf("AllPageIds: %v", err)
+	}
+	if len(pages) > before+1 {
+		t.Fatalf("purged slots not reused: %d pages, had %d", len(pages), before)
+	}
+	if err := pool(rm).AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeRecordIgnoresSoftDelete(t *testing.T) {
+	rm, cleanup := setup(t)
+	defer cleanup()
+	rm.Rel.SoftDelete = true
+	rid, err := rm.InsertRecord(NewRecord("1", "a"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if err := rm.PurgeRecord(rid); err != nil {
+		t.Fatalf("PurgeRecord: %v", err)
+	}
+	if got, err := rm.Undelete(); err != nil || got != 0 {
+		t.Fatalf("Undelete = %d, %v; want 0", got, err)
+	}
+	again, err := rm.InsertRecord(NewRecord("2", "b"))
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if again != rid {
+		t.Fatalf("purged slot not reused: got %v want %v", again, rid)
+	}
+}
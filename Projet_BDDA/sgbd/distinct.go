@@ -0,0 +1,220 @@
+package sgbd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// distinctPartitions is how many hash partitions a spilled DISTINCT is split into.
+const distinctPartitions = 16
+
+// rowDistinct drops duplicate rows while keeping the first occurrence of each, in
+// arrival order. Rows are equal when key gives them the same key. Up to limit distinct rows are tracked in memory; past that every
+// row, numbered by arrival, is written to one of distinctPartitions temporary files
+// chosen by hashing the row, so duplicates always land in the same partition. Each
+// then dedupes one partition at a time and restores the arrival order with a
+// rowSorter on the row numbers. Close removes the temporary files and must be
+// called, also on error.
+type rowDistinct struct {
+	dir   string
+	limit int
+	key   func(row []string) string
+	seen  map[string]bool
+	rows  [][]string
+	seq   int
+	tmp   string
+	files []*os.File
+	parts []*csv.Writer
+}
+
+// newRowDistinct returns a rowDistinct comparing rows with key, or with distinctKey
+// (every value as written) if key is nil.
+func newRowDistinct(dir string, limit int, key func(row []string) string) *rowDistinct {
+	if limit <= 0 {
+		limit = 1
+	}
+	if key == nil {
+		key = distinctKey
+	}
+	return &rowDistinct{dir: dir, limit: limit, key: key, seen: make(map[string]bool)}
+}
+
+// distinctKey encodes row so that two rows get the same key only if all their
+// values are equal.
+func distinctKey(row []string) string {
+	var b strings.Builder
+	for _, v := range row {
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// Add offers row; it is kept unless an equal row was added before.
+func (d *rowDistinct) Add(row []string) error {
+	seq := d.seq
+	d.seq++
+	if d.tmp != "" {
+		return d.write(seq, row)
+	}
+	key := d.key(row)
+	if d.seen[key] {
+		return nil
+	}
+	d.seen[key] = true
+	d.rows = append(d.rows, row)
+	if len(d.rows) > d.limit {
+		return d.spill()
+	}
+	return nil
+}
+
+// spill moves the rows kept so far into the partitions and stops tracking keys in
+// memory. The kept rows are unique, so their index stands in for their row number.
+func (d *rowDistinct) spill() error {
+	tmp, err := os.MkdirTemp(d.dir, "distinct-")
+	if err != nil {
+		return err
+	}
+	d.tmp = tmp
+	for i := 0; i < distinctPartitions; i++ {
+		f, err := os.Create(filepath.Join(tmp, fmt.Sprintf("part%d.csv", i)))
+		if err != nil {
+			return err
+		}
+		d.files = append(d.files, f)
+		d.parts = append(d.parts, csv.NewWriter(f))
+	}
+	for i, row := range d.rows {
+		if err := d.write(i, row); err != nil {
+			return err
+		}
+	}
+	d.rows = nil
+	d.seen = nil
+	return nil
+}
+
+// write appends row, prefixed with its row number, to its hash partition.
+func (d *rowDistinct) write(seq int, row []string) error {
+	h := fnv.New32a()
+	h.Write([]byte(d.key(row)))
+	rec := append([]string{strconv.Itoa(seq)}, row...)
+	return d.parts[h.Sum32()%distinctPartitions].Write(rec)
+}
+
+// Spilled reports whether the rows went to the partition files.
+func (d *rowDistinct) Spilled() bool {
+	return d.tmp != ""
+}
+
+// Each calls fn with every distinct row, in order of first occurrence.
+func (d *rowDistinct) Each(fn func(row []string) error) error {
+	if d.tmp == "" {
+		for _, row := range d.rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i, w := range d.parts {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		if err := d.files[i].Close(); err != nil {
+			return err
+		}
+	}
+	d.parts = nil
+	bySeq := func(a, b []string) bool {
+		x, _ := strconv.Atoi(a[0])
+		y, _ := strconv.Atoi(b[0])
+		return x < y
+	}
+	sorter := newRowSorter(d.tmp, d.limit, bySeq)
+	defer sorter.Close()
+	for _, f := range d.files {
+		if err := dedupePartition(f.Name(), d.key, sorter.Add); err != nil {
+			return err
+		}
+	}
+	return sorter.Each(func(row []string) error {
+		return fn(row[1:])
+	})
+}
+
+// dedupePartition passes the first occurrence of each row of a partition file, by
+// key, to add. Rows are written in arrival order, so the first one read is the first
+// seen.
+func dedupePartition(path string, key func(row []string) string, add func(row []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	seen := make(map[string]bool)
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		k := key(rec[1:])
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if err := add(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Close removes the partition files.
+func (d *rowDistinct) Close() error {
+	d.rows = nil
+	if d.tmp == "" {
+		return nil
+	}
+	for _, f := range d.files {
+		f.Close()
+	}
+	err := os.RemoveAll(d.tmp)
+	d.tmp = ""
+	d.files = nil
+	d.parts = nil
+	return err
+}
+
+// rowKey returns a key function for rows with the given columns: Relation.RecordKey
+// over a relation of the columns' types, so numbers compare by value. A column whose
+// type is not a column type, like ROWID, compares as text.
+func rowKey(cols []ResultColumn) func(row []string) string {
+	rc := make([]relation.ColumnInfo, len(cols))
+	for i, c := range cols {
+		rc[i] = relation.ColumnInfo{Name: c.Name, Kind: relation.KindVarchar}
+		if kind, size, err := relation.ParseColumnType(c.Type); err == nil {
+			rc[i].Kind, rc[i].Size = kind, size
+		}
+	}
+	rel := relation.NewRelation("", rc)
+	return func(row []string) string {
+		return rel.RecordKey(&relation.Record{Values: row})
+	}
+}
@@ -0,0 +1,159 @@
+package sgbd
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+func TestRowDistinctSpilledMatchesInMemory(t *testing.T) {
+	var rows [][]string
+	for i := 0; i < 200; i++ {
+		// values with separators and quotes must not collide once encoded
+		rows = append(rows, []string{fmt.Sprint((i * 13) % 37), strings.Repeat("a,\"", i%3)})
+	}
+	run := func(limit int) ([][]string, bool) {
+		dir := t.TempDir()
+		d := newRowDistinct(dir, limit, nil)
+		defer d.Close()
+		for _, r := range rows {
+			if err := d.Add(r); err != nil {
+				t.Fatalf("Add: %v", err)
+			}
+		}
+		spilled := d.Spilled()
+		var out [][]string
+		if err := d.Each(func(row []string) error {
+			out = append(out, row)
+			return nil
+		}); err != nil {
+			t.Fatalf("Each: %v", err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if left, _ := filepath.Glob(filepath.Join(dir, "distinct-*")); len(left) != 0 {
+			t.Fatalf("temporary partitions left behind: %v", left)
+		}
+		return out, spilled
+	}
+	want, spilled := run(1000)
+	if spilled {
+		t.Fatalf("a large limit should not spill")
+	}
+	if len(want) != 111 {
+		t.Fatalf("%d distinct rows, want 111", len(want))
+	}
+	got, spilled := run(7)
+	if !spilled {
+		t.Fatalf("a limit of 7 should spill")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("spilled DISTINCT differs from the in-memory one:\n%v\n%v", got, want)
+	}
+	if distinctKey([]string{"a", "b:c"}) == distinctKey([]string{"a:b", "c"}) {
+		t.Fatalf("distinctKey collides on separators")
+	}
+}
+
+func TestSelectDistinctWithLowThreshold(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	cfg.DistinctMemoryRows = 3
+	cfg.SortMemoryRows = 4
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	if _, err := s.Execute("CREATE TABLE Emp (id:INT,dept:VARCHAR(4),grade:INT)"); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	for i := 0; i < 60; i++ {
+		if _, err := s.Execute(fmt.Sprintf(`INSERT INTO Emp VALUES (%d,"d%d",%d)`, i, i%4, i%3)); err != nil {
+			t.Fatalf("INSERT: %v", err)
+		}
+	}
+	res, err := s.Execute("SELECT DISTINCT e.dept, e.grade FROM Emp e WHERE e.id >= 0")
+	if err != nil {
+		t.Fatalf("SELECT DISTINCT: %v", err)
+	}
+	if res.Count != 12 || len(res.Rows) != 12 {
+		t.Fatalf("got %d rows, want the 12 dept/grade pairs: %v", len(res.Rows), res.Rows)
+	}
+	seen := make(map[string]bool)
+	for _, r := range res.Rows {
+		k := strings.Join(r, "|")
+		if seen[k] {
+			t.Fatalf("duplicate row %v", r)
+		}
+		seen[k] = true
+	}
+
+	res, err = s.Execute("SELECT DISTINCT e.dept FROM Emp e ORDER BY e.dept DESC")
+	if err != nil {
+		t.Fatalf("SELECT DISTINCT ORDER BY: %v", err)
+	}
+	if want := [][]string{{"d3"}, {"d2"}, {"d1"}, {"d0"}}; !reflect.DeepEqual(res.Rows, want) {
+		t.Fatalf("rows = %v, want %v", res.Rows, want)
+	}
+	for _, pattern := range []string{"distinct-*", "sort-*"} {
+		if left, _ := filepath.Glob(filepath.Join(dir, pattern)); len(left) != 0 {
+			t.Fatalf("temporary files left behind: %v", left)
+		}
+	}
+}
+
+func TestSelectDistinctComparesByColumnType(t *testing.T) {
+	for _, memRows := range []int{1, config.DefaultDistinctMemoryRows} {
+		t.Run(fmt.Sprintf("memory=%d", memRows), func(t *testing.T) {
+			d := newRowDistinct(t.TempDir(), memRows, rowKey([]ResultColumn{{Name: "n", Type: "INT"}, {Name: "s", Type: "VARCHAR(4)"}}))
+			defer d.Close()
+			for _, row := range [][]string{{"1", "1"}, {"01", "1"}, {"1", "01"}, {"001", "01"}} {
+				if err := d.Add(row); err != nil {
+					t.Fatalf("Add: %v", err)
+				}
+			}
+			var got [][]string
+			if err := d.Each(func(row []string) error {
+				got = append(got, row)
+				return nil
+			}); err != nil {
+				t.Fatalf("Each: %v", err)
+			}
+			// "1" and "01" collapse on the INT column and stay apart on the VARCHAR one
+			if want := [][]string{{"1", "1"}, {"1", "01"}}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("rows = %v, want %v", got, want)
+			}
+		})
+	}
+
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	for _, cmd := range []string{
+		"CREATE TABLE T (n:INT,s:VARCHAR(4))",
+		`INSERT INTO T VALUES (1,"1")`,
+		`INSERT INTO T VALUES (01,"01")`,
+	} {
+		if _, err := s.Execute(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	for cmd, want := range map[string]int{
+		"SELECT DISTINCT t.n FROM T t": 1,
+		"SELECT DISTINCT t.s FROM T t": 2,
+	} {
+		res, err := s.Execute(cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		if res.Count != want {
+			t.Fatalf("%s returned %v, want %d rows", cmd, res.Rows, want)
+		}
+	}
+}
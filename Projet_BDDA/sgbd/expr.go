@@ -0,0 +1,303 @@
+package sgbd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// expr is a small arithmetic expression over a record's columns and constants, e.g.
+// e.salary * 1.1 or (e.a + e.b) / 2. Supported operators: + - * / and unary minus.
+type expr struct {
+	op     byte // 0 for leaves, otherwise one of + - * / or 'n' for unary minus
+	left   *expr
+	right  *expr
+	colIdx int    // column leaf when >= 0
+	val    string // constant leaf text (quotes stripped)
+	quoted bool   // constant was a quoted string literal
+}
+
+// exprValue is the result of evaluating an expr: either a number or a string.
+type exprValue struct {
+	isNum bool
+	num   float64
+	str   string
+}
+
+// isConst reports whether e is a single constant (no columns, no operators).
+func (e *expr) isConst() bool {
+	return e.op == 0 && e.colIdx < 0
+}
+
+// numeric reports whether e yields a number for the given relation.
+func (e *expr) numeric(rel *relation.Relation) bool {
+	if e.op != 0 {
+		return true
+	}
+	if e.colIdx >= 0 {
+		k := rel.Columns[e.colIdx].Kind
+		return k == relation.KindInt || k == relation.KindFloat
+	}
+	if e.quoted {
+		return false
+	}
+	_, err := strconv.ParseFloat(e.val, 64)
+	return err == nil
+}
+
+// parseExpr parses text as an arithmetic expression. Column references must use
+// alias.col; unquoted words that are neither columns nor numbers are kept as string
+// constants for compatibility with the historical unquoted SET syntax.
+func parseExpr(text string, rel *relation.Relation, alias string) (*expr, error) {
+	toks, err := tokenizeExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	p := &exprParser{toks: toks, rel: rel, alias: alias}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("%w: unexpected %q in expression %q", ErrSyntax, p.toks[p.pos], text)
+	}
+	if err := e.check(rel); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// check rejects arithmetic over non-numeric operands.
+func (e *expr) check(rel *relation.Relation) error {
+	if e.op == 0 {
+		return nil
+	}
+	for _, sub := range []*expr{e.left, e.right} {
+		if sub == nil {
+			continue
+		}
+		if err := sub.check(rel); err != nil {
+			return err
+		}
+		if !sub.numeric(rel) {
+			return fmt.Errorf("arithmetic on non-numeric operand")
+		}
+	}
+	return nil
+}
+
+// eval evaluates e against rec.
+func (e *expr) eval(rec *relation.Record, rel *relation.Relation) (exprValue, error) {
+	switch e.op {
+	case 0:
+		if e.colIdx >= 0 {
+			raw := rec.Values[e.colIdx]
+			col := rel.Columns[e.colIdx]
+			if col.Kind == relation.KindInt || col.Kind == relation.KindFloat {
+				f, err := strconv.ParseFloat(raw, 64)
+				if err != nil {
+					return exprValue{}, fmt.Errorf("col %s: invalid number %q", col.Name, raw)
+				}
+				return exprValue{isNum: true, num: f}, nil
+			}
+			return exprValue{str: raw}, nil
+		}
+		if !e.quoted {
+			if f, err := strconv.ParseFloat(e.val, 64); err == nil {
+				return exprValue{isNum: true, num: f, str: e.val}, nil
+			}
+		}
+		return exprValue{str: e.val}, nil
+	case 'n':
+		v, err := e.left.eval(rec, rel)
+		if err != nil {
+			return exprValue{}, err
+		}
+		return exprValue{isNum: true, num: -v.num}, nil
+	}
+	l, err := e.left.eval(rec, rel)
+	if err != nil {
+		return exprValue{}, err
+	}
+	r, err := e.right.eval(rec, rel)
+	if err != nil {
+		return exprValue{}, err
+	}
+	switch e.op {
+	case '+':
+		return exprValue{isNum: true, num: l.num + r.num}, nil
+	case '-':
+		return exprValue{isNum: true, num: l.num - r.num}, nil
+	case '*':
+		return exprValue{isNum: true, num: l.num * r.num}, nil
+	case '/':
+		if r.num == 0 {
+			return exprValue{}, fmt.Errorf("division by zero")
+		}
+		return exprValue{isNum: true, num: l.num / r.num}, nil
+	}
+	return exprValue{}, fmt.Errorf("unknown operator %q", e.op)
+}
+
+// formatForColumn renders v as the text stored for a column of the given kind.
+// Numeric results assigned to an INT column are truncated toward zero, and must then
+// fit in its 32 bits.
+func (v exprValue) formatForColumn(col relation.ColumnInfo) (string, error) {
+	if !v.isNum {
+		return v.str, nil
+	}
+	switch col.Kind {
+	case relation.KindInt:
+		n := math.Trunc(v.num)
+		if math.IsNaN(n) || n < math.MinInt32 || n > math.MaxInt32 {
+			return "", fmt.Errorf("col %s: %w: %g is out of range for INT", col.Name, relation.ErrInvalidValue, v.num)
+		}
+		return strconv.FormatInt(int64(n), 10), nil
+	case relation.KindFloat:
+		return strconv.FormatFloat(v.num, 'g', -1, 32), nil
+	}
+	if v.str != "" {
+		return v.str, nil
+	}
+	return strconv.FormatFloat(v.num, 'g', -1, 64), nil
+}
+
+type exprParser struct {
+	toks  []string
+	pos   int
+	rel   *relation.Relation
+	alias string
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseSum() (*expr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t == "+" || t == "-"; t = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{op: t[0], left: left, right: right, colIdx: -1}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (*expr, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t == "*" || t == "/"; t = p.peek() {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr{op: t[0], left: left, right: right, colIdx: -1}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseFactor() (*expr, error) {
+	t := p.peek()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrSyntax)
+	case t == "-":
+		p.pos++
+		inner, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{op: 'n', left: inner, colIdx: -1}, nil
+	case t == "(":
+		p.pos++
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing ) in expression", ErrSyntax)
+		}
+		p.pos++
+		return inner, nil
+	case t == ")" || t == "+" || t == "*" || t == "/":
+		return nil, fmt.Errorf("%w: unexpected %q in expression", ErrSyntax, t)
+	}
+	p.pos++
+	if len(t) >= 2 && t[0] == '"' && t[len(t)-1] == '"' {
+		return &expr{val: t[1 : len(t)-1], quoted: true, colIdx: -1}, nil
+	}
+	if p.alias != "" && strings.HasPrefix(t, p.alias+".") {
+		col := t[len(p.alias)+1:]
+		for i, c := range p.rel.Columns {
+			if c.Name == col {
+				return &expr{colIdx: i}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown column: %s", col)
+	}
+	return &expr{val: t, colIdx: -1}, nil
+}
+
+// tokenizeExpr splits an expression into operators, parentheses, quoted strings and
+// words (numbers, alias.col references, bare constants).
+func tokenizeExpr(text string) ([]string, error) {
+	var toks []string
+	i := 0
+	for i < len(text) {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			j := strings.IndexByte(text[i+1:], '"')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string in expression %q", text)
+			}
+			toks = append(toks, text[i:i+j+2])
+			i += j + 2
+		case strings.IndexByte("+-*/()", c) >= 0:
+			toks = append(toks, string(c))
+			i++
+		default:
+			j := i
+			for j < len(text) && strings.IndexByte(" \t\"+-*/()", text[j]) < 0 {
+				// keep exponent signs inside numbers such as 1e-3
+				if (text[j] == 'e' || text[j] == 'E') && j+1 < len(text) && (text[j+1] == '-' || text[j+1] == '+') && isNumberPrefix(text[i:j]) {
+					j += 2
+					continue
+				}
+				j++
+			}
+			toks = append(toks, text[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// isNumberPrefix reports whether s looks like the mantissa of a float literal.
+func isNumberPrefix(s string) bool {
+	if s == "" {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
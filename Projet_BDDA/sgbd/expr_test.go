@@ -0,0 +1,53 @@
+package sgbd
+
+import (
+	"testing"
+
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+func TestExprEval(t *testing.T) {
+	rel := relation.NewRelation("Emp", []relation.ColumnInfo{
+		{Name: "age", Kind: relation.KindInt},
+		{Name: "salary", Kind: relation.KindFloat},
+		{Name: "name", Kind: relation.KindVarchar, Size: 10},
+	})
+	rec := relation.NewRecord("30", "1000", "ann")
+	cases := []struct {
+		text string
+		want float64
+	}{
+		{"e.age + 1", 31},
+		{"e.salary * 1.1", 1100},
+		{"(e.age - 10) * 2", 40},
+		{"-e.age + 5", -25},
+		{"e.salary / 4 - e.age", 220},
+		{"1e2 + e.age", 130},
+	}
+	for _, c := range cases {
+		e, err := parseExpr(c.text, rel, "e")
+		if err != nil {
+			t.Fatalf("parse %q: %v", c.text, err)
+		}
+		v, err := e.eval(rec, rel)
+		if err != nil {
+			t.Fatalf("eval %q: %v", c.text, err)
+		}
+		if !v.isNum || v.num-c.want > 1e-6 || c.want-v.num > 1e-6 {
+			t.Fatalf("%q = %v, want %v", c.text, v.num, c.want)
+		}
+	}
+	if _, err := parseExpr("e.name * 2", rel, "e"); err == nil {
+		t.Fatalf("expected error for arithmetic on string column")
+	}
+	if _, err := parseExpr("e.missing + 1", rel, "e"); err == nil {
+		t.Fatalf("expected error for unknown column")
+	}
+	e, err := parseExpr("e.age / 0", rel, "e")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if _, err := e.eval(rec, rel); err == nil {
+		t.Fatalf("expected division by zero error")
+	}
+}
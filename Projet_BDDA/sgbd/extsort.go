@@ -0,0 +1,317 @@
+package sgbd
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/query"
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// sortKey is one ORDER BY term: a column of the scanned relation and its direction.
+type sortKey struct {
+	idx  int
+	kind relation.ColumnKind
+	desc bool
+}
+
+// splitOrderBy cuts a trailing ORDER BY clause off the FROM/WHERE part of a SELECT.
+// Quoted strings are skipped, so a WHERE constant containing ORDER BY is kept.
+func splitOrderBy(rest string) (string, string) {
+	up := strings.ToUpper(rest)
+	at := -1
+	inQuote := false
+	for i := 0; i < len(up); i++ {
+		if up[i] == '"' {
+			inQuote = !inQuote
+			continue
+		}
+		if !inQuote && strings.HasPrefix(up[i:], " ORDER BY ") {
+			at = i
+		}
+	}
+	if at < 0 {
+		return rest, ""
+	}
+	return strings.TrimSpace(rest[:at]), strings.TrimSpace(rest[at+len(" ORDER BY "):])
+}
+
+// parseRowIdOrder recognizes ORDER BY alias.ROWID [ASC|DESC], the storage order: the
+// order of a table scan, or its reverse with DESC, which the scan gives with no sort.
+// ok is false for any other ORDER BY clause.
+func parseRowIdOrder(text, alias string) (dir relation.ScanDirection, ok bool) {
+	f := strings.Fields(text)
+	if len(f) == 0 || len(f) > 2 || strings.Contains(text, ",") || !isRowIdColumn(f[0], alias) {
+		return relation.Forward, false
+	}
+	if len(f) == 1 || strings.EqualFold(f[1], "ASC") {
+		return relation.Forward, true
+	}
+	if strings.EqualFold(f[1], "DESC") {
+		return relation.Reverse, true
+	}
+	return relation.Forward, false
+}
+
+// parseOrderBy parses "alias.col [ASC|DESC], ..." into sort keys. Each term has
+// its own direction, ASC by default.
+func parseOrderBy(text string, rel *relation.Relation, alias string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, term := range strings.Split(text, ",") {
+		f := strings.Fields(term)
+		if len(f) == 0 || len(f) > 2 {
+			return nil, fmt.Errorf("%w: invalid ORDER BY term: %q", ErrSyntax, strings.TrimSpace(term))
+		}
+		k := sortKey{}
+		if len(f) == 2 {
+			switch strings.ToUpper(f[1]) {
+			case "ASC":
+			case "DESC":
+				k.desc = true
+			default:
+				return nil, fmt.Errorf("%w: invalid ORDER BY direction: %s", ErrSyntax, f[1])
+			}
+		}
+		if isRowIdColumn(f[0], alias) {
+			return nil, fmt.Errorf("ORDER BY ROWID cannot be combined with other ORDER BY terms")
+		}
+		if !strings.HasPrefix(f[0], alias+".") {
+			return nil, fmt.Errorf("ORDER BY must use alias: %s", f[0])
+		}
+		k.idx = query.ColumnIndex(rel, f[0][len(alias)+1:])
+		if k.idx < 0 {
+			return nil, fmt.Errorf("unknown column in ORDER BY: %s", f[0][len(alias)+1:])
+		}
+		k.kind = rel.Columns[k.idx].Kind
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// lessByKeys orders rows (record values) by keys: numbers numerically, strings
+// lexically, a value that does not parse as a number after those that do. Rows
+// equal on every key are not less than each other, so the stable rowSorter keeps
+// them in scan order.
+func lessByKeys(keys []sortKey) func(a, b []string) bool {
+	return func(a, b []string) bool {
+		for _, k := range keys {
+			c := compareSortValues(k.kind, a[k.idx], b[k.idx])
+			if c == 0 {
+				continue
+			}
+			if k.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	}
+}
+
+func compareSortValues(kind relation.ColumnKind, a, b string) int {
+	if kind == relation.KindInt || kind == relation.KindFloat {
+		fa, errA := strconv.ParseFloat(a, 64)
+		fb, errB := strconv.ParseFloat(b, 64)
+		switch {
+		case errA == nil && errB == nil:
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// rowSorter is an external merge sort over rows of strings. Rows are buffered up to
+// limit; each full buffer is sorted and spilled as a run to a temporary directory
+// under dir, and Each merges the runs. The sort is stable. Close removes the
+// temporary files and must be called, also on error.
+type rowSorter struct {
+	less  func(a, b []string) bool
+	limit int
+	dir   string
+	tmp   string
+	buf   [][]string
+	runs  []string
+}
+
+func newRowSorter(dir string, limit int, less func(a, b []string) bool) *rowSorter {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &rowSorter{less: less, limit: limit, dir: dir}
+}
+
+// Add buffers row, spilling the buffer as a sorted run once it holds limit rows.
+func (s *rowSorter) Add(row []string) error {
+	s.buf = append(s.buf, row)
+	if len(s.buf) >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill sorts the buffered rows and writes them to a new run file.
+func (s *rowSorter) spill() error {
+	if s.tmp == "" {
+		tmp, err := os.MkdirTemp(s.dir, "sort-")
+		if err != nil {
+			return err
+		}
+		s.tmp = tmp
+	}
+	sort.SliceStable(s.buf, func(i, j int) bool { return s.less(s.buf[i], s.buf[j]) })
+	path := filepath.Join(s.tmp, fmt.Sprintf("run%d.csv", len(s.runs)))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, path)
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(s.buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Spilled reports whether any run was written to disk.
+func (s *rowSorter) Spilled() bool {
+	return len(s.runs) > 0
+}
+
+// Each calls fn with every added row in sorted order.
+func (s *rowSorter) Each(fn func(row []string) error) error {
+	if len(s.runs) == 0 {
+		sort.SliceStable(s.buf, func(i, j int) bool { return s.less(s.buf[i], s.buf[j]) })
+		for _, row := range s.buf {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(s.buf) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	m := &runMerge{less: s.less}
+	defer m.close()
+	for _, path := range s.runs {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		r := &runReader{r: csv.NewReader(f), seq: len(m.runs)}
+		r.r.FieldsPerRecord = -1
+		m.files = append(m.files, f)
+		if err := r.next(); err != nil {
+			return err
+		}
+		if r.row != nil {
+			m.runs = append(m.runs, r)
+		}
+	}
+	heap.Init(m)
+	for m.Len() > 0 {
+		r := m.runs[0]
+		if err := fn(r.row); err != nil {
+			return err
+		}
+		if err := r.next(); err != nil {
+			return err
+		}
+		if r.row == nil {
+			heap.Pop(m)
+		} else {
+			heap.Fix(m, 0)
+		}
+	}
+	return nil
+}
+
+// Close removes the run files.
+func (s *rowSorter) Close() error {
+	s.buf = nil
+	if s.tmp == "" {
+		return nil
+	}
+	err := os.RemoveAll(s.tmp)
+	s.tmp = ""
+	s.runs = nil
+	return err
+}
+
+// runReader yields the rows of one sorted run; row is nil once the run is exhausted.
+type runReader struct {
+	r   *csv.Reader
+	seq int
+	row []string
+}
+
+func (r *runReader) next() error {
+	row, err := r.r.Read()
+	if errors.Is(err, io.EOF) {
+		r.row = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	r.row = row
+	return nil
+}
+
+// runMerge is a heap of runs ordered by their current row; ties go to the earlier
+// run, which keeps the merge stable.
+type runMerge struct {
+	less  func(a, b []string) bool
+	runs  []*runReader
+	files []*os.File
+}
+
+func (m *runMerge) Len() int { return len(m.runs) }
+func (m *runMerge) Less(i, j int) bool {
+	a, b := m.runs[i], m.runs[j]
+	if m.less(a.row, b.row) {
+		return true
+	}
+	if m.less(b.row, a.row) {
+		return false
+	}
+	return a.seq < b.seq
+}
+func (m *runMerge) Swap(i, j int) { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *runMerge) Push(x any)    { m.runs = append(m.runs, x.(*runReader)) }
+func (m *runMerge) Pop() any {
+	r := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return r
+}
+
+func (m *runMerge) close() {
+	for _, f := range m.files {
+		f.Close()
+	}
+}
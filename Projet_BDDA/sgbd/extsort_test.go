@@ -0,0 +1,222 @@
+package sgbd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+func TestRowSorterSpillsAndMerges(t *testing.T) {
+	dir := t.TempDir()
+	less := func(a, b []string) bool { return compareSortValues(0, a[0], b[0]) < 0 }
+	s := newRowSorter(dir, 4, less)
+	defer s.Close()
+	// many duplicate keys: the second field records the insertion order
+	for i := 0; i < 30; i++ {
+		if err := s.Add([]string{fmt.Sprint((i * 7) % 5), fmt.Sprint(i), "with, comma\nand \"quotes\""}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if !s.Spilled() {
+		t.Fatalf("30 rows over a limit of 4 should spill")
+	}
+	var got [][]string
+	if err := s.Each(func(row []string) error {
+		got = append(got, row)
+		return nil
+	}); err != nil {
+		t.Fatalf("Each: %v", err)
+	}
+	if len(got) != 30 {
+		t.Fatalf("got %d rows, want 30", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if less(got[i], got[i-1]) {
+			t.Fatalf("row %d %v sorts before row %d %v", i, got[i], i-1, got[i-1])
+		}
+		// equal keys keep their insertion order
+		if got[i][0] == got[i-1][0] && compareSortValues(0, got[i][1], got[i-1][1]) < 0 {
+			t.Fatalf("sort is not stable at row %d: %v after %v", i, got[i], got[i-1])
+		}
+		if got[i][2] != "with, comma\nand \"quotes\"" {
+			t.Fatalf("value did not survive the spill: %q", got[i][2])
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "sort-*")); len(left) != 0 {
+		t.Fatalf("temporary runs left behind: %v", left)
+	}
+}
+
+func TestOrderBySpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	cfg.SortMemoryRows = 5
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s.ProcessCommand("CREATE TABLE Emp (id:INT,dept:VARCHAR(4),salary:FLOAT)", &out); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	const n = 47
+	for i := 0; i < n; i++ {
+		cmd := fmt.Sprintf(`INSERT INTO Emp VALUES (%d,"d%d",%d.5)`, i, i%3, (i*17)%23)
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	res, err := s.Execute("SELECT e.dept, e.salary, e.id FROM Emp e WHERE e.id >= 0 ORDER BY e.dept DESC, e.salary")
+	if err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if res.Count != n || len(res.Rows) != n {
+		t.Fatalf("got %d rows, want %d", len(res.Rows), n)
+	}
+	for i := 1; i < n; i++ {
+		prev, cur := res.Rows[i-1], res.Rows[i]
+		if cur[0] > prev[0] || cur[0] == prev[0] && compareSortValues(1, cur[1], prev[1]) < 0 {
+			t.Fatalf("rows out of order: %v then %v", prev, cur)
+		}
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "sort-*")); len(left) != 0 {
+		t.Fatalf("temporary runs left behind: %v", left)
+	}
+
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.id FROM Emp e WHERE e.id < 3 ORDER BY e.id DESC", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if want := "2\n1\n0\nTotal selected records = 3\n"; out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+	for _, cmd := range []string{
+		"SELECT * FROM Emp e ORDER BY e.nope",
+		"SELECT * FROM Emp e ORDER BY id",
+		"SELECT * FROM Emp e ORDER BY e.id SIDEWAYS",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err == nil {
+			t.Fatalf("%s should fail", cmd)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "sort-") {
+			t.Fatalf("temporary runs left behind: %s", e.Name())
+		}
+	}
+}
+
+func TestSplitOrderBy(t *testing.T) {
+	rest, order := splitOrderBy(`Emp e WHERE e.name = " ORDER BY x" ORDER BY e.id desc`)
+	if rest != `Emp e WHERE e.name = " ORDER BY x"` || order != "e.id desc" {
+		t.Fatalf("splitOrderBy = %q, %q", rest, order)
+	}
+	if rest, order := splitOrderBy(`Emp e WHERE e.name = " ORDER BY x"`); order != "" || rest != `Emp e WHERE e.name = " ORDER BY x"` {
+		t.Fatalf("splitOrderBy without ORDER BY = %q, %q", rest, order)
+	}
+}
+
+func TestOrderByStableMixedDirections(t *testing.T) {
+	for _, memRows := range []int{3, config.DefaultSortMemoryRows} {
+		t.Run(fmt.Sprintf("memory=%d", memRows), func(t *testing.T) {
+			cfg := config.NewDBConfig(t.TempDir())
+			cfg.SortMemoryRows = memRows
+			s, err := NewSGBD(cfg)
+			if err != nil {
+				t.Fatalf("NewSGBD: %v", err)
+			}
+			var out bytes.Buffer
+			if err := s.ProcessCommand("CREATE TABLE T (id:INT,x:INT,y:INT)", &out); err != nil {
+				t.Fatalf("CREATE: %v", err)
+			}
+			// few distinct x and y values, so many rows tie on x and on (x, y)
+			const n = 40
+			for i := 0; i < n; i++ {
+				cmd := fmt.Sprintf("INSERT INTO T VALUES (%d,%d,%d)", i, (i*7)%3, (i*5)%4)
+				if err := s.ProcessCommand(cmd, &out); err != nil {
+					t.Fatalf("%s: %v", cmd, err)
+				}
+			}
+			scan, err := s.Execute("SELECT t.id FROM T t")
+			if err != nil {
+				t.Fatalf("SELECT: %v", err)
+			}
+			pos := map[string]int{}
+			for i, row := range scan.Rows {
+				pos[row[0]] = i
+			}
+			res, err := s.Execute("SELECT t.x, t.y, t.id FROM T t ORDER BY t.x ASC, t.y DESC")
+			if err != nil {
+				t.Fatalf("SELECT ORDER BY: %v", err)
+			}
+			if len(res.Rows) != n {
+				t.Fatalf("got %d rows, want %d", len(res.Rows), n)
+			}
+			for i := 1; i < n; i++ {
+				prev, cur := res.Rows[i-1], res.Rows[i]
+				switch c := compareSortValues(relation.KindInt, prev[0], cur[0]); {
+				case c > 0:
+					t.Fatalf("x out of order: %v then %v", prev, cur)
+				case c < 0:
+					continue
+				}
+				switch c := compareSortValues(relation.KindInt, prev[1], cur[1]); {
+				case c < 0:
+					t.Fatalf("y not descending within x: %v then %v", prev, cur)
+				case c == 0 && pos[prev[2]] > pos[cur[2]]:
+					t.Fatalf("tied rows lost their scan order: %v then %v", prev, cur)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderByRowIdFollowsScanOrder(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfigWithParams(t.TempDir(), 256, 4))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s.ProcessCommand("CREATE TABLE T (id:INT)", &out); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	// enough rows for several pages
+	const n = 120
+	for i := 0; i < n; i++ {
+		if err := s.ProcessCommand(fmt.Sprintf("INSERT INTO T VALUES (%d)", i), &out); err != nil {
+			t.Fatalf("INSERT: %v", err)
+		}
+	}
+	scan, err := s.Execute("SELECT t.id FROM T t")
+	if err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	asc, err := s.Execute("SELECT t.id FROM T t ORDER BY t.ROWID")
+	if err != nil {
+		t.Fatalf("ORDER BY ROWID: %v", err)
+	}
+	if fmt.Sprint(asc.Rows) != fmt.Sprint(scan.Rows) {
+		t.Fatalf("ORDER BY ROWID = %v, want scan order %v", asc.Rows, scan.Rows)
+	}
+	last, err := s.Execute("SELECT t.id FROM T t ORDER BY t.ROWID DESC LIMIT 3")
+	if err != nil {
+		t.Fatalf("ORDER BY ROWID DESC: %v", err)
+	}
+	want := [][]string{scan.Rows[n-1], scan.Rows[n-2], scan.Rows[n-3]}
+	if fmt.Sprint(last.Rows) != fmt.Sprint(want) {
+		t.Fatalf("ORDER BY ROWID DESC LIMIT 3 = %v, want %v", last.Rows, want)
+	}
+	if _, err := s.Execute("SELECT t.id FROM T t ORDER BY t.id, t.ROWID DESC"); err == nil {
+		t.Fatalf("expected an error combining ROWID with other ORDER BY terms")
+	}
+}
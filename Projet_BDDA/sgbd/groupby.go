@@ -0,0 +1,359 @@
+package sgbd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/query"
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// aggregate is a COUNT or SUM computed for every group of a SELECT.
+type aggregate struct {
+	fn  string // COUNT or SUM
+	col int    // column counted or summed, -1 for COUNT(*)
+	// text is the aggregate as the result header shows it, such as SUM(e.salary);
+	// HAVING and ORDER BY refer to an aggregate of the projection by this text
+	text string
+}
+
+// parseAggregate recognizes COUNT(*), COUNT(alias.col) and SUM(alias.col), the
+// function name in any case; ok is false for any other term. SUM needs an INT or
+// FLOAT column.
+func parseAggregate(term string, rel *relation.Relation, alias string) (aggregate, bool, error) {
+	t := strings.TrimSpace(term)
+	open := strings.IndexByte(t, '(')
+	if open < 0 || !strings.HasSuffix(t, ")") {
+		return aggregate{}, false, nil
+	}
+	fn := strings.ToUpper(strings.TrimSpace(t[:open]))
+	if fn != "COUNT" && fn != "SUM" {
+		return aggregate{}, false, nil
+	}
+	arg := strings.TrimSpace(t[open+1 : len(t)-1])
+	a := aggregate{fn: fn, col: -1, text: fn + "(" + arg + ")"}
+	if arg == "*" {
+		if fn != "COUNT" {
+			return aggregate{}, true, fmt.Errorf("%w: %s(*): only COUNT takes *", ErrSyntax, fn)
+		}
+		return a, true, nil
+	}
+	if !strings.HasPrefix(arg, alias+".") {
+		return aggregate{}, true, fmt.Errorf("aggregate must use alias: %s", t)
+	}
+	if a.col = query.ColumnIndex(rel, arg[len(alias)+1:]); a.col < 0 {
+		return aggregate{}, true, fmt.Errorf("unknown column in aggregate: %s", arg[len(alias)+1:])
+	}
+	if k := rel.Columns[a.col].Kind; fn == "SUM" && k != relation.KindInt && k != relation.KindFloat {
+		return aggregate{}, true, fmt.Errorf("%s: SUM needs an INT or FLOAT column, not %s", t, rel.Columns[a.col].TypeString())
+	}
+	return a, true, nil
+}
+
+// hasAggregate reports whether the projection of a SELECT lists an aggregate.
+func hasAggregate(selPart string) bool {
+	for _, item := range splitValues(selPart) {
+		up := strings.ToUpper(item)
+		if (strings.HasPrefix(up, "COUNT(") || strings.HasPrefix(up, "SUM(")) && strings.HasSuffix(up, ")") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitGroupBy cuts the GROUP BY and HAVING clauses, outside double-quoted constants,
+// off the FROM/WHERE part of a SELECT whose ORDER BY and LIMIT are already cut. Either
+// clause is "" when missing.
+func splitGroupBy(rest string) (from, group, having string) {
+	up := strings.ToUpper(rest)
+	if i := indexOutsideQuotes(up, " HAVING "); i >= 0 {
+		having = strings.TrimSpace(rest[i+len(" HAVING "):])
+		rest, up = rest[:i], up[:i]
+	}
+	if i := indexOutsideQuotes(up, " GROUP BY "); i >= 0 {
+		group = strings.TrimSpace(rest[i+len(" GROUP BY "):])
+		rest = rest[:i]
+	}
+	return strings.TrimSpace(rest), group, having
+}
+
+// rewriteAggregates replaces every aggregate of text, outside double-quoted
+// constants, by alias.#i, the column of the group rows holding it. Each must be one
+// of aggs, the aggregates of the projection, the first of which is column first.
+func rewriteAggregates(text, clause string, rel *relation.Relation, alias string, aggs []aggregate, first int) (string, error) {
+	var b strings.Builder
+	inQuote := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '"' {
+			inQuote = !inQuote
+		}
+		if inQuote || (i > 0 && isIdentByte(text[i-1])) {
+			b.WriteByte(c)
+			continue
+		}
+		up := strings.ToUpper(text[i:])
+		if !strings.HasPrefix(up, "COUNT(") && !strings.HasPrefix(up, "SUM(") {
+			b.WriteByte(c)
+			continue
+		}
+		end := strings.IndexByte(text[i:], ')')
+		if end < 0 {
+			return "", fmt.Errorf("%w: missing ) in %s", ErrSyntax, clause)
+		}
+		a, _, err := parseAggregate(text[i:i+end+1], rel, alias)
+		if err != nil {
+			return "", err
+		}
+		found := -1
+		for j, g := range aggs {
+			if g.text == a.text {
+				found = j
+				break
+			}
+		}
+		if found < 0 {
+			return "", fmt.Errorf("%w: %s in %s is not an aggregate of the SELECT list", ErrSyntax, a.text, clause)
+		}
+		fmt.Fprintf(&b, "%s.#%d", alias, first+found)
+		i += end
+	}
+	return b.String(), nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// groupState accumulates the aggregates of one group.
+type groupState struct {
+	vals   []string // values of the grouping columns
+	counts []int64
+	ints   []int64
+	floats []float64
+}
+
+// executeGroupBy runs a SELECT with GROUP BY, HAVING or aggregates in its projection.
+// The records matching WHERE are grouped in memory by the values of the grouping
+// columns, and each group yields one row of the grouping columns and aggregates the
+// projection lists, kept when HAVING holds for it. Without GROUP BY the whole table
+// is a single group. Groups come out ordered by their grouping values unless ORDER
+// BY, over grouping columns and aggregates of the projection, says otherwise.
+func (s *SGBD) executeGroupBy(name string, rel *relation.Relation, alias, selPart, wherePart, groupPart, havingPart, orderPart string, limit, offset int) (Result, error) {
+	if f := strings.Fields(selPart); len(f) > 0 && strings.EqualFold(f[0], "DISTINCT") {
+		return Result{}, fmt.Errorf("%w: DISTINCT is not supported with GROUP BY", ErrSyntax)
+	}
+	var keys []int
+	if groupPart != "" {
+		for _, term := range splitValues(groupPart) {
+			if !strings.HasPrefix(term, alias+".") {
+				return Result{}, fmt.Errorf("GROUP BY must use alias: %s", term)
+			}
+			idx := query.ColumnIndex(rel, term[len(alias)+1:])
+			if idx < 0 {
+				return Result{}, fmt.Errorf("unknown column in GROUP BY: %s", term[len(alias)+1:])
+			}
+			keys = append(keys, idx)
+		}
+	}
+	// proj[i] is the column of the group rows that projection item i shows, or
+	// literalProj for a constant, whose column is litCols[i] and value litVals[i]
+	var aggs []aggregate
+	var proj []int
+	var aggCols []int
+	litCols := make(map[int]ResultColumn)
+	litVals := make(map[int]string)
+	for _, item := range splitValues(selPart) {
+		a, ok, err := parseAggregate(item, rel, alias)
+		if err != nil {
+			return Result{}, err
+		}
+		if ok {
+			found := -1
+			for j, g := range aggs {
+				if g.text == a.text {
+					found = j
+					break
+				}
+			}
+			if found < 0 {
+				found = len(aggs)
+				aggs = append(aggs, a)
+			}
+			aggCols = append(aggCols, found)
+			proj = append(proj, -1)
+			continue
+		}
+		if col, val, ok := projLiteral(item); ok {
+			litCols[len(proj)], litVals[len(proj)] = col, val
+			proj = append(proj, literalProj)
+			continue
+		}
+		pos := -1
+		if strings.HasPrefix(item, alias+".") {
+			idx := query.ColumnIndex(rel, item[len(alias)+1:])
+			for j, k := range keys {
+				if k == idx {
+					pos = j
+				}
+			}
+		}
+		if pos < 0 {
+			return Result{}, fmt.Errorf("%w: %s is neither a GROUP BY column nor an aggregate", ErrSyntax, item)
+		}
+		proj = append(proj, pos)
+	}
+	for i, j := 0, 0; i < len(proj); i++ {
+		if proj[i] == -1 {
+			proj[i] = len(keys) + aggCols[j]
+			j++
+		}
+	}
+	// a group row holds the grouping columns, then the aggregates as columns #i;
+	// HAVING and ORDER BY are compiled over it once their aggregates are rewritten
+	cols := make([]relation.ColumnInfo, 0, len(keys)+len(aggs))
+	for _, k := range keys {
+		cols = append(cols, rel.Columns[k])
+	}
+	for i, a := range aggs {
+		kind := relation.KindInt
+		if a.fn == "SUM" {
+			kind = rel.Columns[a.col].Kind
+		}
+		cols = append(cols, relation.ColumnInfo{Name: "#" + strconv.Itoa(len(keys)+i), Kind: kind})
+	}
+	out := relation.NewRelation(rel.Name, cols)
+	havingText, err := rewriteAggregates(havingPart, "HAVING", rel, alias, aggs, len(keys))
+	if err != nil {
+		return Result{}, err
+	}
+	having, err := query.Compile(havingText, out, alias)
+	if err != nil {
+		return Result{}, err
+	}
+	order := make([]sortKey, len(keys))
+	for i, k := range keys {
+		order[i] = sortKey{idx: i, kind: rel.Columns[k].Kind}
+	}
+	if orderPart != "" {
+		text, err := rewriteAggregates(orderPart, "ORDER BY", rel, alias, aggs, len(keys))
+		if err != nil {
+			return Result{}, err
+		}
+		if order, err = parseOrderBy(text, out, alias); err != nil {
+			return Result{}, err
+		}
+	}
+	pred, err := query.Compile(wherePart, rel, alias)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		return Result{}, err
+	}
+
+	newGroup := func(vals []string) *groupState {
+		return &groupState{vals: vals, counts: make([]int64, len(aggs)), ints: make([]int64, len(aggs)), floats: make([]float64, len(aggs))}
+	}
+	groups := make(map[string]*groupState)
+	var keyOrder []string
+	if len(keys) == 0 {
+		// without GROUP BY an empty table still makes one group
+		groups[""] = newGroup(nil)
+		keyOrder = append(keyOrder, "")
+	}
+	err = s.dbm.ScanTableRecordsIn(s.context(), name, relation.Forward, func(rec relation.Record, _ relation.RecordId) error {
+		ok, err := pred.Match(&rec)
+		if err != nil || !ok {
+			return err
+		}
+		vals := make([]string, len(keys))
+		var key strings.Builder
+		for i, k := range keys {
+			vals[i] = rec.Values[k]
+			key.WriteString(strconv.Quote(vals[i]))
+		}
+		g, ok := groups[key.String()]
+		if !ok {
+			g = newGroup(vals)
+			groups[key.String()] = g
+			keyOrder = append(keyOrder, key.String())
+		}
+		for i, a := range aggs {
+			g.counts[i]++
+			if a.fn != "SUM" {
+				continue
+			}
+			v := rec.Values[a.col]
+			if rel.Columns[a.col].Kind == relation.KindInt {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err != nil {
+					return fmt.Errorf("%s: invalid INT value %q", a.text, v)
+				}
+				g.ints[i] += n
+			} else {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return fmt.Errorf("%s: invalid FLOAT value %q", a.text, v)
+				}
+				g.floats[i] += f
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	var rows [][]string
+	for _, key := range keyOrder {
+		g := groups[key]
+		row := append([]string{}, g.vals...)
+		for i, a := range aggs {
+			switch {
+			case a.fn == "COUNT":
+				row = append(row, strconv.FormatInt(g.counts[i], 10))
+			case rel.Columns[a.col].Kind == relation.KindInt:
+				row = append(row, strconv.FormatInt(g.ints[i], 10))
+			default:
+				row = append(row, strconv.FormatFloat(g.floats[i], 'g', -1, 32))
+			}
+		}
+		ok, err := having.Match(&relation.Record{Values: row})
+		if err != nil {
+			return Result{}, err
+		}
+		if ok {
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return lessByKeys(order)(rows[i], rows[j]) })
+	rows = window(rows, limit, offset)
+
+	res := Result{Kind: ResultRows, Command: "SELECT", Rows: make([][]string, 0, len(rows))}
+	for i, p := range proj {
+		if p == literalProj {
+			res.Columns = append(res.Columns, litCols[i])
+		} else if p < len(keys) {
+			c := rel.Columns[keys[p]]
+			res.Columns = append(res.Columns, ResultColumn{Name: c.Name, Type: c.TypeString()})
+		} else {
+			res.Columns = append(res.Columns, ResultColumn{Name: aggs[p-len(keys)].text, Type: cols[p].TypeString()})
+		}
+	}
+	for _, row := range rows {
+		r := make([]string, len(proj))
+		for i, p := range proj {
+			if p == literalProj {
+				r[i] = litVals[i]
+			} else {
+				r[i] = cols[p].FormatText(row[p])
+			}
+		}
+		res.Rows = append(res.Rows, r)
+	}
+	res.Count = len(res.Rows)
+	return res, nil
+}
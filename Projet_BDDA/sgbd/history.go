@@ -0,0 +1,83 @@
+package sgbd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// historyFile is the name of the file, under DBPath, that keeps the commands entered
+// in interactive mode across sessions.
+const historyFile = ".history"
+
+// defaultHistoryCount is how many commands HISTORY prints without an argument.
+const defaultHistoryCount = 20
+
+func (s *SGBD) historyPath() string {
+	return filepath.Join(s.cfg.DBPath, historyFile)
+}
+
+// appendHistory adds line to the history file, creating it if needed.
+func (s *SGBD) appendHistory(line string) error {
+	f, err := os.OpenFile(s.historyPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// History returns the last n commands of the history file, oldest first (all of
+// them when n <= 0). A missing history file yields no commands.
+func (s *SGBD) History(n int) ([]string, error) {
+	f, err := os.Open(s.historyPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if n > 0 && len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// ProcessHistoryCommand handles HISTORY [n]: it prints the last n commands entered in
+// interactive mode (20 by default), oldest first, one per line.
+func (s *SGBD) ProcessHistoryCommand(text string, w io.Writer) error {
+	parts := strings.Fields(text)
+	n := defaultHistoryCount
+	switch len(parts) {
+	case 1:
+	case 2:
+		v, err := strconv.Atoi(parts[1])
+		if err != nil || v <= 0 {
+			return fmt.Errorf("%w: invalid HISTORY count: %s", ErrSyntax, parts[1])
+		}
+		n = v
+	default:
+		return fmt.Errorf("%w in HISTORY", ErrSyntax)
+	}
+	lines, err := s.History(n)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package sgbd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/query"
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// joinSide is one relation of a two-table join, as named in the FROM clause.
+type joinSide struct {
+	name  string
+	alias string
+	rel   *relation.Relation
+}
+
+// parseJoinFrom parses the "R r, S s" FROM list of a join.
+func (s *SGBD) parseJoinFrom(fromPart string) (joinSide, joinSide, error) {
+	items := strings.Split(fromPart, ",")
+	if len(items) != 2 {
+		return joinSide{}, joinSide{}, fmt.Errorf("only joins of two tables are supported")
+	}
+	var sides [2]joinSide
+	for i, item := range items {
+		f := strings.Fields(item)
+		if len(f) != 2 {
+			return joinSide{}, joinSide{}, fmt.Errorf("%w in SELECT FROM", ErrSyntax)
+		}
+		rel, err := s.dbm.GetTable(f[0])
+		if err != nil {
+			return joinSide{}, joinSide{}, err
+		}
+		sides[i] = joinSide{name: f[0], alias: f[1], rel: rel}
+	}
+	if sides[0].alias == sides[1].alias {
+		return joinSide{}, joinSide{}, fmt.Errorf("duplicate alias in join: %s", sides[0].alias)
+	}
+	return sides[0], sides[1], nil
+}
+
+// joinRelation builds the relation a join's WHERE clause and projection are resolved
+// against: the columns of l then r, named alias.col. A joined row is the values of a
+// record of l followed by those of a record of r.
+func joinRelation(l, r joinSide) *relation.Relation {
+	cols := make([]relation.ColumnInfo, 0, len(l.rel.Columns)+len(r.rel.Columns))
+	for _, side := range []joinSide{l, r} {
+		for _, c := range side.rel.Columns {
+			c.Name = side.alias + "." + c.Name
+			cols = append(cols, c)
+		}
+	}
+	return relation.NewRelation(l.name+","+r.name, cols)
+}
+
+// executeJoin runs SELECT proj FROM R r, S s [WHERE ...]. The projection is *, an
+// alias.* or alias.col list; ROWID, DISTINCT, EXISTS and ORDER BY are not supported
+// on joins.
+func (s *SGBD) executeJoin(selPart, fromPart, wherePart, orderPart string) (Result, error) {
+	l, r, err := s.parseJoinFrom(fromPart)
+	if err != nil {
+		return Result{}, err
+	}
+	if orderPart != "" {
+		return Result{}, fmt.Errorf("ORDER BY is not supported on joins")
+	}
+	if f := strings.Fields(selPart); len(f) > 0 && (strings.EqualFold(f[0], "DISTINCT") || strings.EqualFold(f[0], "EXISTS")) {
+		return Result{}, fmt.Errorf("%s is not supported on joins", strings.ToUpper(f[0]))
+	}
+	joined := joinRelation(l, r)
+	var projIdxs []int
+	litCols := make(map[int]ResultColumn)
+	litVals := make(map[int]string)
+	if strings.TrimSpace(selPart) == "*" {
+		for i := range joined.Columns {
+			projIdxs = append(projIdxs, i)
+		}
+	} else {
+		for _, c := range splitValues(selPart) {
+			if col, val, ok := projLiteral(c); ok {
+				litCols[len(projIdxs)], litVals[len(projIdxs)] = col, val
+				projIdxs = append(projIdxs, literalProj)
+				continue
+			}
+			switch {
+			case isRowIdColumn(c, l.alias) || isRowIdColumn(c, r.alias):
+				return Result{}, fmt.Errorf("ROWID is not supported on joins")
+			case c == l.alias+".*":
+				for i := range l.rel.Columns {
+					projIdxs = append(projIdxs, i)
+				}
+			case c == r.alias+".*":
+				for i := range r.rel.Columns {
+					projIdxs = append(projIdxs, len(l.rel.Columns)+i)
+				}
+			case strings.HasPrefix(c, l.alias+".") || strings.HasPrefix(c, r.alias+"."):
+				idx := query.ColumnIndex(joined, c)
+				if idx < 0 {
+					return Result{}, fmt.Errorf("unknown column in projection: %s", c)
+				}
+				projIdxs = append(projIdxs, idx)
+			default:
+				return Result{}, fmt.Errorf("projection must use alias: %s", c)
+			}
+		}
+	}
+	pred, err := query.Compile(wherePart, joined, "")
+	if err != nil {
+		return Result{}, err
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		return Result{}, err
+	}
+	res := Result{Kind: ResultRows, Command: "SELECT", Rows: [][]string{}}
+	for i, pi := range projIdxs {
+		if pi == literalProj {
+			res.Columns = append(res.Columns, litCols[i])
+			continue
+		}
+		c := joined.Columns[pi]
+		res.Columns = append(res.Columns, ResultColumn{Name: c.Name, Type: c.TypeString()})
+	}
+	err = s.joinRows(l, r, pred, func(vals []string) error {
+		row := make([]string, len(projIdxs))
+		for i, pi := range projIdxs {
+			if pi == literalProj {
+				row[i] = litVals[i]
+				continue
+			}
+			row[i] = joined.Columns[pi].FormatText(vals[pi])
+		}
+		res.Rows = append(res.Rows, row)
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	res.Count = len(res.Rows)
+	return res, nil
+}
+
+// joinRows calls fn with every joined row of l and r matching pred, compiled over
+// joinRelation(l, r). A single equality between a column of each side is run as a
+// hash join; any other predicate as a nested loop.
+func (s *SGBD) joinRows(l, r joinSide, pred *query.Predicate, fn func(vals []string) error) error {
+	n := len(l.rel.Columns)
+	if lc, rc, ok := pred.Equality(); ok && (lc < n) != (rc < n) {
+		if lc >= n {
+			lc, rc = rc, lc
+		}
+		return s.hashJoin(l, r, lc, rc-n, fn)
+	}
+	return s.nestedLoopJoin(l, r, pred, fn)
+}
+
+// nestedLoopJoin scans r once per record of l and keeps the pairs matching pred.
+// Nothing is held in memory, at the cost of reading r len(l) times.
+func (s *SGBD) nestedLoopJoin(l, r joinSide, pred *query.Predicate, fn func(vals []string) error) error {
+	return s.dbm.ScanTableRecordsContext(s.context(), l.name, func(outer relation.Record, _ relation.RecordId) error {
+		return s.dbm.ScanTableRecordsContext(s.context(), r.name, func(inner relation.Record, _ relation.RecordId) error {
+			row := relation.Record{Values: append(append([]string{}, outer.Values...), inner.Values...)}
+			ok, err := pred.Match(&row)
+			if err != nil || !ok {
+				return err
+			}
+			return fn(row.Values)
+		})
+	})
+}
+
+// hashJoin joins l and r on l.Columns[lc] = r.Columns[rc]. The side with fewer data
+// pages is loaded into a hash table keyed by its join column, then the other one is
+// scanned once and probes it. Rows come out in the order of the probed side.
+func (s *SGBD) hashJoin(l, r joinSide, lc, rc int, fn func(vals []string) error) error {
+	lp, err := s.dbm.TablePages(l.name)
+	if err != nil {
+		return err
+	}
+	rp, err := s.dbm.TablePages(r.name)
+	if err != nil {
+		return err
+	}
+	build, probe := l, r
+	bc, pc := lc, rc
+	buildLeft := true
+	if rp < lp {
+		build, probe = r, l
+		bc, pc = rc, lc
+		buildLeft = false
+	}
+	// both keys are read the way compareValues would compare them
+	kind := build.rel.Columns[bc].Kind
+	if pk := probe.rel.Columns[pc].Kind; pk != kind {
+		// INT with FLOAT (numbers against strings are rejected by query.Compile)
+		// or CHAR with VARCHAR
+		if isNumericKind(kind) {
+			kind = relation.KindFloat
+		} else {
+			kind = relation.KindVarchar
+		}
+	}
+	table := make(map[string][][]string)
+	err = s.dbm.ScanTableRecordsContext(s.context(), build.name, func(rec relation.Record, _ relation.RecordId) error {
+		key, ok, err := joinKey(kind, rec.Values[bc])
+		if err != nil || !ok {
+			return err
+		}
+		table[key] = append(table[key], rec.Values)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.dbm.ScanTableRecordsContext(s.context(), probe.name, func(rec relation.Record, _ relation.RecordId) error {
+		key, ok, err := joinKey(kind, rec.Values[pc])
+		if err != nil || !ok {
+			return err
+		}
+		for _, vals := range table[key] {
+			var row []string
+			if buildLeft {
+				row = append(append([]string{}, vals...), rec.Values...)
+			} else {
+				row = append(append([]string{}, rec.Values...), vals...)
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// joinKey returns the hash key of a join column value: numbers are normalized so
+// that values comparing equal (1 and 1.0, 0 and -0) share a key. ok is false for a
+// NaN, which equals nothing.
+func joinKey(kind relation.ColumnKind, v string) (string, bool, error) {
+	switch kind {
+	case relation.KindInt, relation.KindFloat:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid numeric value %q", v)
+		}
+		if math.IsNaN(f) {
+			return "", false, nil
+		}
+		if f == 0 {
+			f = 0
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), true, nil
+	}
+	return v, true, nil
+}
+
+func isNumericKind(kind relation.ColumnKind) bool {
+	return kind == relation.KindInt || kind == relation.KindFloat
+}
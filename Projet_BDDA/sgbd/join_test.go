@@ -0,0 +1,123 @@
+package sgbd
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/query"
+)
+
+func TestHashJoinMatchesNestedLoop(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	cmds := []string{
+		"CREATE TABLE Emp (id:INT,dept:INT,name:VARCHAR(8))",
+		"CREATE TABLE Dept (id:FLOAT,label:CHAR(6))",
+	}
+	for i := 0; i < 300; i++ {
+		cmds = append(cmds, fmt.Sprintf(`INSERT INTO Emp VALUES (%d,%d,"e%d")`, i, i%7, i))
+	}
+	// departments 0 to 4, 2 twice and 9 with no employee; 5 and 6 have no department
+	for _, d := range []string{"0", "1", "2", "2.0", "3", "4", "9"} {
+		cmds = append(cmds, fmt.Sprintf(`INSERT INTO Dept VALUES (%s,"d%s")`, d, d))
+	}
+	for _, c := range cmds {
+		if err := s.ProcessCommand(c, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		t.Fatalf("FlushBuffers: %v", err)
+	}
+	collect := func(from, where string, hash bool) [][]string {
+		l, r, err := s.parseJoinFrom(from)
+		if err != nil {
+			t.Fatalf("parseJoinFrom: %v", err)
+		}
+		joined := joinRelation(l, r)
+		pred, err := query.Compile(where, joined, "")
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		var rows [][]string
+		fn := func(vals []string) error {
+			rows = append(rows, vals)
+			return nil
+		}
+		if hash {
+			lc, rc, ok := pred.Equality()
+			if !ok {
+				t.Fatalf("%q should be planned as a hash join", where)
+			}
+			if lc >= len(l.rel.Columns) {
+				lc, rc = rc, lc
+			}
+			err = s.hashJoin(l, r, lc, rc-len(l.rel.Columns), fn)
+		} else {
+			err = s.nestedLoopJoin(l, r, pred, fn)
+		}
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		sort.Slice(rows, func(i, j int) bool { return strings.Join(rows[i], "|") < strings.Join(rows[j], "|") })
+		return rows
+	}
+	// Dept has fewer pages, so it is the build side in one order and the probe side
+	// in the other
+	for _, from := range []string{"Emp e, Dept d", "Dept d, Emp e"} {
+		for _, where := range []string{"e.dept = d.id", "d.id = e.dept"} {
+			hash := collect(from, where, true)
+			nested := collect(from, where, false)
+			// 43 employees in each of departments 0 to 4 (1 in 7 of 300, rounded up
+			// for 0 to 6), with department 2 matching twice
+			if len(hash) != 43*6 {
+				t.Fatalf("%s WHERE %s: %d rows, want %d", from, where, len(hash), 43*6)
+			}
+			if !reflect.DeepEqual(hash, nested) {
+				t.Fatalf("%s WHERE %s: hash join differs from nested loop", from, where)
+			}
+		}
+	}
+
+	// the same through SELECT, and a non-equality predicate runs as a nested loop
+	var out bytes.Buffer
+	if err := s.ProcessCommand(`SELECT e.name, d.label FROM Emp e, Dept d WHERE e.id = d.id`, &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if want := "e0 ; d0\ne1 ; d1\ne2 ; d2\ne2 ; d2.0\ne3 ; d3\ne4 ; d4\ne9 ; d9\nTotal selected records = 7\n"; !sameLines(out.String(), want) {
+		t.Fatalf("SELECT output = %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	if err := s.ProcessCommand(`SELECT d.label FROM Emp e, Dept d WHERE e.id < 2 AND d.id > 3`, &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if want := "d4\nd9\nd4\nd9\nTotal selected records = 4\n"; !sameLines(out.String(), want) {
+		t.Fatalf("SELECT output = %q, want %q", out.String(), want)
+	}
+	for _, bad := range []string{
+		"SELECT * FROM Emp e, Dept e",
+		"SELECT e.nope FROM Emp e, Dept d",
+		"SELECT e.ROWID FROM Emp e, Dept d",
+		"SELECT * FROM Emp e, Dept d WHERE e.name = d.id",
+		"SELECT * FROM Emp e, Dept d WHERE x.id = 1",
+	} {
+		if err := s.ProcessCommand(bad, &bytes.Buffer{}); err == nil {
+			t.Fatalf("%q should fail", bad)
+		}
+	}
+}
+
+// sameLines reports whether a and b hold the same lines in any order.
+func sameLines(a, b string) bool {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	sort.Strings(x)
+	sort.Strings(y)
+	return reflect.DeepEqual(x, y)
+}
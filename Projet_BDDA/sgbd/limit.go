@@ -0,0 +1,58 @@
+package sgbd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// noLimit is the limit of a SELECT without a LIMIT clause.
+const noLimit = -1
+
+// splitLimit splits a trailing "LIMIT n [OFFSET m]" clause, outside double-quoted
+// constants, from rest. It returns the text before it and n and m, noLimit and 0
+// without the clause.
+func splitLimit(rest string) (string, int, int, error) {
+	up := strings.ToUpper(rest)
+	at := -1
+	inQuote := false
+	for i := 0; i < len(up); i++ {
+		if up[i] == '"' {
+			inQuote = !inQuote
+			continue
+		}
+		if !inQuote && strings.HasPrefix(up[i:], " LIMIT ") {
+			at = i
+		}
+	}
+	if at < 0 {
+		return rest, noLimit, 0, nil
+	}
+	f := strings.Fields(rest[at+len(" LIMIT "):])
+	if len(f) != 1 && (len(f) != 3 || !strings.EqualFold(f[1], "OFFSET")) {
+		return "", 0, 0, fmt.Errorf("%w: invalid LIMIT clause: expected LIMIT n [OFFSET m]", ErrSyntax)
+	}
+	limit, err := strconv.Atoi(f[0])
+	if err != nil || limit < 0 {
+		return "", 0, 0, fmt.Errorf("%w: invalid LIMIT %q", ErrSyntax, f[0])
+	}
+	offset := 0
+	if len(f) == 3 {
+		if offset, err = strconv.Atoi(f[2]); err != nil || offset < 0 {
+			return "", 0, 0, fmt.Errorf("%w: invalid OFFSET %q", ErrSyntax, f[2])
+		}
+	}
+	return strings.TrimSpace(rest[:at]), limit, offset, nil
+}
+
+// window returns the rows LIMIT limit OFFSET offset keeps.
+func window(rows [][]string, limit, offset int) [][]string {
+	if offset >= len(rows) {
+		return rows[:0]
+	}
+	rows = rows[offset:]
+	if limit != noLimit && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
@@ -0,0 +1,114 @@
+package sgbd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// metricOps are the commands METRICS times, in the order it prints them.
+var metricOps = []string{"insert", "select", "delete", "update"}
+
+// latencyBuckets are the upper bounds of the METRICS histogram buckets; a last
+// bucket counts the longer commands.
+var latencyBuckets = []time.Duration{100 * time.Microsecond, time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second}
+
+// bucketNames label latencyBuckets, and the last bucket, in METRICS output.
+var bucketNames = []string{"le_100us", "le_1ms", "le_10ms", "le_100ms", "le_1s", "gt_1s"}
+
+// opMetrics accumulates the durations of one kind of command.
+type opMetrics struct {
+	count, errors int64
+	total         time.Duration
+	min, max      time.Duration
+	buckets       [6]int64
+}
+
+func (m *opMetrics) record(d time.Duration, failed bool) {
+	if m.count == 0 || d < m.min {
+		m.min = d
+	}
+	if d > m.max {
+		m.max = d
+	}
+	m.count++
+	m.total += d
+	if failed {
+		m.errors++
+	}
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	m.buckets[i]++
+}
+
+// metricOp returns the METRICS operation a command counts under, from its upper-cased
+// text, or "" for a command that is not timed. APPEND counts as an insert.
+func metricOp(up string) string {
+	switch {
+	case strings.HasPrefix(up, "INSERT INTO "), strings.HasPrefix(up, "APPEND INTO "):
+		return "insert"
+	case strings.HasPrefix(up, "SELECT "):
+		return "select"
+	case strings.HasPrefix(up, "DELETE "):
+		return "delete"
+	case strings.HasPrefix(up, "UPDATE "):
+		return "update"
+	}
+	return ""
+}
+
+// timeCommand runs run, recording its duration under op unless op is empty. The
+// cost is two clock reads per timed command.
+func (s *SGBD) timeCommand(op string, run func() error) error {
+	if op == "" {
+		return run()
+	}
+	start := time.Now()
+	err := run()
+	if s.metrics == nil {
+		s.metrics = make(map[string]*opMetrics)
+	}
+	m := s.metrics[op]
+	if m == nil {
+		m = &opMetrics{}
+		s.metrics[op] = m
+	}
+	m.record(time.Since(start), err != nil)
+	return err
+}
+
+// ProcessMetricsCommand handles METRICS and METRICS RESET. METRICS prints one line
+// per timed operation (insert, select, delete, update) since the session started or
+// the last reset: the number of commands, how many failed, their average, minimum
+// and maximum duration in microseconds, and how many fell in each latency bucket.
+// METRICS RESET zeroes the counters and prints OK.
+func (s *SGBD) ProcessMetricsCommand(text string, w io.Writer) error {
+	args := strings.Fields(text)[1:]
+	if len(args) == 1 && strings.EqualFold(args[0], "RESET") {
+		s.metrics = nil
+		fmt.Fprintln(w, "OK")
+		return nil
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("%w in METRICS: expected METRICS [RESET]", ErrSyntax)
+	}
+	for _, op := range metricOps {
+		m := s.metrics[op]
+		if m == nil {
+			m = &opMetrics{}
+		}
+		var avg time.Duration
+		if m.count > 0 {
+			avg = m.total / time.Duration(m.count)
+		}
+		fmt.Fprintf(w, "%s count=%d errors=%d avg_us=%d min_us=%d max_us=%d", op, m.count, m.errors, avg.Microseconds(), m.min.Microseconds(), m.max.Microseconds())
+		for i, name := range bucketNames {
+			fmt.Fprintf(w, " %s=%d", name, m.buckets[i])
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package sgbd
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// metricsLine returns the METRICS line of op as a key to value map.
+func metricsLine(t *testing.T, s *SGBD, op string) map[string]string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := s.ProcessCommand("METRICS", &out); err != nil {
+		t.Fatalf("METRICS: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != op {
+			continue
+		}
+		kv := make(map[string]string)
+		for _, f := range fields[1:] {
+			if k, v, ok := strings.Cut(f, "="); ok {
+				kv[k] = v
+			}
+		}
+		return kv
+	}
+	t.Fatalf("no %s line in %q", op, out.String())
+	return nil
+}
+
+func TestMetricsCountsWorkload(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	defer s.Close()
+	cmds := []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10))",
+		`INSERT INTO Emp VALUES (1,"ann")`,
+		`INSERT INTO Emp VALUES (2,"bob")`,
+		`INSERT INTO Emp VALUES (3,"cid")`,
+		"SELECT * FROM Emp e",
+		"SELECT e.name FROM Emp e WHERE e.id = 2",
+		`UPDATE Emp e SET e.name = "zed" WHERE e.id = 3`,
+		"DELETE FROM Emp e WHERE e.id = 1",
+	}
+	for _, c := range cmds {
+		if err := s.ProcessCommand(c, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+	// a failing insert is timed too
+	if err := s.ProcessCommand(`INSERT INTO Nope VALUES (1)`, &bytes.Buffer{}); err == nil {
+		t.Fatalf("expected error inserting into an unknown table")
+	}
+	for op, want := range map[string]string{"insert": "4", "select": "2", "update": "1", "delete": "1"} {
+		m := metricsLine(t, s, op)
+		if m["count"] != want {
+			t.Fatalf("%s count = %s, want %s (%v)", op, m["count"], want, m)
+		}
+		total := 0
+		for _, b := range bucketNames {
+			n, err := strconv.Atoi(m[b])
+			if err != nil {
+				t.Fatalf("%s %s = %q", op, b, m[b])
+			}
+			total += n
+		}
+		if strconv.Itoa(total) != want {
+			t.Fatalf("%s buckets sum to %d, want %s (%v)", op, total, want, m)
+		}
+	}
+	if m := metricsLine(t, s, "insert"); m["errors"] != "1" {
+		t.Fatalf("insert errors = %s, want 1", m["errors"])
+	}
+
+	var out bytes.Buffer
+	if err := s.ProcessCommand("METRICS RESET", &out); err != nil || strings.TrimSpace(out.String()) != "OK" {
+		t.Fatalf("METRICS RESET = %q, %v", out.String(), err)
+	}
+	for _, op := range metricOps {
+		if m := metricsLine(t, s, op); m["count"] != "0" || m["max_us"] != "0" {
+			t.Fatalf("%s not reset: %v", op, m)
+		}
+	}
+	if err := s.ProcessCommand("METRICS NOW", &out); err == nil {
+		t.Fatalf("expected syntax error")
+	}
+}
+
+func TestOpMetricsBuckets(t *testing.T) {
+	var m opMetrics
+	for _, d := range []time.Duration{50 * time.Microsecond, 100 * time.Microsecond, 2 * time.Millisecond, 3 * time.Second} {
+		m.record(d, false)
+	}
+	if m.buckets != [6]int64{2, 0, 1, 0, 0, 1} {
+		t.Fatalf("buckets = %v", m.buckets)
+	}
+	if m.min != 50*time.Microsecond || m.max != 3*time.Second || m.count != 4 {
+		t.Fatalf("min %v max %v count %d", m.min, m.max, m.count)
+	}
+}
+
+// TestMetricsCountsLibraryCalls checks that Execute and prepared statements are timed
+// like the commands of the shell.
+func TestMetricsCountsLibraryCalls(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	defer s.Close()
+	for _, c := range []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10))",
+		`INSERT INTO Emp VALUES (1,"ann")`,
+		`INSERT INTO Emp VALUES (2,"bob")`,
+		`INSERT INTO Emp VALUES (3,"cid")`,
+	} {
+		if _, err := s.Execute(c); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+	sel, err := s.Prepare("SELECT e.name FROM Emp e WHERE e.id = ?")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	ins, err := s.Prepare("INSERT INTO Emp VALUES (?, ?)")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if _, err := sel.Exec(2); err != nil {
+		t.Fatalf("Exec SELECT: %v", err)
+	}
+	if _, err := ins.Exec(4, "dan"); err != nil {
+		t.Fatalf("Exec INSERT: %v", err)
+	}
+	for op, want := range map[string]string{"insert": "4", "select": "1", "update": "0"} {
+		if m := metricsLine(t, s, op); m["count"] != want {
+			t.Fatalf("%s count = %s, want %s (%v)", op, m["count"], want, m)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package sgbd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// Stmt is a command prepared by SGBD.Prepare. Each '?' outside a quoted string is a
+// parameter bound by Exec.
+type Stmt struct {
+	s *SGBD
+	// parts is the command text split around its placeholders
+	parts []string
+	// insert is set for INSERT INTO ... VALUES (...), which is executed directly from
+	// the bound values instead of being re-parsed as text
+	insert *preparedInsert
+}
+
+type preparedInsert struct {
+	table string
+	// values holds the literal value of each column, or nil for a placeholder
+	values []*string
+}
+
+// Prepare parses text once for repeated execution with different arguments.
+//
+// In INSERT INTO t VALUES (...), arguments are converted to the type of their column
+// and stored as is, so strings may hold quotes or commas. In other commands each
+// argument is substituted as a literal: numbers as written by strconv, strings between
+// double quotes (a string containing a double quote is rejected there).
+func (s *SGBD) Prepare(text string) (*Stmt, error) {
+	t := strings.TrimSpace(text)
+	st := &Stmt{s: s, parts: splitPlaceholders(t)}
+	if strings.HasPrefix(strings.ToUpper(t), "INSERT INTO ") {
+		ins, err := s.prepareInsert(t)
+		if err != nil {
+			return nil, err
+		}
+		st.insert = ins
+	}
+	return st, nil
+}
+
+// NumParams returns the number of placeholders of the statement.
+func (st *Stmt) NumParams() int {
+	return len(st.parts) - 1
+}
+
+// Exec binds args to the placeholders, in order, and runs the statement.
+func (st *Stmt) Exec(args ...any) (Result, error) {
+	if len(args) != st.NumParams() {
+		return Result{}, fmt.Errorf("statement expects %d argument(s), got %d", st.NumParams(), len(args))
+	}
+	if st.insert != nil {
+		var res Result
+		err := st.s.dispatch(context.Background(), "insert", func() error {
+			var err error
+			res, err = st.execInsert(args)
+			return err
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		return res, nil
+	}
+	var b strings.Builder
+	for i, arg := range args {
+		b.WriteString(st.parts[i])
+		lit, err := formatLiteral(arg)
+		if err != nil {
+			return Result{}, fmt.Errorf("argument %d: %v", i+1, err)
+		}
+		b.WriteString(lit)
+	}
+	b.WriteString(st.parts[len(args)])
+	return st.s.Execute(b.String())
+}
+
+func (s *SGBD) prepareInsert(text string) (*preparedInsert, error) {
+	up := strings.ToUpper(text)
+	idx := strings.Index(up, " VALUES (")
+	if idx < 0 || !strings.HasSuffix(text, ")") {
+		return nil, fmt.Errorf("%w in INSERT", ErrSyntax)
+	}
+	parts := strings.Fields(text[:idx])
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w in INSERT", ErrSyntax)
+	}
+	rel, err := s.dbm.GetTable(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	vals := splitValues(text[idx+len(" VALUES (") : len(text)-1])
+	if len(vals) != len(rel.Columns) {
+		return nil, fmt.Errorf("record arity mismatch: got %d values, want %d", len(vals), len(rel.Columns))
+	}
+	ins := &preparedInsert{table: parts[2], values: make([]*string, len(vals))}
+	for i, v := range vals {
+		if v == "?" {
+			continue
+		}
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		ins.values[i] = &v
+	}
+	return ins, nil
+}
+
+func (st *Stmt) execInsert(args []any) (Result, error) {
+	ins := st.insert
+	// look the table up again: it may have been dropped or recreated since Prepare
+	rel, err := st.s.dbm.GetTable(ins.table)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(rel.Columns) != len(ins.values) {
+		return Result{}, fmt.Errorf("table %s changed since the statement was prepared", ins.table)
+	}
+	rec := &relation.Record{Values: make([]string, len(ins.values))}
+	next := 0
+	for i, lit := range ins.values {
+		if lit != nil {
+			v, err := rel.Columns[i].ParseText(*lit)
+			if err != nil {
+				return Result{}, err
+			}
+			rec.Values[i] = v
+			continue
+		}
+		v, err := coerceArg(rel.Columns[i], args[next])
+		if err != nil {
+			return Result{}, fmt.Errorf("argument %d: %v", next+1, err)
+		}
+		rec.Values[i] = v
+		next++
+	}
+	if _, err := st.s.dbm.InsertRecord(ins.table, rec); err != nil {
+		return Result{}, err
+	}
+	if err := st.s.bm.FlushBuffers(); err != nil {
+		return Result{}, err
+	}
+	return Result{Kind: ResultOK, Command: "INSERT", Count: 1}, nil
+}
+
+// coerceArg converts a Go value to the stored text of column col.
+func coerceArg(col relation.ColumnInfo, v any) (string, error) {
+	switch col.Kind {
+	case relation.KindInt:
+		var text string
+		switch n := v.(type) {
+		case int:
+			text = strconv.Itoa(n)
+		case int32:
+			text = strconv.FormatInt(int64(n), 10)
+		case int64:
+			text = strconv.FormatInt(n, 10)
+		case string:
+			text = strings.TrimSpace(n)
+		}
+		// INT is stored on 32 bits
+		if _, err := strconv.ParseInt(text, 10, 32); err == nil {
+			return text, nil
+		}
+	case relation.KindFloat:
+		switch n := v.(type) {
+		case int:
+			return strconv.Itoa(n), nil
+		case int32:
+			return strconv.FormatInt(int64(n), 10), nil
+		case int64:
+			return strconv.FormatInt(n, 10), nil
+		case float32:
+			return strconv.FormatFloat(float64(n), 'g', -1, 32), nil
+		case float64:
+			return strconv.FormatFloat(n, 'g', -1, 64), nil
+		case string:
+			if _, err := strconv.ParseFloat(strings.TrimSpace(n), 32); err == nil {
+				return strings.TrimSpace(n), nil
+			}
+		}
+	case relation.KindChar, relation.KindVarchar:
+		switch x := v.(type) {
+		case string:
+			return x, nil
+		case int, int32, int64, float32, float64:
+			return fmt.Sprint(x), nil
+		}
+	case relation.KindBlob:
+		// arguments are raw bytes, unlike base64 literals
+		switch x := v.(type) {
+		case []byte:
+			return string(x), nil
+		case string:
+			return x, nil
+		}
+	}
+	return "", fmt.Errorf("cannot store %T %v in column %s %s", v, v, col.Name, col.TypeString())
+}
+
+// formatLiteral writes v as a command literal.
+func formatLiteral(v any) (string, error) {
+	switch x := v.(type) {
+	case int:
+		return strconv.Itoa(x), nil
+	case int32:
+		return strconv.FormatInt(int64(x), 10), nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case string:
+		if strings.Contains(x, `"`) {
+			return "", fmt.Errorf("string %q contains a double quote", x)
+		}
+		return `"` + x + `"`, nil
+	}
+	return "", fmt.Errorf("unsupported argument type %T", v)
+}
+
+// splitPlaceholders splits text around each '?' found outside quoted strings.
+func splitPlaceholders(text string) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// splitValues splits a VALUES list on the commas found outside quoted strings.
+func splitValues(body string) []string {
+	var out []string
+	var quote rune
+	start := 0
+	for i, r := range body {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ',':
+			out = append(out, strings.TrimSpace(body[start:i]))
+			start = i + 1
+		}
+	}
+	return append(out, strings.TrimSpace(body[start:]))
+}
@@ -0,0 +1,76 @@
+package sgbd
+
+import (
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+func TestPreparedStatements(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	if _, err := s.Execute("CREATE TABLE Emp (id:INT,score:FLOAT,name:VARCHAR(20),tag:CHAR(2))"); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	ins, err := s.Prepare(`INSERT INTO Emp VALUES (?, ?, ?, "x,")`)
+	if err != nil {
+		t.Fatalf("Prepare INSERT: %v", err)
+	}
+	if ins.NumParams() != 3 {
+		t.Fatalf("NumParams = %d, want 3", ins.NumParams())
+	}
+	for _, args := range [][]any{
+		{1, 2.5, `say "hi", ok`},
+		{int64(2), 3, "it's"},
+		{"3", float32(0.25), "a ; b"},
+	} {
+		if _, err := ins.Exec(args...); err != nil {
+			t.Fatalf("Exec%v: %v", args, err)
+		}
+	}
+	if _, err := ins.Exec(1.5, 1.0, "bad"); err == nil {
+		t.Fatalf("a float for an INT column should be rejected")
+	}
+	for _, id := range []any{int64(3000000000), 3000000000, "-2147483649"} {
+		if _, err := ins.Exec(id, 1.0, "big"); err == nil {
+			t.Fatalf("%T %v is out of range for an INT column and should be rejected", id, id)
+		}
+	}
+	if _, err := ins.Exec(1, 1.0); err == nil {
+		t.Fatalf("missing arguments should be rejected")
+	}
+
+	sel, err := s.Prepare("SELECT e.id, e.score, e.name, e.tag FROM Emp e WHERE e.id >= ? AND e.name <> ?")
+	if err != nil {
+		t.Fatalf("Prepare SELECT: %v", err)
+	}
+	res, err := sel.Exec(1, "it's")
+	if err != nil {
+		t.Fatalf("Exec SELECT: %v", err)
+	}
+	var got []string
+	for _, row := range res.Rows {
+		got = append(got, strings.Join(row, "|"))
+	}
+	want := []string{`1|2.5|say "hi", ok|x,`, "3|0.25|a ; b|x,"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("rows = %q, want %q", got, want)
+	}
+	if _, err := sel.Exec(1, `a"b`); err == nil {
+		t.Fatalf("a double quote cannot be substituted into a WHERE clause")
+	}
+
+	del, err := s.Prepare("DELETE Emp e WHERE e.score < ?")
+	if err != nil {
+		t.Fatalf("Prepare DELETE: %v", err)
+	}
+	if res, err := del.Exec(1.0); err != nil || res.Count != 1 {
+		t.Fatalf("Exec DELETE = %+v, %v", res, err)
+	}
+	if _, err := s.Prepare("INSERT INTO Emp VALUES (?, ?)"); err == nil {
+		t.Fatalf("Prepare should check the INSERT arity")
+	}
+}
@@ -0,0 +1,117 @@
+package sgbd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ResultKind tells which fields of a Result are meaningful.
+type ResultKind int
+
+const (
+	// ResultOK is a command without rows or count (CREATE, INSERT, ...); Text holds
+	// its human-readable output, if any.
+	ResultOK ResultKind = iota
+	// ResultCount carries the number of records affected in Count (DELETE, UPDATE).
+	ResultCount
+	// ResultRows carries Columns and Rows (SELECT); Count is len(Rows).
+	ResultRows
+)
+
+// ResultColumn describes one column of a ResultRows result: its name and type as
+// written in CREATE TABLE (e.g. INT, VARCHAR(10)), or ROWID for ROWID projections.
+type ResultColumn struct {
+	Name string
+	Type string
+}
+
+// Result is the structured outcome of a command, for programs embedding the engine.
+type Result struct {
+	Kind ResultKind
+	// Command is the statement keyword (SELECT, INSERT, ...); SELECT EXISTS reports EXISTS.
+	Command string
+	Columns []ResultColumn
+	Rows    [][]string
+	Count   int
+	// DryRun is set when a DELETE/UPDATE ... DRY RUN only counted the records.
+	DryRun bool
+	Text   string
+}
+
+// Execute runs a single command like ProcessCommand, returning its outcome as a Result
+// instead of writing text. SELECT, INSERT, DELETE and UPDATE produce structured
+// results; other commands produce a ResultOK with their text output. Like
+// ProcessCommand, it applies query_timeout_ms and may be followed by an automatic
+// checkpoint.
+func (s *SGBD) Execute(text string) (Result, error) {
+	t := strings.TrimSpace(text)
+	up := strings.ToUpper(t)
+	var exec func(string) (Result, error)
+	switch {
+	case strings.HasPrefix(up, "SELECT "):
+		exec = s.executeSelect
+	case strings.HasPrefix(up, "INSERT INTO "):
+		exec = s.executeInsert
+	case strings.HasPrefix(up, "DELETE "):
+		exec = s.executeDelete
+	case strings.HasPrefix(up, "UPDATE "):
+		exec = s.executeUpdate
+	}
+	if exec != nil {
+		var res Result
+		err := s.dispatch(context.Background(), metricOp(up), func() error {
+			var err error
+			res, err = exec(t)
+			return err
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		return res, nil
+	}
+	var buf bytes.Buffer
+	if err := s.ProcessCommand(t, &buf); err != nil {
+		return Result{}, err
+	}
+	cmd := ""
+	if f := strings.Fields(up); len(f) > 0 {
+		cmd = f[0]
+	}
+	return Result{Kind: ResultOK, Command: cmd, Text: buf.String()}, nil
+}
+
+// writeResult prints res in the text format of the interactive shell.
+func writeResult(w io.Writer, res Result) error {
+	switch res.Kind {
+	case ResultRows:
+		if res.Command == "EXISTS" {
+			_, err := fmt.Fprintln(w, res.Rows[0][0])
+			return err
+		}
+		for _, row := range res.Rows {
+			fmt.Fprintln(w, strings.Join(row, " ; "))
+		}
+		_, err := fmt.Fprintf(w, "Total selected records = %d\n", res.Count)
+		return err
+	case ResultCount:
+		verb, past := "delete", "deleted"
+		if res.Command == "UPDATE" {
+			verb, past = "update", "updated"
+		}
+		if res.DryRun {
+			_, err := fmt.Fprintf(w, "Total records to %s = %d (dry run)\n", verb, res.Count)
+			return err
+		}
+		_, err := fmt.Fprintf(w, "Total %s records = %d\n", past, res.Count)
+		return err
+	}
+	if res.Text != "" {
+		_, err := io.WriteString(w, res.Text)
+		return err
+	}
+	_, err := fmt.Fprintln(w, "OK")
+	return err
+}
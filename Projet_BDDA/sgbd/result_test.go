@@ -0,0 +1,76 @@
+package sgbd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+func TestExecuteResults(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	res, err := s.Execute("CREATE TABLE Emp (id:INT,name:VARCHAR(10))")
+	if err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	if res.Kind != ResultOK || res.Command != "CREATE" || res.Text != "OK\n" {
+		t.Fatalf("CREATE result = %+v", res)
+	}
+	for _, cmd := range []string{`INSERT INTO Emp VALUES (1,"ann")`, `INSERT INTO Emp VALUES (2,"bob")`, `INSERT INTO Emp VALUES (3,"cid")`} {
+		res, err := s.Execute(cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		if res.Kind != ResultOK || res.Command != "INSERT" || res.Count != 1 {
+			t.Fatalf("INSERT result = %+v", res)
+		}
+	}
+
+	res, err = s.Execute("SELECT e.name, e.id FROM Emp e WHERE e.id >= 2")
+	if err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if res.Kind != ResultRows || res.Count != 2 {
+		t.Fatalf("SELECT result = %+v", res)
+	}
+	wantCols := []ResultColumn{{Name: "name", Type: "VARCHAR(10)"}, {Name: "id", Type: "INT"}}
+	if !reflect.DeepEqual(res.Columns, wantCols) {
+		t.Fatalf("columns = %+v, want %+v", res.Columns, wantCols)
+	}
+	got := make([]string, len(res.Rows))
+	for i, row := range res.Rows {
+		got[i] = strings.Join(row, ",")
+	}
+	if strings.Join(got, "|") != "bob,2|cid,3" {
+		t.Fatalf("rows = %q", got)
+	}
+
+	res, err = s.Execute("SELECT * FROM Emp e WHERE e.id > 10")
+	if err != nil || res.Kind != ResultRows || res.Count != 0 || len(res.Rows) != 0 || len(res.Columns) != 2 {
+		t.Fatalf("empty SELECT = %+v, %v", res, err)
+	}
+
+	res, err = s.Execute("DELETE Emp e WHERE e.id = 1 DRY RUN")
+	if err != nil || res.Kind != ResultCount || res.Command != "DELETE" || res.Count != 1 || !res.DryRun {
+		t.Fatalf("DELETE DRY RUN = %+v, %v", res, err)
+	}
+	res, err = s.Execute("DELETE Emp e WHERE e.id <= 2")
+	if err != nil || res.Kind != ResultCount || res.Count != 2 || res.DryRun {
+		t.Fatalf("DELETE = %+v, %v", res, err)
+	}
+	res, err = s.Execute(`UPDATE Emp e SET e.name = "zed"`)
+	if err != nil || res.Kind != ResultCount || res.Command != "UPDATE" || res.Count != 1 {
+		t.Fatalf("UPDATE = %+v, %v", res, err)
+	}
+	res, err = s.Execute("SELECT EXISTS FROM Emp e WHERE e.name = \"zed\"")
+	if err != nil || res.Command != "EXISTS" || !reflect.DeepEqual(res.Rows, [][]string{{"true"}}) {
+		t.Fatalf("SELECT EXISTS = %+v, %v", res, err)
+	}
+	if _, err := s.Execute("SELECT * FROM Nope n"); err == nil {
+		t.Fatalf("SELECT on a missing table should fail")
+	}
+}
@@ -2,16 +2,40 @@ package sgbd
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/db"
+	"malzahar-project/Projet_BDDA/disk"
+	"malzahar-project/Projet_BDDA/relation"
 )
 
-// TestScenario executes the README example scenario through ProcessCommand and Save.
-func TestScenario(t *testing.T) {
-	dir := t.TempDir()
-	cfg := config.NewDBConfig(dir)
+// runOnEachStore runs test against a database in a temporary directory and against
+// an in-memory one.
+func runOnEachStore(t *testing.T, test func(t *testing.T, cfg *config.DBConfig)) {
+	t.Run("files", func(t *testing.T) {
+		test(t, config.NewDBConfig(t.TempDir()))
+	})
+	t.Run("memory", func(t *testing.T) {
+		// the files an in-memory database keeps beside its pages go to TMPDIR
+		t.Setenv("TMPDIR", t.TempDir())
+		test(t, config.NewDBConfig(config.MemoryDBPath))
+	})
+}
+
+func TestScenario(t *testing.T) { runOnEachStore(t, testScenario) }
+
+// testScenario executes the README example scenario through ProcessCommand and Save.
+func testScenario(t *testing.T, cfg *config.DBConfig) {
 	s, err := NewSGBD(cfg)
 	if err != nil {
 		t.Fatalf("NewSGBD: %v", err)
@@ -142,3 +166,1609 @@ func TestDropTables(t *testing.T) {
 		t.Fatalf("Tables still present after reload: output=%q", txt)
 	}
 }
+
+// TestCompactRemovesEmptyFiles drops every table of a database whose BinData also
+// holds an empty Data1 and a Data2 with a used page, as an older layout spreading
+// pages over several files leaves them: COMPACT removes Data1 only.
+func TestCompactRemovesEmptyFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	binDir := filepath.Join(dir, "BinData")
+	if err := os.MkdirAll(binDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"Data1.bin":    make([]byte, 2*cfg.PageSize),
+		"Data1.bitmap": {0, 0},
+		"Data2.bin":    make([]byte, cfg.PageSize),
+		"Data2.bitmap": {1},
+	} {
+		if err := os.WriteFile(filepath.Join(binDir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	defer s.Close()
+	var out bytes.Buffer
+	for _, c := range []string{
+		"CREATE TABLE Tab1 (C1:INT,C2:VARCHAR(10))",
+		"CREATE TABLE Tab2 (C3:CHAR(120))",
+		`INSERT INTO Tab1 VALUES (1,"one")`,
+		`INSERT INTO Tab2 VALUES ("two")`,
+		"DROP TABLES",
+	} {
+		if err := s.ProcessCommand(c, &out); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("COMPACT", &out); err != nil {
+		t.Fatalf("COMPACT: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "Total removed files = 1" {
+		t.Fatalf("COMPACT printed %q", got)
+	}
+	for name, want := range map[string]bool{"Data0.bin": true, "Data1.bin": false, "Data1.bitmap": false, "Data2.bin": true, "Data2.bitmap": true} {
+		if _, err := os.Stat(filepath.Join(binDir, name)); (err == nil) != want {
+			t.Fatalf("%s: exists %v, want %v", name, err == nil, want)
+		}
+	}
+	// the database stays usable
+	for _, c := range []string{"CREATE TABLE Tab3 (C1:INT)", "INSERT INTO Tab3 VALUES (3)"} {
+		if err := s.ProcessCommand(c, &out); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT * FROM Tab3 t", &out); err != nil || !strings.Contains(out.String(), "3") {
+		t.Fatalf("SELECT after COMPACT = %q, %v", out.String(), err)
+	}
+}
+
+// TestSelectRowIdThenDelete selects the ROWID of a row and deletes it by that id.
+func TestSelectRowIdThenDelete(t *testing.T) { runOnEachStore(t, testSelectRowIdThenDelete) }
+
+func testSelectRowIdThenDelete(t *testing.T, cfg *config.DBConfig) {
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	cmds := []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10))",
+		`INSERT INTO Emp VALUES (1,"ann")`,
+		`INSERT INTO Emp VALUES (2,"bob")`,
+		`INSERT INTO Emp VALUES (3,"cid")`,
+	}
+	for _, c := range cmds {
+		if err := s.ProcessCommand(c, &out); err != nil {
+			t.Fatalf("ProcessCommand(%q) failed: %v", c, err)
+		}
+	}
+
+	out.Reset()
+	if err := s.ProcessCommand("SELECT ROWID, e.name FROM Emp e WHERE e.id = 2", &out); err != nil {
+		t.Fatalf("SELECT ROWID: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " ; bob") {
+		t.Fatalf("unexpected SELECT ROWID output: %q", out.String())
+	}
+	rowid := strings.TrimSuffix(lines[0], " ; bob")
+
+	out.Reset()
+	if err := s.ProcessCommand(`DELETE FROM Emp WHERE ROWID = "`+rowid+`"`, &out); err != nil {
+		t.Fatalf("DELETE by ROWID: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "Total deleted records = 1" {
+		t.Fatalf("unexpected DELETE output: %q", got)
+	}
+
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.name FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	txt := out.String()
+	if strings.Contains(txt, "bob") || !strings.Contains(txt, "Total selected records = 2") {
+		t.Fatalf("row not deleted by ROWID: %q", txt)
+	}
+
+	// a ROWID comparison cannot be mixed with other conditions
+	for _, c := range []string{
+		`DELETE FROM Emp e WHERE e.ROWID = "` + rowid + `" AND e.id = 5`,
+		`DELETE FROM Emp e WHERE e.ROWID = "1:2:3" AND e.name = "ann"`,
+	} {
+		if err := s.ProcessCommand(c, &out); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("ProcessCommand(%q): err = %v, want ErrSyntax", c, err)
+		}
+	}
+
+	// deleting the same rowid again must fail
+	if err := s.ProcessCommand(`DELETE FROM Emp WHERE ROWID = "`+rowid+`"`, &out); err == nil {
+		t.Fatalf("expected error deleting an already free rowid")
+	}
+
+	// any other use of ROWID in WHERE is rejected instead of comparing the word as text
+	for _, c := range []string{
+		`DELETE FROM Emp e WHERE ROWID >= "0:1:0"`,
+		`DELETE FROM Emp e WHERE e.ROWID != "0:1:0" DRY RUN`,
+		`DELETE FROM Emp e WHERE e.id = 1 OR ROWID <> "x"`,
+		`UPDATE Emp e SET e.id = 9 WHERE ROWID > "9:9:9"`,
+		`UPDATE Emp e SET e.id = 9 WHERE e.id = 1 OR e.ROWID = "9:9:9"`,
+		`SELECT e.name FROM Emp e WHERE NOT ROWID = "0:1:0"`,
+		`SELECT e.name FROM Emp e WHERE e.ROWID < "9:9:9" ORDER BY e.id`,
+	} {
+		if err := s.ProcessCommand(c, &out); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("ProcessCommand(%q): err = %v, want ErrSyntax", c, err)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.name FROM Emp e", &out); err != nil || !strings.Contains(out.String(), "Total selected records = 2") {
+		t.Fatalf("rejected commands changed the table: %q, %v", out.String(), err)
+	}
+
+	// SELECT and UPDATE take the exact ROWID = "f:p:s" form too
+	out.Reset()
+	if err := s.ProcessCommand("SELECT ROWID FROM Emp e WHERE e.id = 1", &out); err != nil {
+		t.Fatalf("SELECT ROWID: %v", err)
+	}
+	annId := strings.SplitN(out.String(), "\n", 2)[0]
+	out.Reset()
+	if err := s.ProcessCommand(`SELECT e.name FROM Emp e WHERE ROWID = "`+annId+`"`, &out); err != nil {
+		t.Fatalf("SELECT by ROWID: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "ann\nTotal selected records = 1" {
+		t.Fatalf("SELECT by ROWID = %q", got)
+	}
+	out.Reset()
+	if err := s.ProcessCommand(`UPDATE Emp e SET e.name = "amy" WHERE e.ROWID = "`+annId+`"`, &out); err != nil {
+		t.Fatalf("UPDATE by ROWID: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "Total updated records = 1" {
+		t.Fatalf("UPDATE by ROWID = %q", got)
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.name FROM Emp e ORDER BY e.id", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "amy\ncid\nTotal selected records = 2" {
+		t.Fatalf("after UPDATE by ROWID: %q", got)
+	}
+}
+
+func TestUpdateArithmetic(t *testing.T) { runOnEachStore(t, testUpdateArithmetic) }
+
+// testUpdateArithmetic increments numeric columns using expressions over the current row.
+func testUpdateArithmetic(t *testing.T, cfg *config.DBConfig) {
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	cmds := []string{
+		"CREATE TABLE Emp (name:VARCHAR(10),dept:VARCHAR(5),salary:FLOAT,age:INT)",
+		`INSERT INTO Emp VALUES ("ann","Eng",1000,30)`,
+		`INSERT INTO Emp VALUES ("bob","Ops",2000,40)`,
+		`UPDATE Emp e SET e.salary = e.salary * 1.1, e.age = e.age + 1 WHERE e.dept = "Eng"`,
+	}
+	for _, c := range cmds {
+		if err := s.ProcessCommand(c, &out); err != nil {
+			t.Fatalf("ProcessCommand(%q) failed: %v", c, err)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.name, e.salary, e.age FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	txt := out.String()
+	if !strings.Contains(txt, "ann ; 1100 ; 31") || !strings.Contains(txt, "bob ; 2000 ; 40") {
+		t.Fatalf("unexpected rows after arithmetic update: %q", txt)
+	}
+	if err := s.ProcessCommand(`UPDATE Emp e SET e.age = e.name + 1`, &out); err == nil {
+		t.Fatalf("expected type error for arithmetic on a string column")
+	}
+	if err := s.ProcessCommand(`UPDATE Emp e SET e.name = e.age * 2`, &out); err == nil {
+		t.Fatalf("expected type error assigning numeric expression to VARCHAR")
+	}
+	// a string column takes an unquoted date as text, not as a subtraction
+	if err := s.ProcessCommand(`UPDATE Emp e SET e.name = 2020-01-05 WHERE e.name = "bob"`, &out); err != nil {
+		t.Fatalf("UPDATE with date literal: %v", err)
+	}
+	if err := s.ProcessCommand(`UPDATE Emp e SET e.age = e.age * 100000000`, &out); !errors.Is(err, relation.ErrInvalidValue) {
+		t.Fatalf("INT overflow in UPDATE: err = %v, want ErrInvalidValue", err)
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.name, e.dept, e.age FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	txt = out.String()
+	if !strings.Contains(txt, "2020-01-05 ; Ops ; 40") || !strings.Contains(txt, "ann ; Eng ; 31") {
+		t.Fatalf("unexpected rows after literal update: %q", txt)
+	}
+}
+
+func TestCheckCommand(t *testing.T) { runOnEachStore(t, testCheckCommand) }
+
+// testCheckCommand runs CHECK, CHECK ... REPAIR, REPAIR and FSCK on a healthy table.
+func testCheckCommand(t *testing.T, cfg *config.DBConfig) {
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	for _, c := range []string{"CREATE TABLE Emp (id:INT)", "INSERT INTO Emp VALUES (1)", "INSERT INTO Emp VALUES (2)"} {
+		if err := s.ProcessCommand(c, &out); err != nil {
+			t.Fatalf("ProcessCommand(%q) failed: %v", c, err)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("CHECK Emp", &out); err != nil {
+		t.Fatalf("CHECK: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "OK" {
+		t.Fatalf("CHECK output = %q, want OK", got)
+	}
+	out.Reset()
+	if err := s.ProcessCommand("CHECK Emp REPAIR", &out); err != nil {
+		t.Fatalf("CHECK REPAIR: %v", err)
+	}
+	if got := strings.Fields(out.String()); len(got) != 2 || got[0] != "REPAIRED" || got[1] != "OK" {
+		t.Fatalf("CHECK REPAIR output = %q", out.String())
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.id FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if !strings.Contains(out.String(), "Total selected records = 2") {
+		t.Fatalf("records lost by repair: %q", out.String())
+	}
+	out.Reset()
+	if err := s.ProcessCommand("REPAIR Emp", &out); err != nil {
+		t.Fatalf("REPAIR: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "OK" {
+		t.Fatalf("REPAIR output = %q, want OK", got)
+	}
+	if err := s.ProcessCommand("REPAIR Nope", &out); err == nil {
+		t.Fatalf("expected error repairing unknown table")
+	}
+	if err := s.ProcessCommand("CHECK Nope", &out); err == nil {
+		t.Fatalf("expected error for unknown table")
+	}
+	if err := s.ProcessCommand("CHECK Emp NOW", &out); err == nil {
+		t.Fatalf("expected syntax error")
+	}
+	out.Reset()
+	if err := s.ProcessCommand("FSCK", &out); err != nil {
+		t.Fatalf("FSCK: %v", err)
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); len(got) != 2 || got[0] != "Emp: OK" || got[1] != "1 table(s) checked, 0 problem(s)" {
+		t.Fatalf("FSCK output = %q", out.String())
+	}
+}
+
+// TestDataSurvivesRestart inserts rows, saves, and reads them back from a fresh SGBD.
+func TestDataSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	csvPath := filepath.Join(dir, "more.csv")
+	if err := os.WriteFile(csvPath, []byte("4,\"dan\",4.5\n5,\"eve\",5.5\n"), 0o644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	var out bytes.Buffer
+	cmds := []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10),score:FLOAT)",
+		"CREATE TABLE Empty (id:INT)",
+		`INSERT INTO Emp VALUES (1,"ann",1.5)`,
+		`INSERT INTO Emp VALUES (2,"bob",2.5)`,
+		`INSERT INTO Emp VALUES (3,"cat",3.5)`,
+		"APPEND INTO Emp ALLRECORDS (" + csvPath + ")",
+	}
+	for _, c := range cmds {
+		if err := s.ProcessCommand(c, &out); err != nil {
+			t.Fatalf("ProcessCommand(%q) failed: %v", c, err)
+		}
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	s2, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD after restart: %v", err)
+	}
+	out.Reset()
+	if err := s2.ProcessCommand("SELECT * FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	want := []string{"1 ; ann ; 1.5", "2 ; bob ; 2.5", "3 ; cat ; 3.5", "4 ; dan ; 4.5", "5 ; eve ; 5.5"}
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(got) != len(want)+1 {
+		t.Fatalf("unexpected SELECT output after restart: %q", out.String())
+	}
+	sort.Strings(got[:len(want)])
+	for i, w := range want {
+		if got[i] != w {
+			t.Fatalf("row %d = %q, want %q (output %q)", i, got[i], w, out.String())
+		}
+	}
+	if got[len(want)] != "Total selected records = 5" {
+		t.Fatalf("unexpected total line %q", got[len(want)])
+	}
+	// tables without rows keep a usable header too
+	if err := s2.ProcessCommand("INSERT INTO Empty VALUES (7)", &out); err != nil {
+		t.Fatalf("insert into reloaded empty table: %v", err)
+	}
+	if err := s2.ProcessCommand("CHECK Emp", &out); err != nil {
+		t.Fatalf("CHECK after restart: %v", err)
+	}
+}
+
+// TestDryRunDoesNotModify previews DELETE and UPDATE counts without changing rows.
+func TestDryRunDoesNotModify(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	cmds := []string{
+		"CREATE TABLE Emp (id:INT,age:INT)",
+		"INSERT INTO Emp VALUES (1,20)",
+		"INSERT INTO Emp VALUES (2,40)",
+		"INSERT INTO Emp VALUES (3,50)",
+	}
+	for _, c := range cmds {
+		if err := s.ProcessCommand(c, &out); err != nil {
+			t.Fatalf("ProcessCommand(%q) failed: %v", c, err)
+		}
+	}
+	for _, tc := range []struct{ cmd, want string }{
+		{"DELETE Emp e WHERE e.age > 30 DRY RUN", "Total records to delete = 2 (dry run)"},
+		{"DELETE Emp e dry run", "Total records to delete = 3 (dry run)"},
+		{"UPDATE Emp e SET e.age = e.age + 1 WHERE e.id = 1 DRY RUN", "Total records to update = 1 (dry run)"},
+		{`DELETE FROM Emp WHERE ROWID = "9:9:9" DRY RUN`, "Total records to delete = 0 (dry run)"},
+	} {
+		out.Reset()
+		if err := s.ProcessCommand(tc.cmd, &out); err != nil {
+			t.Fatalf("ProcessCommand(%q) failed: %v", tc.cmd, err)
+		}
+		if got := strings.TrimSpace(out.String()); got != tc.want {
+			t.Fatalf("%q printed %q, want %q", tc.cmd, got, tc.want)
+		}
+	}
+	// a dry run still validates the new values
+	if err := s.ProcessCommand(`UPDATE Emp e SET e.age = "old" DRY RUN`, &out); err == nil {
+		t.Fatalf("expected validation error in dry-run UPDATE")
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.id, e.age FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	txt := out.String()
+	for _, row := range []string{"1 ; 20", "2 ; 40", "3 ; 50", "Total selected records = 3"} {
+		if !strings.Contains(txt, row) {
+			t.Fatalf("dry run modified the table, missing %q in %q", row, txt)
+		}
+	}
+}
+
+// TestShowStatus checks SHOW STATUS reports the configured sizes and runtime counts.
+func TestShowStatus(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfigWithParams(dir, 1024, 3)
+	cfg.BMBufferCount = 5
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s.ProcessCommand("CREATE TABLE Emp (id:INT)", &out); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	for _, cmd := range []string{"SHOW STATUS", "show settings"} {
+		out.Reset()
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		status := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				t.Fatalf("unparseable status line %q", line)
+			}
+			status[k] = v
+		}
+		for k, want := range map[string]string{
+			"dbpath":          dir,
+			"pagesize":        "1024",
+			"dm_maxfilecount": "3",
+			"bm_buffercount":  "5",
+			"tables":          "1",
+			"allocated_pages": "2",
+			"free_pages":      "0",
+		} {
+			if status[k] != want {
+				t.Fatalf("%s: %s = %q, want %q (output %q)", cmd, k, status[k], want, out.String())
+			}
+		}
+	}
+}
+
+// TestSelectConstants mixes constants with columns in projections: each row repeats
+// them as written, strings without their quotes.
+func TestSelectConstants(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	defer s.Close()
+	for _, cmd := range []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10))",
+		"CREATE TABLE Dept (id:INT,label:VARCHAR(10))",
+		`INSERT INTO Emp VALUES (1,"ann")`,
+		`INSERT INTO Emp VALUES (2,"bob")`,
+		`INSERT INTO Dept VALUES (2,"sales")`,
+	} {
+		if err := s.ProcessCommand(cmd, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	for _, tc := range []struct {
+		cmd  string
+		want []string
+	}{
+		{`SELECT "label", e.name FROM Emp e`, []string{"label ; ann", "label ; bob"}},
+		{`SELECT e.id, 42, -1.5, "sent from, here" FROM Emp e WHERE e.id = 2`, []string{"2 ; 42 ; -1.5 ; sent from, here"}},
+		{`SELECT DISTINCT "all" FROM Emp e`, []string{"all"}},
+		{`SELECT "dept", e.name, d.label FROM Emp e, Dept d WHERE e.id = d.id`, []string{"dept ; bob ; sales"}},
+	} {
+		var out bytes.Buffer
+		if err := s.ProcessCommand(tc.cmd, &out); err != nil {
+			t.Fatalf("%s: %v", tc.cmd, err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		got := lines[:len(lines)-1]
+		sort.Strings(got)
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Fatalf("%s printed %q, want %q", tc.cmd, got, tc.want)
+		}
+	}
+	res, err := s.Execute(`SELECT "tag", 7, 2.5, e.id FROM Emp e LIMIT 1`)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	wantCols := []ResultColumn{{Name: `"tag"`, Type: "VARCHAR(3)"}, {Name: "7", Type: "INT"}, {Name: "2.5", Type: "FLOAT"}, {Name: "id", Type: "INT"}}
+	if fmt.Sprint(res.Columns) != fmt.Sprint(wantCols) {
+		t.Fatalf("columns = %v, want %v", res.Columns, wantCols)
+	}
+	for _, cmd := range []string{`SELECT label FROM Emp e`, `SELECT NaN FROM Emp e`, `SELECT "open FROM Emp e`} {
+		if err := s.ProcessCommand(cmd, &bytes.Buffer{}); err == nil {
+			t.Fatalf("%s: expected an error", cmd)
+		}
+	}
+}
+
+func TestSelectExists(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	for _, cmd := range []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10))",
+		`INSERT INTO Emp VALUES (1,"ann")`,
+		`INSERT INTO Emp VALUES (2,"bob")`,
+	} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	for cmd, want := range map[string]string{
+		"SELECT EXISTS FROM Emp e WHERE e.id=2":       "true",
+		"SELECT EXISTS FROM Emp e WHERE e.id>5":       "false",
+		`select exists FROM Emp e WHERE e.name="ann"`: "true",
+		"SELECT EXISTS FROM Emp e":                    "true",
+	} {
+		out.Reset()
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		if got := strings.TrimSpace(out.String()); got != want {
+			t.Fatalf("%s: got %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestSelectAliasStar(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	for _, cmd := range []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10),age:INT)",
+		`INSERT INTO Emp VALUES (1,"ann",30)`,
+	} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT e.name, e.* FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT e.*: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || lines[0] != "ann ; 1 ; ann ; 30" {
+		t.Fatalf("unexpected SELECT e.* output: %q", out.String())
+	}
+	if err := s.ProcessCommand("SELECT x.* FROM Emp e", &out); err == nil {
+		t.Fatalf("expected error for a star on an unknown alias")
+	}
+}
+
+func TestUnconditionalDeleteNeedsAll(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	for _, cmd := range []string{
+		"CREATE TABLE Emp (id:INT)",
+		"INSERT INTO Emp VALUES (1)",
+		"INSERT INTO Emp VALUES (2)",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	for _, cmd := range []string{"DELETE Emp e", "DELETE FROM Emp"} {
+		err := s.ProcessCommand(cmd, &out)
+		if err == nil || !strings.Contains(err.Error(), "ALL") {
+			t.Fatalf("%s: expected a confirmation error, got %v", cmd, err)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT * FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if !strings.Contains(out.String(), "Total selected records = 2") {
+		t.Fatalf("guarded DELETE removed records: %q", out.String())
+	}
+
+	out.Reset()
+	if err := s.ProcessCommand("DELETE FROM Emp ALL", &out); err != nil {
+		t.Fatalf("DELETE ALL: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "Total deleted records = 2" {
+		t.Fatalf("unexpected DELETE ALL output: %q", got)
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT * FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if !strings.Contains(out.String(), "Total selected records = 0") {
+		t.Fatalf("DELETE ALL left records: %q", out.String())
+	}
+}
+
+func TestSoftDeleteCommands(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	run := func(s *SGBD, cmd string) string {
+		t.Helper()
+		out.Reset()
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		return strings.TrimSpace(out.String())
+	}
+	total := func(s *SGBD) string {
+		lines := strings.Split(run(s, "SELECT * FROM Emp e"), "\n")
+		return lines[len(lines)-1]
+	}
+	run(s, "CREATE TABLE Emp (id:INT,name:VARCHAR(10)) SOFT DELETE")
+	run(s, `INSERT INTO Emp VALUES (1,"ann")`)
+	run(s, `INSERT INTO Emp VALUES (2,"bob")`)
+	run(s, `INSERT INTO Emp VALUES (3,"cid")`)
+
+	rowid := strings.TrimSuffix(run(s, "SELECT ROWID FROM Emp e WHERE e.id = 1"), "\nTotal selected records = 1")
+	run(s, "DELETE Emp e WHERE e.id >= 2")
+	run(s, `DELETE FROM Emp WHERE ROWID = "`+rowid+`"`)
+	if got := total(s); got != "Total selected records = 0" {
+		t.Fatalf("after soft delete: %q", got)
+	}
+	if got := run(s, `UNDELETE Emp WHERE ROWID = "`+rowid+`"`); got != "Total restored records = 1" {
+		t.Fatalf("UNDELETE ROWID: %q", got)
+	}
+	if got := total(s); got != "Total selected records = 1" {
+		t.Fatalf("after UNDELETE ROWID: %q", got)
+	}
+
+	// the mode and the tombstones survive a restart
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	s2, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD after restart: %v", err)
+	}
+	if got := run(s2, "UNDELETE Emp"); got != "Total restored records = 2" {
+		t.Fatalf("UNDELETE: %q", got)
+	}
+	if got := total(s2); got != "Total selected records = 3" {
+		t.Fatalf("after UNDELETE: %q", got)
+	}
+
+	// UPDATE must not leave the old version behind as a tombstone
+	run(s2, `UPDATE Emp e SET e.name = "zed" WHERE e.id = 3`)
+	run(s2, "DELETE FROM Emp ALL")
+	if got := run(s2, "PURGE Emp"); got != "Total purged records = 3" {
+		t.Fatalf("PURGE: %q", got)
+	}
+	if got := run(s2, "UNDELETE Emp"); got != "Total restored records = 0" {
+		t.Fatalf("UNDELETE after PURGE: %q", got)
+	}
+	if got := total(s2); got != "Total selected records = 0" {
+		t.Fatalf("after PURGE: %q", got)
+	}
+}
+
+func TestCloseThenReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	for _, cmd := range []string{
+		"CREATE TABLE T (a:INT,b:VARCHAR(8))",
+		`INSERT INTO T VALUES (1,"one")`,
+		`INSERT INTO T VALUES (2,"two")`,
+	} {
+		if err := s.ProcessCommand(cmd, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	s2, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s2.ProcessCommand("SELECT * FROM T t", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	for _, want := range []string{"1 ; one", "2 ; two", "Total selected records = 2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output %q lacks %q", out.String(), want)
+		}
+	}
+}
+
+// TestInMemoryDatabaseIsDiscarded checks that an in-memory database writes no data
+// file, cleans up on Close and starts empty the next time.
+func TestInMemoryDatabaseIsDiscarded(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	s, err := NewSGBD(config.NewDBConfig(config.MemoryDBPath))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	for _, cmd := range []string{
+		"CREATE TABLE T (a:INT,b:VARCHAR(8))",
+		`INSERT INTO T VALUES (1,"one")`,
+		`INSERT INTO T VALUES (2,"two")`,
+	} {
+		if err := s.ProcessCommand(cmd, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	bins, err := filepath.Glob(filepath.Join(s.tmpDir, "BinData", "Data*"))
+	if err != nil || len(bins) != 0 {
+		t.Fatalf("data files written for an in-memory database: %v %v", bins, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if left, _ := os.ReadDir(tmp); len(left) != 0 {
+		t.Fatalf("Close left %d entries in TMPDIR", len(left))
+	}
+
+	s2, err := NewSGBD(config.NewDBConfig(config.MemoryDBPath))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+	if err := s2.ProcessCommand("SELECT * FROM T t", &bytes.Buffer{}); err == nil {
+		t.Fatalf("table T survived the in-memory database")
+	}
+}
+
+// TestCloseReleasesMemoryDirOnSaveError makes the save of an in-memory database
+// fail: Close reports it and still removes the temporary directory.
+func TestCloseReleasesMemoryDirOnSaveError(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	s, err := NewSGBD(config.NewDBConfig(config.MemoryDBPath))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	if err := s.ProcessCommand("CREATE TABLE T (a:INT)", &bytes.Buffer{}); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	// a file in place of the directory makes the catalog unwritable
+	if err := os.RemoveAll(s.tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.tmpDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err == nil {
+		t.Fatalf("Close should report the failed save")
+	}
+	if left, _ := os.ReadDir(tmp); len(left) != 0 {
+		t.Fatalf("Close left %d entries in TMPDIR", len(left))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestAlterTableRenameColumn(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	run := func(cmd string) (string, error) {
+		var out bytes.Buffer
+		err := s.ProcessCommand(cmd, &out)
+		return out.String(), err
+	}
+	for _, cmd := range []string{
+		"CREATE TABLE Emp (id:INT,name:VARCHAR(10),note:VARCHAR(10))",
+		`INSERT INTO Emp VALUES (1,"ann","a")`,
+		`INSERT INTO Emp VALUES (2,"bob","b")`,
+		"ALTER TABLE Emp RENAME COLUMN name TO fullname",
+	} {
+		if _, err := run(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	out, err := run(`SELECT e.fullname FROM Emp e WHERE e.fullname = "bob"`)
+	if err != nil {
+		t.Fatalf("SELECT with new name: %v", err)
+	}
+	if !strings.Contains(out, "bob") || !strings.Contains(out, "Total selected records = 1") {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if _, err := run(`SELECT e.name FROM Emp e`); err == nil {
+		t.Fatalf("the old column name should no longer resolve")
+	}
+	if _, err := run(`SELECT * FROM Emp e WHERE e.name = "bob"`); err == nil {
+		t.Fatalf("the old column name should no longer resolve in WHERE")
+	}
+	for _, cmd := range []string{
+		"ALTER TABLE Emp RENAME COLUMN note TO id",
+		"ALTER TABLE Emp RENAME COLUMN missing TO other",
+		"ALTER TABLE Nope RENAME COLUMN id TO key",
+		"ALTER TABLE Emp RENAME note TO other",
+	} {
+		if _, err := run(cmd); err == nil {
+			t.Fatalf("%s should fail", cmd)
+		}
+	}
+
+	if _, err := run("ALTER TABLE Emp DROP COLUMN note"); err != nil {
+		t.Fatalf("DROP COLUMN: %v", err)
+	}
+	out, err = run(`SELECT * FROM Emp e WHERE e.id = 2`)
+	if err != nil || !strings.Contains(out, "2 ; bob\n") {
+		t.Fatalf("SELECT after DROP COLUMN = %q, %v", out, err)
+	}
+
+	// the schema changes are persisted without an explicit Save
+	s2, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	rel, err := s2.dbm.GetTable("Emp")
+	if err != nil {
+		t.Fatalf("GetTable: %v", err)
+	}
+	if len(rel.Columns) != 2 || rel.Columns[1].Name != "fullname" {
+		t.Fatalf("columns after reopen: %+v", rel.Columns)
+	}
+}
+
+func TestMalformedNumericConstantInWhere(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	for _, cmd := range []string{
+		"CREATE TABLE Emp (age:INT,salary:FLOAT)",
+		"INSERT INTO Emp VALUES (0,0)",
+		"INSERT INTO Emp VALUES (30,1000)",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	for _, cmd := range []string{
+		`SELECT * FROM Emp e WHERE e.age = "abc"`,
+		`SELECT EXISTS FROM Emp e WHERE e.salary > "x"`,
+		`DELETE Emp e WHERE e.age = "abc"`,
+		`UPDATE Emp e SET e.age = 1 WHERE e.salary = "x"`,
+	} {
+		if err := s.ProcessCommand(cmd, &out); err == nil {
+			t.Fatalf("%s should fail on the malformed constant", cmd)
+		}
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT * FROM Emp e", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if !strings.Contains(out.String(), "0 ; 0\n") || !strings.Contains(out.String(), "Total selected records = 2") {
+		t.Fatalf("the failed commands changed the table: %q", out.String())
+	}
+}
+
+func TestWALRecoversTornDataFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	cfg.WAL = true
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	if err := s.ProcessCommand("CREATE TABLE T (a:INT,b:VARCHAR(8))", &bytes.Buffer{}); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	// the catalogue is saved and the log emptied by the checkpoint in Save
+	if err := s.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if st, err := os.Stat(filepath.Join(dir, walFile)); err != nil || st.Size() != 0 {
+		t.Fatalf("WAL after Save: %v, %v", st, err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := s.ProcessCommand(fmt.Sprintf(`INSERT INTO T VALUES (%d,"r%d")`, i, i), &bytes.Buffer{}); err != nil {
+			t.Fatalf("INSERT: %v", err)
+		}
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		t.Fatalf("FlushBuffers: %v", err)
+	}
+	// crash: no Save, and the data page the inserts went to is torn to zeros
+	var out bytes.Buffer
+	if err := s.ProcessCommand("SELECT t.ROWID FROM T t WHERE t.a = 0", &out); err != nil {
+		t.Fatalf("SELECT ROWID: %v", err)
+	}
+	rid, err := relation.ParseRecordId(strings.SplitN(out.String(), "\n", 2)[0])
+	if err != nil {
+		t.Fatalf("ParseRecordId: %v", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "BinData", fmt.Sprintf("Data%d.bin", rid.PageId.FileIdx)), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	_, err = f.WriteAt(make([]byte, cfg.PageSize), int64(rid.PageId.PageIdx*cfg.PageSize))
+	f.Close()
+	if err != nil {
+		t.Fatalf("WriteAt: %v", err)
+	}
+
+	s2, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	out.Reset()
+	if err := s2.ProcessCommand("SELECT t.a FROM T t WHERE t.a >= 150", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "Total selected records = 50\n") {
+		t.Fatalf("rows lost after recovery: %q", out.String())
+	}
+	if st, err := os.Stat(filepath.Join(dir, walFile)); err != nil || st.Size() != 0 {
+		t.Fatalf("WAL not emptied by recovery: %v, %v", st, err)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestCheckpointEmptiesWAL(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	cfg.WAL = true
+	cfg.CheckpointEvery = 5
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	walSize := func() int64 {
+		st, err := os.Stat(filepath.Join(dir, walFile))
+		if err != nil {
+			t.Fatalf("Stat WAL: %v", err)
+		}
+		return st.Size()
+	}
+	// CREATE and four inserts make five commands: the automatic checkpoint runs
+	cmds := []string{"CREATE TABLE T (a:INT)"}
+	for i := 0; i < 4; i++ {
+		cmds = append(cmds, fmt.Sprintf("INSERT INTO T VALUES (%d)", i))
+	}
+	for i, c := range cmds {
+		if err := s.ProcessCommand(c, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", c, err)
+		}
+		if i == 3 && walSize() == 0 {
+			t.Fatalf("WAL empty before the automatic checkpoint")
+		}
+	}
+	if n := walSize(); n != 0 {
+		t.Fatalf("WAL is %d bytes after %d commands, want 0", n, len(cmds))
+	}
+	if err := s.ProcessCommand("INSERT INTO T VALUES (4)", &bytes.Buffer{}); err != nil {
+		t.Fatalf("INSERT: %v", err)
+	}
+	if walSize() == 0 {
+		t.Fatalf("INSERT was not logged")
+	}
+	if err := s.ProcessCommand("CHECKPOINT", &bytes.Buffer{}); err != nil {
+		t.Fatalf("CHECKPOINT: %v", err)
+	}
+	if n := walSize(); n != 0 {
+		t.Fatalf("WAL is %d bytes after CHECKPOINT, want 0", n)
+	}
+	// the interval trigger fires on the next command once enough time has passed
+	cfg.CheckpointEvery = 0
+	cfg.CheckpointInterval = 60
+	if err := s.ProcessCommand("INSERT INTO T VALUES (5)", &bytes.Buffer{}); err != nil {
+		t.Fatalf("INSERT: %v", err)
+	}
+	if walSize() == 0 {
+		t.Fatalf("checkpoint ran before the interval elapsed")
+	}
+	s.lastCheckpoint = s.lastCheckpoint.Add(-time.Minute)
+	if err := s.ProcessCommand("INSERT INTO T VALUES (6)", &bytes.Buffer{}); err != nil {
+		t.Fatalf("INSERT: %v", err)
+	}
+	if n := walSize(); n != 0 {
+		t.Fatalf("WAL is %d bytes after the interval elapsed, want 0", n)
+	}
+	// Execute and prepared statements count as commands too
+	ins, err := s.Prepare("INSERT INTO T VALUES (?)")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	for i, run := range []func() error{
+		func() error { _, err := s.Execute("INSERT INTO T VALUES (7)"); return err },
+		func() error { _, err := ins.Exec(8); return err },
+	} {
+		s.lastCheckpoint = s.lastCheckpoint.Add(-time.Minute)
+		if err := run(); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+		if n := walSize(); n != 0 {
+			t.Fatalf("insert %d: WAL is %d bytes after the interval elapsed, want 0", i, n)
+		}
+	}
+	// checkpointed data is durable without Save: the catalogue aside, a new
+	// instance reads it from the data files alone
+	if err := s.dbm.SaveState(); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	s2, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s2.ProcessCommand("SELECT * FROM T t", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "Total selected records = 9\n") {
+		t.Fatalf("SELECT after checkpoint = %q", out.String())
+	}
+}
+
+func TestBlobColumnCommands(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	data := make([]byte, 3000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	b64 := base64.StdEncoding.EncodeToString(data)
+	for _, c := range []string{
+		"CREATE TABLE F (id:INT,data:BLOB)",
+		fmt.Sprintf(`INSERT INTO F VALUES (1,"%s")`, b64),
+		`INSERT INTO F VALUES (2,"")`,
+	} {
+		if err := s.ProcessCommand(c, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%.40s: %v", c, err)
+		}
+	}
+	if err := s.ProcessCommand(`INSERT INTO F VALUES (3,"not base64!")`, &bytes.Buffer{}); err == nil {
+		t.Fatalf("invalid base64 should be rejected")
+	}
+	if err := s.ProcessCommand(`SELECT f.id FROM F f WHERE f.data = "AA=="`, &bytes.Buffer{}); err == nil {
+		t.Fatalf("comparing a BLOB should be rejected")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// the schema and the blob survive a restart
+	s, err = NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	defer s.Close()
+	var out bytes.Buffer
+	if err := s.ProcessCommand("DESCRIBE TABLE F", &out); err != nil || !strings.Contains(out.String(), "data:BLOB") {
+		t.Fatalf("DESCRIBE = %q, %v", out.String(), err)
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT f.data FROM F f WHERE f.id = 1", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if want := b64 + "\nTotal selected records = 1\n"; out.String() != want {
+		t.Fatalf("SELECT returned %d bytes, want the base64 blob", out.Len())
+	}
+	out.Reset()
+	if err := s.ProcessCommand(`UPDATE F f SET f.data = "AAEC" WHERE f.id = 2`, &out); err != nil {
+		t.Fatalf("UPDATE: %v", err)
+	}
+	rel, _ := s.dbm.GetTable("F")
+	var got []byte
+	if err := s.dbm.ScanTableRecords("F", func(rec relation.Record, _ relation.RecordId) error {
+		if rec.Values[0] == "2" {
+			got, err = rec.Bind(rel).Bytes(1)
+		}
+		return err
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if !bytes.Equal(got, []byte{0, 1, 2}) {
+		t.Fatalf("updated blob = %v", got)
+	}
+}
+
+func TestSelectLimitStopsScanEarly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s.ProcessCommand("CREATE TABLE T (id:INT,pad:CHAR(100))", &out); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	const rows = 1000
+	for i := 0; i < rows; i++ {
+		if err := s.ProcessCommand(fmt.Sprintf(`INSERT INTO T VALUES (%d,"p")`, i%10), &out); err != nil {
+			t.Fatalf("INSERT: %v", err)
+		}
+	}
+	// selects, returning the printed rows and the GetPage calls of the scan
+	sel := func(cmd string) ([]string, int64) {
+		t.Helper()
+		out.Reset()
+		s.bm.ResetStats()
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		return lines[:len(lines)-1], s.bm.Stats().Requests
+	}
+	all, full := sel("SELECT t.id FROM T t")
+	if len(all) != rows {
+		t.Fatalf("full scan returned %d rows", len(all))
+	}
+	// the window is taken in scan order: the 3rd to 5th matches of the full scan
+	var want []string
+	for _, v := range all {
+		if v >= "5" {
+			want = append(want, v)
+		}
+	}
+	got, early := sel("SELECT t.id FROM T t WHERE t.id>=5 LIMIT 3 OFFSET 2")
+	if strings.Join(got, ",") != strings.Join(want[2:5], ",") {
+		t.Fatalf("LIMIT 3 OFFSET 2 returned %v, want %v", got, want[2:5])
+	}
+	if early*5 > full {
+		t.Fatalf("LIMIT read %d pages, a full scan %d: the scan did not stop early", early, full)
+	}
+	if got, n := sel("SELECT t.id FROM T t LIMIT 0"); len(got) != 0 || n != 0 {
+		t.Fatalf("LIMIT 0 returned %v after %d page reads", got, n)
+	}
+	if got, _ := sel(fmt.Sprintf("SELECT t.id FROM T t LIMIT 5 OFFSET %d", rows-2)); len(got) != 2 {
+		t.Fatalf("an OFFSET near the end returned %v", got)
+	}
+	// with ORDER BY or DISTINCT the window applies to the final rows
+	if got, _ := sel("SELECT t.id FROM T t ORDER BY t.id DESC LIMIT 2 OFFSET 99"); strings.Join(got, ",") != "9,8" {
+		t.Fatalf("ORDER BY ... LIMIT returned %v", got)
+	}
+	if got, _ := sel("SELECT DISTINCT t.id FROM T t LIMIT 4 OFFSET 8"); len(got) != 2 || got[0] == got[1] {
+		t.Fatalf("DISTINCT ... LIMIT returned %v", got)
+	}
+	if got, _ := sel(`SELECT t.id FROM T t WHERE t.pad=" LIMIT 1"`); len(got) != 0 {
+		t.Fatalf("LIMIT inside a constant was parsed: %v", got)
+	}
+	for _, cmd := range []string{
+		"SELECT t.id FROM T t LIMIT -1",
+		"SELECT t.id FROM T t LIMIT x",
+		"SELECT t.id FROM T t LIMIT 1 OFFSET",
+		"SELECT EXISTS FROM T t LIMIT 1",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err == nil {
+			t.Fatalf("%s: expected an error", cmd)
+		}
+	}
+}
+
+func TestNegativeAndExponentLiterals(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	for _, cmd := range []string{
+		"CREATE TABLE Acc (id:INT,balance:FLOAT)",
+		"INSERT INTO Acc VALUES (-1,-250.5)",
+		"INSERT INTO Acc VALUES (2e1,1.5e3)",
+		"INSERT INTO Acc VALUES (3,-2E-2)",
+		"INSERT INTO Acc VALUES (+4,-1e+2)",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	sel := func(cmd string) string {
+		t.Helper()
+		out.Reset()
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		return strings.Join(lines[:len(lines)-1], "|")
+	}
+	for cmd, want := range map[string]string{
+		"SELECT a.id FROM Acc a WHERE a.balance > -100 ORDER BY a.id":  "3|20",
+		"SELECT a.id FROM Acc a WHERE a.balance>-1e2 ORDER BY a.id":    "3|20",
+		"SELECT a.id FROM Acc a WHERE -1E2 = a.balance":                "4",
+		"SELECT a.id FROM Acc a WHERE a.balance < -2e-3 ORDER BY a.id": "-1|3|4",
+		"SELECT a.id FROM Acc a WHERE a.id = -1":                       "-1",
+		"SELECT a.id FROM Acc a WHERE a.id >= 2e1":                     "20",
+		"SELECT a.balance FROM Acc a WHERE a.id = 20":                  "1500",
+	} {
+		if got := sel(cmd); got != want {
+			t.Fatalf("%s: got %q, want %q", cmd, got, want)
+		}
+	}
+	for _, cmd := range []string{
+		"UPDATE Acc a SET a.balance = -3.5e2 WHERE a.id = -1",
+		"UPDATE Acc a SET a.id = 1e2, a.balance = a.balance * -1e-1 WHERE a.id = 20",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	if got := sel("SELECT a.id, a.balance FROM Acc a WHERE a.id = -1 OR a.id = 100 ORDER BY a.id"); got != "-1 ; -350|100 ; -150" {
+		t.Fatalf("after UPDATE: got %q", got)
+	}
+	// an exponent does not make a fraction an INT
+	for _, cmd := range []string{
+		"INSERT INTO Acc VALUES (1.5e0,1)",
+		"SELECT a.id FROM Acc a WHERE a.id = 2.5e-1",
+		"UPDATE Acc a SET a.id = 1e-1 WHERE a.id = 3",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err == nil {
+			t.Fatalf("%s: expected an error", cmd)
+		}
+	}
+}
+
+func TestErrorsMatchSentinels(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	cfg.StrictStrings = true
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s.ProcessCommand("CREATE TABLE Emp (id:INT,name:VARCHAR(4))", &out); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	cases := []struct {
+		cmd  string
+		want error
+	}{
+		{"SELEKT * FROM Emp e", ErrSyntax},
+		{"INSERT INTO Emp (1,\"a\")", ErrSyntax},
+		{"SELECT e.id FROM Emp e WHERE (e.id = 1", ErrSyntax},
+		{"SELECT e.id FROM Emp e LIMIT x", ErrSyntax},
+		{"SELECT e.id FROM Nope e", db.ErrTableNotFound},
+		{"DROP TABLE Nope", db.ErrTableNotFound},
+		{"CREATE TABLE Emp (id:INT)", db.ErrTableExists},
+		{"ALTER TABLE Emp RENAME COLUMN nope TO x", db.ErrColumnNotFound},
+		{"ALTER TABLE Emp RENAME COLUMN id TO name", db.ErrColumnExists},
+		{"INSERT INTO Emp VALUES (abc,\"a\")", relation.ErrInvalidValue},
+		{"SELECT e.id FROM Emp e WHERE e.id = x1", relation.ErrInvalidValue},
+		{"INSERT INTO Emp VALUES (1,\"toolong\")", relation.ErrValueTooLong},
+		{"DELETE Emp e WHERE ROWID = \"0:1:900\"", relation.ErrRecordNotFound},
+	}
+	for _, c := range cases {
+		err := s.ProcessCommand(c.cmd, &out)
+		if !errors.Is(err, c.want) {
+			t.Fatalf("%s: got %v, want an error matching %v", c.cmd, err, c.want)
+		}
+	}
+	// the REPL adds a hint where one helps
+	var errs bytes.Buffer
+	if err := s.RunWith(strings.NewReader("SELECT e.id FROM Nope e\n"), &out, &errs); err != nil {
+		t.Fatalf("RunWith: %v", err)
+	}
+	if !strings.Contains(errs.String(), "table not found: Nope (DESCRIBE TABLES") {
+		t.Fatalf("REPL error output: %q", errs.String())
+	}
+}
+
+func TestCancelledCommandLeavesTableUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	for _, cmd := range []string{"CREATE TABLE T (id:INT)", "INSERT INTO T VALUES (1)", "INSERT INTO T VALUES (2)"} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, cmd := range []string{"DELETE T t WHERE t.id > 0", "UPDATE T t SET t.id = 9", "SELECT t.id FROM T t", "SELECT t.id FROM T t, T u WHERE t.id = u.id"} {
+		if err := s.ProcessCommandContext(ctx, cmd, &out); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%s with a cancelled context = %v", cmd, err)
+		}
+	}
+	if err := s.bm.AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := s.ProcessCommand("SELECT t.id FROM T t ORDER BY t.id", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "1\n2\nTotal selected records = 2" {
+		t.Fatalf("table changed by cancelled commands: %q", got)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var sb strings.Builder
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&sb, "%d\n", i)
+	}
+	csvPath := filepath.Join(dir, "big.csv")
+	if err := os.WriteFile(csvPath, []byte(sb.String()), 0o644); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	var out bytes.Buffer
+	for _, cmd := range []string{
+		"CREATE TABLE Big (id:INT)",
+		"APPEND INTO Big ALLRECORDS (" + csvPath + ")",
+		"SET query_timeout_ms = 1",
+	} {
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	// a nested-loop join rescans the inner table for every outer row: 9M pairs
+	start := time.Now()
+	err = s.ProcessCommand("SELECT a.id FROM Big a, Big b WHERE a.id < b.id", &out)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "query_timeout_ms=1") {
+		t.Fatalf("runaway join = %v, want a query timeout", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("the timeout took %v to stop the join", d)
+	}
+	if err := s.bm.AssertAllUnpinned(); err != nil {
+		t.Fatal(err)
+	}
+	// so does the library API
+	if _, err := s.Execute("SELECT a.id FROM Big a, Big b WHERE a.id < b.id"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Execute of the runaway join = %v, want a query timeout", err)
+	}
+	stmt, err := s.Prepare("SELECT a.id FROM Big a, Big b WHERE a.id < b.id AND b.id < ?")
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if _, err := stmt.Exec(3000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stmt.Exec of the runaway join = %v, want a query timeout", err)
+	}
+	// a timed-out DELETE deletes nothing
+	s.cfg.QueryTimeoutMs = 0
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	if err := s.ProcessCommandContext(ctx, "DELETE Big b WHERE b.id >= 0", &out); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DELETE past its deadline = %v", err)
+	}
+	for _, cmd := range []string{"SET query_timeout_ms = 0", "SELECT b.id FROM Big b"} {
+		out.Reset()
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	if !strings.HasSuffix(out.String(), "Total selected records = 3000\n") {
+		t.Fatalf("full scan without a timeout did not return every row")
+	}
+	for _, cmd := range []string{"SET query_timeout_ms = -1", "SET query_timeout_ms", "SET nope = 1"} {
+		if err := s.ProcessCommand(cmd, &out); err == nil {
+			t.Fatalf("%s: expected an error", cmd)
+		}
+	}
+}
+
+func TestGroupByHaving(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	cmds := []string{
+		"CREATE TABLE Emp (id:INT,dept:VARCHAR(12),salary:FLOAT,age:INT)",
+		`INSERT INTO Emp VALUES (1," HAVING x ",10,20)`,
+	}
+	for i := 2; i <= 11; i++ {
+		dept := "Eng"
+		if i > 8 {
+			dept = "Ops"
+		}
+		cmds = append(cmds, fmt.Sprintf(`INSERT INTO Emp VALUES (%d,"%s",%d.5,%d)`, i, dept, i*100, 20+i))
+	}
+	for _, cmd := range cmds {
+		if _, err := s.Execute(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	rows := func(cmd string) [][]string {
+		t.Helper()
+		res, err := s.Execute(cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		return res.Rows
+	}
+	// Eng: ids 2..8, Ops: ids 9..11; groups come out ordered by their values
+	got := fmt.Sprint(rows("SELECT e.dept, COUNT(*), SUM(e.age), SUM(e.salary) FROM Emp e GROUP BY e.dept"))
+	if want := "[[ HAVING x  1 20 10] [Eng 7 175 3503.5] [Ops 3 90 3001.5]]"; got != want {
+		t.Fatalf("GROUP BY rows = %s, want %s", got, want)
+	}
+	for cmd, want := range map[string]string{
+		"SELECT e.dept, COUNT(*) FROM Emp e GROUP BY e.dept HAVING COUNT(*) > 5":                           "[[Eng 7]]",
+		"SELECT e.dept, count(*) FROM Emp e WHERE e.id > 1 GROUP BY e.dept HAVING COUNT(*) >= 3":           "[[Eng 7] [Ops 3]]",
+		"SELECT e.dept, SUM(e.age) FROM Emp e GROUP BY e.dept HAVING SUM(e.age) < 100 AND SUM(e.age) > 50": "[[Ops 90]]",
+		"SELECT e.dept, SUM(e.salary) FROM Emp e GROUP BY e.dept HAVING SUM(e.salary) > 3500":              "[[Eng 3503.5]]",
+		`SELECT e.dept, COUNT(*) FROM Emp e GROUP BY e.dept HAVING e.dept <> "Eng"`:                        "[[ HAVING x  1] [Ops 3]]",
+		"SELECT e.dept, COUNT(*) FROM Emp e GROUP BY e.dept ORDER BY COUNT(*) DESC LIMIT 2":                "[[Eng 7] [Ops 3]]",
+		"SELECT COUNT(*), SUM(e.age) FROM Emp e WHERE e.id > 8":                                            "[[3 90]]",
+		"SELECT COUNT(e.id) FROM Emp e WHERE e.id > 100":                                                   "[[0]]",
+		`SELECT e.dept, "z", COUNT(*), 7 FROM Emp e WHERE e.id > 1 GROUP BY e.dept`:                        "[[Eng z 7 7] [Ops z 3 7]]",
+	} {
+		if got := fmt.Sprint(rows(cmd)); got != want {
+			t.Fatalf("%s: rows = %s, want %s", cmd, got, want)
+		}
+	}
+	for _, cmd := range []string{
+		"SELECT e.dept, COUNT(*) FROM Emp e GROUP BY e.dept HAVING SUM(e.age) > 5",
+		"SELECT e.id FROM Emp e GROUP BY e.dept",
+		"SELECT e.dept, SUM(*) FROM Emp e GROUP BY e.dept",
+		"SELECT DISTINCT e.dept FROM Emp e GROUP BY e.dept",
+	} {
+		if _, err := s.Execute(cmd); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("%s: err = %v, want ErrSyntax", cmd, err)
+		}
+	}
+	if _, err := s.Execute("SELECT e.dept, SUM(e.dept) FROM Emp e GROUP BY e.dept"); err == nil {
+		t.Fatalf("SUM over a VARCHAR column should fail")
+	}
+	res, err := s.Execute(`SELECT e.id FROM Emp e WHERE e.dept = " HAVING x "`)
+	if err != nil || res.Count != 1 {
+		t.Fatalf("HAVING inside a constant: %v, %v", res.Rows, err)
+	}
+}
+
+func TestBigEndianRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	bigCfg := func() *config.DBConfig {
+		cfg := config.NewDBConfig(dir)
+		cfg.ByteOrder = config.ByteOrderBig
+		return cfg
+	}
+	s, err := NewSGBD(bigCfg())
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	// 16909060 is 0x01020304
+	for _, cmd := range []string{
+		"CREATE TABLE T (a:INT,f:FLOAT,s:VARCHAR(8))",
+		`INSERT INTO T VALUES (16909060,-2.5,"one")`,
+		`INSERT INTO T VALUES (-7,1e3,"two")`,
+	} {
+		if err := s.ProcessCommand(cmd, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "BinData", "Data0.bin"))
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+	if !bytes.Contains(data, []byte{1, 2, 3, 4}) || bytes.Contains(data, []byte{4, 3, 2, 1}) {
+		t.Fatalf("the INT value is not stored big-endian")
+	}
+
+	s2, err := NewSGBD(bigCfg())
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s2.ProcessCommand("SELECT * FROM T t ORDER BY t.a", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if want := "-7 ; 1000 ; two\n16909060 ; -2.5 ; one\nTotal selected records = 2\n"; out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// the pages cannot be read in the default order
+	if _, err := NewSGBD(config.NewDBConfig(dir)); err == nil || !strings.Contains(err.Error(), "byte_order") {
+		t.Fatalf("opening with byte_order little: err = %v", err)
+	}
+}
+
+// TestReopenWithOtherPageSize reopens a database created with 4096-byte pages with
+// 8192 configured: every page offset would be off, so it must be refused up front.
+func TestReopenWithOtherPageSize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfigWithParams(dir, 4096, 4))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	for _, cmd := range []string{"CREATE TABLE T (a:INT,s:VARCHAR(8))", `INSERT INTO T VALUES (1,"one")`, `INSERT INTO T VALUES (2,"two")`} {
+		if err := s.ProcessCommand(cmd, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	before, err := os.ReadFile(filepath.Join(dir, "BinData", "Data0.bin"))
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+
+	_, err = NewSGBD(config.NewDBConfigWithParams(dir, 8192, 4))
+	if !errors.Is(err, disk.ErrPageSizeMismatch) || !strings.Contains(err.Error(), "pagesize 4096") {
+		t.Fatalf("opening with pagesize 8192: err = %v", err)
+	}
+	after, err := os.ReadFile(filepath.Join(dir, "BinData", "Data0.bin"))
+	if err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("data file changed by the refused open (%v)", err)
+	}
+
+	s2, err := NewSGBD(config.NewDBConfigWithParams(dir, 4096, 4))
+	if err != nil {
+		t.Fatalf("reopen with pagesize 4096: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s2.ProcessCommand("SELECT * FROM T t ORDER BY t.a", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if want := "1 ; one\n2 ; two\nTotal selected records = 2\n"; out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// TestReopenWithOtherPageReserve reopens a database created with page_reserve_bytes
+// 64 without it: the page headers would be read at the wrong offset.
+func TestReopenWithOtherPageReserve(t *testing.T) {
+	dir := t.TempDir()
+	reserveCfg := func(reserve int) *config.DBConfig {
+		cfg := config.NewDBConfig(dir)
+		cfg.PageReserveBytes = reserve
+		return cfg
+	}
+	s, err := NewSGBD(reserveCfg(64))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	for _, cmd := range []string{"CREATE TABLE T (a:INT,s:VARCHAR(8))", `INSERT INTO T VALUES (1,"one")`, `INSERT INTO T VALUES (2,"two")`} {
+		if err := s.ProcessCommand(cmd, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	before, err := os.ReadFile(filepath.Join(dir, "BinData", "Data0.bin"))
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+
+	for _, reserve := range []int{0, 32} {
+		_, err = NewSGBD(reserveCfg(reserve))
+		if !errors.Is(err, disk.ErrPageReserveMismatch) || !strings.Contains(err.Error(), "page_reserve_bytes 64") {
+			t.Fatalf("opening with page_reserve_bytes %d: err = %v", reserve, err)
+		}
+	}
+	after, err := os.ReadFile(filepath.Join(dir, "BinData", "Data0.bin"))
+	if err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("data file changed by the refused open (%v)", err)
+	}
+
+	s2, err := NewSGBD(reserveCfg(64))
+	if err != nil {
+		t.Fatalf("reopen with page_reserve_bytes 64: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s2.ProcessCommand("SELECT * FROM T t ORDER BY t.a", &out); err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if want := "1 ; one\n2 ; two\nTotal selected records = 2\n"; out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package sgbd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SplitStatements splits a script into commands separated by ';' or newlines.
+// Separators inside single- or double-quoted strings are kept; empty statements
+// are dropped.
+func SplitStatements(script string) []string {
+	var out []string
+	var cur strings.Builder
+	var quote rune
+	flush := func() {
+		if st := strings.TrimSpace(cur.String()); st != "" {
+			out = append(out, st)
+		}
+		cur.Reset()
+	}
+	for _, r := range script {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ';' || r == '\n' || r == '\r':
+			flush()
+			continue
+		}
+		cur.WriteRune(r)
+	}
+	flush()
+	return out
+}
+
+// RunScript executes the commands of script (see SplitStatements) without reading
+// stdin, writing their output to w and errors to errw. As in the interactive loop a
+// failing command does not stop the script, and EXIT ends it early. The SGBD is
+// closed at the end (see Close); if any command failed an error is returned after that.
+func (s *SGBD) RunScript(script string, w, errw io.Writer) error {
+	failed := 0
+	for _, st := range SplitStatements(script) {
+		if strings.EqualFold(st, "EXIT") {
+			break
+		}
+		if err := s.ProcessCommand(st, w); err != nil {
+			fmt.Fprintf(errw, "error: %s\n", describeError(err))
+			failed++
+		}
+	}
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d command(s) failed", failed)
+	}
+	return nil
+}
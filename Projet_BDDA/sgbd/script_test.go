@@ -0,0 +1,145 @@
+package sgbd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+func TestSplitStatements(t *testing.T) {
+	got := SplitStatements("CREATE TABLE T (id:INT,n:VARCHAR(5)); INSERT INTO T VALUES (1,\"a;b\");\n\n SELECT * FROM T t ;;INSERT INTO T VALUES (2,'x\ny')")
+	want := []string{
+		"CREATE TABLE T (id:INT,n:VARCHAR(5))",
+		`INSERT INTO T VALUES (1,"a;b")`,
+		"SELECT * FROM T t",
+		"INSERT INTO T VALUES (2,'x\ny')",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("SplitStatements = %q, want %q", got, want)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out, errs bytes.Buffer
+	script := `CREATE TABLE Emp (id:INT,name:VARCHAR(10)); INSERT INTO Emp VALUES (1,"ann")
+INSERT INTO Emp VALUES (2,"bob"); SELECT e.name FROM Emp e WHERE e.id = 2
+EXIT
+INSERT INTO Emp VALUES (3,"cid")`
+	if err := s.RunScript(script, &out, &errs); err != nil {
+		t.Fatalf("RunScript: %v (stderr %q)", err, errs.String())
+	}
+	if got, want := out.String(), "OK\nOK\nOK\nbob\nTotal selected records = 1\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+
+	// the state was saved: a new instance sees both rows and nothing after EXIT
+	s2, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD after script: %v", err)
+	}
+	out.Reset()
+	if err := s2.RunScript("SELECT * FROM Emp e; BOGUS", &out, &errs); err == nil {
+		t.Fatalf("a failing command should make RunScript return an error")
+	}
+	if !strings.Contains(out.String(), "Total selected records = 2") {
+		t.Fatalf("unexpected rows after reload: %q", out.String())
+	}
+	if !strings.Contains(errs.String(), "unsupported command: BOGUS") {
+		t.Fatalf("error not reported: %q", errs.String())
+	}
+}
+
+func TestPromptSuppressedWithoutTerminal(t *testing.T) {
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	s.SetPrompt("> ")
+	var out, errOut bytes.Buffer
+	in := strings.NewReader("CREATE TABLE T (id:INT)\n\nINSERT INTO T VALUES (1)\nSELECT * FROM T t\nEXIT\n")
+	if err := s.RunWith(in, &out, &errOut); err != nil {
+		t.Fatalf("RunWith: %v", err)
+	}
+	if errOut.Len() != 0 {
+		t.Fatalf("unexpected errors: %q", errOut.String())
+	}
+	if want := "OK\nOK\n1\nTotal selected records = 1\n"; out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Fatalf("a regular file is not a terminal")
+	}
+}
+
+func TestInteractiveHistory(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	// commands run through ProcessCommand are not recorded
+	if err := s.ProcessCommand("CREATE TABLE T (id:INT)", &bytes.Buffer{}); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".history")); !os.IsNotExist(err) {
+		t.Fatalf("ProcessCommand should not write the history, Stat error = %v", err)
+	}
+	// nor are piped commands
+	var out, errOut bytes.Buffer
+	if err := s.RunWith(strings.NewReader("SELECT * FROM T t\n"), &out, &errOut); err != nil {
+		t.Fatalf("RunWith: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".history")); !os.IsNotExist(err) {
+		t.Fatalf("piped input should not write the history, Stat error = %v", err)
+	}
+	// a reader and writer taken for terminals
+	in := strings.NewReader("INSERT INTO T VALUES (1)\n\nSELECT * FROM T t\nBOGUS\nEXIT\n")
+	if err := s.runLoop(in, &out, &errOut, true); err != nil {
+		t.Fatalf("runLoop: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".history"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if want := "INSERT INTO T VALUES (1)\nSELECT * FROM T t\nBOGUS\n"; string(data) != want {
+		t.Fatalf("history = %q, want %q", data, want)
+	}
+
+	// a new session sees the previous commands
+	s2, err := NewSGBD(config.NewDBConfig(dir))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	out.Reset()
+	if err := s2.runLoop(strings.NewReader("HISTORY 2\n"), &out, &errOut, true); err != nil {
+		t.Fatalf("runLoop: %v", err)
+	}
+	if want := "SELECT * FROM T t\nBOGUS\n"; out.String() != want {
+		t.Fatalf("HISTORY 2 = %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	if err := s2.ProcessCommand("HISTORY", &out); err != nil {
+		t.Fatalf("HISTORY: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "INSERT INTO T VALUES (1)\n") || !strings.HasSuffix(out.String(), "HISTORY 2\n") {
+		t.Fatalf("HISTORY = %q", out.String())
+	}
+	if err := s2.ProcessCommand("HISTORY x", &out); err == nil {
+		t.Fatalf("HISTORY x should fail")
+	}
+}
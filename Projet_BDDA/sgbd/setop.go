@@ -0,0 +1,149 @@
+package sgbd
+
+import (
+	"fmt"
+	"strings"
+
+	"malzahar-project/Projet_BDDA/config"
+	"malzahar-project/Projet_BDDA/relation"
+)
+
+// setOps are the operators combining two SELECTs, longest first so UNION ALL is not
+// read as UNION.
+var setOps = []string{"UNION ALL", "UNION", "INTERSECT", "EXCEPT"}
+
+// splitSetOp finds the last set operator followed by SELECT outside double-quoted
+// constants in text, and returns the SELECT (or chain of SELECTs) before it, the
+// operator and the SELECT after it. Chains thus group from the left, all operators
+// alike: INTERSECT does not bind tighter than UNION.
+func splitSetOp(text string) (string, string, string, bool) {
+	up := strings.ToUpper(text)
+	at, op := -1, ""
+	inQuote := false
+	for i := 0; i < len(up); i++ {
+		if up[i] == '"' {
+			inQuote = !inQuote
+			continue
+		}
+		if inQuote || up[i] != ' ' {
+			continue
+		}
+		for _, o := range setOps {
+			if strings.HasPrefix(up[i:], " "+o+" SELECT ") {
+				at, op = i, o
+				break
+			}
+		}
+	}
+	if at < 0 {
+		return "", "", "", false
+	}
+	return strings.TrimSpace(text[:at]), op, strings.TrimSpace(text[at+len(op)+2:]), true
+}
+
+// executeSetOp runs "left op right": both sides are complete SELECTs, ORDER BY and
+// LIMIT applying to their own side only. UNION ALL appends the right rows to the left
+// ones; UNION also drops duplicate rows, compared by value as with DISTINCT.
+// INTERSECT keeps the distinct left rows found on the right, EXCEPT those not found
+// there; the right rows are held in memory as a set of keys.
+func (s *SGBD) executeSetOp(left, op, right string) (Result, error) {
+	l, err := s.executeSelect(left)
+	if err != nil {
+		return Result{}, err
+	}
+	r, err := s.executeSelect(right)
+	if err != nil {
+		return Result{}, err
+	}
+	cols, err := combineColumns(op, l, r)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{Kind: ResultRows, Command: "SELECT", Columns: cols, Rows: [][]string{}}
+	if op == "UNION ALL" {
+		res.Rows = append(append(res.Rows, l.Rows...), r.Rows...)
+		res.Count = len(res.Rows)
+		return res, nil
+	}
+	limit := s.cfg.DistinctMemoryRows
+	if limit == 0 {
+		limit = config.DefaultDistinctMemoryRows
+	}
+	key := rowKey(cols)
+	dedup := newRowDistinct(s.cfg.DBPath, limit, key)
+	defer dedup.Close()
+	if op == "UNION" {
+		for _, rows := range [][][]string{l.Rows, r.Rows} {
+			for _, row := range rows {
+				if err := dedup.Add(row); err != nil {
+					return Result{}, err
+				}
+			}
+		}
+	} else {
+		right := make(map[string]bool, len(r.Rows))
+		for _, row := range r.Rows {
+			right[key(row)] = true
+		}
+		for _, row := range l.Rows {
+			if right[key(row)] != (op == "INTERSECT") {
+				continue
+			}
+			if err := dedup.Add(row); err != nil {
+				return Result{}, err
+			}
+		}
+	}
+	if err := dedup.Each(func(row []string) error {
+		res.Rows = append(res.Rows, row)
+		return nil
+	}); err != nil {
+		return Result{}, err
+	}
+	if err := dedup.Close(); err != nil {
+		return Result{}, err
+	}
+	res.Count = len(res.Rows)
+	return res, nil
+}
+
+// combineColumns checks that the results of the two sides of op have as many
+// columns, of compatible types, and returns the columns of the combined result: the
+// left names, with INT and FLOAT combining to FLOAT, and CHAR and VARCHAR to a
+// VARCHAR as long as the longer of both.
+func combineColumns(op string, l, r Result) ([]ResultColumn, error) {
+	if l.Command != "SELECT" || r.Command != "SELECT" {
+		return nil, fmt.Errorf("%s needs two SELECTs of rows, not %s and %s", op, l.Command, r.Command)
+	}
+	if len(l.Columns) != len(r.Columns) {
+		return nil, fmt.Errorf("%s: the left SELECT has %d columns, the right one %d", op, len(l.Columns), len(r.Columns))
+	}
+	cols := make([]ResultColumn, len(l.Columns))
+	for i, lc := range l.Columns {
+		rc := r.Columns[i]
+		cols[i] = lc
+		if lc.Type == rc.Type {
+			continue
+		}
+		lk, ls, lerr := relation.ParseColumnType(lc.Type)
+		rk, rs, rerr := relation.ParseColumnType(rc.Type)
+		switch {
+		case lerr != nil || rerr != nil:
+		case isNumericKind(lk) && isNumericKind(rk):
+			cols[i].Type = relation.ColumnInfo{Kind: relation.KindFloat}.TypeString()
+			continue
+		case isStringKind(lk) && isStringKind(rk):
+			if rs > ls {
+				ls = rs
+			}
+			cols[i].Type = relation.ColumnInfo{Kind: relation.KindVarchar, Size: ls}.TypeString()
+			continue
+		}
+		return nil, fmt.Errorf("%s: column %d is %s on the left and %s on the right", op, i+1, lc.Type, rc.Type)
+	}
+	return cols, nil
+}
+
+func isStringKind(k relation.ColumnKind) bool {
+	return k == relation.KindChar || k == relation.KindVarchar
+}
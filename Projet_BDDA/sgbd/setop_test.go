@@ -0,0 +1,132 @@
+package sgbd
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+// newSetOpDB creates A (id INT, name VARCHAR(6)) and B (n FLOAT, label CHAR(4)),
+// sharing the rows (1,"x") and (3,"z").
+func newSetOpDB(t *testing.T) *SGBD {
+	t.Helper()
+	s, err := NewSGBD(config.NewDBConfig(t.TempDir()))
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	for _, cmd := range []string{
+		"CREATE TABLE A (id:INT,name:VARCHAR(6))",
+		"CREATE TABLE B (n:FLOAT,label:CHAR(4))",
+		`INSERT INTO A VALUES (1,"x")`,
+		`INSERT INTO A VALUES (2,"y")`,
+		`INSERT INTO A VALUES (1,"x")`,
+		`INSERT INTO A VALUES (3,"z")`,
+		`INSERT INTO B VALUES (1.0,"x")`,
+		`INSERT INTO B VALUES (3,"z")`,
+		`INSERT INTO B VALUES (4.5,"w")`,
+	} {
+		if _, err := s.Execute(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	return s
+}
+
+func TestUnionAndUnionAll(t *testing.T) {
+	s := newSetOpDB(t)
+	// rows sorted by the first column, so the expected rows do not depend on scan order
+	sorted := func(cmd string) Result {
+		t.Helper()
+		res, err := s.Execute(cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+		if res.Count != len(res.Rows) {
+			t.Fatalf("%s counted %d of %d rows", cmd, res.Count, len(res.Rows))
+		}
+		sortRows(res.Rows)
+		return res
+	}
+
+	res := sorted("SELECT a.id, a.name FROM A a UNION ALL SELECT b.n, b.label FROM B b")
+	want := [][]string{{"1", "x"}, {"1", "x"}, {"1", "x"}, {"2", "y"}, {"3", "z"}, {"3", "z"}, {"4.5", "w"}}
+	if !reflect.DeepEqual(res.Rows, want) {
+		t.Fatalf("UNION ALL = %v, want %v", res.Rows, want)
+	}
+	// INT and FLOAT combine to FLOAT, VARCHAR(6) and CHAR(4) to VARCHAR(6)
+	if want := []ResultColumn{{Name: "id", Type: "FLOAT"}, {Name: "name", Type: "VARCHAR(6)"}}; !reflect.DeepEqual(res.Columns, want) {
+		t.Fatalf("columns = %v, want %v", res.Columns, want)
+	}
+
+	// UNION drops duplicates within and across sides, 1 and 1.0 being the same FLOAT
+	res = sorted("SELECT a.id, a.name FROM A a UNION SELECT b.n, b.label FROM B b")
+	if want := [][]string{{"1", "x"}, {"2", "y"}, {"3", "z"}, {"4.5", "w"}}; !reflect.DeepEqual(res.Rows, want) {
+		t.Fatalf("UNION = %v, want %v", res.Rows, want)
+	}
+
+	// chains group from the left; each side keeps its own WHERE
+	res = sorted(`SELECT a.name FROM A a WHERE a.id = 2 UNION SELECT b.label FROM B b WHERE b.label = " UNION SELECT " UNION ALL SELECT a.name FROM A a WHERE a.id = 2`)
+	if want := [][]string{{"y"}, {"y"}}; !reflect.DeepEqual(res.Rows, want) {
+		t.Fatalf("chained UNION = %v, want %v", res.Rows, want)
+	}
+
+	var out bytes.Buffer
+	if err := s.ProcessCommand("SELECT a.id FROM A a WHERE a.id = 2 UNION SELECT b.n FROM B b WHERE b.n > 4", &out); err != nil {
+		t.Fatalf("UNION: %v", err)
+	}
+	if got := out.String(); got != "2\n4.5\nTotal selected records = 2\n" {
+		t.Fatalf("output = %q", got)
+	}
+
+	for _, cmd := range []string{
+		"SELECT a.id FROM A a UNION SELECT b.n, b.label FROM B b",
+		"SELECT a.name FROM A a UNION SELECT b.n FROM B b",
+		"SELECT EXISTS FROM A a UNION SELECT a.id FROM A a",
+		"SELECT a.id FROM A a UNION SELECT b.nope FROM B b",
+	} {
+		if _, err := s.Execute(cmd); err == nil {
+			t.Fatalf("%s should fail", cmd)
+		}
+	}
+}
+
+func TestIntersectAndExcept(t *testing.T) {
+	s := newSetOpDB(t)
+	for _, c := range []struct {
+		cmd  string
+		want [][]string
+	}{
+		// A holds (1,"x") twice: set operators return it once
+		{"SELECT a.id, a.name FROM A a INTERSECT SELECT b.n, b.label FROM B b", [][]string{{"1", "x"}, {"3", "z"}}},
+		{"SELECT a.id, a.name FROM A a EXCEPT SELECT b.n, b.label FROM B b", [][]string{{"2", "y"}}},
+		{"SELECT b.n, b.label FROM B b EXCEPT SELECT a.id, a.name FROM A a", [][]string{{"4.5", "w"}}},
+		{"SELECT a.id FROM A a EXCEPT SELECT a.id FROM A a", [][]string{}},
+		// from the left: (A UNION B) EXCEPT A
+		{"SELECT a.name FROM A a UNION SELECT b.label FROM B b EXCEPT SELECT a.name FROM A a", [][]string{{"w"}}},
+	} {
+		res, err := s.Execute(c.cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", c.cmd, err)
+		}
+		sortRows(res.Rows)
+		if !reflect.DeepEqual(res.Rows, c.want) || res.Count != len(c.want) {
+			t.Fatalf("%s = %v (count %d), want %v", c.cmd, res.Rows, res.Count, c.want)
+		}
+	}
+	for _, cmd := range []string{
+		"SELECT a.id FROM A a INTERSECT SELECT b.n, b.label FROM B b",
+		"SELECT a.name FROM A a EXCEPT SELECT b.n FROM B b",
+	} {
+		if _, err := s.Execute(cmd); err == nil {
+			t.Fatalf("%s should fail", cmd)
+		}
+	}
+}
+
+func sortRows(rows [][]string) {
+	sort.Slice(rows, func(i, j int) bool { return strings.Join(rows[i], "|") < strings.Join(rows[j], "|") })
+}
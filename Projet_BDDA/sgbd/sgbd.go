@@ -2,68 +2,262 @@ package sgbd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"malzahar-project/Projet_BDDA/buffer"
 	"malzahar-project/Projet_BDDA/config"
 	"malzahar-project/Projet_BDDA/db"
 	"malzahar-project/Projet_BDDA/disk"
+	"malzahar-project/Projet_BDDA/query"
 	"malzahar-project/Projet_BDDA/relation"
 )
 
+// ErrSyntax is returned for a command, WHERE clause included, that cannot be parsed.
+// Errors of the lower layers wrap their own sentinels, such as db.ErrTableNotFound,
+// relation.ErrInvalidValue, disk.ErrNoSpace or buffer.ErrNoFreeFrame.
+var ErrSyntax = query.ErrSyntax
+
 type SGBD struct {
 	cfg *config.DBConfig
 	dm  *disk.DiskManager
 	bm  *buffer.BufferManager
 	dbm *db.DBManager
+	// closed is set by Close so that a second call is a no-op.
+	closed bool
+	// prompt is printed before each interactive line, see SetPrompt.
+	prompt string
+	// wal is the write-ahead log attached to dm when cfg.WAL is set.
+	wal *disk.WAL
+	// commands and lastCheckpoint drive the automatic checkpoints, see autoCheckpoint.
+	commands       int
+	lastCheckpoint time.Time
+	// ctx is the context of the command being run, see ProcessCommandContext.
+	ctx context.Context
+	// metrics holds the command durations by operation, see ProcessMetricsCommand.
+	metrics map[string]*opMetrics
+	// tmpDir, for an in-memory database, holds the files kept beside the pages
+	// (catalog, headers, sort runs); Close removes it.
+	tmpDir string
 }
 
 func NewSGBD(cfg *config.DBConfig) (*SGBD, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.InMemory() {
+		return newMemorySGBD(cfg)
+	}
 	dm := disk.NewDiskManager(cfg)
 	if err := dm.Init(); err != nil {
 		return nil, err
 	}
+	wal, err := openWAL(cfg, dm)
+	if err != nil {
+		dm.Close()
+		return nil, err
+	}
 	bm := buffer.NewBufferManager(cfg, dm)
 	dbm := db.NewDBManager(cfg, dm, bm)
 	// attempt to load previous DB state if present; ignore missing save file
 	if err := dbm.LoadState(); err != nil {
 		if !os.IsNotExist(err) {
+			if wal != nil {
+				wal.Close()
+			}
+			dm.Close()
 			return nil, err
 		}
 		// else no saved state found — continue with empty DB
 	}
-	return &SGBD{cfg: cfg, dm: dm, bm: bm, dbm: dbm}, nil
+	return &SGBD{cfg: cfg, dm: dm, bm: bm, dbm: dbm, wal: wal, lastCheckpoint: time.Now()}, nil
+}
+
+// newMemorySGBD opens an empty database whose pages live in memory. The pages are
+// the only thing the disk manager keeps: the catalog, the relation headers and the
+// spilled sort runs still go to files, under a temporary DBPath removed by Close.
+func newMemorySGBD(cfg *config.DBConfig) (*SGBD, error) {
+	tmp, err := os.MkdirTemp("", "gobuffer-memory-")
+	if err != nil {
+		return nil, err
+	}
+	mem := *cfg
+	mem.DBPath = tmp
+	dm := disk.NewDiskManagerInMemory(&mem)
+	if err := dm.Init(); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	bm := buffer.NewBufferManager(&mem, dm)
+	dbm := db.NewDBManager(&mem, dm, bm)
+	return &SGBD{cfg: &mem, dm: dm, bm: bm, dbm: dbm, tmpDir: tmp, lastCheckpoint: time.Now()}, nil
 }
 
-// Run listens on stdin for commands until EXIT. No prompt is printed.
+// walFile is the name of the write-ahead log under DBPath.
+const walFile = "wal.log"
+
+// openWAL replays DBPath/wal.log into the data files if a previous run left one,
+// then, when cfg.WAL is set, attaches the emptied log to dm for the new session.
+// A log left while the WAL was enabled is still replayed after it is turned off,
+// and then removed.
+func openWAL(cfg *config.DBConfig, dm *disk.DiskManager) (*disk.WAL, error) {
+	path := filepath.Join(cfg.DBPath, walFile)
+	if _, err := os.Stat(path); err != nil && !cfg.WAL {
+		return nil, nil
+	}
+	// DBPath may not exist yet when the data files live in a separate bin_dir
+	if err := os.MkdirAll(cfg.DBPath, 0o755); err != nil {
+		return nil, err
+	}
+	w, err := disk.OpenWAL(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dm.Recover(w); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("WAL recovery: %w", err)
+	}
+	if !cfg.WAL {
+		w.Close()
+		return nil, os.Remove(path)
+	}
+	dm.AttachWAL(w)
+	return w, nil
+}
+
+// Run listens on stdin for commands until EXIT. No prompt is printed unless one was
+// set with SetPrompt.
 func (s *SGBD) Run() error {
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
+	return s.RunFrom(os.Stdin)
+}
+
+// SetPrompt sets the prompt printed before each line read by Run. It is only shown
+// when both the input and the output are terminals, so piped and batch use are
+// unaffected. An empty prompt (the default) disables it.
+func (s *SGBD) SetPrompt(prompt string) {
+	s.prompt = prompt
+}
+
+// RunFrom is Run reading the commands from r.
+func (s *SGBD) RunFrom(r io.Reader) error {
+	return s.RunWith(r, os.Stdout, os.Stderr)
+}
+
+// RunWith is Run reading the commands from r, writing their output to w and errors
+// to errw. When r and w are terminals, each command is appended to the history file
+// once executed (see HISTORY); piped and batch input is not recorded.
+func (s *SGBD) RunWith(r io.Reader, w, errw io.Writer) error {
+	return s.runLoop(r, w, errw, isTerminal(r) && isTerminal(w))
+}
+
+// runLoop is RunWith, interactive telling whether r and w are terminals.
+func (s *SGBD) runLoop(r io.Reader, w, errw io.Writer, interactive bool) error {
+	prompt := ""
+	if interactive {
+		prompt = s.prompt
+	}
+	scanner := bufio.NewScanner(r)
+	for fmt.Fprint(w, prompt); scanner.Scan(); fmt.Fprint(w, prompt) {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
 		}
 		if strings.EqualFold(line, "EXIT") {
-			// save state and exit
-			_ = s.dbm.SaveState()
-			_ = s.bm.FlushBuffers()
-			_ = s.dm.Finish()
-			return nil
+			return s.Close()
 		}
-		if err := s.ProcessCommand(line, os.Stdout); err != nil {
+		if err := s.processInteractive(line, w, interactive); err != nil {
 			// print error but continue
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			fmt.Fprintf(errw, "error: %s\n", describeError(err))
+		}
+		if !interactive {
+			continue
+		}
+		if err := s.appendHistory(line); err != nil {
+			fmt.Fprintf(errw, "error: history: %v\n", err)
 		}
 	}
 	return scanner.Err()
 }
 
+// describeError renders err for the REPL, with a hint for the errors a setting or
+// another command can help with.
+func describeError(err error) string {
+	switch {
+	case errors.Is(err, db.ErrTableNotFound):
+		return err.Error() + " (DESCRIBE TABLES lists the tables)"
+	case errors.Is(err, disk.ErrNoSpace):
+		return err.Error() + " (raise dm_maxfilecount to allow more data files)"
+	case errors.Is(err, buffer.ErrNoFreeFrame):
+		return err.Error() + " (raise bm_buffercount)"
+	}
+	return err.Error()
+}
+
+// processInteractive runs a command of the REPL. On a terminal, Ctrl-C cancels the
+// command while it runs instead of ending the program.
+func (s *SGBD) processInteractive(line string, w io.Writer, terminal bool) error {
+	if !terminal {
+		return s.ProcessCommand(line, w)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return s.ProcessCommandContext(ctx, line, w)
+}
+
 // ProcessCommand parses and executes a single command text, writing outputs to w.
+// A successful command may be followed by an automatic checkpoint.
 func (s *SGBD) ProcessCommand(text string, w io.Writer) error {
+	return s.ProcessCommandContext(context.Background(), text, w)
+}
+
+// ProcessCommandContext is ProcessCommand with a context: the table scans of the
+// command stop with ctx.Err() once ctx is done, leaving the tables unchanged. With
+// query_timeout_ms set, ctx is also given that deadline.
+func (s *SGBD) ProcessCommandContext(ctx context.Context, text string, w io.Writer) error {
+	op := metricOp(strings.ToUpper(strings.TrimSpace(text)))
+	return s.dispatch(ctx, op, func() error { return s.runCommand(text, w) })
+}
+
+// dispatch runs a command for every entry point (ProcessCommand, Execute, prepared
+// statements): under ctx, bounded by query_timeout_ms and timed under the METRICS
+// operation op, then followed by the automatic checkpoint if one is due.
+func (s *SGBD) dispatch(ctx context.Context, op string, run func() error) error {
+	// query_timeout_ms bounds the scans like a cancellation would
+	ms := s.cfg.QueryTimeoutMs
+	if ms > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+	}
+	s.ctx = ctx
+	defer func() { s.ctx = nil }()
+	if err := s.timeCommand(op, run); err != nil {
+		if ms > 0 && errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("query timeout: command ran longer than query_timeout_ms=%d: %w", ms, err)
+		}
+		return err
+	}
+	return s.autoCheckpoint()
+}
+
+// context returns the context of the command being run.
+func (s *SGBD) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func (s *SGBD) runCommand(text string, w io.Writer) error {
 	// normalize
 	t := strings.TrimSpace(text)
 	up := strings.ToUpper(t)
@@ -85,59 +279,60 @@ func (s *SGBD) ProcessCommand(text string, w io.Writer) error {
 	case strings.HasPrefix(up, "DROP TABLE "):
 		return s.ProcessDropTableCommand(t, w)
 	case strings.HasPrefix(up, "DESCRIBE TABLES"):
-		return s.ProcessDescribeTablesCommand(w)
+		return s.ProcessDescribeTablesCommand(t, w)
 	case strings.HasPrefix(up, "DESCRIBE TABLE "):
 		return s.ProcessDescribeTableCommand(t, w)
+	case up == "SHOW STATUS" || up == "SHOW SETTINGS":
+		return s.ProcessShowStatusCommand(w)
+	case strings.HasPrefix(up, "CHECK "):
+		return s.ProcessCheckCommand(t, w)
+	case strings.HasPrefix(up, "REPAIR "):
+		return s.ProcessRepairCommand(t, w)
+	case up == "FSCK":
+		return s.ProcessFsckCommand(w)
+	case up == "METRICS" || strings.HasPrefix(up, "METRICS "):
+		return s.ProcessMetricsCommand(t, w)
+	case strings.HasPrefix(up, "UNDELETE "):
+		return s.ProcessUndeleteCommand(t, w)
+	case strings.HasPrefix(up, "PURGE "):
+		return s.ProcessPurgeCommand(t, w)
+	case strings.HasPrefix(up, "DEFRAGMENT TABLE "):
+		return s.ProcessDefragmentCommand(t, w)
+	case up == "COMPACT":
+		return s.ProcessCompactCommand(w)
+	case strings.HasPrefix(up, "ALTER TABLE "):
+		return s.ProcessAlterTableCommand(t, w)
+	case up == "HISTORY" || strings.HasPrefix(up, "HISTORY "):
+		return s.ProcessHistoryCommand(t, w)
+	case up == "SYNC":
+		return s.ProcessSyncCommand()
+	case up == "CHECKPOINT":
+		return s.Checkpoint()
+	case strings.HasPrefix(up, "SET "):
+		return s.ProcessSetCommand(t, w)
 	default:
-		return fmt.Errorf("unsupported command: %s", text)
+		return fmt.Errorf("%w: unsupported command: %s", ErrSyntax, text)
 	}
 }
 
-// helper parse column type like INT, FLOAT, CHAR(n), VARCHAR(n)
-func parseColType(s string) (relation.ColumnKind, int, error) {
-	s = strings.TrimSpace(s)
-	sUp := strings.ToUpper(s)
-	if sUp == "INT" {
-		return relation.KindInt, 0, nil
-	}
-	if sUp == "FLOAT" {
-		return relation.KindFloat, 0, nil
-	}
-	// accept REAL as an alias for FLOAT (README uses REAL)
-	if sUp == "REAL" {
-		return relation.KindFloat, 0, nil
-	}
-	if strings.HasPrefix(sUp, "CHAR(") && strings.HasSuffix(sUp, ")") {
-		inner := sUp[len("CHAR(") : len(sUp)-1]
-		n, err := strconv.Atoi(inner)
-		if err != nil {
-			return 0, 0, err
-		}
-		return relation.KindChar, n, nil
-	}
-	if strings.HasPrefix(sUp, "VARCHAR(") && strings.HasSuffix(sUp, ")") {
-		inner := sUp[len("VARCHAR(") : len(sUp)-1]
-		n, err := strconv.Atoi(inner)
-		if err != nil {
-			return 0, 0, err
-		}
-		return relation.KindVarchar, n, nil
-	}
-	return 0, 0, fmt.Errorf("unknown column type: %s", s)
-}
-
-// ProcessCreateTableCommand expects: CREATE TABLE Name (col:TYPE, ...)
+// ProcessCreateTableCommand expects: CREATE TABLE Name (col:TYPE, ...) [SOFT DELETE]
+// SOFT DELETE makes deletes leave tombstones (see UNDELETE and PURGE).
 func (s *SGBD) ProcessCreateTableCommand(text string, w io.Writer) error {
+	softDelete := false
+	if fields := strings.Fields(text); len(fields) >= 2 && strings.EqualFold(fields[len(fields)-2], "SOFT") && strings.EqualFold(fields[len(fields)-1], "DELETE") {
+		softDelete = true
+		text = strings.TrimSpace(text[:strings.LastIndex(strings.ToUpper(text), "SOFT")])
+	}
 	// find opening paren
 	idx := strings.Index(text, "(")
 	if idx < 0 {
-		return fmt.Errorf("invalid CREATE TABLE syntax")
+		return fmt.Errorf("%w in CREATE TABLE", ErrSyntax)
 	}
 	pre := strings.TrimSpace(text[:idx])
 	// pre is like "CREATE TABLE Name"
 	parts := strings.Fields(pre)
 	if len(parts) < 3 {
-		return fmt.Errorf("invalid CREATE TABLE syntax")
+		return fmt.Errorf("%w in CREATE TABLE", ErrSyntax)
 	}
 	name := parts[2]
 	body := strings.TrimSpace(text[idx+1:])
@@ -154,17 +349,18 @@ func (s *SGBD) ProcessCreateTableCommand(text string, w io.Writer) error {
 		// split name:type
 		sp := strings.SplitN(c, ":", 2)
 		if len(sp) != 2 {
-			return fmt.Errorf("invalid column definition: %s", c)
+			return fmt.Errorf("%w: invalid column definition: %s", ErrSyntax, c)
 		}
 		cname := strings.TrimSpace(sp[0])
 		ctype := strings.TrimSpace(sp[1])
-		kind, size, err := parseColType(ctype)
+		kind, size, err := relation.ParseColumnType(ctype)
 		if err != nil {
 			return err
 		}
 		cis = append(cis, relation.ColumnInfo{Name: cname, Kind: kind, Size: size})
 	}
 	rel := relation.NewRelation(name, cis)
+	rel.SoftDelete = softDelete
 	if err := s.dbm.AddTable(rel); err != nil {
 		return err
 	}
@@ -174,22 +370,30 @@ func (s *SGBD) ProcessCreateTableCommand(text string, w io.Writer) error {
 
 // INSERT INTO Name VALUES (v1,v2,...)
 func (s *SGBD) ProcessInsertCommand(text string, w io.Writer) error {
+	res, err := s.executeInsert(text)
+	if err != nil {
+		return err
+	}
+	return writeResult(w, res)
+}
+
+func (s *SGBD) executeInsert(text string) (Result, error) {
 	// find " VALUES ("
 	up := strings.ToUpper(text)
 	idx := strings.Index(up, " VALUES (")
 	if idx < 0 {
-		return fmt.Errorf("invalid INSERT syntax")
+		return Result{}, fmt.Errorf("%w in INSERT", ErrSyntax)
 	}
 	pre := strings.TrimSpace(text[:idx])
 	parts := strings.Fields(pre)
 	if len(parts) < 3 {
-		return fmt.Errorf("invalid INSERT syntax")
+		return Result{}, fmt.Errorf("%w in INSERT", ErrSyntax)
 	}
 	name := parts[2]
 	// extract values inside parentheses
 	vstart := idx + len(" VALUES (")
 	if !strings.HasSuffix(text, ")") {
-		return fmt.Errorf("invalid INSERT syntax: missing )")
+		return Result{}, fmt.Errorf("%w in INSERT: missing )", ErrSyntax)
 	}
 	body := text[vstart : len(text)-1]
 	vals := splitCSVLine(body)
@@ -201,16 +405,25 @@ func (s *SGBD) ProcessInsertCommand(text string, w io.Writer) error {
 		}
 		vals[i] = v
 	}
+	// BLOB values are written in base64
+	if rel, err := s.dbm.GetTable(name); err == nil && len(rel.Columns) == len(vals) {
+		for i, c := range rel.Columns {
+			v, err := c.ParseText(vals[i])
+			if err != nil {
+				return Result{}, err
+			}
+			vals[i] = v
+		}
+	}
 	rec := &relation.Record{Values: vals}
 	if _, err := s.dbm.InsertRecord(name, rec); err != nil {
-		return err
+		return Result{}, err
 	}
 	// Force flush to disk after each insert for data persistence
 	if err := s.bm.FlushBuffers(); err != nil {
-		return err
+		return Result{}, err
 	}
-	fmt.Fprintln(w, "OK")
-	return nil
+	return Result{Kind: ResultOK, Command: "INSERT", Count: 1}, nil
 }
 
 // APPEND INTO Name ALLRECORDS (file.csv)
@@ -219,14 +432,14 @@ func (s *SGBD) ProcessAppendCommand(text string, w io.Writer) error {
 	// split by spaces
 	parts := strings.Fields(text)
 	if len(parts) < 4 {
-		return fmt.Errorf("invalid APPEND syntax")
+		return fmt.Errorf("%w in APPEND", ErrSyntax)
 	}
 	name := parts[2]
 	// find '(' and ')'
 	idx := strings.Index(text, "(")
 	jdx := strings.LastIndex(text, ")")
 	if idx < 0 || jdx < 0 || jdx <= idx {
-		return fmt.Errorf("invalid APPEND syntax: missing parentheses")
+		return fmt.Errorf("%w in APPEND: missing parentheses", ErrSyntax)
 	}
 	fname := strings.TrimSpace(text[idx+1 : jdx])
 	// file path relative to project root
@@ -234,250 +447,162 @@ func (s *SGBD) ProcessAppendCommand(text string, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	// flush like INSERT so appended rows reach disk
+	if err := s.bm.FlushBuffers(); err != nil {
+		return err
+	}
 	fmt.Fprintf(w, "OK (%d inserted)\n", cnt)
 	return nil
 }
 
-// Condition represents a simple comparison between terms (col or constant)
-type Condition struct {
-	LeftIsCol   bool
-	LeftColIdx  int
-	LeftConst   string
-	RightIsCol  bool
-	RightColIdx int
-	RightConst  string
-	Op          string
+// rowIdProj marks the ROWID pseudo-column in a projection list, literalProj a
+// constant (see projLiteral).
+const (
+	rowIdProj   = -1
+	literalProj = -2
+)
+
+// projLiteral recognizes a projection item that is a constant: a double-quoted
+// string, emitted without its quotes, or a number, emitted as written, for every
+// row. The result column is named after the item and typed after the constant. ok is
+// false for any other item.
+func projLiteral(item string) (col ResultColumn, val string, ok bool) {
+	if len(item) >= 2 && item[0] == '"' && item[len(item)-1] == '"' {
+		val = item[1 : len(item)-1]
+		if strings.Contains(val, `"`) {
+			return ResultColumn{}, "", false
+		}
+		return ResultColumn{Name: item, Type: fmt.Sprintf("VARCHAR(%d)", len(val))}, val, true
+	}
+	// ParseFloat also takes words such as Inf or NaN, which are not constants here
+	if item == "" || strings.IndexByte("+-.0123456789", item[0]) < 0 {
+		return ResultColumn{}, "", false
+	}
+	if _, err := strconv.Atoi(item); err == nil {
+		return ResultColumn{Name: item, Type: "INT"}, item, true
+	}
+	if _, err := strconv.ParseFloat(item, 32); err == nil {
+		return ResultColumn{Name: item, Type: "FLOAT"}, item, true
+	}
+	return ResultColumn{}, "", false
 }
 
-// helper to split CSV-style comma list used for INSERT parsing
-func splitCSVLine(line string) []string {
-	parts := strings.Split(line, ",")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		out = append(out, strings.TrimSpace(p))
+// isRowIdColumn reports whether a projection/where term names the ROWID pseudo-column
+// (either bare ROWID or alias.ROWID).
+func isRowIdColumn(term string, alias string) bool {
+	term = strings.TrimSpace(term)
+	if alias != "" && strings.HasPrefix(term, alias+".") {
+		term = term[len(alias)+1:]
 	}
-	return out
+	return strings.EqualFold(term, "ROWID")
 }
 
-// parse simple WHERE clause into conditions (conjunction of comparisons using AND)
-func parseWhereClause(where string, rel *relation.Relation, alias string) ([]Condition, error) {
-	var res []Condition
-	where = strings.TrimSpace(where)
-	if where == "" {
-		return res, nil
+// errRowIdPredicate rejects a WHERE clause using ROWID other than as ROWID = "f:p:s".
+var errRowIdPredicate = fmt.Errorf("%w: ROWID is only supported as WHERE ROWID = \"FileIdx:PageIdx:SlotIdx\"", ErrSyntax)
+
+// parseRowIdPredicate recognizes a WHERE clause of the form ROWID = "f:p:s". ok is false
+// when the clause does not name ROWID at all. Any other use of ROWID (another
+// operator, or a ROWID comparison combined with other conditions) is rejected:
+// query.Compile would read the word as a string constant.
+func parseRowIdPredicate(where string, alias string) (relation.RecordId, bool, error) {
+	if !mentionsRowId(where) {
+		return relation.RecordId{}, false, nil
 	}
-	parts := strings.Split(where, " AND ")
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		// find operator
-		ops := []string{"<=", ">=", "<>", "=", "<", ">"}
-		var found string
-		var left, right string
-		for _, op := range ops {
-			if idx := strings.Index(p, op); idx >= 0 {
-				found = op
-				left = strings.TrimSpace(p[:idx])
-				right = strings.TrimSpace(p[idx+len(op):])
-				break
-			}
-		}
-		if found == "" {
-			return nil, fmt.Errorf("unsupported condition: %s", p)
-		}
-		cond := Condition{Op: found}
-		// left can be alias.col or constant
-		if strings.HasPrefix(left, alias+".") {
-			col := left[len(alias)+1:]
-			idx := -1
-			for i, c := range rel.Columns {
-				if c.Name == col {
-					idx = i
-					break
-				}
-			}
-			if idx < 0 {
-				return nil, fmt.Errorf("unknown column: %s", col)
-			}
-			cond.LeftIsCol = true
-			cond.LeftColIdx = idx
-		} else {
-			// constant: strip quotes if present
-			lv := left
-			if len(lv) >= 2 && lv[0] == '"' && lv[len(lv)-1] == '"' {
-				lv = lv[1 : len(lv)-1]
-			}
-			cond.LeftConst = lv
-		}
-		// right can be alias.col or constant
-		if strings.HasPrefix(right, alias+".") {
-			col := right[len(alias)+1:]
-			idx := -1
-			for i, c := range rel.Columns {
-				if c.Name == col {
-					idx = i
-					break
-				}
-			}
-			if idx < 0 {
-				return nil, fmt.Errorf("unknown column: %s", col)
-			}
-			cond.RightIsCol = true
-			cond.RightColIdx = idx
-		} else {
-			// constant: strip quotes if present
-			rv := right
-			if len(rv) >= 2 && rv[0] == '"' && rv[len(rv)-1] == '"' {
-				rv = rv[1 : len(rv)-1]
-			}
-			cond.RightConst = rv
-		}
-		res = append(res, cond)
+	idx := strings.Index(where, "=")
+	if idx < 0 || !isRowIdColumn(where[:idx], alias) {
+		return relation.RecordId{}, false, errRowIdPredicate
 	}
-	return res, nil
+	val := strings.TrimSpace(where[idx+1:])
+	if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+		val = val[1 : len(val)-1]
+	}
+	if strings.ContainsAny(val, "\" \t") {
+		return relation.RecordId{}, false, errRowIdPredicate
+	}
+	rid, err := relation.ParseRecordId(val)
+	if err != nil {
+		return relation.RecordId{}, false, err
+	}
+	return rid, true, nil
 }
 
-// evaluate conditions on a record
-func evalConditions(rec *relation.Record, rel *relation.Relation, conds []Condition) (bool, error) {
-	for _, c := range conds {
-		var leftVal string
-		if c.LeftIsCol {
-			leftVal = rec.Values[c.LeftColIdx]
-		} else {
-			leftVal = c.LeftConst
-		}
-		var rightVal string
-		if c.RightIsCol {
-			rightVal = rec.Values[c.RightColIdx]
-		} else {
-			rightVal = c.RightConst
-		}
-		// determine column kind: prefer left if it's a column, else right
-		var kind relation.ColumnKind
-		if c.LeftIsCol {
-			kind = rel.Columns[c.LeftColIdx].Kind
-		} else if c.RightIsCol {
-			kind = rel.Columns[c.RightColIdx].Kind
-		} else {
-			// both constants? not supported, but assume string
-			kind = relation.KindVarchar
+// mentionsRowId reports whether a WHERE clause names ROWID or alias.ROWID outside
+// quoted constants.
+func mentionsRowId(where string) bool {
+	inQuote := false
+	start := -1
+	for i := 0; i <= len(where); i++ {
+		if i < len(where) && where[i] == '"' {
+			inQuote = !inQuote
+			continue
 		}
-		switch kind {
-		case relation.KindInt:
-			li, err := strconv.Atoi(leftVal)
-			if err != nil {
-				return false, err
-			}
-			ri, err := strconv.Atoi(rightVal)
-			if c.RightIsCol && err != nil {
-				return false, err
+		if !inQuote && i < len(where) && isIdentByte(where[i]) {
+			if start < 0 {
+				start = i
 			}
-			if !c.RightIsCol {
-				ri, _ = strconv.Atoi(rightVal)
-			}
-			switch c.Op {
-			case "=":
-				if !(li == ri) {
-					return false, nil
-				}
-			case "<>":
-				if !(li != ri) {
-					return false, nil
-				}
-			case "<":
-				if !(li < ri) {
-					return false, nil
-				}
-			case ">":
-				if !(li > ri) {
-					return false, nil
-				}
-			case "<=":
-				if !(li <= ri) {
-					return false, nil
-				}
-			case ">=":
-				if !(li >= ri) {
-					return false, nil
-				}
-			}
-		case relation.KindFloat:
-			lf, err := strconv.ParseFloat(leftVal, 64)
-			if err != nil {
-				return false, err
-			}
-			rf, err := strconv.ParseFloat(rightVal, 64)
-			if c.RightIsCol && err != nil {
-				return false, err
-			}
-			switch c.Op {
-			case "=":
-				if !(lf == rf) {
-					return false, nil
-				}
-			case "<>":
-				if !(lf != rf) {
-					return false, nil
-				}
-			case "<":
-				if !(lf < rf) {
-					return false, nil
-				}
-			case ">":
-				if !(lf > rf) {
-					return false, nil
-				}
-			case "<=":
-				if !(lf <= rf) {
-					return false, nil
-				}
-			case ">=":
-				if !(lf >= rf) {
-					return false, nil
-				}
+			continue
+		}
+		if start >= 0 {
+			word := where[start:i]
+			if dot := strings.LastIndexByte(word, '.'); dot >= 0 {
+				word = word[dot+1:]
 			}
-		case relation.KindChar, relation.KindVarchar:
-			// lexical comparison
-			switch c.Op {
-			case "=":
-				if !(leftVal == rightVal) {
-					return false, nil
-				}
-			case "<>":
-				if !(leftVal != rightVal) {
-					return false, nil
-				}
-			case "<":
-				if !(leftVal < rightVal) {
-					return false, nil
-				}
-			case ">":
-				if !(leftVal > rightVal) {
-					return false, nil
-				}
-			case "<=":
-				if !(leftVal <= rightVal) {
-					return false, nil
-				}
-			case ">=":
-				if !(leftVal >= rightVal) {
-					return false, nil
-				}
+			if strings.EqualFold(word, "ROWID") {
+				return true
 			}
+			start = -1
 		}
 	}
-	return true, nil
+	return false
+}
+
+// helper to split CSV-style comma list used for INSERT parsing
+func splitCSVLine(line string) []string {
+	parts := strings.Split(line, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		out = append(out, strings.TrimSpace(p))
+	}
+	return out
 }
 
 // SELECT ... FROM name alias [WHERE ...]
+// WHERE ROWID = "FileIdx:PageIdx:SlotIdx" selects a single record; ROWID cannot appear
+// in any other WHERE clause.
+// SELECT EXISTS FROM name alias [WHERE ...] prints true or false.
+// SELECT ... FROM name1 alias1, name2 alias2 [WHERE ...] joins two tables.
+// SELECT ... UNION [ALL] | INTERSECT | EXCEPT SELECT ... combines the rows of two SELECTs.
+// Besides alias.col, alias.* and ROWID, the projection may list constants, such as
+// "label" or 42, repeated on every row.
+// SELECT ... [WHERE ...] GROUP BY alias.col, ... [HAVING ...] returns one row per
+// group, listing grouping columns and COUNT(*), COUNT(alias.col) or SUM(alias.col).
 func (s *SGBD) ProcessSelectCommand(text string, w io.Writer) error {
+	res, err := s.executeSelect(text)
+	if err != nil {
+		return err
+	}
+	return writeResult(w, res)
+}
+
+func (s *SGBD) executeSelect(text string) (Result, error) {
+	if left, op, right, ok := splitSetOp(text); ok {
+		return s.executeSetOp(left, op, right)
+	}
 	// split SELECT and FROM
-	up := strings.ToUpper(text)
-	idx := strings.Index(up, " FROM ")
+	// a constant in the projection, such as "sent from", may hold the keyword
+	idx := indexOutsideQuotes(strings.ToUpper(text), " FROM ")
 	if idx < 0 {
-		return fmt.Errorf("invalid SELECT syntax")
+		return Result{}, fmt.Errorf("%w in SELECT", ErrSyntax)
 	}
 	selPart := strings.TrimSpace(text[len("SELECT "):idx])
 	rest := strings.TrimSpace(text[idx+len(" FROM "):])
+	rest, limit, offset, err := splitLimit(rest)
+	if err != nil {
+		return Result{}, err
+	}
+	rest, orderPart := splitOrderBy(rest)
+	rest, groupPart, havingPart := splitGroupBy(rest)
+	grouped := groupPart != "" || havingPart != "" || hasAggregate(selPart)
 	// rest -> "name alias [WHERE ...]"
 	// find WHERE
 	whereIdx := strings.Index(strings.ToUpper(rest), " WHERE ")
@@ -487,26 +612,80 @@ func (s *SGBD) ProcessSelectCommand(text string, w io.Writer) error {
 		fromPart = strings.TrimSpace(rest[:whereIdx])
 		wherePart = strings.TrimSpace(rest[whereIdx+len(" WHERE "):])
 	}
+	if limit != noLimit && strings.Contains(fromPart, ",") {
+		return Result{}, fmt.Errorf("LIMIT is not supported on joins")
+	}
+	if grouped && strings.Contains(fromPart, ",") {
+		return Result{}, fmt.Errorf("GROUP BY and aggregates are not supported on joins")
+	}
+	if strings.Contains(fromPart, ",") {
+		if mentionsRowId(wherePart) {
+			return Result{}, fmt.Errorf("ROWID is not supported in the WHERE clause of a join")
+		}
+		return s.executeJoin(selPart, fromPart, wherePart, orderPart)
+	}
 	parts := strings.Fields(fromPart)
 	if len(parts) < 2 {
-		return fmt.Errorf("invalid SELECT FROM syntax")
+		return Result{}, fmt.Errorf("%w in SELECT FROM", ErrSyntax)
 	}
 	name := parts[0]
 	alias := parts[1]
 	rel, err := s.dbm.GetTable(name)
 	if err != nil {
-		return err
+		return Result{}, err
+	}
+	// WHERE ROWID = "f:p:s" keeps the one record scanned with that RecordId
+	rowId, byRowId, err := parseRowIdPredicate(wherePart, alias)
+	if err != nil {
+		return Result{}, err
 	}
-	// parse selection columns
+	if byRowId {
+		if grouped || strings.EqualFold(selPart, "EXISTS") {
+			return Result{}, fmt.Errorf("WHERE ROWID is not supported with EXISTS or GROUP BY")
+		}
+		wherePart = ""
+	}
+	if strings.EqualFold(selPart, "EXISTS") {
+		if limit != noLimit {
+			return Result{}, fmt.Errorf("LIMIT is not supported with EXISTS")
+		}
+		return s.executeSelectExists(name, rel, alias, wherePart)
+	}
+	if grouped {
+		return s.executeGroupBy(name, rel, alias, selPart, wherePart, groupPart, havingPart, orderPart, limit, offset)
+	}
+	distinct := false
+	if f := strings.Fields(selPart); len(f) > 1 && strings.EqualFold(f[0], "DISTINCT") {
+		distinct = true
+		selPart = strings.TrimSpace(selPart[len(f[0]):])
+	}
+	// parse selection columns; a constant at position i has its column in litCols[i]
+	// and its value in litVals[i]
 	var projIdxs []int
+	litCols := make(map[int]ResultColumn)
+	litVals := make(map[int]string)
 	if strings.TrimSpace(selPart) == "*" {
 		for i := range rel.Columns {
 			projIdxs = append(projIdxs, i)
 		}
 	} else {
-		cols := strings.Split(selPart, ",")
-		for _, c := range cols {
-			c = strings.TrimSpace(c)
+		for _, c := range splitValues(selPart) {
+			if isRowIdColumn(c, alias) {
+				projIdxs = append(projIdxs, rowIdProj)
+				continue
+			}
+			if col, val, ok := projLiteral(c); ok {
+				litCols[len(projIdxs)], litVals[len(projIdxs)] = col, val
+				projIdxs = append(projIdxs, literalProj)
+				continue
+			}
+			// alias.* expands to all columns of the relation, in order
+			if c == alias+".*" {
+				for i := range rel.Columns {
+					projIdxs = append(projIdxs, i)
+				}
+				continue
+			}
 			if strings.HasPrefix(c, alias+".") {
 				col := c[len(alias)+1:]
 				found := -1
@@ -517,60 +696,253 @@ func (s *SGBD) ProcessSelectCommand(text string, w io.Writer) error {
 					}
 				}
 				if found < 0 {
-					return fmt.Errorf("unknown column in projection: %s", col)
+					return Result{}, fmt.Errorf("unknown column in projection: %s", col)
 				}
 				projIdxs = append(projIdxs, found)
 			} else {
-				return fmt.Errorf("projection must use alias: %s", c)
+				return Result{}, fmt.Errorf("projection must use alias: %s", c)
 			}
 		}
 	}
 	// parse where
-	conds, err := parseWhereClause(wherePart, rel, alias)
+	pred, err := query.Compile(wherePart, rel, alias)
 	if err != nil {
-		return err
+		return Result{}, err
+	}
+	// ORDER BY alias.ROWID needs no sort: the scan runs in the requested direction, so
+	// with LIMIT it stops after the first rows, e.g. the last rows stored with DESC
+	scanDir := relation.Forward
+	if dir, ok := parseRowIdOrder(orderPart, alias); ok {
+		scanDir, orderPart = dir, ""
+	}
+	// with LIMIT and without DISTINCT only the first offset+limit rows of the order
+	// are kept, in a bounded heap, unless they would not fit in sort_memory_rows
+	var sorter rowOrderer
+	if orderPart != "" {
+		keys, err := parseOrderBy(orderPart, rel, alias)
+		if err != nil {
+			return Result{}, err
+		}
+		memRows := s.cfg.SortMemoryRows
+		if memRows == 0 {
+			memRows = config.DefaultSortMemoryRows
+		}
+		if limit != noLimit && !distinct && limit <= memRows-offset {
+			sorter = newTopN(offset+limit, lessByKeys(keys))
+		} else {
+			sorter = newRowSorter(s.cfg.DBPath, memRows, lessByKeys(keys))
+		}
+		defer sorter.Close()
 	}
 	// ensure all pending writes are flushed
 	if err := s.bm.FlushBuffers(); err != nil {
-		return err
+		return Result{}, err
 	}
-	// scan records and print matches
-	total := 0
-	err = s.dbm.ScanTableRecords(name, func(rec relation.Record, rid relation.RecordId) error {
-		ok, err := evalConditions(&rec, rel, conds)
-		if err != nil {
-			return err
+	res := Result{Kind: ResultRows, Command: "SELECT", Rows: [][]string{}}
+	for i, pi := range projIdxs {
+		if pi == rowIdProj {
+			res.Columns = append(res.Columns, ResultColumn{Name: "ROWID", Type: "ROWID"})
+		} else if pi == literalProj {
+			res.Columns = append(res.Columns, litCols[i])
+		} else {
+			c := rel.Columns[pi]
+			res.Columns = append(res.Columns, ResultColumn{Name: c.Name, Type: c.TypeString()})
+		}
+	}
+	// DISTINCT compares the projected rows by value, as the result columns' types see them
+	var dedup *rowDistinct
+	if distinct {
+		limit := s.cfg.DistinctMemoryRows
+		if limit == 0 {
+			limit = config.DefaultDistinctMemoryRows
 		}
-		if ok {
-			// print projection
-			if len(projIdxs) == 0 {
-				// nothing to print
-				fmt.Fprintln(w, "")
+		dedup = newRowDistinct(s.cfg.DBPath, limit, rowKey(res.Columns))
+		defer dedup.Close()
+	}
+	// emit projects a matching record into the result, through DISTINCT when asked
+	emit := func(vals []string, rid string) error {
+		row := make([]string, len(projIdxs))
+		for i, pi := range projIdxs {
+			if pi == rowIdProj {
+				row[i] = rid
+			} else if pi == literalProj {
+				row[i] = litVals[i]
 			} else {
-				out := ""
-				for i, pi := range projIdxs {
-					if i > 0 {
-						out += " ; "
-					}
-					out += rec.Values[pi]
-				}
-				fmt.Fprintln(w, out)
+				row[i] = rel.Columns[pi].FormatText(vals[pi])
 			}
-			total++
 		}
+		if dedup != nil {
+			return dedup.Add(row)
+		}
+		res.Rows = append(res.Rows, row)
 		return nil
-	})
+	}
+	// without ORDER BY or DISTINCT the rows come out in scan order, so the scan skips
+	// the first offset matches and stops once limit rows are kept; otherwise LIMIT
+	// applies to the final rows
+	early := limit != noLimit && sorter == nil && dedup == nil
+	skip := offset
+	// scan records and collect the projection of matches; with ORDER BY whole records
+	// (and their ROWID) go through the sorter first, as sort keys need not be projected
+	if !early || limit > 0 {
+		err = s.dbm.ScanTableRecordsIn(s.context(), name, scanDir, func(rec relation.Record, rid relation.RecordId) error {
+			if byRowId && rid != rowId {
+				return nil
+			}
+			ok, err := pred.Match(&rec)
+			if err != nil || !ok {
+				return err
+			}
+			if sorter != nil {
+				return sorter.Add(append(append([]string{}, rec.Values...), rid.String()))
+			}
+			if early && skip > 0 {
+				skip--
+				return nil
+			}
+			if err := emit(rec.Values, rid.String()); err != nil {
+				return err
+			}
+			if early && len(res.Rows) == limit {
+				return relation.ErrStopScan
+			}
+			return nil
+		})
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	if sorter != nil {
+		n := len(rel.Columns)
+		if err := sorter.Each(func(row []string) error {
+			return emit(row[:n], row[n])
+		}); err != nil {
+			return Result{}, err
+		}
+		if err := sorter.Close(); err != nil {
+			return Result{}, err
+		}
+	}
+	if dedup != nil {
+		if err := dedup.Each(func(row []string) error {
+			res.Rows = append(res.Rows, row)
+			return nil
+		}); err != nil {
+			return Result{}, err
+		}
+		if err := dedup.Close(); err != nil {
+			return Result{}, err
+		}
+	}
+	if !early {
+		res.Rows = window(res.Rows, limit, offset)
+	}
+	res.Count = len(res.Rows)
+	return res, nil
+}
+
+// indexOutsideQuotes returns the index of the first sep in s outside double-quoted
+// constants, or -1.
+func indexOutsideQuotes(s, sep string) int {
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			inQuote = !inQuote
+			continue
+		}
+		if !inQuote && strings.HasPrefix(s[i:], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// executeSelectExists reports whether any record of name matches the WHERE clause,
+// stopping at the first match, as a single EXISTS row holding true or false.
+func (s *SGBD) executeSelectExists(name string, rel *relation.Relation, alias, wherePart string) (Result, error) {
+	pred, err := query.Compile(wherePart, rel, alias)
 	if err != nil {
-		return err
+		return Result{}, err
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		return Result{}, err
+	}
+	found, err := s.dbm.ExistsContext(s.context(), name, pred.Match)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Kind:    ResultRows,
+		Command: "EXISTS",
+		Columns: []ResultColumn{{Name: "EXISTS", Type: "BOOL"}},
+		Rows:    [][]string{{strconv.FormatBool(found)}},
+		Count:   1,
+	}, nil
+}
+
+// checkAssignable verifies that e can be stored into column idx of rel.
+func checkAssignable(e *expr, rel *relation.Relation, idx int) error {
+	col := rel.Columns[idx]
+	switch col.Kind {
+	case relation.KindInt, relation.KindFloat:
+		if e.isConst() {
+			var err error
+			if col.Kind == relation.KindInt {
+				_, err = strconv.Atoi(e.val)
+			} else {
+				_, err = strconv.ParseFloat(e.val, 32)
+			}
+			if err != nil {
+				return fmt.Errorf("cannot assign %q to numeric column %s", e.val, col.Name)
+			}
+			return nil
+		}
+		if !e.numeric(rel) {
+			return fmt.Errorf("cannot assign a string expression to numeric column %s", col.Name)
+		}
+	case relation.KindBlob:
+		if !e.isConst() {
+			return fmt.Errorf("only a base64 constant can be assigned to BLOB column %s", col.Name)
+		}
+	default:
+		if !e.isConst() && e.numeric(rel) {
+			return fmt.Errorf("cannot assign a numeric expression to string column %s", col.Name)
+		}
 	}
-	fmt.Fprintf(w, "Total selected records = %d\n", total)
 	return nil
 }
 
-// DELETE name alias [WHERE ...]
+// stripDryRun removes a trailing DRY RUN modifier and reports whether it was present.
+func stripDryRun(text string) (string, bool) {
+	t := strings.TrimSpace(text)
+	fields := strings.Fields(t)
+	if len(fields) >= 2 && strings.EqualFold(fields[len(fields)-2], "DRY") && strings.EqualFold(fields[len(fields)-1], "RUN") {
+		up := strings.ToUpper(t)
+		return strings.TrimSpace(t[:strings.LastIndex(up, "DRY")]), true
+	}
+	return t, false
+}
+
+// DELETE name alias WHERE ... [DRY RUN]
+// DELETE [FROM] name [alias] ALL [DRY RUN]
+// DELETE [FROM] name [alias] WHERE ROWID = "FileIdx:PageIdx:SlotIdx" [DRY RUN]
+// With DRY RUN, the matching records are only counted. Deleting every record needs the
+// explicit ALL keyword (or DROP TABLE); without WHERE or ALL only a dry run is allowed.
 func (s *SGBD) ProcessDeleteCommand(text string, w io.Writer) error {
+	res, err := s.executeDelete(text)
+	if err != nil {
+		return err
+	}
+	return writeResult(w, res)
+}
+
+func (s *SGBD) executeDelete(text string) (Result, error) {
+	text, dryRun := stripDryRun(text)
 	// split "DELETE " then rest
 	rest := strings.TrimSpace(text[len("DELETE "):])
+	if strings.HasPrefix(strings.ToUpper(rest), "FROM ") {
+		rest = strings.TrimSpace(rest[len("FROM "):])
+	}
 	// find WHERE
 	whereIdx := strings.Index(strings.ToUpper(rest), " WHERE ")
 	var wherePart string
@@ -580,45 +952,97 @@ func (s *SGBD) ProcessDeleteCommand(text string, w io.Writer) error {
 		wherePart = strings.TrimSpace(rest[whereIdx+len(" WHERE "):])
 	}
 	parts := strings.Fields(fromPart)
-	if len(parts) < 2 {
-		return fmt.Errorf("invalid DELETE syntax")
+	if len(parts) < 1 {
+		return Result{}, fmt.Errorf("%w in DELETE", ErrSyntax)
+	}
+	deleteAll := false
+	if whereIdx < 0 && len(parts) > 1 && strings.EqualFold(parts[len(parts)-1], "ALL") {
+		deleteAll = true
+		parts = parts[:len(parts)-1]
 	}
 	name := parts[0]
-	alias := parts[1]
+	alias := ""
+	if len(parts) > 1 {
+		alias = parts[1]
+	}
+	if whereIdx < 0 && !deleteAll && !dryRun {
+		return Result{}, fmt.Errorf("DELETE without WHERE removes every record; use DELETE FROM %s ALL to confirm", name)
+	}
+	if rid, ok, err := parseRowIdPredicate(wherePart, alias); err != nil {
+		return Result{}, err
+	} else if ok {
+		if dryRun {
+			cnt := 0
+			err := s.dbm.ScanTableRecordsContext(s.context(), name, func(_ relation.Record, r relation.RecordId) error {
+				if r == rid {
+					cnt = 1
+				}
+				return nil
+			})
+			if err != nil {
+				return Result{}, err
+			}
+			return Result{Kind: ResultCount, Command: "DELETE", Count: cnt, DryRun: true}, nil
+		}
+		if err := s.dbm.DeleteByRecordId(name, rid); err != nil {
+			return Result{}, err
+		}
+		if err := s.bm.FlushBuffers(); err != nil {
+			return Result{}, err
+		}
+		return Result{Kind: ResultCount, Command: "DELETE", Count: 1}, nil
+	}
+	if alias == "" && !deleteAll {
+		return Result{}, fmt.Errorf("%w in DELETE", ErrSyntax)
+	}
 	rel, err := s.dbm.GetTable(name)
 	if err != nil {
-		return err
+		return Result{}, err
 	}
-	conds, err := parseWhereClause(wherePart, rel, alias)
+	pred, err := query.Compile(wherePart, rel, alias)
 	if err != nil {
-		return err
+		return Result{}, err
 	}
 	// define predicate
-	match := func(rec *relation.Record) bool {
-		ok, _ := evalConditions(rec, rel, conds)
-		return ok
+	match := pred.Match
+	if deleteAll {
+		match = func(*relation.Record) (bool, error) { return true, nil }
 	}
-	cnt, err := s.dbm.DeleteWhere(name, match)
+	cnt, err := s.dbm.DeleteWhereContext(s.context(), name, match, dryRun)
 	if err != nil {
-		return err
+		return Result{}, err
+	}
+	res := Result{Kind: ResultCount, Command: "DELETE", Count: cnt, DryRun: dryRun}
+	if dryRun {
+		return res, nil
 	}
 	// Force flush to disk after delete for data persistence
 	if err := s.bm.FlushBuffers(); err != nil {
-		return err
+		return Result{}, err
 	}
-	fmt.Fprintf(w, "Total deleted records = %d\n", cnt)
-	return nil
+	return res, nil
 }
 
-// UPDATE name alias SET alias.col=val,... [WHERE ...]
+// UPDATE name alias SET alias.col=val,... [WHERE ...] [DRY RUN]
+// WHERE ROWID = "FileIdx:PageIdx:SlotIdx" updates that single record.
+// With DRY RUN, the matching records are only counted.
 func (s *SGBD) ProcessUpdateCommand(text string, w io.Writer) error {
+	res, err := s.executeUpdate(text)
+	if err != nil {
+		return err
+	}
+	return writeResult(w, res)
+}
+
+func (s *SGBD) executeUpdate(text string) (Result, error) {
+	text, dryRun := stripDryRun(text)
 	// strip leading UPDATE
 	rest := strings.TrimSpace(text[len("UPDATE "):])
 	// find SET
 	upRest := strings.ToUpper(rest)
 	setIdx := strings.Index(upRest, " SET ")
 	if setIdx < 0 {
-		return fmt.Errorf("invalid UPDATE syntax: missing SET")
+		return Result{}, fmt.Errorf("%w in UPDATE: missing SET", ErrSyntax)
 	}
 	before := strings.TrimSpace(rest[:setIdx]) // "name alias"
 	after := strings.TrimSpace(rest[setIdx+len(" SET "):])
@@ -632,27 +1056,27 @@ func (s *SGBD) ProcessUpdateCommand(text string, w io.Writer) error {
 	}
 	parts := strings.Fields(before)
 	if len(parts) < 2 {
-		return fmt.Errorf("invalid UPDATE syntax")
+		return Result{}, fmt.Errorf("%w in UPDATE", ErrSyntax)
 	}
 	name := parts[0]
 	alias := parts[1]
 	rel, err := s.dbm.GetTable(name)
 	if err != nil {
-		return err
+		return Result{}, err
 	}
 	// parse assignments
 	assigns := strings.Split(setPart, ",")
-	changes := make(map[int]string)
+	changes := make(map[int]*expr)
 	for _, a := range assigns {
 		a = strings.TrimSpace(a)
 		spIdx := strings.Index(a, "=")
 		if spIdx < 0 {
-			return fmt.Errorf("invalid SET assignment: %s", a)
+			return Result{}, fmt.Errorf("%w: invalid SET assignment: %s", ErrSyntax, a)
 		}
 		lhs := strings.TrimSpace(a[:spIdx])
 		rhs := strings.TrimSpace(a[spIdx+1:])
 		if !strings.HasPrefix(lhs, alias+".") {
-			return fmt.Errorf("left side must be alias.column: %s", lhs)
+			return Result{}, fmt.Errorf("left side must be alias.column: %s", lhs)
 		}
 		col := lhs[len(alias)+1:]
 		idx := -1
@@ -663,45 +1087,90 @@ func (s *SGBD) ProcessUpdateCommand(text string, w io.Writer) error {
 			}
 		}
 		if idx < 0 {
-			return fmt.Errorf("unknown column: %s", col)
+			return Result{}, fmt.Errorf("unknown column: %s", col)
+		}
+		// the right side is arithmetic only for a numeric column or when it reads a
+		// column: a string column keeps the historical constant semantics, so that
+		// SET e.day = 2020-01-05 stores the text
+		kind := rel.Columns[idx].Kind
+		var e *expr
+		var err error
+		if kind == relation.KindInt || kind == relation.KindFloat || strings.Contains(rhs, alias+".") {
+			e, err = parseExpr(rhs, rel, alias)
+		}
+		if e == nil {
+			if err != nil && strings.Contains(rhs, alias+".") {
+				return Result{}, err
+			}
+			if len(rhs) >= 2 && rhs[0] == '"' && rhs[len(rhs)-1] == '"' {
+				rhs = rhs[1 : len(rhs)-1]
+			}
+			e = &expr{val: rhs, colIdx: -1}
+		}
+		if e.isConst() {
+			if e.val, err = rel.Columns[idx].ParseText(e.val); err != nil {
+				return Result{}, err
+			}
 		}
-		if len(rhs) >= 2 && rhs[0] == '"' && rhs[len(rhs)-1] == '"' {
-			rhs = rhs[1 : len(rhs)-1]
+		if err := checkAssignable(e, rel, idx); err != nil {
+			return Result{}, err
 		}
-		changes[idx] = rhs
+		changes[idx] = e
 	}
-	conds, err := parseWhereClause(wherePart, rel, alias)
+	rowId, byRowId, err := parseRowIdPredicate(wherePart, alias)
 	if err != nil {
-		return err
+		return Result{}, err
+	}
+	if byRowId {
+		wherePart = ""
+	}
+	pred, err := query.Compile(wherePart, rel, alias)
+	if err != nil {
+		return Result{}, err
 	}
-	// updater builds new record by copying and applying changes
-	updater := func(rec *relation.Record) *relation.Record {
+	// updater builds new record by copying and applying changes; every RHS is
+	// evaluated against the original record values
+	updater := func(rec *relation.Record) (*relation.Record, error) {
 		nr := &relation.Record{Values: append([]string{}, rec.Values...)}
-		for idx, val := range changes {
-			nr.Values[idx] = val
+		for idx, e := range changes {
+			if e.isConst() {
+				nr.Values[idx] = e.val
+				continue
+			}
+			v, err := e.eval(rec, rel)
+			if err != nil {
+				return nil, err
+			}
+			if nr.Values[idx], err = v.formatForColumn(rel.Columns[idx]); err != nil {
+				return nil, err
+			}
 		}
-		return nr
+		return nr, nil
 	}
-	match := func(rec *relation.Record) bool {
-		ok, _ := evalConditions(rec, rel, conds)
-		return ok
+	var cnt int
+	if byRowId {
+		cnt, err = s.dbm.UpdateByRecordId(name, rowId, updater, dryRun)
+	} else {
+		cnt, err = s.dbm.UpdateWhereContext(s.context(), name, pred.Match, updater, dryRun)
 	}
-	cnt, err := s.dbm.UpdateWhere(name, match, updater)
 	if err != nil {
-		return err
+		return Result{}, err
+	}
+	res := Result{Kind: ResultCount, Command: "UPDATE", Count: cnt, DryRun: dryRun}
+	if dryRun {
+		return res, nil
 	}
 	// Force flush to disk after update for data persistence
 	if err := s.bm.FlushBuffers(); err != nil {
-		return err
+		return Result{}, err
 	}
-	fmt.Fprintf(w, "Total updated records = %d\n", cnt)
-	return nil
+	return res, nil
 }
 
 func (s *SGBD) ProcessDropTableCommand(text string, w io.Writer) error {
 	parts := strings.Fields(text)
 	if len(parts) < 3 {
-		return fmt.Errorf("invalid DROP TABLE syntax")
+		return fmt.Errorf("%w in DROP TABLE", ErrSyntax)
 	}
 	name := parts[2]
 	if err := s.dbm.RemoveTable(name); err != nil {
@@ -711,6 +1180,42 @@ func (s *SGBD) ProcessDropTableCommand(text string, w io.Writer) error {
 	return nil
 }
 
+// ProcessAlterTableCommand expects one of:
+//
+//	ALTER TABLE Name RENAME COLUMN old TO new
+//	ALTER TABLE Name DROP COLUMN col
+//	ALTER TABLE Name MODIFY COLUMN col TYPE
+//
+// DROP and MODIFY rewrite the table, and are refused while it holds soft-deleted
+// records: PURGE or UNDELETE them first.
+func (s *SGBD) ProcessAlterTableCommand(text string, w io.Writer) error {
+	parts := strings.Fields(text)
+	var err error
+	switch {
+	case len(parts) == 8 && strings.EqualFold(parts[3], "RENAME") && strings.EqualFold(parts[4], "COLUMN") && strings.EqualFold(parts[6], "TO"):
+		err = s.dbm.RenameColumn(parts[2], parts[5], parts[7])
+	case len(parts) == 6 && strings.EqualFold(parts[3], "DROP") && strings.EqualFold(parts[4], "COLUMN"):
+		if err = s.dbm.DropColumn(parts[2], parts[5]); err == nil {
+			err = s.bm.FlushBuffers()
+		}
+	case len(parts) == 7 && strings.EqualFold(parts[3], "MODIFY") && strings.EqualFold(parts[4], "COLUMN"):
+		kind, size, perr := relation.ParseColumnType(parts[6])
+		if perr != nil {
+			return perr
+		}
+		if err = s.dbm.ModifyColumn(parts[2], parts[5], relation.ColumnInfo{Kind: kind, Size: size}); err == nil {
+			err = s.bm.FlushBuffers()
+		}
+	default:
+		return fmt.Errorf("%w in ALTER TABLE", ErrSyntax)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "OK")
+	return nil
+}
+
 func (s *SGBD) ProcessDropTablesCommand(w io.Writer) error {
 	if err := s.dbm.RemoveAllTables(); err != nil {
 		return err
@@ -722,7 +1227,7 @@ func (s *SGBD) ProcessDropTablesCommand(w io.Writer) error {
 func (s *SGBD) ProcessDescribeTableCommand(text string, w io.Writer) error {
 	parts := strings.Fields(text)
 	if len(parts) < 3 {
-		return fmt.Errorf("invalid DESCRIBE TABLE syntax")
+		return fmt.Errorf("%w in DESCRIBE TABLE", ErrSyntax)
 	}
 	name := parts[2]
 	if sStr, err := s.dbm.DescribeTable(name); err == nil {
@@ -733,15 +1238,318 @@ func (s *SGBD) ProcessDescribeTableCommand(text string, w io.Writer) error {
 	}
 }
 
-func (s *SGBD) ProcessDescribeTablesCommand(w io.Writer) error {
-	lines := s.dbm.DescribeAllTables()
+// DESCRIBE TABLES [VERBOSE]: VERBOSE adds record and page counts to every line.
+func (s *SGBD) ProcessDescribeTablesCommand(text string, w io.Writer) error {
+	parts := strings.Fields(text)
+	verbose := len(parts) == 3 && strings.EqualFold(parts[2], "VERBOSE")
+	if len(parts) > 3 || (len(parts) == 3 && !verbose) {
+		return fmt.Errorf("%w in DESCRIBE TABLES", ErrSyntax)
+	}
+	lines, err := s.dbm.DescribeAllTables(verbose)
+	if err != nil {
+		return err
+	}
 	for _, l := range lines {
 		fmt.Fprintln(w, l)
 	}
 	return nil
 }
 
-// Utility: Save DB state to disk (calls DBManager.SaveState)
+// ProcessCheckCommand handles CHECK <table> [REPAIR]. It prints OK when the table's
+// page lists are consistent, otherwise one line per problem. With REPAIR the lists are
+// rebuilt first and REPAIRED is printed before the check result.
+func (s *SGBD) ProcessCheckCommand(text string, w io.Writer) error {
+	parts := strings.Fields(text)
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("%w in CHECK", ErrSyntax)
+	}
+	repair := false
+	if len(parts) == 3 {
+		if !strings.EqualFold(parts[2], "REPAIR") {
+			return fmt.Errorf("%w in CHECK", ErrSyntax)
+		}
+		repair = true
+	}
+	err := s.dbm.CheckTable(parts[1], repair)
+	ierr, isIntegrity := err.(*relation.IntegrityError)
+	if err != nil && !isIntegrity {
+		return err
+	}
+	if repair {
+		if err := s.bm.FlushBuffers(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "REPAIRED")
+	}
+	if isIntegrity {
+		for _, p := range ierr.Problems {
+			fmt.Fprintln(w, p)
+		}
+		return fmt.Errorf("table %s: %d integrity problem(s)", parts[1], len(ierr.Problems))
+	}
+	fmt.Fprintln(w, "OK")
+	return nil
+}
+
+// ProcessRepairCommand handles REPAIR <table>: it rebuilds the table's free/full page
+// lists from scratch and prints OK.
+func (s *SGBD) ProcessRepairCommand(text string, w io.Writer) error {
+	parts := strings.Fields(text)
+	if len(parts) != 2 {
+		return fmt.Errorf("%w in REPAIR", ErrSyntax)
+	}
+	if err := s.dbm.RepairTable(parts[1]); err != nil {
+		return err
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "OK")
+	return nil
+}
+
+// ProcessFsckCommand handles FSCK: it checks every table (see DBManager.CheckAll) and
+// prints, in table name order, "<table>: OK" or one "<table>: <problem>" line per
+// problem, then one line per orphaned page and a summary line.
+func (s *SGBD) ProcessFsckCommand(w io.Writer) error {
+	report, err := s.dbm.CheckAll()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(report.Tables))
+	for name := range report.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(report.Tables[name]) == 0 {
+			fmt.Fprintf(w, "%s: OK\n", name)
+			continue
+		}
+		for _, p := range report.Tables[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, p)
+		}
+	}
+	for _, pid := range report.Orphans {
+		fmt.Fprintf(w, "orphaned page %d:%d\n", pid.FileIdx, pid.PageIdx)
+	}
+	n := report.Problems()
+	fmt.Fprintf(w, "%d table(s) checked, %d problem(s)\n", len(names), n)
+	if n > 0 {
+		return fmt.Errorf("fsck: %d integrity problem(s)", n)
+	}
+	return nil
+}
+
+// UNDELETE name restores every soft-deleted record of the table.
+// UNDELETE name WHERE ROWID = "FileIdx:PageIdx:SlotIdx" restores a single one.
+func (s *SGBD) ProcessUndeleteCommand(text string, w io.Writer) error {
+	rest := strings.TrimSpace(text[len("UNDELETE "):])
+	var wherePart string
+	if idx := strings.Index(strings.ToUpper(rest), " WHERE "); idx >= 0 {
+		wherePart = strings.TrimSpace(rest[idx+len(" WHERE "):])
+		rest = strings.TrimSpace(rest[:idx])
+	}
+	parts := strings.Fields(rest)
+	if len(parts) != 1 {
+		return fmt.Errorf("%w in UNDELETE", ErrSyntax)
+	}
+	name := parts[0]
+	cnt := 0
+	if wherePart != "" {
+		rid, ok, err := parseRowIdPredicate(wherePart, "")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("UNDELETE only supports WHERE ROWID = \"FileIdx:PageIdx:SlotIdx\"")
+		}
+		if err := s.dbm.UndeleteRecord(name, rid); err != nil {
+			return err
+		}
+		cnt = 1
+	} else {
+		var err error
+		if cnt, err = s.dbm.UndeleteAll(name); err != nil {
+			return err
+		}
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Total restored records = %d\n", cnt)
+	return nil
+}
+
+// DEFRAGMENT TABLE name moves the pages of the table next to each other on disk.
+// ROWIDs of its records change.
+func (s *SGBD) ProcessDefragmentCommand(text string, w io.Writer) error {
+	parts := strings.Fields(text)
+	if len(parts) != 3 {
+		return fmt.Errorf("%w in DEFRAGMENT", ErrSyntax)
+	}
+	n, err := s.dbm.DefragmentTable(parts[2])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Total moved pages = %d\n", n)
+	return nil
+}
+
+// PURGE name reclaims the slots of every soft-deleted record of the table.
+func (s *SGBD) ProcessPurgeCommand(text string, w io.Writer) error {
+	parts := strings.Fields(text)
+	if len(parts) != 2 {
+		return fmt.Errorf("%w in PURGE", ErrSyntax)
+	}
+	cnt, err := s.dbm.PurgeTable(parts[1])
+	if err != nil {
+		return err
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Total purged records = %d\n", cnt)
+	return nil
+}
+
+// COMPACT deletes the data files whose pages are all free, such as those left
+// behind by dropped tables. Data0.bin is never removed.
+func (s *SGBD) ProcessCompactCommand(w io.Writer) error {
+	n, err := s.dbm.Compact()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Total removed files = %d\n", n)
+	return nil
+}
+
+// ProcessSetCommand expects: SET query_timeout_ms = N
+// It changes the setting for the rest of the session; 0 removes the timeout.
+func (s *SGBD) ProcessSetCommand(text string, w io.Writer) error {
+	kv := strings.TrimSpace(text[len("SET "):])
+	eq := strings.Index(kv, "=")
+	if eq < 0 {
+		return fmt.Errorf("%w in SET: expected SET name = value", ErrSyntax)
+	}
+	name, val := strings.ToLower(strings.TrimSpace(kv[:eq])), strings.TrimSpace(kv[eq+1:])
+	switch name {
+	case "query_timeout_ms":
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid query_timeout_ms %q", val)
+		}
+		s.cfg.QueryTimeoutMs = n
+	default:
+		return fmt.Errorf("%w in SET: unknown setting %s", ErrSyntax, name)
+	}
+	fmt.Fprintln(w, "OK")
+	return nil
+}
+
+// ProcessSyncCommand handles SYNC: the dirty pages are written back and every data
+// file is fsynced, so the changes made so far survive a crash even under the batch
+// or never sync modes. It prints nothing.
+func (s *SGBD) ProcessSyncCommand() error {
+	if err := s.bm.FlushBuffers(); err != nil {
+		return err
+	}
+	return s.dm.Sync()
+}
+
+// Checkpoint handles CHECKPOINT: the dirty pages are written back, every data file
+// is fsynced and the WAL, if enabled, is emptied, which bounds the work of recovery
+// after a crash. It prints nothing.
+func (s *SGBD) Checkpoint() error {
+	if err := s.bm.FlushBuffers(); err != nil {
+		return err
+	}
+	if err := s.dm.Checkpoint(); err != nil {
+		return err
+	}
+	s.commands = 0
+	s.lastCheckpoint = time.Now()
+	return nil
+}
+
+// autoCheckpoint counts a successful command and checkpoints once cfg.CheckpointEvery
+// commands or cfg.CheckpointInterval seconds have gone by since the last checkpoint.
+func (s *SGBD) autoCheckpoint() error {
+	s.commands++
+	every, interval := s.cfg.CheckpointEvery, s.cfg.CheckpointInterval
+	if (every > 0 && s.commands >= every) ||
+		(interval > 0 && time.Since(s.lastCheckpoint) >= time.Duration(interval)*time.Second) {
+		return s.Checkpoint()
+	}
+	return nil
+}
+
+// ProcessShowStatusCommand handles SHOW STATUS (alias SHOW SETTINGS). It prints the
+// effective configuration followed by runtime state, one key=value per line in a fixed
+// order; configuration keys use the config file names.
+func (s *SGBD) ProcessShowStatusCommand(w io.Writer) error {
+	st, err := s.dm.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "dbpath=%s\n", s.cfg.DBPath)
+	fmt.Fprintf(w, "bin_dir=%s\n", s.dm.BinDir())
+	fmt.Fprintf(w, "pagesize=%d\n", s.cfg.PageSize)
+	fmt.Fprintf(w, "dm_maxfilecount=%d\n", s.cfg.DMMaxFileCount)
+	fmt.Fprintf(w, "bm_buffercount=%d\n", s.cfg.BMBufferCount)
+	fmt.Fprintf(w, "bm_policy=%s\n", s.cfg.BMPolicy)
+	fmt.Fprintf(w, "sync_mode=%s\n", s.cfg.SyncMode)
+	fmt.Fprintf(w, "strict_strings=%t\n", s.cfg.StrictStrings)
+	fmt.Fprintf(w, "query_timeout_ms=%d\n", s.cfg.QueryTimeoutMs)
+	fmt.Fprintf(w, "tables=%d\n", s.dbm.TableCount())
+	fmt.Fprintf(w, "allocated_pages=%d\n", st.Used)
+	fmt.Fprintf(w, "free_pages=%d\n", st.Free)
+	return nil
+}
+
+// Save persists everything a restart needs: the schema and header locations
+// (DBManager.SaveState), the dirty pages still in the buffer pool (fsynced, see
+// DiskManager.Sync), and the disk bitmaps.
 func (s *SGBD) Save() error {
-	return s.dbm.SaveState()
+	s.bm.WaitPrefetch()
+	if err := s.dbm.SaveState(); err != nil {
+		return err
+	}
+	if err := s.bm.FlushBuffers(); err != nil {
+		return err
+	}
+	// the data files are now complete: sync them and empty the WAL
+	if err := s.dm.Checkpoint(); err != nil {
+		return err
+	}
+	return s.dm.Finish()
+}
+
+// Close shuts the SGBD down cleanly: it saves the state, flushes the buffer
+// pool and finishes the disk manager, like EXIT does for Run. Embedders that
+// never call Run must call Close before exiting or recent writes may be lost.
+// Calling Close again returns nil; the SGBD must not be used after Close. When the
+// save fails, its error is returned but the files and the temporary directory of an
+// in-memory database are released all the same: the WAL, if enabled, still holds the
+// writes the save could not complete.
+func (s *SGBD) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.Save()
+	if err != nil {
+		s.dm.Close()
+	}
+	if s.wal != nil {
+		if cerr := s.wal.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.tmpDir != "" {
+		if rerr := os.RemoveAll(s.tmpDir); err == nil {
+			err = rerr
+		}
+	}
+	return err
 }
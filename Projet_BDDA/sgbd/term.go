@@ -0,0 +1,14 @@
+package sgbd
+
+import "os"
+
+// isTerminal reports whether v is an *os.File opened on a character device, which
+// is how an interactive terminal shows up without pulling in a terminal package.
+func isTerminal(v any) bool {
+	f, ok := v.(*os.File)
+	if !ok {
+		return false
+	}
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,91 @@
+package sgbd
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// rowOrderer collects rows and yields them in sort order: a rowSorter, or a topN
+// when only the first rows of the order are kept.
+type rowOrderer interface {
+	Add(row []string) error
+	Each(fn func(row []string) error) error
+	Close() error
+}
+
+// topN keeps the n first rows of the order given by less among every added row,
+// in a bounded max-heap whose root is the worst row kept, so ORDER BY ... LIMIT
+// holds n rows instead of the whole result. Like rowSorter, it is stable: rows
+// equal for less keep the order they were added in.
+type topN struct {
+	less func(a, b []string) bool
+	n    int
+	rows []seqRow
+	next int
+}
+
+// seqRow is a row and its position among the added rows.
+type seqRow struct {
+	row []string
+	seq int
+}
+
+func newTopN(n int, less func(a, b []string) bool) *topN {
+	return &topN{less: less, n: n}
+}
+
+// before orders a and b by less, then by the order they were added in.
+func (t *topN) before(a, b seqRow) bool {
+	if t.less(a.row, b.row) {
+		return true
+	}
+	if t.less(b.row, a.row) {
+		return false
+	}
+	return a.seq < b.seq
+}
+
+func (t *topN) Len() int           { return len(t.rows) }
+func (t *topN) Less(i, j int) bool { return t.before(t.rows[j], t.rows[i]) }
+func (t *topN) Swap(i, j int)      { t.rows[i], t.rows[j] = t.rows[j], t.rows[i] }
+func (t *topN) Push(x any)         { t.rows = append(t.rows, x.(seqRow)) }
+func (t *topN) Pop() any {
+	r := t.rows[len(t.rows)-1]
+	t.rows = t.rows[:len(t.rows)-1]
+	return r
+}
+
+// Add keeps row if it is among the n first rows added so far. A row added later
+// replaces the root only when it sorts strictly before it, as a tie goes to the
+// earlier row.
+func (t *topN) Add(row []string) error {
+	r := seqRow{row: row, seq: t.next}
+	t.next++
+	switch {
+	case t.n <= 0:
+	case len(t.rows) < t.n:
+		heap.Push(t, r)
+	case t.less(row, t.rows[0].row):
+		t.rows[0] = r
+		heap.Fix(t, 0)
+	}
+	return nil
+}
+
+// Each calls fn with the kept rows in sorted order.
+func (t *topN) Each(fn func(row []string) error) error {
+	sorted := append([]seqRow(nil), t.rows...)
+	sort.Slice(sorted, func(i, j int) bool { return t.before(sorted[i], sorted[j]) })
+	for _, r := range sorted {
+		if err := fn(r.row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close drops the kept rows.
+func (t *topN) Close() error {
+	t.rows = nil
+	return nil
+}
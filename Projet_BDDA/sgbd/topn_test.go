@@ -0,0 +1,82 @@
+package sgbd
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"malzahar-project/Projet_BDDA/config"
+)
+
+func TestTopNMatchesFullSort(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.NewDBConfig(dir)
+	cfg.SortMemoryRows = 50
+	s, err := NewSGBD(cfg)
+	if err != nil {
+		t.Fatalf("NewSGBD: %v", err)
+	}
+	var out bytes.Buffer
+	if err := s.ProcessCommand("CREATE TABLE T (id:INT,k:INT,name:VARCHAR(8))", &out); err != nil {
+		t.Fatalf("CREATE: %v", err)
+	}
+	// far more rows than sort_memory_rows, with many ties on k
+	const rows = 2000
+	for i := 0; i < rows; i++ {
+		cmd := fmt.Sprintf(`INSERT INTO T VALUES (%d,%d,"n%d")`, i, (i*131)%37, i%7)
+		if err := s.ProcessCommand(cmd, &out); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	for _, order := range []string{"t.k", "t.k DESC", "t.name DESC, t.k"} {
+		full, err := s.Execute("SELECT t.id, t.k, t.name FROM T t WHERE t.id >= 0 ORDER BY " + order)
+		if err != nil {
+			t.Fatalf("ORDER BY %s: %v", order, err)
+		}
+		if len(full.Rows) != rows {
+			t.Fatalf("ORDER BY %s returned %d rows", order, len(full.Rows))
+		}
+		for _, w := range []struct{ limit, offset int }{{0, 0}, {1, 0}, {10, 0}, {25, 15}, {5, rows - 3}} {
+			cmd := fmt.Sprintf("SELECT t.id, t.k, t.name FROM T t WHERE t.id >= 0 ORDER BY %s LIMIT %d OFFSET %d", order, w.limit, w.offset)
+			got, err := s.Execute(cmd)
+			if err != nil {
+				t.Fatalf("%s: %v", cmd, err)
+			}
+			want := window(full.Rows, w.limit, w.offset)
+			if fmt.Sprint(got.Rows) != fmt.Sprint(want) {
+				t.Fatalf("%s returned %v, want %v", cmd, got.Rows, want)
+			}
+			if got.Count != len(want) {
+				t.Fatalf("%s counted %d rows, want %d", cmd, got.Count, len(want))
+			}
+		}
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "sort-*")); len(left) != 0 {
+		t.Fatalf("temporary runs left behind: %v", left)
+	}
+}
+
+func TestTopNKeepsFirstRowsStable(t *testing.T) {
+	top := newTopN(3, lessByKeys([]sortKey{{idx: 0}}))
+	for i, v := range []string{"c", "a", "b", "a", "d", "b", "a"} {
+		if err := top.Add([]string{v, fmt.Sprint(i)}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if len(top.rows) > 3 {
+			t.Fatalf("top 3 holds %d rows", len(top.rows))
+		}
+	}
+	var got []string
+	if err := top.Each(func(row []string) error {
+		got = append(got, strings.Join(row, ""))
+		return nil
+	}); err != nil {
+		t.Fatalf("Each: %v", err)
+	}
+	// tied rows keep the order they were added in
+	if strings.Join(got, ",") != "a1,a3,a6" {
+		t.Fatalf("top 3 = %v, want a1,a3,a6", got)
+	}
+}
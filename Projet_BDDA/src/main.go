@@ -1,21 +1,41 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"strings"
 
 	"malzahar-project/Projet_BDDA/config"
 	"malzahar-project/Projet_BDDA/sgbd"
 )
 
 func main() {
-	cfgPath := flag.String("config", "config.txt", "path to config file")
+	cfgPath := flag.String("config", "config.txt", "path or file:// URL of the config file; - reads it from stdin up to the first empty line")
+	execCmds := flag.String("exec", "", "run these ';'-separated commands, save and exit without reading stdin")
+	scriptPath := flag.String("file", "", "run the commands of this script file, save and exit without reading stdin")
+	prompt := flag.Bool("prompt", false, "print a \"> \" prompt before each command when stdin and stdout are terminals")
 	flag.Parse()
+	if *execCmds != "" && *scriptPath != "" {
+		fmt.Fprintln(os.Stderr, "-exec and -file are mutually exclusive")
+		os.Exit(2)
+	}
 
-	abs, _ := filepath.Abs(*cfgPath)
-	cfg, err := config.LoadDBConfig(abs)
+	var (
+		cfg      *config.DBConfig
+		err      error
+		commands io.Reader = os.Stdin
+	)
+	if *cfgPath == "-" {
+		in := bufio.NewReader(os.Stdin)
+		cfg, err = loadConfigFromStdin(in)
+		commands = in
+	} else {
+		cfg, err = config.LoadDBConfig(*cfgPath)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
 		os.Exit(2)
@@ -25,8 +45,50 @@ func main() {
 		fmt.Fprintf(os.Stderr, "failed to initialize SGBD: %v\n", err)
 		os.Exit(2)
 	}
-	if err := s.Run(); err != nil {
+	if *execCmds != "" || *scriptPath != "" {
+		script := *execCmds
+		if *scriptPath != "" {
+			data, err := os.ReadFile(*scriptPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to read script: %v\n", err)
+				os.Exit(2)
+			}
+			script = string(data)
+		}
+		if err := s.RunScript(script, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "runtime error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *prompt {
+		s.SetPrompt("> ")
+	}
+	if err := s.RunFrom(commands); err != nil {
 		fmt.Fprintf(os.Stderr, "runtime error: %v\n", err)
 		os.Exit(2)
 	}
 }
+
+// loadConfigFromStdin reads the config from in up to the first empty line (or EOF),
+// leaving the rest of the stream for the commands.
+func loadConfigFromStdin(in *bufio.Reader) (*config.DBConfig, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := in.ReadString('\n')
+		if strings.TrimSpace(line) == "" {
+			if buf.Len() > 0 || err != nil {
+				break
+			}
+			continue
+		}
+		buf.WriteString(line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config.ParseDBConfig(&buf)
+}